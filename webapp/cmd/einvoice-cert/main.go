@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+)
+
+// Generates a self-signed certificate and RSA key for signing e-invoices in development.
+// Production invoices must be signed with a certificate issued by a licensed CA.
+func main() {
+	name := flag.String("name", "Siêu thị ABC", "Seller name (certificate common name)")
+	taxCode := flag.String("mst", "0000000000", "Seller tax code")
+	certFile := flag.String("cert", "einvoice-cert.pem", "Certificate output file")
+	keyFile := flag.String("key", "einvoice-key.pem", "Private key output file")
+	years := flag.Int("years", 2, "Validity in years")
+	help := flag.Bool("help", false, "Show help message")
+	flag.Parse()
+
+	if *help {
+		fmt.Println("E-invoice development certificate generator")
+		fmt.Println("Usage: go run ./cmd/einvoice-cert [-name ...] [-mst ...] [-cert file] [-key file]")
+		fmt.Println("Then set EINVOICE_CERT_FILE and EINVOICE_KEY_FILE to the generated files.")
+		return
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		log.Fatalf("Failed to generate serial: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: *name,
+			Country:    []string{"VN"},
+			// The tax code goes in UID like on certificates from Vietnamese CAs
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: []int{0, 9, 2342, 19200300, 100, 1, 1}, Value: "MST:" + *taxCode},
+			},
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().AddDate(*years, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	if err := writePEM(*certFile, "CERTIFICATE", der, 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *certFile, err)
+	}
+	if err := writePEM(*keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), 0o600); err != nil {
+		log.Fatalf("Failed to write %s: %v", *keyFile, err)
+	}
+	fmt.Printf("✅ Certificate: %s\n✅ Private key: %s\n", *certFile, *keyFile)
+}
+
+func writePEM(path, blockType string, der []byte, mode os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), mode)
+}
@@ -127,7 +127,7 @@ func dropAllTables() error {
 }
 
 func showHelp() {
-	fmt.Println(`
+	fmt.Print(`
 Database Migration Tool for Supermarket Management System
 
 Usage:
@@ -159,7 +159,7 @@ Environment:
 }
 
 func showPostMigrationInfo() {
-	fmt.Println(`
+	fmt.Print(`
 📝 Next Steps:
 1. Insert sample data:
    psql -U postgres -d supermarket -f ../sql/04_insert_sample_data.sql
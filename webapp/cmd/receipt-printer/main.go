@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A stand-in for a network receipt printer: it accepts raw ESC/POS jobs on a TCP port the way
+// a real printer does on 9100, saves each job to a file and prints the text part to the console.
+func main() {
+	addr := flag.String("addr", ":9100", "Address to listen on")
+	dir := flag.String("dir", "receipts", "Directory to save print jobs in")
+	help := flag.Bool("help", false, "Show help message")
+	flag.Parse()
+
+	if *help {
+		fmt.Println("Receipt printer stand-in")
+		fmt.Println("Usage: go run ./cmd/receipt-printer [-addr :9100] [-dir receipts]")
+		fmt.Println("Set RECEIPT_PRINTER_ADDR=localhost:9100 for the web app to print to it.")
+		return
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatalf("Failed to create %s: %v", *dir, err)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+	}
+	log.Printf("🖨️  Receipt printer listening on %s, saving jobs to %s", *addr, *dir)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("Accept failed: %v", err)
+			continue
+		}
+		go receive(conn, *dir)
+	}
+}
+
+// receive reads one job until the sender closes the connection
+func receive(conn net.Conn, dir string) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	job, err := io.ReadAll(conn)
+	if err != nil && len(job) == 0 {
+		log.Printf("Read from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	name := filepath.Join(dir, fmt.Sprintf("job-%s.bin", time.Now().Format("20060102-150405.000")))
+	if err := os.WriteFile(name, job, 0o644); err != nil {
+		log.Printf("Failed to save job: %v", err)
+		return
+	}
+	log.Printf("Received %d bytes from %s -> %s", len(job), conn.RemoteAddr(), name)
+	fmt.Println(preview(job))
+}
+
+// preview strips the ESC/POS commands from a job, leaving the printed text
+func preview(job []byte) string {
+	var out bytes.Buffer
+	for i := 0; i < len(job); i++ {
+		b := job[i]
+		switch {
+		case b == 0x1B && i+1 < len(job): // ESC
+			switch job[i+1] {
+			case '@':
+				i++
+			default: // ESC a n, ESC E n, ESC d n
+				i += 2
+			}
+		case b == 0x1D && i+1 < len(job): // GS
+			switch job[i+1] {
+			case '(': // GS ( k pL pH fn ... carries its own length
+				if i+4 >= len(job) {
+					i = len(job)
+					break
+				}
+				n := int(job[i+3]) + int(job[i+4])*256
+				if i+5+n > len(job) {
+					i = len(job)
+					break
+				}
+				if n > 3 && job[i+6] == 80 { // stored QR data
+					out.WriteString("[QR: " + string(job[i+8:i+5+n]) + "]\n")
+				}
+				i += 4 + n
+			case 'V': // GS V m n
+				i += 3
+			default: // GS ! n
+				i += 2
+			}
+		case b == '\n' || (b >= 0x20 && b < 0x7F):
+			out.WriteByte(b)
+		}
+	}
+	return out.String()
+}
@@ -109,7 +109,7 @@ func main() {
 	// Run simulation
 	log.Printf("Starting simulation from %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
 
-	if err := database.RunSimulation(db, start, end); err != nil {
+	if err := database.RunSimulation(db, start, end, cfg.Loyalty.ExpiryMonths); err != nil {
 		log.Fatalf("Simulation failed: %v", err)
 	}
 
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +13,10 @@ import (
 type Config struct {
 	Database DatabaseConfig
 	App      AppConfig
+	POS      POSConfig
+	Receipt  ReceiptConfig
+	EInvoice EInvoiceConfig
+	Loyalty  LoyaltyConfig
 }
 
 // DatabaseConfig holds database configuration
@@ -21,6 +27,9 @@ type DatabaseConfig struct {
 	Password string
 	DBName   string
 	SSLMode  string
+	// LoyaltyEarnRate is passed to every connection as supermarket.loyalty_earn_rate,
+	// the share of a sale the update_customer_metrics trigger awards as points
+	LoyaltyEarnRate float64
 }
 
 // AppConfig holds application configuration
@@ -29,6 +38,52 @@ type AppConfig struct {
 	Port        string
 }
 
+// POSConfig holds point-of-sale configuration
+type POSConfig struct {
+	// ParkedCartTTL is how long a suspended basket is kept before it expires
+	ParkedCartTTL time.Duration
+}
+
+// ReceiptConfig holds the store header printed on receipts and the till printer
+type ReceiptConfig struct {
+	StoreName    string
+	StoreAddress string
+	StorePhone   string
+	StoreTaxCode string
+	// PrinterAddr is the host:port of the raw TCP (port 9100) receipt printer; empty disables printing
+	PrinterAddr    string
+	PrinterTimeout time.Duration
+}
+
+// EInvoiceConfig holds the electronic invoice series and the signing certificate
+type EInvoiceConfig struct {
+	// TemplateCode (KHMSHDon) and Series (KHHDon) identify the registered invoice series;
+	// an empty Series means C<yy>TAA with the year each invoice is issued in
+	TemplateCode string
+	Series       string
+	// CertFile and KeyFile are the PEM certificate and private key used for XML-DSig signing
+	CertFile string
+	KeyFile  string
+	// Threshold is the invoice total from which a sale must get an electronic invoice
+	Threshold float64
+}
+
+// LoyaltyConfig holds the loyalty points earn and redemption rates and validity
+type LoyaltyConfig struct {
+	// EarnRate is the share of a sale's net before VAT earned as points, before the
+	// membership level's multiplier
+	EarnRate float64
+	// RedeemPoints points are worth RedeemValue VND when redeemed on a sale
+	RedeemPoints int
+	RedeemValue  float64
+	// ExpiryMonths is how long earned points stay valid; 0 keeps them forever
+	ExpiryMonths int
+	// Membership tiers are evaluated on the spending of the last TierWindowMonths; a customer
+	// who falls below their tier keeps it for TierGraceDays before being downgraded
+	TierWindowMonths int
+	TierGraceDays    int
+}
+
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	// Load .env file if it exists
@@ -50,16 +105,48 @@ func Load() (*Config, error) {
 			Environment: getEnv("APP_ENV", "development"),
 			Port:        getEnv("APP_PORT", "8080"),
 		},
+		POS: POSConfig{
+			ParkedCartTTL: time.Duration(getEnvInt("POS_PARKED_CART_TTL_MINUTES", 120)) * time.Minute,
+		},
+		Receipt: ReceiptConfig{
+			StoreName:      getEnv("STORE_NAME", "Siêu thị ABC"),
+			StoreAddress:   getEnv("STORE_ADDRESS", "123 Đường ABC, Quận 1, TP.HCM"),
+			StorePhone:     getEnv("STORE_PHONE", "(028) 1234-5678"),
+			StoreTaxCode:   getEnv("STORE_TAX_CODE", ""),
+			PrinterAddr:    getEnv("RECEIPT_PRINTER_ADDR", ""),
+			PrinterTimeout: time.Duration(getEnvInt("RECEIPT_PRINTER_TIMEOUT_SECONDS", 5)) * time.Second,
+		},
+		EInvoice: EInvoiceConfig{
+			TemplateCode: getEnv("EINVOICE_TEMPLATE_CODE", "1"),
+			Series:       getEnv("EINVOICE_SERIES", ""),
+			CertFile:     getEnv("EINVOICE_CERT_FILE", ""),
+			KeyFile:      getEnv("EINVOICE_KEY_FILE", ""),
+			Threshold:    float64(getEnvInt("EINVOICE_THRESHOLD", 200000)),
+		},
+		Loyalty: LoyaltyConfig{
+			EarnRate:         getEnvFloat("LOYALTY_EARN_RATE", 0.10),
+			RedeemPoints:     getEnvInt("LOYALTY_REDEEM_POINTS", 1000),
+			RedeemValue:      getEnvFloat("LOYALTY_REDEEM_VALUE", 1),
+			ExpiryMonths:     getEnvInt("LOYALTY_POINTS_EXPIRY_MONTHS", 12),
+			TierWindowMonths: getEnvInt("LOYALTY_TIER_WINDOW_MONTHS", 12),
+			TierGraceDays:    getEnvInt("LOYALTY_TIER_GRACE_DAYS", 60),
+		},
 	}
+	// The earn rate is applied by a trigger, so it travels with the database connection
+	if config.Loyalty.EarnRate < 0 {
+		config.Loyalty.EarnRate = 0
+	}
+	config.Database.LoyaltyEarnRate = config.Loyalty.EarnRate
 
 	return config, nil
 }
 
 // GetDSN returns the database connection string
 func (c *DatabaseConfig) GetDSN() string {
-	// Include search_path to ensure all pooled connections use the supermarket schema
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s search_path=supermarket",
-		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
+	// Include search_path to ensure all pooled connections use the supermarket schema,
+	// and the loyalty earn rate read by the triggers
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s search_path=supermarket supermarket.loyalty_earn_rate=%s",
+		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode, strconv.FormatFloat(c.LoyaltyEarnRate, 'f', -1, 64))
 }
 
 // getEnv gets an environment variable with a fallback value
@@ -69,3 +156,23 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvInt gets an integer environment variable with a fallback value
+func getEnvInt(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+// getEnvFloat gets a decimal environment variable with a fallback value
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
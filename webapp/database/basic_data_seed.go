@@ -37,8 +37,29 @@ func seedWarehouses(tx *gorm.DB) (map[string]uint, error) {
 	return warehouseMap, nil
 }
 
+// seedTaxClasses creates the VAT classes; shelf prices are displayed tax-inclusive
+func seedTaxClasses(tx *gorm.DB) (map[string]uint, error) {
+	taxClasses := []models.TaxClass{
+		{TaxCode: "VAT0", TaxName: "VAT 0%", Rate: 0, TaxMode: models.TaxInclusive, Description: strPtr("Hàng hóa chịu thuế suất 0%"), IsActive: true},
+		{TaxCode: "VAT5", TaxName: "VAT 5%", Rate: 5, TaxMode: models.TaxInclusive, Description: strPtr("Nông sản tươi sống, thực phẩm thiết yếu"), IsActive: true},
+		{TaxCode: "VAT10", TaxName: "VAT 10%", Rate: 10, TaxMode: models.TaxInclusive, Description: strPtr("Thuế suất phổ thông"), IsActive: true},
+	}
+
+	if err := tx.Create(&taxClasses).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("  ✓ Seeded %d tax classes", len(taxClasses))
+
+	// Return tax class ID map
+	taxClassMap := make(map[string]uint)
+	for _, tc := range taxClasses {
+		taxClassMap[tc.TaxCode] = tc.TaxClassID
+	}
+	return taxClassMap, nil
+}
+
 // seedProductCategories creates product category data
-func seedProductCategories(tx *gorm.DB) (map[string]uint, error) {
+func seedProductCategories(tx *gorm.DB, taxClassMap map[string]uint) (map[string]uint, error) {
 	categories := []models.ProductCategory{
 		{CategoryName: "Văn phòng phẩm", Description: strPtr("Đồ dùng văn phòng, học tập")},
 		{CategoryName: "Đồ gia dụng", Description: strPtr("Đồ dùng gia đình")},
@@ -59,6 +80,20 @@ func seedProductCategories(tx *gorm.DB) (map[string]uint, error) {
 		{CategoryName: "Thời trang - Unisex", Description: strPtr("Đồ dùng chung cho nam nữ")},
 	}
 
+	// Fresh produce, meat and dairy are taxed at 5%, everything else at the standard 10%
+	reducedRate := map[string]bool{
+		"Thực phẩm - Rau quả":   true,
+		"Thực phẩm - Thịt cá":   true,
+		"Thực phẩm - Sữa trứng": true,
+	}
+	for i := range categories {
+		taxClassID := taxClassMap["VAT10"]
+		if reducedRate[categories[i].CategoryName] {
+			taxClassID = taxClassMap["VAT5"]
+		}
+		categories[i].TaxClassID = uintPtr(taxClassID)
+	}
+
 	if err := tx.Create(&categories).Error; err != nil {
 		return nil, err
 	}
@@ -46,6 +46,13 @@ CREATE TRIGGER tr_validate_stock_transfer
     FOR EACH ROW
     EXECUTE FUNCTION validate_stock_transfer();
 
+-- 1.5 Register Session Lock
+DROP TRIGGER IF EXISTS tr_check_register_session_open ON sales_invoices;
+CREATE TRIGGER tr_check_register_session_open
+    BEFORE INSERT OR UPDATE OF session_id ON sales_invoices
+    FOR EACH ROW
+    EXECUTE FUNCTION check_register_session_open();
+
 -- ============================================================================
 -- 2. INVENTORY MANAGEMENT TRIGGERS
 -- ============================================================================
@@ -78,16 +85,19 @@ CREATE TRIGGER tr_check_low_stock
     FOR EACH ROW
     EXECUTE FUNCTION check_low_stock();
 
+-- 2.5 Sales Invoice Status Change (complete drafts, void)
+DROP TRIGGER IF EXISTS tr_process_invoice_status_change ON sales_invoices;
+CREATE TRIGGER tr_process_invoice_status_change
+    AFTER UPDATE OF status ON sales_invoices
+    FOR EACH ROW
+    EXECUTE FUNCTION process_invoice_status_change();
+
 -- ============================================================================
 -- 8. PURCHASE ORDER RECEIPT → WAREHOUSE INVENTORY
 -- ============================================================================
 
--- 8.1 Process purchase receipt
+-- 8.1 Process purchase receipt: retired, goods receipt notes post the stock
 DROP TRIGGER IF EXISTS tr_process_purchase_receipt ON purchase_orders;
-CREATE TRIGGER tr_process_purchase_receipt
-    AFTER UPDATE OF status ON purchase_orders
-    FOR EACH ROW
-    EXECUTE FUNCTION process_purchase_receipt();
 
 -- ============================================================================
 -- 3. CUSTOMER MANAGEMENT TRIGGERS
@@ -100,13 +110,6 @@ CREATE TRIGGER tr_update_customer_metrics
     FOR EACH ROW
     EXECUTE FUNCTION update_customer_metrics();
 
--- 3.2 Check for Membership Upgrades
-DROP TRIGGER IF EXISTS tr_check_membership_upgrade ON customers;
-CREATE TRIGGER tr_check_membership_upgrade
-    AFTER UPDATE OF total_spending ON customers
-    FOR EACH ROW
-    EXECUTE FUNCTION check_membership_upgrade();
-
 -- ============================================================================
 -- 4. FINANCIAL CALCULATION TRIGGERS
 -- ============================================================================
@@ -161,6 +164,13 @@ CREATE TRIGGER tr_apply_expiry_discounts
     FOR EACH ROW
     EXECUTE FUNCTION apply_expiry_discounts();
 
+-- 6.2 Product Price History
+DROP TRIGGER IF EXISTS tr_record_product_price_change ON products;
+CREATE TRIGGER tr_record_product_price_change
+    AFTER INSERT OR UPDATE OF selling_price ON products
+    FOR EACH ROW
+    EXECUTE FUNCTION record_product_price_change();
+
 -- ============================================================================
 -- 8. ACTIVITY LOGGING TRIGGERS
 -- ============================================================================
@@ -379,7 +389,7 @@ BEGIN
     RAISE NOTICE 'The following trigger categories are now active:';
     RAISE NOTICE '  ✓ Validation Triggers (price, capacity, category consistency)';
     RAISE NOTICE '  ✓ Inventory Management Triggers (stock transfers, sales deduction, expiry)';
-    RAISE NOTICE '  ✓ Customer Management Triggers (spending, points, membership upgrades)';
+    RAISE NOTICE '  ✓ Customer Management Triggers (spending, points)';
     RAISE NOTICE '  ✓ Financial Calculation Triggers (invoice totals, discounts)';
     RAISE NOTICE '  ✓ Employee Management Triggers (work hours calculation)';
     RAISE NOTICE '  ✓ Pricing Management Triggers (expiry discounts)';
@@ -26,11 +26,28 @@ func cleanupPartialData(db *gorm.DB) error {
 			"DELETE FROM shelf_layout",
 			"DELETE FROM warehouse_inventory",
 			"DELETE FROM stock_transfers",
+			"DELETE FROM stock_transfer_headers",
+			"DELETE FROM damaged_stock",
+			"DELETE FROM sales_return_details",
+			"DELETE FROM sales_returns",
+			"DELETE FROM pos_cart_items",
+			"DELETE FROM pos_carts",
+			"DELETE FROM sales_invoice_allocations",
+			"DELETE FROM voucher_transactions",
+			"DELETE FROM sales_invoice_promotions",
+			"DELETE FROM sales_invoice_payments",
 			"DELETE FROM sales_invoice_details",
 			"DELETE FROM sales_invoices",
+			"DELETE FROM vouchers",
+			"DELETE FROM register_session_totals",
+			"DELETE FROM register_sessions",
+			"DELETE FROM goods_receipt_lines",
+			"DELETE FROM goods_receipts",
 			"DELETE FROM purchase_order_details",
 			"DELETE FROM purchase_orders",
 			"DELETE FROM discount_rules",
+			"DELETE FROM promotion_items",
+			"DELETE FROM promotions",
 			"DELETE FROM customers",
 			"DELETE FROM employees",
 			// Only clear master data if we're doing full reseed
@@ -40,6 +57,7 @@ func cleanupPartialData(db *gorm.DB) error {
 			"TRUNCATE TABLE positions RESTART IDENTITY CASCADE",
 			"TRUNCATE TABLE suppliers RESTART IDENTITY CASCADE",
 			"TRUNCATE TABLE product_categories RESTART IDENTITY CASCADE",
+			"TRUNCATE TABLE tax_classes RESTART IDENTITY CASCADE",
 			"TRUNCATE TABLE warehouse RESTART IDENTITY CASCADE",
 		}
 
@@ -100,8 +118,12 @@ func SeedData(db *gorm.DB) error {
 			return fmt.Errorf("failed to seed warehouses: %w", err)
 		}
 
-		// 2. Seed Product Categories
-		categoryMap, err := seedProductCategories(tx)
+		// 2. Seed Tax Classes and Product Categories
+		taxClassMap, err := seedTaxClasses(tx)
+		if err != nil {
+			return fmt.Errorf("failed to seed tax classes: %w", err)
+		}
+		categoryMap, err := seedProductCategories(tx, taxClassMap)
 		if err != nil {
 			return fmt.Errorf("failed to seed product categories: %w", err)
 		}
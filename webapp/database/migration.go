@@ -78,6 +78,12 @@ func AutoMigrate(db *gorm.DB) error {
 		}
 	}
 
+	// Add columns introduced after the tables were first created
+	log.Println("Adding new columns to existing tables...")
+	if err := AddMissingColumns(db); err != nil {
+		log.Printf("Warning: Some columns could not be added: %v", err)
+	}
+
 	// Add unique constraints first (needed for composite foreign keys)
 	log.Println("Adding unique constraints...")
 	if err := AddUniqueConstraints(db); err != nil {
@@ -102,6 +108,30 @@ func AutoMigrate(db *gorm.DB) error {
 		log.Printf("Warning: Some indexes could not be created: %v", err)
 	}
 
+	// Default numbering rules, needed before any document can be created
+	log.Println("Ensuring document sequences...")
+	if err := EnsureDocumentSequences(db); err != nil {
+		log.Printf("Warning: Some document sequences could not be created: %v", err)
+	}
+
+	// Points held before the ledger existed become its opening entries
+	log.Println("Opening loyalty point balances...")
+	if err := EnsureLoyaltyOpeningBalances(db); err != nil {
+		log.Printf("Warning: Loyalty point balances could not be opened: %v", err)
+	}
+
+	// Managers and supervisors keep the override rights they had before limits were configurable
+	log.Println("Ensuring price override limits...")
+	if err := EnsureOverrideLimits(db); err != nil {
+		log.Printf("Warning: Price override limits could not be set: %v", err)
+	}
+
+	// Prices set before the history existed become its first entries
+	log.Println("Opening product price history...")
+	if err := EnsurePriceHistory(db); err != nil {
+		log.Printf("Warning: Product price history could not be opened: %v", err)
+	}
+
 	// Create triggers
 	log.Println("Creating database triggers...")
 	if err := CreateTriggers(db); err != nil {
@@ -198,10 +228,123 @@ func CreateForeignKeys(db *gorm.DB) error {
 		// Sales invoices
 		{"sales_invoices", "fk_sales_invoices_customer", "customer_id", "customers", "customer_id"},
 		{"sales_invoices", "fk_sales_invoices_employee", "employee_id", "employees", "employee_id"},
+		{"sales_invoices", "fk_sales_invoices_voided_by", "voided_by", "employees", "employee_id"},
+		{"sales_invoices", "fk_sales_invoices_session", "session_id", "register_sessions", "session_id"},
+		{"sales_invoices", "fk_sales_invoices_age_verified_by", "age_verified_by", "employees", "employee_id"},
+		{"sales_invoices", "fk_sales_invoices_restriction_override_by", "restriction_override_by", "employees", "employee_id"},
+
+		// Register sessions
+		{"register_sessions", "fk_register_sessions_employee", "employee_id", "employees", "employee_id"},
+		{"register_sessions", "fk_register_sessions_closed_by", "closed_by", "employees", "employee_id"},
+		{"register_session_totals", "fk_register_session_totals_session", "session_id", "register_sessions", "session_id"},
+
+		// POS carts
+		{"pos_carts", "fk_pos_carts_employee", "employee_id", "employees", "employee_id"},
+		{"pos_carts", "fk_pos_carts_session", "session_id", "register_sessions", "session_id"},
+		{"pos_carts", "fk_pos_carts_customer", "customer_id", "customers", "customer_id"},
+		{"pos_carts", "fk_pos_carts_invoice", "invoice_id", "sales_invoices", "invoice_id"},
+		{"pos_cart_items", "fk_pos_cart_items_cart", "cart_id", "pos_carts", "cart_id"},
+		{"pos_cart_items", "fk_pos_cart_items_product", "product_id", "products", "product_id"},
+		{"pos_carts", "fk_pos_carts_parked_by", "parked_by", "employees", "employee_id"},
 
 		// Sales invoice details
 		{"sales_invoice_details", "fk_sales_invoice_details_invoice", "invoice_id", "sales_invoices", "invoice_id"},
 		{"sales_invoice_details", "fk_sales_invoice_details_product", "product_id", "products", "product_id"},
+		{"sales_invoice_details", "fk_sales_invoice_details_override_approver", "override_approved_by", "employees", "employee_id"},
+
+		// Promotions
+		{"promotion_items", "fk_promotion_items_promotion", "promotion_id", "promotions", "promotion_id"},
+		{"promotion_items", "fk_promotion_items_product", "product_id", "products", "product_id"},
+		{"promotion_items", "fk_promotion_items_category", "category_id", "product_categories", "category_id"},
+		{"sales_invoice_details", "fk_sales_invoice_details_promotion", "promotion_id", "promotions", "promotion_id"},
+		{"sales_invoice_promotions", "fk_sales_invoice_promotions_detail", "detail_id", "sales_invoice_details", "detail_id"},
+		{"sales_invoice_promotions", "fk_sales_invoice_promotions_promotion", "promotion_id", "promotions", "promotion_id"},
+
+		// Vouchers and gift cards
+		{"vouchers", "fk_vouchers_customer", "customer_id", "customers", "customer_id"},
+		{"vouchers", "fk_vouchers_issued_by", "issued_by", "employees", "employee_id"},
+		{"voucher_transactions", "fk_voucher_transactions_voucher", "voucher_id", "vouchers", "voucher_id"},
+		{"voucher_transactions", "fk_voucher_transactions_invoice", "invoice_id", "sales_invoices", "invoice_id"},
+		{"voucher_transactions", "fk_voucher_transactions_payment", "payment_id", "sales_invoice_payments", "payment_id"},
+		{"voucher_transactions", "fk_voucher_transactions_employee", "employee_id", "employees", "employee_id"},
+		{"sales_invoice_payments", "fk_sales_invoice_payments_voucher", "voucher_id", "vouchers", "voucher_id"},
+
+		// Tax classes
+		{"product_categories", "fk_product_categories_tax_class", "tax_class_id", "tax_classes", "tax_class_id"},
+		{"products", "fk_products_tax_class", "tax_class_id", "tax_classes", "tax_class_id"},
+		{"sales_invoice_details", "fk_sales_invoice_details_tax_class", "tax_class_id", "tax_classes", "tax_class_id"},
+
+		// Document numbering
+		{"document_sequence_counters", "fk_document_sequence_counters_sequence", "document_type", "document_sequences", "document_type"},
+
+		// Electronic invoices
+		{"einvoice_exports", "fk_einvoice_exports_invoice", "invoice_id", "sales_invoices", "invoice_id"},
+		{"einvoice_exports", "fk_einvoice_exports_original", "original_export_id", "einvoice_exports", "export_id"},
+
+		// Membership tier history
+		{"membership_tier_history", "fk_membership_tier_history_customer", "customer_id", "customers", "customer_id"},
+		{"membership_tier_history", "fk_membership_tier_history_from_level", "from_level_id", "membership_levels", "level_id"},
+		{"membership_tier_history", "fk_membership_tier_history_to_level", "to_level_id", "membership_levels", "level_id"},
+
+		// Loyalty points ledger
+		{"loyalty_transactions", "fk_loyalty_transactions_customer", "customer_id", "customers", "customer_id"},
+		{"loyalty_transactions", "fk_loyalty_transactions_invoice", "invoice_id", "sales_invoices", "invoice_id"},
+		{"loyalty_transactions", "fk_loyalty_transactions_return", "return_id", "sales_returns", "return_id"},
+		{"loyalty_transactions", "fk_loyalty_transactions_employee", "employee_id", "employees", "employee_id"},
+		{"loyalty_lot_usages", "fk_loyalty_lot_usages_entry", "entry_id", "loyalty_transactions", "transaction_id"},
+		{"loyalty_lot_usages", "fk_loyalty_lot_usages_lot", "lot_id", "loyalty_transactions", "transaction_id"},
+
+		// Sales invoice batch allocations
+		{"sales_invoice_allocations", "fk_sales_invoice_allocations_detail", "detail_id", "sales_invoice_details", "detail_id"},
+		{"sales_invoice_allocations", "fk_sales_invoice_allocations_shelf", "shelf_id", "display_shelves", "shelf_id"},
+
+		// Offline sale stock shortages
+		{"stock_shortages", "fk_stock_shortages_detail", "detail_id", "sales_invoice_details", "detail_id"},
+		{"stock_shortages", "fk_stock_shortages_invoice", "invoice_id", "sales_invoices", "invoice_id"},
+		{"stock_shortages", "fk_stock_shortages_product", "product_id", "products", "product_id"},
+		{"stock_shortages", "fk_stock_shortages_shelf", "shelf_id", "display_shelves", "shelf_id"},
+		{"stock_shortages", "fk_stock_shortages_resolved_by", "resolved_by", "employees", "employee_id"},
+
+		// Stock count foreign keys
+		{"stock_counts", "fk_stock_counts_warehouse", "warehouse_id", "warehouse", "warehouse_id"},
+		{"stock_counts", "fk_stock_counts_shelf", "shelf_id", "display_shelves", "shelf_id"},
+		{"stock_counts", "fk_stock_counts_category", "category_id", "product_categories", "category_id"},
+		{"stock_counts", "fk_stock_counts_created_by", "created_by", "employees", "employee_id"},
+		{"stock_counts", "fk_stock_counts_approved_by", "approved_by", "employees", "employee_id"},
+		{"stock_count_lines", "fk_stock_count_lines_count", "count_id", "stock_counts", "count_id"},
+		{"stock_count_lines", "fk_stock_count_lines_warehouse", "warehouse_id", "warehouse", "warehouse_id"},
+		{"stock_count_lines", "fk_stock_count_lines_shelf", "shelf_id", "display_shelves", "shelf_id"},
+		{"stock_count_lines", "fk_stock_count_lines_product", "product_id", "products", "product_id"},
+		{"stock_count_lines", "fk_stock_count_lines_counted_by", "counted_by", "employees", "employee_id"},
+		{"stock_count_lines", "fk_stock_count_lines_recounted_by", "recounted_by", "employees", "employee_id"},
+
+		// Disposal foreign keys
+		{"disposals", "fk_disposals_created_by", "created_by", "employees", "employee_id"},
+		{"disposals", "fk_disposals_approved_by", "approved_by", "employees", "employee_id"},
+		{"disposal_lines", "fk_disposal_lines_disposal", "disposal_id", "disposals", "disposal_id"},
+		{"disposal_lines", "fk_disposal_lines_warehouse", "warehouse_id", "warehouse", "warehouse_id"},
+		{"disposal_lines", "fk_disposal_lines_shelf", "shelf_id", "display_shelves", "shelf_id"},
+		{"disposal_lines", "fk_disposal_lines_product", "product_id", "products", "product_id"},
+
+		// Transfer order foreign keys
+		{"transfer_orders", "fk_transfer_orders_from_warehouse", "from_warehouse_id", "warehouse", "warehouse_id"},
+		{"transfer_orders", "fk_transfer_orders_from_shelf", "from_shelf_id", "display_shelves", "shelf_id"},
+		{"transfer_orders", "fk_transfer_orders_to_warehouse", "to_warehouse_id", "warehouse", "warehouse_id"},
+		{"transfer_orders", "fk_transfer_orders_dispatched_by", "dispatched_by", "employees", "employee_id"},
+		{"transfer_orders", "fk_transfer_orders_received_by", "received_by", "employees", "employee_id"},
+		{"transfer_order_lines", "fk_transfer_order_lines_order", "transfer_order_id", "transfer_orders", "transfer_order_id"},
+		{"transfer_order_lines", "fk_transfer_order_lines_product", "product_id", "products", "product_id"},
+
+		// Goods receipt foreign keys
+		{"goods_receipts", "fk_goods_receipts_order", "order_id", "purchase_orders", "order_id"},
+		{"goods_receipts", "fk_goods_receipts_warehouse", "warehouse_id", "warehouse", "warehouse_id"},
+		{"goods_receipts", "fk_goods_receipts_received_by", "received_by", "employees", "employee_id"},
+		{"goods_receipt_lines", "fk_goods_receipt_lines_receipt", "receipt_id", "goods_receipts", "receipt_id"},
+		{"goods_receipt_lines", "fk_goods_receipt_lines_detail", "detail_id", "purchase_order_details", "detail_id"},
+		{"goods_receipt_lines", "fk_goods_receipt_lines_product", "product_id", "products", "product_id"},
+
+		// Sales invoice tenders
+		{"sales_invoice_payments", "fk_sales_invoice_payments_invoice", "invoice_id", "sales_invoices", "invoice_id"},
 
 		// Purchase orders
 		{"purchase_orders", "fk_purchase_orders_supplier", "supplier_id", "suppliers", "supplier_id"},
@@ -212,10 +355,29 @@ func CreateForeignKeys(db *gorm.DB) error {
 		{"purchase_order_details", "fk_purchase_order_details_product", "product_id", "products", "product_id"},
 
 		// Stock transfers
+		{"stock_transfer_headers", "fk_stock_transfer_headers_product", "product_id", "products", "product_id"},
+		{"stock_transfer_headers", "fk_stock_transfer_headers_from_warehouse", "from_warehouse_id", "warehouse", "warehouse_id"},
+		{"stock_transfer_headers", "fk_stock_transfer_headers_to_shelf", "to_shelf_id", "display_shelves", "shelf_id"},
+		{"stock_transfer_headers", "fk_stock_transfer_headers_employee", "employee_id", "employees", "employee_id"},
+		{"stock_transfers", "fk_stock_transfers_header", "header_id", "stock_transfer_headers", "header_id"},
 		{"stock_transfers", "fk_stock_transfers_product", "product_id", "products", "product_id"},
 		{"stock_transfers", "fk_stock_transfers_from_warehouse", "from_warehouse_id", "warehouse", "warehouse_id"},
 		{"stock_transfers", "fk_stock_transfers_to_shelf", "to_shelf_id", "display_shelves", "shelf_id"},
 		{"stock_transfers", "fk_stock_transfers_employee", "employee_id", "employees", "employee_id"},
+
+		// Sales returns
+		{"sales_returns", "fk_sales_returns_invoice", "invoice_id", "sales_invoices", "invoice_id"},
+		{"sales_returns", "fk_sales_returns_customer", "customer_id", "customers", "customer_id"},
+		{"sales_returns", "fk_sales_returns_employee", "employee_id", "employees", "employee_id"},
+		{"sales_returns", "fk_sales_returns_session", "session_id", "register_sessions", "session_id"},
+		{"product_price_history", "fk_product_price_history_product", "product_id", "products", "product_id"},
+		{"sales_return_details", "fk_sales_return_details_return", "return_id", "sales_returns", "return_id"},
+		{"sales_return_details", "fk_sales_return_details_invoice_detail", "invoice_detail_id", "sales_invoice_details", "detail_id"},
+		{"sales_return_details", "fk_sales_return_details_product", "product_id", "products", "product_id"},
+		{"sales_return_details", "fk_sales_return_details_shelf", "shelf_id", "display_shelves", "shelf_id"},
+
+		// Damaged stock bin
+		{"damaged_stock", "fk_damaged_stock_product", "product_id", "products", "product_id"},
 	}
 
 	for _, fk := range foreignKeys {
@@ -302,6 +464,92 @@ func CreateForeignKeys(db *gorm.DB) error {
 	return nil
 }
 
+// AddMissingColumns adds columns that were introduced after a table was first created.
+// CreateTable only runs for missing tables, so existing databases need these explicitly.
+func AddMissingColumns(db *gorm.DB) error {
+	columns := []struct {
+		name  string
+		query string
+	}{
+		// Sales invoice lifecycle
+		{"sales_invoices.status", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'COMPLETED'"},
+		{"sales_invoices.voided_at", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS voided_at TIMESTAMPTZ"},
+		{"sales_invoices.voided_by", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS voided_by BIGINT"},
+		{"sales_invoices.void_reason", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS void_reason TEXT"},
+		// Server-side pricing breakdown
+		{"sales_invoices.pricing_breakdown", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS pricing_breakdown JSONB"},
+		{"sales_invoice_details.list_price", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS list_price DECIMAL(12,2)"},
+		{"sales_invoice_details.batch_discount_amount", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS batch_discount_amount DECIMAL(12,2) DEFAULT 0"},
+		{"sales_invoice_details.membership_discount_amount", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS membership_discount_amount DECIMAL(12,2) DEFAULT 0"},
+		{"sales_invoice_details.points_discount_amount", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS points_discount_amount DECIMAL(12,2) DEFAULT 0"},
+		{"sales_invoice_details.override_approved_by", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS override_approved_by BIGINT"},
+		// Split-tender settlement
+		{"sales_invoices.rounding_adjustment", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS rounding_adjustment DECIMAL(12,2) DEFAULT 0"},
+		{"sales_invoices.change_amount", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS change_amount DECIMAL(12,2) DEFAULT 0"},
+		// Register sessions
+		{"sales_invoices.session_id", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS session_id BIGINT"},
+		// Parked (suspended) POS carts
+		{"pos_carts.parked_label", "ALTER TABLE pos_carts ADD COLUMN IF NOT EXISTS parked_label VARCHAR(100)"},
+		{"pos_carts.parked_by", "ALTER TABLE pos_carts ADD COLUMN IF NOT EXISTS parked_by BIGINT"},
+		{"pos_carts.parked_at", "ALTER TABLE pos_carts ADD COLUMN IF NOT EXISTS parked_at TIMESTAMPTZ"},
+		{"pos_carts.expires_at", "ALTER TABLE pos_carts ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ"},
+		// Promotions engine
+		{"products.brand", "ALTER TABLE products ADD COLUMN IF NOT EXISTS brand VARCHAR(100)"},
+		{"sales_invoice_details.promotion_discount_amount", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS promotion_discount_amount DECIMAL(12,2) DEFAULT 0"},
+		{"sales_invoice_details.promotion_id", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS promotion_id BIGINT"},
+		// Voucher tenders
+		{"sales_invoice_payments.voucher_id", "ALTER TABLE sales_invoice_payments ADD COLUMN IF NOT EXISTS voucher_id BIGINT"},
+		// Tax classes and per-line tax
+		{"product_categories.tax_class_id", "ALTER TABLE product_categories ADD COLUMN IF NOT EXISTS tax_class_id BIGINT"},
+		{"products.tax_class_id", "ALTER TABLE products ADD COLUMN IF NOT EXISTS tax_class_id BIGINT"},
+		{"sales_invoice_details.tax_class_id", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS tax_class_id BIGINT"},
+		{"sales_invoice_details.tax_rate", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(5,2) NOT NULL DEFAULT 10"},
+		{"sales_invoice_details.tax_mode", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS tax_mode VARCHAR(10) NOT NULL DEFAULT 'EXCLUSIVE'"},
+		{"sales_invoice_details.tax_amount", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(12,2) DEFAULT 0"},
+		{"sales_return_details.tax_amount", "ALTER TABLE sales_return_details ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(12,2) DEFAULT 0"},
+		// Refund tender and till session of sales returns
+		{"sales_returns.refund_method", "ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS refund_method VARCHAR(20) NOT NULL DEFAULT 'CASH'"},
+		{"sales_returns.session_id", "ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS session_id BIGINT"},
+		{"register_session_totals.return_count", "ALTER TABLE register_session_totals ADD COLUMN IF NOT EXISTS return_count INTEGER DEFAULT 0"},
+		{"register_session_totals.refund_amount", "ALTER TABLE register_session_totals ADD COLUMN IF NOT EXISTS refund_amount DECIMAL(12,2) NOT NULL DEFAULT 0"},
+		// Rolling-window membership tiers
+		{"customers.tier_grace_until", "ALTER TABLE customers ADD COLUMN IF NOT EXISTS tier_grace_until DATE"},
+		{"customers.tier_evaluated_at", "ALTER TABLE customers ADD COLUMN IF NOT EXISTS tier_evaluated_at TIMESTAMPTZ"},
+		// Price override authorization
+		{"positions.override_max_percent", "ALTER TABLE positions ADD COLUMN IF NOT EXISTS override_max_percent DECIMAL(5,2)"},
+		{"positions.override_max_amount", "ALTER TABLE positions ADD COLUMN IF NOT EXISTS override_max_amount DECIMAL(12,2)"},
+		{"employees.approval_pin_hash", "ALTER TABLE employees ADD COLUMN IF NOT EXISTS approval_pin_hash VARCHAR(200)"},
+		{"sales_invoice_details.override_type", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS override_type VARCHAR(10)"},
+		{"sales_invoice_details.override_reason", "ALTER TABLE sales_invoice_details ADD COLUMN IF NOT EXISTS override_reason TEXT"},
+		// Sale restrictions
+		{"products.min_age", "ALTER TABLE products ADD COLUMN IF NOT EXISTS min_age INTEGER"},
+		{"products.max_qty_per_invoice", "ALTER TABLE products ADD COLUMN IF NOT EXISTS max_qty_per_invoice INTEGER"},
+		{"products.max_qty_per_customer_day", "ALTER TABLE products ADD COLUMN IF NOT EXISTS max_qty_per_customer_day INTEGER"},
+		{"products.sale_hours_start", "ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_hours_start VARCHAR(5)"},
+		{"products.sale_hours_end", "ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_hours_end VARCHAR(5)"},
+		{"product_categories.min_age", "ALTER TABLE product_categories ADD COLUMN IF NOT EXISTS min_age INTEGER"},
+		{"product_categories.max_qty_per_invoice", "ALTER TABLE product_categories ADD COLUMN IF NOT EXISTS max_qty_per_invoice INTEGER"},
+		{"product_categories.max_qty_per_customer_day", "ALTER TABLE product_categories ADD COLUMN IF NOT EXISTS max_qty_per_customer_day INTEGER"},
+		{"product_categories.sale_hours_start", "ALTER TABLE product_categories ADD COLUMN IF NOT EXISTS sale_hours_start VARCHAR(5)"},
+		{"product_categories.sale_hours_end", "ALTER TABLE product_categories ADD COLUMN IF NOT EXISTS sale_hours_end VARCHAR(5)"},
+		{"sales_invoices.age_verified_by", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS age_verified_by INTEGER"},
+		{"sales_invoices.restriction_override_by", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS restriction_override_by INTEGER"},
+		// Offline sales ingestion
+		{"sales_invoices.client_uuid", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS client_uuid UUID"},
+		{"sales_invoices.synced_at", "ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS synced_at TIMESTAMPTZ"},
+	}
+
+	for _, col := range columns {
+		if err := db.Exec(col.query).Error; err != nil {
+			log.Printf("  ⚠ Failed to add column %s: %v", col.name, err)
+		} else {
+			log.Printf("  ✓ Ensured column: %s", col.name)
+		}
+	}
+
+	return nil
+}
+
 // AddUniqueConstraints adds unique constraints to tables (needed before foreign keys)
 func AddUniqueConstraints(db *gorm.DB) error {
 	constraints := []struct {
@@ -316,6 +564,7 @@ func AddUniqueConstraints(db *gorm.DB) error {
 		{"unique_shelf_batch", "ALTER TABLE shelf_batch_inventory ADD CONSTRAINT unique_shelf_batch UNIQUE (shelf_id, product_id, batch_code)"},
 		{"unique_employee_date", "ALTER TABLE employee_work_hours ADD CONSTRAINT unique_employee_date UNIQUE (employee_id, work_date)"},
 		{"unique_category_days", "ALTER TABLE discount_rules ADD CONSTRAINT unique_category_days UNIQUE (category_id, days_before_expiry)"},
+		{"unique_sequence_series", "ALTER TABLE document_sequence_counters ADD CONSTRAINT unique_sequence_series UNIQUE (document_type, series_key, period_key)"},
 	}
 
 	for _, c := range constraints {
@@ -340,6 +589,72 @@ func AddCustomConstraints(db *gorm.DB) error {
 	}{
 		// Check constraint for product prices
 		{"check_price", "ALTER TABLE products ADD CONSTRAINT check_price CHECK (selling_price > import_price)"},
+		// Check constraint for invoice lifecycle status (recreated so databases migrated without drafts accept them again)
+		{"check_invoice_status", "ALTER TABLE sales_invoices DROP CONSTRAINT IF EXISTS check_invoice_status, ADD CONSTRAINT check_invoice_status CHECK (status IN ('DRAFT', 'COMPLETED', 'VOIDED'))"},
+		// Check constraint for invoice tender types (MIXED only appears on the invoice header)
+		{"check_invoice_payment_method", "ALTER TABLE sales_invoice_payments ADD CONSTRAINT check_invoice_payment_method CHECK (payment_method IN ('CASH', 'CARD', 'TRANSFER', 'VOUCHER'))"},
+		// Check constraint for register session status
+		{"check_register_session_status", "ALTER TABLE register_sessions ADD CONSTRAINT check_register_session_status CHECK (status IN ('OPEN', 'CLOSED'))"},
+		// Check constraint for POS cart status (recreated so databases migrated before parking get the new states)
+		{"check_pos_cart_status", "ALTER TABLE pos_carts DROP CONSTRAINT IF EXISTS check_pos_cart_status, ADD CONSTRAINT check_pos_cart_status CHECK (status IN ('OPEN', 'PARKED', 'COMPLETED', 'CANCELLED', 'EXPIRED'))"},
+		// Check constraints for promotions
+		{"check_promotion_type", "ALTER TABLE promotions ADD CONSTRAINT check_promotion_type CHECK (promotion_type IN ('BUY_X_GET_Y', 'MULTI_BUY', 'COMBO', 'SPEND_THRESHOLD', 'PERCENT_OFF', 'HAPPY_HOUR'))"},
+		{"check_promotion_dates", "ALTER TABLE promotions ADD CONSTRAINT check_promotion_dates CHECK (end_date >= start_date)"},
+		{"check_promotion_item_target", "ALTER TABLE promotion_items ADD CONSTRAINT check_promotion_item_target CHECK (num_nonnulls(product_id, category_id, brand) = 1)"},
+		// Check constraints for vouchers and their ledger
+		{"check_voucher_kind", "ALTER TABLE vouchers ADD CONSTRAINT check_voucher_kind CHECK (kind IN ('VOUCHER', 'GIFT_CARD'))"},
+		{"check_voucher_status", "ALTER TABLE vouchers ADD CONSTRAINT check_voucher_status CHECK (status IN ('ACTIVE', 'REDEEMED', 'EXPIRED', 'CANCELLED'))"},
+		{"check_voucher_entry_type", "ALTER TABLE voucher_transactions ADD CONSTRAINT check_voucher_entry_type CHECK (entry_type IN ('ISSUE', 'TOPUP', 'REDEEM', 'REVERSAL', 'EXPIRE', 'CANCEL', 'FORFEIT'))"},
+		{"check_voucher_balance_after", "ALTER TABLE voucher_transactions ADD CONSTRAINT check_voucher_balance_after CHECK (balance_after >= 0)"},
+		// Check constraints for tax modes
+		{"check_tax_class_mode", "ALTER TABLE tax_classes ADD CONSTRAINT check_tax_class_mode CHECK (tax_mode IN ('INCLUSIVE', 'EXCLUSIVE'))"},
+		{"check_sales_detail_tax_mode", "ALTER TABLE sales_invoice_details ADD CONSTRAINT check_sales_detail_tax_mode CHECK (tax_mode IN ('INCLUSIVE', 'EXCLUSIVE'))"},
+		// Check constraint for document numbering resets
+		{"check_sequence_reset_policy", "ALTER TABLE document_sequences ADD CONSTRAINT check_sequence_reset_policy CHECK (reset_policy IN ('NEVER', 'DAILY', 'MONTHLY'))"},
+		// Check constraints for the e-invoice export log
+		{"check_einvoice_export_type", "ALTER TABLE einvoice_exports ADD CONSTRAINT check_einvoice_export_type CHECK (export_type IN ('ORIGINAL', 'REPLACEMENT', 'ADJUSTMENT'))"},
+		{"check_einvoice_status", "ALTER TABLE einvoice_exports ADD CONSTRAINT check_einvoice_status CHECK (status IN ('SIGNED', 'REPLACED', 'FAILED'))"},
+		// Check constraint for membership tier history
+		{"check_tier_change_type", "ALTER TABLE membership_tier_history ADD CONSTRAINT check_tier_change_type CHECK (change_type IN ('UPGRADE', 'DOWNGRADE', 'GRACE_STARTED', 'GRACE_CLEARED', 'MANUAL'))"},
+		// Check constraints for the loyalty points ledger
+		{"check_loyalty_entry_type", "ALTER TABLE loyalty_transactions ADD CONSTRAINT check_loyalty_entry_type CHECK (entry_type IN ('EARN', 'REDEEM', 'ADJUST', 'EXPIRE', 'REVERSE'))"},
+		{"check_loyalty_balance_after", "ALTER TABLE loyalty_transactions ADD CONSTRAINT check_loyalty_balance_after CHECK (balance_after >= 0)"},
+		{"check_loyalty_remaining", "ALTER TABLE loyalty_transactions ADD CONSTRAINT check_loyalty_remaining CHECK (remaining >= 0 AND remaining <= GREATEST(points, 0))"},
+		{"check_loyalty_lot_usage_points", "ALTER TABLE loyalty_lot_usages ADD CONSTRAINT check_loyalty_lot_usage_points CHECK (points <> 0)"},
+		// Check constraints for price override authorization
+		{"check_position_override_limits", "ALTER TABLE positions ADD CONSTRAINT check_position_override_limits CHECK ((override_max_percent IS NULL OR override_max_percent BETWEEN 0 AND 100) AND (override_max_amount IS NULL OR override_max_amount >= 0))"},
+		{"check_sales_detail_override_type", "ALTER TABLE sales_invoice_details ADD CONSTRAINT check_sales_detail_override_type CHECK (override_type IS NULL OR override_type IN ('PRICE', 'DISCOUNT'))"},
+		// Check constraints for sale restrictions
+		{"check_product_sale_restriction", "ALTER TABLE products ADD CONSTRAINT check_product_sale_restriction CHECK ((min_age IS NULL OR min_age BETWEEN 1 AND 99) AND (max_qty_per_invoice IS NULL OR max_qty_per_invoice > 0) AND (max_qty_per_customer_day IS NULL OR max_qty_per_customer_day > 0))"},
+		{"check_product_sale_hours", "ALTER TABLE products ADD CONSTRAINT check_product_sale_hours CHECK ((sale_hours_start IS NULL) = (sale_hours_end IS NULL) AND (sale_hours_start IS NULL OR (sale_hours_start ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$' AND sale_hours_end ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$' AND sale_hours_start <> sale_hours_end)))"},
+		{"check_category_sale_restriction", "ALTER TABLE product_categories ADD CONSTRAINT check_category_sale_restriction CHECK ((min_age IS NULL OR min_age BETWEEN 1 AND 99) AND (max_qty_per_invoice IS NULL OR max_qty_per_invoice > 0) AND (max_qty_per_customer_day IS NULL OR max_qty_per_customer_day > 0))"},
+		{"check_category_sale_hours", "ALTER TABLE product_categories ADD CONSTRAINT check_category_sale_hours CHECK ((sale_hours_start IS NULL) = (sale_hours_end IS NULL) AND (sale_hours_start IS NULL OR (sale_hours_start ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$' AND sale_hours_end ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$' AND sale_hours_start <> sale_hours_end)))"},
+		// Check constraints for offline sale stock shortages
+		{"check_stock_shortage_status", "ALTER TABLE stock_shortages ADD CONSTRAINT check_stock_shortage_status CHECK (status IN ('OPEN', 'SETTLED', 'DISMISSED', 'CANCELLED'))"},
+		{"check_stock_shortage_settled", "ALTER TABLE stock_shortages ADD CONSTRAINT check_stock_shortage_settled CHECK (settled_quantity >= 0 AND settled_quantity <= quantity)"},
+		// Check constraints for stock counts
+		{"check_stock_count_scope", "ALTER TABLE stock_counts ADD CONSTRAINT check_stock_count_scope CHECK ((scope = 'WAREHOUSE' AND warehouse_id IS NOT NULL) OR (scope = 'SHELF' AND shelf_id IS NOT NULL) OR (scope = 'CATEGORY' AND category_id IS NOT NULL))"},
+		{"check_stock_count_status", "ALTER TABLE stock_counts ADD CONSTRAINT check_stock_count_status CHECK (status IN ('COUNTING', 'RECOUNT', 'SUBMITTED', 'APPROVED', 'CANCELLED'))"},
+		{"check_stock_count_threshold", "ALTER TABLE stock_counts ADD CONSTRAINT check_stock_count_threshold CHECK (recount_threshold_percent >= 0)"},
+		{"check_stock_count_line_location", "ALTER TABLE stock_count_lines ADD CONSTRAINT check_stock_count_line_location CHECK ((location = 'WAREHOUSE' AND warehouse_id IS NOT NULL AND shelf_id IS NULL) OR (location = 'SHELF' AND shelf_id IS NOT NULL AND warehouse_id IS NULL))"},
+		{"check_stock_count_line_quantities", "ALTER TABLE stock_count_lines ADD CONSTRAINT check_stock_count_line_quantities CHECK (system_quantity >= 0 AND (counted_quantity IS NULL OR counted_quantity >= 0) AND (recount_quantity IS NULL OR recount_quantity >= 0))"},
+		{"check_stock_count_line_reason", "ALTER TABLE stock_count_lines ADD CONSTRAINT check_stock_count_line_reason CHECK (reason_code IS NULL OR reason_code IN ('DAMAGED', 'THEFT', 'EXPIRED', 'RECORDING_ERROR', 'FOUND', 'UNKNOWN'))"},
+		// Check constraints for disposals
+		{"check_disposal_reason", "ALTER TABLE disposals ADD CONSTRAINT check_disposal_reason CHECK (reason IN ('EXPIRED', 'DAMAGED', 'THEFT', 'DONATION', 'SUPPLIER_RETURN'))"},
+		{"check_disposal_line_location", "ALTER TABLE disposal_lines ADD CONSTRAINT check_disposal_line_location CHECK ((location = 'WAREHOUSE' AND warehouse_id IS NOT NULL AND shelf_id IS NULL) OR (location = 'SHELF' AND shelf_id IS NOT NULL AND warehouse_id IS NULL))"},
+		{"check_disposal_line_quantity", "ALTER TABLE disposal_lines ADD CONSTRAINT check_disposal_line_quantity CHECK (quantity > 0 AND unit_cost >= 0)"},
+		// Check constraints for transfer orders
+		{"check_transfer_order_source", "ALTER TABLE transfer_orders ADD CONSTRAINT check_transfer_order_source CHECK ((from_location = 'WAREHOUSE' AND from_warehouse_id IS NOT NULL AND from_shelf_id IS NULL AND from_warehouse_id <> to_warehouse_id) OR (from_location = 'SHELF' AND from_shelf_id IS NOT NULL AND from_warehouse_id IS NULL))"},
+		{"check_transfer_order_status", "ALTER TABLE transfer_orders ADD CONSTRAINT check_transfer_order_status CHECK (status IN ('IN_TRANSIT', 'RECEIVED', 'CANCELLED'))"},
+		{"check_transfer_order_line_quantities", "ALTER TABLE transfer_order_lines ADD CONSTRAINT check_transfer_order_line_quantities CHECK (dispatched_quantity > 0 AND (received_quantity IS NULL OR received_quantity BETWEEN 0 AND dispatched_quantity))"},
+		{"check_transfer_order_line_reason", "ALTER TABLE transfer_order_lines ADD CONSTRAINT check_transfer_order_line_reason CHECK (discrepancy_reason IS NULL OR discrepancy_reason IN ('DAMAGED', 'THEFT', 'RECORDING_ERROR', 'UNKNOWN'))"},
+		// Check constraints for purchase orders and goods receipts
+		{"check_purchase_order_status", "ALTER TABLE purchase_orders ADD CONSTRAINT check_purchase_order_status CHECK (status IN ('PENDING', 'APPROVED', 'PARTIALLY_RECEIVED', 'RECEIVED', 'CLOSED_SHORT', 'CANCELLED'))"},
+		{"check_goods_receipt_line_quantities", "ALTER TABLE goods_receipt_lines ADD CONSTRAINT check_goods_receipt_line_quantities CHECK (received_quantity > 0 AND rejected_quantity BETWEEN 0 AND received_quantity AND unit_cost >= 0)"},
+		// Check constraint for return dispositions
+		{"check_return_disposition", "ALTER TABLE sales_return_details ADD CONSTRAINT check_return_disposition CHECK (disposition IN ('RESTOCK', 'DAMAGED'))"},
+		// Check constraint for return refund tenders
+		{"check_return_refund_method", "ALTER TABLE sales_returns ADD CONSTRAINT check_return_refund_method CHECK (refund_method IN ('CASH', 'CARD', 'TRANSFER'))"},
 	}
 
 	for _, c := range constraints {
@@ -379,7 +694,82 @@ func CreateIndexes(db *gorm.DB) error {
 		{"idx_sales_invoice_date", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_date ON sales_invoices(invoice_date)"},
 		{"idx_sales_invoice_customer", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_customer ON sales_invoices(customer_id)"},
 		{"idx_sales_invoice_employee", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_employee ON sales_invoices(employee_id)"},
+		{"idx_sales_invoice_status", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_status ON sales_invoices(status)"},
 		{"idx_sales_details_product", "CREATE INDEX IF NOT EXISTS idx_sales_details_product ON sales_invoice_details(product_id)"},
+		{"idx_sales_allocations_detail", "CREATE INDEX IF NOT EXISTS idx_sales_allocations_detail ON sales_invoice_allocations(detail_id)"},
+		{"idx_sales_allocations_batch", "CREATE INDEX IF NOT EXISTS idx_sales_allocations_batch ON sales_invoice_allocations(shelf_id, batch_code)"},
+		{"idx_sales_payments_invoice", "CREATE INDEX IF NOT EXISTS idx_sales_payments_invoice ON sales_invoice_payments(invoice_id)"},
+		{"idx_sales_payments_method", "CREATE INDEX IF NOT EXISTS idx_sales_payments_method ON sales_invoice_payments(payment_method)"},
+		{"idx_sales_invoice_session", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_session ON sales_invoices(session_id)"},
+		{"idx_sales_invoice_client_uuid", "CREATE UNIQUE INDEX IF NOT EXISTS idx_sales_invoice_client_uuid ON sales_invoices(client_uuid) WHERE client_uuid IS NOT NULL"},
+		{"idx_stock_shortages_open", "CREATE INDEX IF NOT EXISTS idx_stock_shortages_open ON stock_shortages(product_id) WHERE status = 'OPEN'"},
+		{"idx_stock_shortages_detail", "CREATE INDEX IF NOT EXISTS idx_stock_shortages_detail ON stock_shortages(detail_id)"},
+
+		// Stock count indexes
+		{"idx_stock_counts_status", "CREATE INDEX IF NOT EXISTS idx_stock_counts_status ON stock_counts(status, created_at)"},
+		{"idx_stock_count_lines_product", "CREATE INDEX IF NOT EXISTS idx_stock_count_lines_product ON stock_count_lines(product_id)"},
+
+		// Disposal indexes
+		{"idx_disposals_disposed_at", "CREATE INDEX IF NOT EXISTS idx_disposals_disposed_at ON disposals(disposed_at)"},
+		{"idx_disposal_lines_product", "CREATE INDEX IF NOT EXISTS idx_disposal_lines_product ON disposal_lines(product_id)"},
+
+		// Transfer order indexes
+		{"idx_transfer_orders_status", "CREATE INDEX IF NOT EXISTS idx_transfer_orders_status ON transfer_orders(status, dispatched_at)"},
+		{"idx_transfer_order_lines_product", "CREATE INDEX IF NOT EXISTS idx_transfer_order_lines_product ON transfer_order_lines(product_id)"},
+
+		// Goods receipt indexes
+		{"idx_goods_receipts_received_at", "CREATE INDEX IF NOT EXISTS idx_goods_receipts_received_at ON goods_receipts(received_at)"},
+
+		// Register session indexes (one open session per till)
+		{"idx_register_sessions_open", "CREATE UNIQUE INDEX IF NOT EXISTS idx_register_sessions_open ON register_sessions(register_code) WHERE status = 'OPEN'"},
+		{"idx_register_sessions_employee", "CREATE INDEX IF NOT EXISTS idx_register_sessions_employee ON register_sessions(employee_id)"},
+		{"idx_register_session_totals_session", "CREATE UNIQUE INDEX IF NOT EXISTS idx_register_session_totals_session ON register_session_totals(session_id, payment_method)"},
+
+		// POS cart indexes (one line per product in a cart)
+		{"idx_pos_carts_status", "CREATE INDEX IF NOT EXISTS idx_pos_carts_status ON pos_carts(status)"},
+		{"idx_pos_carts_employee", "CREATE INDEX IF NOT EXISTS idx_pos_carts_employee ON pos_carts(employee_id)"},
+		{"idx_pos_cart_items_product", "CREATE UNIQUE INDEX IF NOT EXISTS idx_pos_cart_items_product ON pos_cart_items(cart_id, product_id)"},
+		{"idx_pos_carts_parked", "CREATE INDEX IF NOT EXISTS idx_pos_carts_parked ON pos_carts(expires_at) WHERE status = 'PARKED'"},
+
+		// Promotion indexes
+		{"idx_promotions_active_dates", "CREATE INDEX IF NOT EXISTS idx_promotions_active_dates ON promotions(start_date, end_date) WHERE is_active = true"},
+		{"idx_promotion_items_promotion", "CREATE INDEX IF NOT EXISTS idx_promotion_items_promotion ON promotion_items(promotion_id)"},
+		{"idx_sales_details_promotion", "CREATE INDEX IF NOT EXISTS idx_sales_details_promotion ON sales_invoice_details(promotion_id)"},
+		{"idx_sales_invoice_promotions_promotion", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_promotions_promotion ON sales_invoice_promotions(promotion_id)"},
+		{"idx_sales_invoice_promotions_detail", "CREATE INDEX IF NOT EXISTS idx_sales_invoice_promotions_detail ON sales_invoice_promotions(detail_id)"},
+
+		// Voucher indexes
+		{"idx_vouchers_status_expiry", "CREATE INDEX IF NOT EXISTS idx_vouchers_status_expiry ON vouchers(status, expires_at)"},
+		{"idx_voucher_transactions_voucher", "CREATE INDEX IF NOT EXISTS idx_voucher_transactions_voucher ON voucher_transactions(voucher_id)"},
+		{"idx_voucher_transactions_invoice", "CREATE INDEX IF NOT EXISTS idx_voucher_transactions_invoice ON voucher_transactions(invoice_id)"},
+		{"idx_voucher_transactions_created", "CREATE INDEX IF NOT EXISTS idx_voucher_transactions_created ON voucher_transactions(created_at)"},
+
+		// Price override indexes
+		{"idx_sales_details_override_approver", "CREATE INDEX IF NOT EXISTS idx_sales_details_override_approver ON sales_invoice_details(override_approved_by) WHERE override_approved_by IS NOT NULL"},
+
+		// Tax indexes
+		{"idx_sales_details_tax_rate", "CREATE INDEX IF NOT EXISTS idx_sales_details_tax_rate ON sales_invoice_details(tax_rate, tax_mode)"},
+
+		// Sales return indexes
+		{"idx_sales_returns_invoice", "CREATE INDEX IF NOT EXISTS idx_sales_returns_invoice ON sales_returns(invoice_id)"},
+		{"idx_sales_returns_date", "CREATE INDEX IF NOT EXISTS idx_sales_returns_date ON sales_returns(return_date)"},
+		{"idx_sales_returns_session", "CREATE INDEX IF NOT EXISTS idx_sales_returns_session ON sales_returns(session_id)"},
+		{"idx_product_price_history_effective", "CREATE INDEX IF NOT EXISTS idx_product_price_history_effective ON product_price_history(product_id, effective_from)"},
+		{"idx_sales_return_details_invoice_detail", "CREATE INDEX IF NOT EXISTS idx_sales_return_details_invoice_detail ON sales_return_details(invoice_detail_id)"},
+		{"idx_damaged_stock_product", "CREATE INDEX IF NOT EXISTS idx_damaged_stock_product ON damaged_stock(product_id)"},
+
+		// E-invoice indexes
+		{"idx_einvoice_exports_invoice", "CREATE INDEX IF NOT EXISTS idx_einvoice_exports_invoice ON einvoice_exports(invoice_id)"},
+		{"idx_einvoice_exports_created", "CREATE INDEX IF NOT EXISTS idx_einvoice_exports_created ON einvoice_exports(created_at)"},
+
+		// Membership tier indexes
+		{"idx_membership_tier_history_customer", "CREATE INDEX IF NOT EXISTS idx_membership_tier_history_customer ON membership_tier_history(customer_id, created_at)"},
+
+		// Loyalty ledger indexes
+		{"idx_loyalty_transactions_customer", "CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_customer ON loyalty_transactions(customer_id, created_at)"},
+		{"idx_loyalty_transactions_invoice", "CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_invoice ON loyalty_transactions(invoice_id)"},
+		{"idx_loyalty_transactions_open_lots", "CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_open_lots ON loyalty_transactions(expires_at) WHERE remaining > 0"},
+		{"idx_loyalty_lot_usages_entry", "CREATE INDEX IF NOT EXISTS idx_loyalty_lot_usages_entry ON loyalty_lot_usages(entry_id)"},
 
 		// Employee and customer indexes
 		{"idx_employee_position", "CREATE INDEX IF NOT EXISTS idx_employee_position ON employees(position_id)"},
@@ -409,6 +799,98 @@ func SyncIndexes(db *gorm.DB) error {
 	return CreateIndexes(db)
 }
 
+// EnsureDocumentSequences inserts the default numbering rule of every document type.
+// Existing rules are left alone so prefixes changed by the user survive a restart.
+func EnsureDocumentSequences(db *gorm.DB) error {
+	sequences := []models.DocumentSequence{
+		{DocumentType: models.DocSalesInvoice, Prefix: "HD", ResetPolicy: models.SequenceResetDaily, PerTill: true, Padding: 4},
+		{DocumentType: models.DocSalesReturn, Prefix: "TH", ResetPolicy: models.SequenceResetDaily, Padding: 4},
+		{DocumentType: models.DocPurchaseOrder, Prefix: "PO", ResetPolicy: models.SequenceResetMonthly, Padding: 4},
+		{DocumentType: models.DocStockTransfer, Prefix: "TR", ResetPolicy: models.SequenceResetDaily, Padding: 4},
+		{DocumentType: models.DocStockCount, Prefix: "KK", ResetPolicy: models.SequenceResetMonthly, Padding: 4},
+		{DocumentType: models.DocDisposal, Prefix: "XH", ResetPolicy: models.SequenceResetMonthly, Padding: 4},
+		{DocumentType: models.DocTransferOrder, Prefix: "DC", ResetPolicy: models.SequenceResetMonthly, Padding: 4},
+		{DocumentType: models.DocGoodsReceipt, Prefix: "NK", ResetPolicy: models.SequenceResetMonthly, Padding: 4},
+		{DocumentType: models.DocEInvoice, Prefix: "HDDT", ResetPolicy: models.SequenceResetNever, PerTill: true, Padding: 8},
+	}
+
+	for _, seq := range sequences {
+		err := db.Exec(`
+			INSERT INTO document_sequences (document_type, prefix, reset_policy, per_till, padding, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (document_type) DO NOTHING
+		`, seq.DocumentType, seq.Prefix, seq.ResetPolicy, seq.PerTill, seq.Padding).Error
+		if err != nil {
+			log.Printf("  ⚠ Failed to ensure document sequence %s: %v", seq.DocumentType, err)
+		} else {
+			log.Printf("  ✓ Ensured document sequence: %s", seq.DocumentType)
+		}
+	}
+
+	return nil
+}
+
+// EnsureLoyaltyOpeningBalances gives every customer whose points predate the points ledger an
+// ADJUST entry for the balance, so that the balance equals the sum of the ledger. The points
+// get the default validity from now on.
+func EnsureLoyaltyOpeningBalances(db *gorm.DB) error {
+	result := db.Exec(`
+		INSERT INTO loyalty_transactions (customer_id, entry_type, points, balance_after, remaining, expires_at, notes, created_at)
+		SELECT c.customer_id, $1, c.loyalty_points, c.loyalty_points, c.loyalty_points,
+			CURRENT_DATE + make_interval(months => $2), 'Số dư điểm đầu kỳ', CURRENT_TIMESTAMP
+		FROM customers c
+		WHERE c.loyalty_points > 0
+		  AND NOT EXISTS (SELECT 1 FROM loyalty_transactions lt WHERE lt.customer_id = c.customer_id)
+	`, models.LoyaltyEntryAdjust, models.DefaultLoyaltyExpiryMonths)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("  ✓ Opened loyalty balances of %d customers", result.RowsAffected)
+	}
+	return nil
+}
+
+// EnsureOverrideLimits lets the manager and supervisor positions approve any override while no
+// position has override limits yet, which is how overrides were approved before the limits
+// existed. Once any position has limits they are left to the user.
+func EnsureOverrideLimits(db *gorm.DB) error {
+	result := db.Exec(`
+		UPDATE positions
+		SET override_max_percent = 100
+		WHERE position_code IN ($1, $2)
+		  AND NOT EXISTS (
+			SELECT 1 FROM positions
+			WHERE override_max_percent IS NOT NULL OR override_max_amount IS NOT NULL
+		  )
+	`, models.PositionCodeManager, models.PositionCodeSupervisor)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("  ✓ Set override limits of %d positions", result.RowsAffected)
+	}
+	return nil
+}
+
+// EnsurePriceHistory gives every product without a price history an entry for its current
+// selling price, effective from when the product was created
+func EnsurePriceHistory(db *gorm.DB) error {
+	result := db.Exec(`
+		INSERT INTO product_price_history (product_id, selling_price, effective_from)
+		SELECT p.product_id, p.selling_price, COALESCE(p.created_at, CURRENT_TIMESTAMP)
+		FROM products p
+		WHERE NOT EXISTS (SELECT 1 FROM product_price_history h WHERE h.product_id = p.product_id)
+	`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("  ✓ Opened price history of %d products", result.RowsAffected)
+	}
+	return nil
+}
+
 // CreateTriggers creates all database triggers for the supermarket system
 func CreateTriggers(db *gorm.DB) error {
 	triggerFiles := []string{
@@ -23,15 +23,13 @@ type RealisticSimulation struct {
 	suppliers          []models.Supplier
 	warehouses         []models.Warehouse
 	shelves            []models.DisplayShelf
-	orderCounter       int
-	transferCounter    int
-	invoiceCounter     int
 	dailySalesTarget   map[uint]int // Target daily sales per product
 	productRestockDays map[uint]int // Last restock day for each product
+	pointsExpiryMonths int          // Validity of earned points, 0 = never expire
 }
 
 // RunRealisticSimulation executes a realistic 30-day simulation
-func RunRealisticSimulation(db *gorm.DB, startDate, endDate time.Time) error {
+func RunRealisticSimulation(db *gorm.DB, startDate, endDate time.Time, pointsExpiryMonths int) error {
 	sim := &RealisticSimulation{
 		db:                 db,
 		startDate:          startDate,
@@ -39,6 +37,7 @@ func RunRealisticSimulation(db *gorm.DB, startDate, endDate time.Time) error {
 		currentDate:        startDate,
 		dailySalesTarget:   make(map[uint]int),
 		productRestockDays: make(map[uint]int),
+		pointsExpiryMonths: pointsExpiryMonths,
 	}
 
 	// Initialize simulation
@@ -73,22 +72,6 @@ func (s *RealisticSimulation) initialize() error {
 		return fmt.Errorf("failed to load shelves: %w", err)
 	}
 
-	// Initialize counters
-	var lastOrder models.PurchaseOrder
-	if err := s.db.Order("order_id desc").First(&lastOrder).Error; err == nil {
-		fmt.Sscanf(lastOrder.OrderNo, "PO%*d%d", &s.orderCounter)
-	}
-
-	var lastTransfer models.StockTransfer
-	if err := s.db.Order("transfer_id desc").First(&lastTransfer).Error; err == nil {
-		fmt.Sscanf(lastTransfer.TransferCode, "ST%*d%d", &s.transferCounter)
-	}
-
-	var lastInvoice models.SalesInvoice
-	if err := s.db.Order("invoice_id desc").First(&lastInvoice).Error; err == nil {
-		fmt.Sscanf(lastInvoice.InvoiceNo, "INV%*d%d", &s.invoiceCounter)
-	}
-
 	// Calculate daily sales targets (to sell warehouse stock in ~3 days)
 	for _, product := range s.products {
 		// Base on category: Food/Beverages = high volume, Electronics = low volume
@@ -348,11 +331,15 @@ func (s *RealisticSimulation) createSale() error {
 	}
 
 	employee := s.getRandomEmployee("cashier")
-	s.invoiceCounter++
-	invoiceNo := fmt.Sprintf("INV%s%04d", s.currentDate.Format("200601"), s.invoiceCounter)
 
 	tx := s.db.Begin()
 
+	invoiceNo, err := s.nextDocumentNo(tx, models.DocSalesInvoice)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	paymentMethod := s.getPaymentMethod()
 	invoice := models.SalesInvoice{
 		InvoiceNo:     invoiceNo,
@@ -369,22 +356,21 @@ func (s *RealisticSimulation) createSale() error {
 
 	// Buy 1-8 random items
 	numItems := 1 + rand.Intn(8)
-	var subtotal float64
 	var itemsAdded int
 
 	for j := 0; j < numItems; j++ {
 		// Get random available product from shelf
 		var availableProduct struct {
-			ShelfBatchID uint
 			ProductID    uint
 			Quantity     int
 			CurrentPrice float64
 		}
 
 		if err := tx.Raw(`
-			SELECT shelf_batch_id, product_id, quantity, current_price
+			SELECT product_id, quantity, current_price
 			FROM shelf_batch_inventory
 			WHERE quantity > 0
+			  AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
 			ORDER BY RANDOM()
 			LIMIT 1
 		`).Scan(&availableProduct).Error; err != nil || availableProduct.ProductID == 0 {
@@ -407,28 +393,13 @@ func (s *RealisticSimulation) createSale() error {
 			Subtotal:  itemSubtotal,
 		}
 
+		// deduct_sales_line_stock takes the sold quantity off the shelf
+		// batches and the shelf summary
 		if err := tx.Create(&detail).Error; err != nil {
 			tx.Rollback()
 			return err
 		}
 
-		// Deduct from shelf batch - ensure no negative quantities
-		if err := tx.Model(&models.ShelfBatchInventory{}).
-			Where("shelf_batch_id = ? AND quantity >= ?", availableProduct.ShelfBatchID, purchaseQty).
-			Update("quantity", gorm.Expr("quantity - ?", purchaseQty)).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Update shelf inventory - ensure no negative quantities
-		if err := tx.Model(&models.ShelfInventory{}).
-			Where("product_id = ? AND current_quantity >= ?", availableProduct.ProductID, purchaseQty).
-			Update("current_quantity", gorm.Expr("current_quantity - ?", purchaseQty)).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		subtotal += itemSubtotal
 		itemsAdded++
 	}
 
@@ -438,28 +409,44 @@ func (s *RealisticSimulation) createSale() error {
 		return fmt.Errorf("no items could be added to invoice")
 	}
 
-	// Update invoice totals
-	taxAmount := subtotal * 0.10
-	totalAmount := subtotal + taxAmount
-
-	if err := tx.Model(&invoice).Updates(map[string]interface{}{
-		"subtotal":     subtotal,
-		"tax_amount":   taxAmount,
-		"total_amount": totalAmount,
-	}).Error; err != nil {
+	// Invoice totals, including the per-line tax of each product's tax class,
+	// are kept up to date by the detail triggers
+	var totalAmount float64
+	if err := tx.Raw("SELECT total_amount FROM supermarket.sales_invoices WHERE invoice_id = ?", invoice.InvoiceID).Scan(&totalAmount).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Update customer if applicable
+	// Update customer if applicable; the points the trigger computed go through the points ledger
 	if customerID != nil {
-		points := int(totalAmount / 10000)
 		tx.Model(&models.Customer{}).
 			Where("customer_id = ?", *customerID).
-			Updates(map[string]interface{}{
-				"total_spending": gorm.Expr("total_spending + ?", totalAmount),
-				"loyalty_points": gorm.Expr("loyalty_points + ?", points),
+			Update("total_spending", gorm.Expr("total_spending + ?", totalAmount))
+
+		var points int
+		tx.Raw("SELECT points_earned FROM supermarket.sales_invoices WHERE invoice_id = ?", invoice.InvoiceID).Scan(&points)
+		if points > 0 {
+			var balance int
+			tx.Raw(`
+				UPDATE supermarket.customers SET loyalty_points = loyalty_points + ?
+				WHERE customer_id = ?
+				RETURNING loyalty_points
+			`, points, *customerID).Scan(&balance)
+			var expiresAt *time.Time
+			if s.pointsExpiryMonths > 0 {
+				expiresAt = timePtr(invoice.InvoiceDate.AddDate(0, s.pointsExpiryMonths, 0))
+			}
+			tx.Create(&models.LoyaltyTransaction{
+				CustomerID:   *customerID,
+				EntryType:    models.LoyaltyEntryEarn,
+				Points:       points,
+				BalanceAfter: balance,
+				Remaining:    points,
+				ExpiresAt:    expiresAt,
+				InvoiceID:    &invoice.InvoiceID,
+				CreatedAt:    invoice.InvoiceDate,
 			})
+		}
 	}
 
 	return tx.Commit().Error
@@ -504,12 +491,9 @@ func (s *RealisticSimulation) createEndScenarios() {
 // Helper methods
 
 func (s *RealisticSimulation) createPurchaseOrder(supplierID uint) (*models.PurchaseOrder, error) {
-	s.orderCounter++
-	orderNo := fmt.Sprintf("PO%s%03d", s.currentDate.Format("200601"), s.orderCounter)
 	employee := s.getRandomEmployee("manager")
 
 	order := models.PurchaseOrder{
-		OrderNo:    orderNo,
 		SupplierID: supplierID,
 		EmployeeID: employee.EmployeeID,
 		OrderDate:  s.currentDate,
@@ -519,13 +503,30 @@ func (s *RealisticSimulation) createPurchaseOrder(supplierID uint) (*models.Purc
 	deliveryDate := s.currentDate
 	order.DeliveryDate = &deliveryDate
 
-	if err := s.db.Create(&order).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		orderNo, err := s.nextDocumentNo(tx, models.DocPurchaseOrder)
+		if err != nil {
+			return err
+		}
+		order.OrderNo = orderNo
+		return tx.Create(&order).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &order, nil
 }
 
+// nextDocumentNo numbers a simulated document like the application does, dated on the simulated day
+func (s *RealisticSimulation) nextDocumentNo(tx *gorm.DB, docType models.DocumentType) (string, error) {
+	var documentNo string
+	if err := tx.Raw("SELECT supermarket.next_document_no(?, NULL, ?)", docType, s.currentDate).Scan(&documentNo).Error; err != nil {
+		return "", fmt.Errorf("failed to allocate %s number: %w", docType, err)
+	}
+	return documentNo, nil
+}
+
 func (s *RealisticSimulation) orderProduct(product models.Product, quantity int) error {
 	// DEBUG: Add validation and logging
 	if quantity < 0 {
@@ -637,8 +638,10 @@ func (s *RealisticSimulation) transferToShelfWithTx(tx *gorm.DB, product models.
 		return err
 	}
 
-	s.transferCounter++
-	transferCode := fmt.Sprintf("ST%s%04d", s.currentDate.Format("200601"), s.transferCounter)
+	transferCode, err := s.nextDocumentNo(tx, models.DocStockTransfer)
+	if err != nil {
+		return err
+	}
 
 	// Create stock transfer record
 	transfer := models.StockTransfer{
@@ -655,58 +658,16 @@ func (s *RealisticSimulation) transferToShelfWithTx(tx *gorm.DB, product models.
 		SellingPrice:    product.SellingPrice,
 	}
 
-	if err := tx.Create(&transfer).Error; err != nil {
-		return err
-	}
-
-	// Update warehouse - ensure no negative quantities
 	if warehouseInv.Quantity < quantity {
 		return fmt.Errorf("insufficient warehouse stock: have %d, need %d", warehouseInv.Quantity, quantity)
 	}
 
-	// Calculate new quantity and update directly
-	newQuantity := warehouseInv.Quantity - quantity
-	if err := tx.Model(&warehouseInv).Update("quantity", newQuantity).Error; err != nil {
-		return err
-	}
-
-	// Create shelf batch
-	shelfBatch := models.ShelfBatchInventory{
-		ShelfID:      shelf.ShelfID,
-		ProductID:    product.ProductID,
-		BatchCode:    warehouseInv.BatchCode,
-		Quantity:     quantity,
-		ExpiryDate:   warehouseInv.ExpiryDate,
-		StockedDate:  s.currentDate,
-		ImportPrice:  warehouseInv.ImportPrice,
-		CurrentPrice: product.SellingPrice,
-	}
-
-	if err := tx.Create(&shelfBatch).Error; err != nil {
+	// The transfer triggers deduct the warehouse batch and stock the shelf
+	// batch and shelf summary
+	if err := tx.Create(&transfer).Error; err != nil {
 		return err
 	}
 
-	// Update shelf inventory
-	var shelfInv models.ShelfInventory
-	if err := tx.Where("shelf_id = ? AND product_id = ?", shelf.ShelfID, product.ProductID).
-		First(&shelfInv).Error; err == gorm.ErrRecordNotFound {
-
-		shelfInv = models.ShelfInventory{
-			ShelfID:         shelf.ShelfID,
-			ProductID:       product.ProductID,
-			CurrentQuantity: quantity,
-			LastRestocked:   s.currentDate,
-		}
-		if err := tx.Create(&shelfInv).Error; err != nil {
-			return err
-		}
-	} else {
-		if err := tx.Model(&shelfInv).
-			Update("current_quantity", gorm.Expr("current_quantity + ?", quantity)).Error; err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
@@ -725,8 +686,10 @@ func (s *RealisticSimulation) transferToShelfInitial(tx *gorm.DB, product models
 		return err
 	}
 
-	s.transferCounter++
-	transferCode := fmt.Sprintf("ST%s%04d", s.currentDate.Format("200601"), s.transferCounter)
+	transferCode, err := s.nextDocumentNo(tx, models.DocStockTransfer)
+	if err != nil {
+		return err
+	}
 
 	// Create transfer
 	transfer := models.StockTransfer{
@@ -743,64 +706,18 @@ func (s *RealisticSimulation) transferToShelfInitial(tx *gorm.DB, product models
 		SellingPrice:    product.SellingPrice,
 	}
 
-	if err := tx.Create(&transfer).Error; err != nil {
-		return err
-	}
-
-	// Update warehouse - ensure no negative quantities
 	if warehouseInv.Quantity < quantity {
 		tx.Rollback()
 		return fmt.Errorf("insufficient warehouse stock: have %d, need %d", warehouseInv.Quantity, quantity)
 	}
-	// Calculate new quantity and update directly
-	newQuantity := warehouseInv.Quantity - quantity
-	if err := tx.Model(&warehouseInv).Update("quantity", newQuantity).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
 
-	// Create shelf batch
-	shelfBatch := models.ShelfBatchInventory{
-		ShelfID:      shelf.ShelfID,
-		ProductID:    product.ProductID,
-		BatchCode:    warehouseInv.BatchCode,
-		Quantity:     quantity,
-		ExpiryDate:   warehouseInv.ExpiryDate,
-		StockedDate:  s.currentDate,
-		ImportPrice:  warehouseInv.ImportPrice,
-		CurrentPrice: product.SellingPrice,
-	}
-
-	if err := tx.Create(&shelfBatch).Error; err != nil {
+	// The transfer triggers deduct the warehouse batch and stock the shelf
+	// batch and shelf summary
+	if err := tx.Create(&transfer).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Warehouse inventory already updated above
-
-	// Update shelf inventory
-	var shelfInv models.ShelfInventory
-	if err := tx.Where("shelf_id = ? AND product_id = ?", shelf.ShelfID, product.ProductID).
-		First(&shelfInv).Error; err == gorm.ErrRecordNotFound {
-
-		shelfInv = models.ShelfInventory{
-			ShelfID:         shelf.ShelfID,
-			ProductID:       product.ProductID,
-			CurrentQuantity: quantity,
-			LastRestocked:   s.currentDate,
-		}
-		if err := tx.Create(&shelfInv).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		if err := tx.Model(&shelfInv).
-			Update("current_quantity", gorm.Expr("current_quantity + ?", quantity)).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
 	return tx.Commit().Error
 }
 
@@ -811,11 +728,18 @@ func (s *RealisticSimulation) createStockTransfer(product models.Product, wareho
 		return err
 	}
 
-	s.transferCounter++
-	transferCode := fmt.Sprintf("ST%s%04d", s.currentDate.Format("200601"), s.transferCounter)
+	if warehouseInv.Quantity < quantity {
+		return fmt.Errorf("insufficient warehouse stock: have %d, need %d", warehouseInv.Quantity, quantity)
+	}
 
 	tx := s.db.Begin()
 
+	transferCode, err := s.nextDocumentNo(tx, models.DocStockTransfer)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	transfer := models.StockTransfer{
 		TransferCode:    transferCode,
 		ProductID:       product.ProductID,
@@ -830,77 +754,13 @@ func (s *RealisticSimulation) createStockTransfer(product models.Product, wareho
 		SellingPrice:    product.SellingPrice,
 	}
 
+	// The transfer triggers deduct the warehouse batch and stock the shelf
+	// batch and shelf summary
 	if err := tx.Create(&transfer).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Update warehouse - ensure no negative quantities
-	if warehouseInv.Quantity < quantity {
-		tx.Rollback()
-		return fmt.Errorf("insufficient warehouse stock: have %d, need %d", warehouseInv.Quantity, quantity)
-	}
-	// Calculate new quantity and update directly
-	newQuantity := warehouseInv.Quantity - quantity
-	if err := tx.Model(&warehouseInv).Update("quantity", newQuantity).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	// Update or create shelf batch
-	var shelfBatch models.ShelfBatchInventory
-	err := tx.Where("shelf_id = ? AND product_id = ? AND batch_code = ?",
-		shelf.ShelfID, product.ProductID, warehouseInv.BatchCode).
-		First(&shelfBatch).Error
-
-	if err == gorm.ErrRecordNotFound {
-		shelfBatch = models.ShelfBatchInventory{
-			ShelfID:      shelf.ShelfID,
-			ProductID:    product.ProductID,
-			BatchCode:    warehouseInv.BatchCode,
-			Quantity:     quantity,
-			ExpiryDate:   warehouseInv.ExpiryDate,
-			StockedDate:  s.currentDate,
-			ImportPrice:  warehouseInv.ImportPrice,
-			CurrentPrice: product.SellingPrice,
-		}
-		if err := tx.Create(&shelfBatch).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		if err := tx.Model(&shelfBatch).
-			Update("quantity", gorm.Expr("quantity + ?", quantity)).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	// Update shelf inventory
-	var shelfInv models.ShelfInventory
-	if err := tx.Where("shelf_id = ? AND product_id = ?", shelf.ShelfID, product.ProductID).
-		First(&shelfInv).Error; err == gorm.ErrRecordNotFound {
-
-		shelfInv = models.ShelfInventory{
-			ShelfID:         shelf.ShelfID,
-			ProductID:       product.ProductID,
-			CurrentQuantity: quantity,
-			LastRestocked:   s.currentDate,
-		}
-		if err := tx.Create(&shelfInv).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		if err := tx.Model(&shelfInv).Updates(map[string]interface{}{
-			"current_quantity": gorm.Expr("current_quantity + ?", quantity),
-			"last_restocked":   s.currentDate,
-		}).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
 	return tx.Commit().Error
 }
 
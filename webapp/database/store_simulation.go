@@ -60,8 +60,9 @@ func (s *StoreSimulation) printSimulationSummary() {
 }
 
 // RunSimulation is the main entry point for the simulation
-// This now uses the new realistic simulation approach
-func RunSimulation(db *gorm.DB, startDate, endDate time.Time) error {
+// This now uses the new realistic simulation approach; earned points expire after
+// pointsExpiryMonths (0 = never), like LOYALTY_POINTS_EXPIRY_MONTHS
+func RunSimulation(db *gorm.DB, startDate, endDate time.Time, pointsExpiryMonths int) error {
 	// Use the new realistic simulation that follows the requirements:
 	// 1. Order from suppliers when warehouse is low
 	// 2. Stock arrives in warehouse
@@ -69,5 +70,5 @@ func RunSimulation(db *gorm.DB, startDate, endDate time.Time) error {
 	// 4. Sell to customers daily
 	// 5. Restock shelves when empty from warehouse
 	// 6. Reorder from suppliers when warehouse is empty
-	return RunRealisticSimulation(db, startDate, endDate)
+	return RunRealisticSimulation(db, startDate, endDate, pointsExpiryMonths)
 }
@@ -137,6 +137,45 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+-- 1.5 Register Session Lock
+-- Sales can only be booked on an OPEN register session; a closed (Z-reported) till is locked
+CREATE OR REPLACE FUNCTION check_register_session_open()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_status TEXT;
+    v_register_code TEXT;
+BEGIN
+    IF NEW.session_id IS NULL THEN
+        RETURN NEW;
+    END IF;
+
+    IF TG_OP = 'UPDATE' AND NEW.session_id IS NOT DISTINCT FROM OLD.session_id THEN
+        RETURN NEW;
+    END IF;
+
+    -- An offline sale belongs to the session it was rung up in, even one closed before the
+    -- till came back online; the application checks the sale falls within that session
+    IF NEW.client_uuid IS NOT NULL THEN
+        RETURN NEW;
+    END IF;
+
+    SELECT status, register_code INTO v_status, v_register_code
+    FROM supermarket.register_sessions
+    WHERE session_id = NEW.session_id;
+
+    IF v_status IS NULL THEN
+        RAISE EXCEPTION '%', format('Register session %s does not exist', NEW.session_id);
+    END IF;
+
+    IF v_status <> 'OPEN' THEN
+        RAISE EXCEPTION '%', format('Register session %s (%s) is closed and cannot receive more sales',
+                        NEW.session_id, v_register_code);
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
 -- ============================================================================
 -- 2. INVENTORY MANAGEMENT TRIGGERS
 -- ============================================================================
@@ -157,7 +196,7 @@ BEGIN
         WHERE warehouse_id = NEW.from_warehouse_id 
           AND product_id = NEW.product_id 
           AND quantity > 0
-        ORDER BY import_date ASC, inventory_id ASC
+        ORDER BY (batch_code = NEW.batch_code) DESC, import_date ASC, inventory_id ASC
     LOOP
         IF remaining_qty <= 0 THEN
             EXIT;
@@ -193,6 +232,16 @@ BEGIN
         last_restocked = CURRENT_TIMESTAMP,
         updated_at = CURRENT_TIMESTAMP;
     
+    -- Track the batch on the shelf so sales can be allocated FEFO
+    INSERT INTO shelf_batch_inventory (shelf_id, product_id, batch_code, quantity, expiry_date, stocked_date,
+                                       import_price, current_price, created_at, updated_at)
+    VALUES (NEW.to_shelf_id, NEW.product_id, NEW.batch_code, NEW.quantity, NEW.expiry_date, CURRENT_TIMESTAMP,
+            NEW.import_price, NEW.selling_price, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    ON CONFLICT (shelf_id, product_id, batch_code)
+    DO UPDATE SET
+        quantity = shelf_batch_inventory.quantity + NEW.quantity,
+        updated_at = CURRENT_TIMESTAMP;
+    
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
@@ -202,26 +251,21 @@ $$ LANGUAGE plpgsql;
 CREATE OR REPLACE FUNCTION process_sales_stock_deduction()
 RETURNS TRIGGER AS $$
 DECLARE
-    available_qty INTEGER;
+    invoice_status VARCHAR(20);
 BEGIN
-    -- Check shelf stock availability
-    SELECT si.current_quantity INTO available_qty
-    FROM shelf_inventory si
-    INNER JOIN sales_invoices inv ON inv.invoice_id = NEW.invoice_id
-    INNER JOIN supermarket.products p ON p.product_id = NEW.product_id
-    WHERE si.product_id = NEW.product_id;
+    SELECT status INTO invoice_status
+    FROM sales_invoices WHERE invoice_id = NEW.invoice_id;
     
-    IF NOT FOUND OR available_qty < NEW.quantity THEN
-        RAISE EXCEPTION '%', format('Insufficient shelf stock for product %s. Available: %s, Requested: %s', 
-                        NEW.product_id, COALESCE(available_qty, 0), NEW.quantity);
+    IF invoice_status = 'VOIDED' THEN
+        RAISE EXCEPTION '%', format('Cannot add items to voided invoice %s', NEW.invoice_id);
     END IF;
     
-    -- Deduct from shelf inventory
-    UPDATE shelf_inventory 
-    SET current_quantity = current_quantity - NEW.quantity,
-        updated_at = CURRENT_TIMESTAMP
-    WHERE product_id = NEW.product_id 
-      AND current_quantity >= NEW.quantity;
+    -- Draft invoices do not touch stock until they are completed
+    IF invoice_status = 'DRAFT' THEN
+        RETURN NEW;
+    END IF;
+    
+    PERFORM deduct_sales_line_stock(NEW.detail_id);
     
     RETURN NEW;
 END;
@@ -265,124 +309,258 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
--- ============================================================================
--- 3. CUSTOMER MANAGEMENT TRIGGERS
--- ============================================================================
-
--- 3.1 Update Customer Total Spending and Loyalty Points
-CREATE OR REPLACE FUNCTION update_customer_metrics()
-RETURNS TRIGGER AS $$
+-- 2.5 Sales Line Stock Helpers
+-- Allocate one invoice line first-expiry-first-out across every shelf batch
+-- holding the product. Each batch used is recorded in sales_invoice_allocations.
+-- Uploaded offline sales set supermarket.allow_stock_shortage for their transaction:
+-- units that are not on the shelf are then recorded in stock_shortages instead of
+-- rejecting a sale that has already happened.
+CREATE OR REPLACE FUNCTION deduct_sales_line_stock(p_detail_id BIGINT)
+RETURNS VOID AS $$
 DECLARE
-    points_earned INTEGER;
-    multiplier NUMERIC(3,2) := 1.0;
+    line RECORD;
+    batch_rec RECORD;
+    remaining_qty INTEGER;
+    take_qty INTEGER;
+    v_shelf_id BIGINT;
 BEGIN
-    IF NEW.customer_id IS NOT NULL THEN
-        -- Get points multiplier if customer has membership
-        SELECT COALESCE(ml.points_multiplier, 1.0) INTO multiplier
-        FROM customers c
-        LEFT JOIN membership_levels ml ON c.membership_level_id = ml.level_id
-        WHERE c.customer_id = NEW.customer_id;
+    SELECT detail_id, invoice_id, product_id, quantity INTO line
+    FROM sales_invoice_details WHERE detail_id = p_detail_id;
+    
+    remaining_qty := line.quantity;
+    
+    -- Expired batches are never sold; batches without expiry go last
+    FOR batch_rec IN
+        SELECT shelf_batch_id, shelf_id, batch_code, quantity, expiry_date, current_price, discount_percent
+        FROM shelf_batch_inventory
+        WHERE product_id = line.product_id
+          AND quantity > 0
+          AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+        ORDER BY expiry_date ASC NULLS LAST, stocked_date ASC, shelf_batch_id ASC
+        FOR UPDATE
+    LOOP
+        IF remaining_qty <= 0 THEN
+            EXIT;
+        END IF;
         
-        -- Calculate points earned: 10% of net before VAT
-        points_earned := FLOOR(GREATEST(NEW.subtotal - NEW.discount_amount, 0) * 0.10);
+        take_qty := LEAST(batch_rec.quantity, remaining_qty);
         
-        -- Update customer metrics
-        UPDATE customers 
-        SET total_spending = total_spending + NEW.total_amount,
-            loyalty_points = loyalty_points + points_earned,
+        UPDATE shelf_batch_inventory
+        SET quantity = quantity - take_qty,
             updated_at = CURRENT_TIMESTAMP
-        WHERE customer_id = NEW.customer_id;
+        WHERE shelf_batch_id = batch_rec.shelf_batch_id;
         
-        -- Update points earned in the invoice
-        NEW.points_earned := points_earned;
+        UPDATE shelf_inventory
+        SET current_quantity = GREATEST(current_quantity - take_qty, 0),
+            updated_at = CURRENT_TIMESTAMP
+        WHERE shelf_id = batch_rec.shelf_id
+          AND product_id = line.product_id;
+        
+        INSERT INTO sales_invoice_allocations (detail_id, shelf_id, shelf_batch_id, batch_code, quantity,
+                                               expiry_date, batch_price, discount_percent, created_at)
+        VALUES (line.detail_id, batch_rec.shelf_id, batch_rec.shelf_batch_id, batch_rec.batch_code, take_qty,
+                batch_rec.expiry_date, batch_rec.current_price, COALESCE(batch_rec.discount_percent, 0), CURRENT_TIMESTAMP);
+        
+        remaining_qty := remaining_qty - take_qty;
+    END LOOP;
+    
+    IF remaining_qty > 0 AND COALESCE(current_setting('supermarket.allow_stock_shortage', true), '') = 'on' THEN
+        -- Book the shortage against the shelf the product is laid out on
+        SELECT shelf_id INTO v_shelf_id
+        FROM shelf_layout
+        WHERE product_id = line.product_id
+        ORDER BY shelf_id
+        LIMIT 1;
+        
+        INSERT INTO stock_shortages (detail_id, invoice_id, product_id, shelf_id, quantity,
+                                     settled_quantity, status, created_at)
+        VALUES (line.detail_id, line.invoice_id, line.product_id, v_shelf_id, remaining_qty,
+                0, 'OPEN', CURRENT_TIMESTAMP);
+        RETURN;
     END IF;
     
-    RETURN NEW;
+    IF remaining_qty > 0 THEN
+        RAISE EXCEPTION '%', format('Insufficient shelf stock for product %s. Available: %s, Requested: %s', 
+                        line.product_id, line.quantity - remaining_qty, line.quantity);
+    END IF;
 END;
 $$ LANGUAGE plpgsql;
 
--- ============================================================================
--- 8. PURCHASE ORDER RECEIPT → WAREHOUSE INVENTORY
--- ============================================================================
+-- Put the stock for one invoice line back into the batches it was allocated from (used when voiding)
+CREATE OR REPLACE FUNCTION restore_sales_line_stock(p_detail_id BIGINT)
+RETURNS VOID AS $$
+DECLARE
+    alloc RECORD;
+BEGIN
+    FOR alloc IN
+        SELECT a.shelf_id, a.batch_code, a.quantity, a.expiry_date, a.batch_price, d.product_id
+        FROM sales_invoice_allocations a
+        JOIN sales_invoice_details d ON d.detail_id = a.detail_id
+        WHERE a.detail_id = p_detail_id
+    LOOP
+        INSERT INTO shelf_batch_inventory (shelf_id, product_id, batch_code, quantity, expiry_date, stocked_date,
+                                           import_price, current_price, created_at, updated_at)
+        SELECT alloc.shelf_id, alloc.product_id, alloc.batch_code, alloc.quantity, alloc.expiry_date, CURRENT_TIMESTAMP,
+               p.import_price, alloc.batch_price, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+        FROM supermarket.products p
+        WHERE p.product_id = alloc.product_id
+        ON CONFLICT (shelf_id, product_id, batch_code)
+        DO UPDATE SET
+            quantity = shelf_batch_inventory.quantity + alloc.quantity,
+            updated_at = CURRENT_TIMESTAMP;
+        
+        UPDATE shelf_inventory 
+        SET current_quantity = current_quantity + alloc.quantity,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE shelf_id = alloc.shelf_id
+          AND product_id = alloc.product_id;
+    END LOOP;
+    
+    -- Units that were never on the shelf are not owed any more
+    UPDATE stock_shortages
+    SET status = 'CANCELLED',
+        resolved_at = CURRENT_TIMESTAMP
+    WHERE detail_id = p_detail_id
+      AND status = 'OPEN';
+END;
+$$ LANGUAGE plpgsql;
 
--- 8.1 Process purchase receipt: when an order is marked RECEIVED, create batches in warehouse_inventory
-CREATE OR REPLACE FUNCTION process_purchase_receipt()
+-- 2.6 Sales Invoice Status Change
+-- DRAFT -> COMPLETED deducts stock, COMPLETED -> VOIDED puts it back.
+-- Voided invoices are final.
+CREATE OR REPLACE FUNCTION process_invoice_status_change()
 RETURNS TRIGGER AS $$
 DECLARE
     rec RECORD;
-    v_batch_code TEXT;
 BEGIN
-    -- Only act when status transitions to RECEIVED
-    IF TG_OP = 'UPDATE' AND NEW.status = 'RECEIVED' AND (OLD.status IS DISTINCT FROM 'RECEIVED') THEN
+    IF NEW.status IS NOT DISTINCT FROM OLD.status THEN
+        RETURN NEW;
+    END IF;
+    
+    IF OLD.status = 'VOIDED' THEN
+        RAISE EXCEPTION '%', format('Invoice %s is voided and cannot change status', OLD.invoice_no);
+    END IF;
+    
+    IF NEW.status = 'DRAFT' THEN
+        RAISE EXCEPTION '%', format('Invoice %s cannot be moved back to draft', OLD.invoice_no);
+    END IF;
+    
+    IF OLD.status = 'DRAFT' AND NEW.status = 'COMPLETED' THEN
         FOR rec IN
-            SELECT pod.detail_id, pod.product_id, pod.quantity, pod.unit_price
-            FROM supermarket.purchase_order_details pod
-            WHERE pod.order_id = NEW.order_id
+            SELECT detail_id FROM sales_invoice_details
+            WHERE invoice_id = NEW.invoice_id
+            ORDER BY detail_id
         LOOP
-            -- Generate a deterministic batch code based on order no and detail id
-            v_batch_code := NEW.order_no || '-' || rec.detail_id::TEXT;
-
-            INSERT INTO supermarket.warehouse_inventory (
-                warehouse_id,
-                product_id,
-                batch_code,
-                quantity,
-                import_date,
-                expiry_date,
-                import_price,
-                created_at,
-                updated_at
-            ) VALUES (
-                1, -- default warehouse
-                rec.product_id,
-                v_batch_code,
-                rec.quantity,
-                CURRENT_DATE,
-                NULL, -- expiry to be entered by staff later
-                rec.unit_price,
-                CURRENT_TIMESTAMP,
-                CURRENT_TIMESTAMP
-            );
+            PERFORM deduct_sales_line_stock(rec.detail_id);
         END LOOP;
+    ELSIF NEW.status = 'VOIDED' THEN
+        IF EXISTS (SELECT 1 FROM sales_returns WHERE invoice_id = NEW.invoice_id) THEN
+            RAISE EXCEPTION '%', format('Invoice %s has returns and cannot be voided', OLD.invoice_no);
+        END IF;
+        
+        IF OLD.status = 'COMPLETED' THEN
+            FOR rec IN
+                SELECT detail_id FROM sales_invoice_details
+                WHERE invoice_id = NEW.invoice_id
+                ORDER BY detail_id
+            LOOP
+                PERFORM restore_sales_line_stock(rec.detail_id);
+            END LOOP;
+        END IF;
+        
+        INSERT INTO activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+        VALUES ('SALE_VOIDED',
+                format('Hủy hóa đơn %s - Lý do: %s', NEW.invoice_no, COALESCE(NEW.void_reason, '')),
+                'sales_invoices', NEW.invoice_id, NEW.voided_by, CURRENT_TIMESTAMP);
     END IF;
-
+    
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
 
--- 3.2 Auto-upgrade Customer Membership Level
-CREATE OR REPLACE FUNCTION check_membership_upgrade()
+-- ============================================================================
+-- 3. CUSTOMER MANAGEMENT TRIGGERS
+-- ============================================================================
+
+-- 3.1 Update Customer Total Spending and Points Earned
+-- Only the invoice's points_earned is computed here; the application books earned,
+-- redeemed and reversed points in the loyalty_transactions ledger, which alone
+-- changes customers.loyalty_points
+CREATE OR REPLACE FUNCTION update_customer_metrics()
 RETURNS TRIGGER AS $$
 DECLARE
-    current_level_id INTEGER;
-    new_level_id INTEGER;
-    new_level_name VARCHAR(50);
+    points_earned INTEGER;
+    multiplier NUMERIC(3,2) := 1.0;
+    -- LOYALTY_EARN_RATE, passed by the application on every connection
+    earn_rate NUMERIC := COALESCE(NULLIF(current_setting('supermarket.loyalty_earn_rate', true), '')::NUMERIC, 0.10);
 BEGIN
-    -- Get customer's current membership level
-    SELECT membership_level_id INTO current_level_id
-    FROM customers WHERE customer_id = NEW.customer_id;
-    
-    -- Find the highest membership level this customer qualifies for
-    SELECT level_id, level_name INTO new_level_id, new_level_name
-    FROM membership_levels 
-    WHERE min_spending <= NEW.total_spending
-    ORDER BY min_spending DESC
-    LIMIT 1;
-    
-    -- Update membership if eligible for upgrade
-    IF new_level_id != current_level_id OR current_level_id IS NULL THEN
-        UPDATE customers 
-        SET membership_level_id = new_level_id,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE customer_id = NEW.customer_id;
+    IF NEW.customer_id IS NOT NULL THEN
+        -- Drafts do not count towards customer metrics until completed
+        IF NEW.status = 'DRAFT' THEN
+            NEW.points_earned := 0;
+            RETURN NEW;
+        END IF;
+        
+        -- Voiding reverses the spending the completed invoice contributed
+        IF NEW.status = 'VOIDED' THEN
+            IF TG_OP = 'UPDATE' AND OLD.status = 'COMPLETED' THEN
+                UPDATE customers 
+                SET total_spending = GREATEST(total_spending - OLD.total_amount, 0),
+                    updated_at = CURRENT_TIMESTAMP
+                WHERE customer_id = NEW.customer_id;
+            END IF;
+            RETURN NEW;
+        END IF;
+        
+        -- Get points multiplier if customer has membership
+        SELECT COALESCE(ml.points_multiplier, 1.0) INTO multiplier
+        FROM customers c
+        LEFT JOIN membership_levels ml ON c.membership_level_id = ml.level_id
+        WHERE c.customer_id = NEW.customer_id;
         
-        RAISE NOTICE '%', format('Customer %s upgraded to membership level: %s', NEW.customer_id, new_level_name);
+        -- Calculate points earned: the earn rate of net before VAT (tax-inclusive lines carry
+        -- their VAT in the subtotal), times the multiplier of the customer's membership level
+        points_earned := FLOOR(GREATEST(NEW.total_amount - NEW.tax_amount, 0) * earn_rate * COALESCE(multiplier, 1.0));
+        
+        -- Update customer spending. On UPDATE only the change since the last
+        -- recalculation is applied, so that the totals can be reversed exactly
+        -- by sales returns.
+        IF TG_OP = 'INSERT' OR OLD.status = 'DRAFT' THEN
+            UPDATE customers 
+            SET total_spending = total_spending + NEW.total_amount,
+                updated_at = CURRENT_TIMESTAMP
+            WHERE customer_id = NEW.customer_id;
+        ELSIF NEW.total_amount IS DISTINCT FROM OLD.total_amount THEN
+            UPDATE customers 
+            SET total_spending = total_spending + (NEW.total_amount - COALESCE(OLD.total_amount, 0)),
+                updated_at = CURRENT_TIMESTAMP
+            WHERE customer_id = NEW.customer_id;
+        END IF;
+        
+        -- Update points earned in the invoice
+        NEW.points_earned := points_earned;
     END IF;
     
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
 
+-- ============================================================================
+-- 8. PURCHASE ORDER RECEIPT → WAREHOUSE INVENTORY
+-- ============================================================================
+
+-- 8.1 Goods are booked into the warehouses by goods receipt notes, with the quantity
+-- actually accepted, the chosen warehouse and the printed lot and expiry, and the order
+-- status is derived from them, so the receive-everything-into-warehouse-1 trigger is retired
+DROP TRIGGER IF EXISTS tr_process_purchase_receipt ON purchase_orders;
+DROP FUNCTION IF EXISTS process_purchase_receipt();
+
+-- 3.2 Membership levels are evaluated by the application over a trailing spending
+-- window, with downgrades and a grace period, so the lifetime-spending upgrade
+-- trigger is retired
+DROP TRIGGER IF EXISTS tr_check_membership_upgrade ON customers;
+DROP FUNCTION IF EXISTS check_membership_upgrade();
+
 -- ============================================================================
 -- 4. FINANCIAL CALCULATION TRIGGERS
 -- ============================================================================
@@ -394,21 +572,24 @@ DECLARE
     invoice_subtotal NUMERIC(12,2) := 0;
     invoice_discount NUMERIC(12,2) := 0;
     invoice_tax NUMERIC(12,2) := 0;
+    invoice_added_tax NUMERIC(12,2) := 0;
     invoice_total NUMERIC(12,2) := 0;
+    target_invoice_id BIGINT := COALESCE(NEW.invoice_id, OLD.invoice_id);
 BEGIN
-    -- Calculate subtotal and total discount from all details
+    -- Calculate gross subtotal, total discount and tax from all details
+    -- (detail subtotals are already net of their discount). Only the tax of
+    -- EXCLUSIVE lines is added to the total; INCLUSIVE lines already contain it.
     SELECT 
-        COALESCE(SUM(subtotal), 0),
-        COALESCE(SUM(discount_amount), 0)
-    INTO invoice_subtotal, invoice_discount
+        COALESCE(SUM(unit_price * quantity), 0),
+        COALESCE(SUM(discount_amount), 0),
+        COALESCE(SUM(tax_amount), 0),
+        COALESCE(SUM(tax_amount) FILTER (WHERE tax_mode = 'EXCLUSIVE'), 0)
+    INTO invoice_subtotal, invoice_discount, invoice_tax, invoice_added_tax
     FROM sales_invoice_details 
-    WHERE invoice_id = NEW.invoice_id;
-    
-    -- Calculate tax (10% VAT) on net before tax
-    invoice_tax := GREATEST(invoice_subtotal - invoice_discount, 0) * 0.10;
+    WHERE invoice_id = target_invoice_id;
     
     -- Calculate final total
-    invoice_total := GREATEST(invoice_subtotal - invoice_discount, 0) + invoice_tax;
+    invoice_total := GREATEST(invoice_subtotal - invoice_discount, 0) + invoice_added_tax;
     
     -- Update the invoice
     UPDATE sales_invoices 
@@ -416,22 +597,63 @@ BEGIN
         discount_amount = invoice_discount,
         tax_amount = invoice_tax,
         total_amount = invoice_total
-    WHERE invoice_id = NEW.invoice_id;
+    WHERE invoice_id = target_invoice_id;
     
-    RETURN NEW;
+    RETURN COALESCE(NEW, OLD);
 END;
 $$ LANGUAGE plpgsql;
 
 -- 4.2 Auto-calculate Sales Invoice Detail Subtotal
 CREATE OR REPLACE FUNCTION calculate_detail_subtotal()
 RETURNS TRIGGER AS $$
+DECLARE
+    class_id BIGINT;
+    class_rate NUMERIC(5,2);
+    class_mode VARCHAR(10);
 BEGIN
-    -- Calculate discount amount
-    NEW.discount_amount := NEW.unit_price * NEW.quantity * (NEW.discount_percentage / 100);
+    -- Lines priced by the pricing engine carry their discount breakdown;
+    -- the percentage is derived from it for display only
+    IF NEW.list_price IS NOT NULL THEN
+        NEW.discount_amount := COALESCE(NEW.batch_discount_amount, 0)
+                             + COALESCE(NEW.promotion_discount_amount, 0)
+                             + COALESCE(NEW.membership_discount_amount, 0)
+                             + COALESCE(NEW.points_discount_amount, 0);
+        NEW.discount_percentage := CASE WHEN NEW.unit_price * NEW.quantity > 0
+            THEN ROUND(NEW.discount_amount / (NEW.unit_price * NEW.quantity) * 100, 2)
+            ELSE 0 END;
+    ELSE
+        -- Calculate discount amount
+        NEW.discount_amount := NEW.unit_price * NEW.quantity * (NEW.discount_percentage / 100);
+    END IF;
     
     -- Calculate subtotal
     NEW.subtotal := (NEW.unit_price * NEW.quantity) - NEW.discount_amount;
     
+    -- New lines without a resolved tax class take the product's class, else its
+    -- category's class; without either the default 10% EXCLUSIVE applies.
+    -- The rate is frozen on the line so later class changes do not rewrite history.
+    IF TG_OP = 'INSERT' AND NEW.tax_class_id IS NULL THEN
+        SELECT tc.tax_class_id, tc.rate, tc.tax_mode
+        INTO class_id, class_rate, class_mode
+        FROM products p
+        JOIN product_categories pc ON p.category_id = pc.category_id
+        JOIN tax_classes tc ON tc.tax_class_id = COALESCE(p.tax_class_id, pc.tax_class_id)
+        WHERE p.product_id = NEW.product_id;
+        
+        IF class_id IS NOT NULL THEN
+            NEW.tax_class_id := class_id;
+            NEW.tax_rate := class_rate;
+            NEW.tax_mode := class_mode;
+        END IF;
+    END IF;
+    NEW.tax_rate := COALESCE(NEW.tax_rate, 10);
+    NEW.tax_mode := COALESCE(NEW.tax_mode, 'EXCLUSIVE');
+    
+    -- Tax contained in (INCLUSIVE) or added to (EXCLUSIVE) the net line amount
+    NEW.tax_amount := ROUND(CASE WHEN NEW.tax_mode = 'INCLUSIVE'
+        THEN NEW.subtotal * NEW.tax_rate / (100 + NEW.tax_rate)
+        ELSE NEW.subtotal * NEW.tax_rate / 100 END, 2);
+    
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
@@ -546,6 +768,21 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+-- 6.2 Record Product Price Changes
+-- Keeps the history of selling prices, so a sale uploaded after the price changed is
+-- checked against the price in effect when it was rung up
+CREATE OR REPLACE FUNCTION record_product_price_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.selling_price IS DISTINCT FROM OLD.selling_price THEN
+        INSERT INTO product_price_history (product_id, selling_price, effective_from)
+        VALUES (NEW.product_id, NEW.selling_price, CURRENT_TIMESTAMP);
+    END IF;
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
 -- ============================================================================
 -- 8. ACTIVITY LOGGING TRIGGERS
 -- ============================================================================
@@ -160,6 +160,7 @@ FROM sales_invoice_details sid
 JOIN supermarket.products p ON sid.product_id = p.product_id
 JOIN product_categories c ON p.category_id = c.category_id
 JOIN sales_invoices si ON sid.invoice_id = si.invoice_id
+WHERE si.status = 'COMPLETED'
 GROUP BY p.product_id, p.product_code, p.product_name, c.category_name;
 
 -- View: Doanh thu theo nhà cung cấp
@@ -191,7 +192,7 @@ SELECT
     MAX(si.invoice_date) AS last_purchase
 FROM customers c
 LEFT JOIN membership_levels ml ON c.membership_level_id = ml.level_id
-LEFT JOIN sales_invoices si ON c.customer_id = si.customer_id
+LEFT JOIN sales_invoices si ON c.customer_id = si.customer_id AND si.status = 'COMPLETED'
 GROUP BY c.customer_id, c.full_name, c.phone, c.email, ml.level_name, c.total_spending, c.loyalty_points
 ORDER BY c.total_spending DESC;
 
@@ -216,6 +217,28 @@ LEFT JOIN shelf_inventory si ON ds.shelf_id = si.shelf_id
 LEFT JOIN shelf_layout sl ON ds.shelf_id = sl.shelf_id
 GROUP BY ds.shelf_id, ds.shelf_name, pc.category_name, ds.location;
 
+-- View: Các khoản thanh toán theo hình thức (tender) của hóa đơn
+-- Hóa đơn cũ chưa có dòng thanh toán được tính là một tender theo payment_method
+CREATE OR REPLACE VIEW v_invoice_tenders AS
+SELECT 
+    sip.invoice_id,
+    sip.payment_method,
+    sip.amount,
+    sip.tendered_amount,
+    sip.change_amount
+FROM sales_invoice_payments sip
+UNION ALL
+SELECT 
+    si.invoice_id,
+    COALESCE(si.payment_method, 'UNKNOWN'),
+    si.total_amount,
+    si.total_amount,
+    0
+FROM sales_invoices si
+WHERE NOT EXISTS (
+    SELECT 1 FROM sales_invoice_payments sip WHERE sip.invoice_id = si.invoice_id
+);
+
 -- ===========================================================================
 -- STORED PROCEDURES và FUNCTIONS
 -- ===========================================================================
@@ -449,6 +472,7 @@ BEGIN
         AVG(si.total_amount) AS avg_invoice_value
     FROM sales_invoices si
     WHERE DATE(si.invoice_date) BETWEEN p_start_date AND p_end_date
+      AND si.status = 'COMPLETED'
     GROUP BY DATE(si.invoice_date)
     ORDER BY report_date DESC;
 END;
@@ -485,13 +509,18 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Procedure: Xử lý thanh toán và cập nhật inventory
+-- p_expiry_months là hạn dùng của điểm tích (LOYALTY_POINTS_EXPIRY_MONTHS), 0 = không hết hạn
+DROP PROCEDURE IF EXISTS process_sale_payment(BIGINT);
 CREATE OR REPLACE PROCEDURE process_sale_payment(
-    p_invoice_id BIGINT
+    p_invoice_id BIGINT,
+    p_expiry_months INTEGER
 ) AS $$
 DECLARE
     rec RECORD;
     v_customer_id BIGINT;
     v_total_amount DECIMAL(12,2);
+    v_points_earned INTEGER;
+    v_balance INTEGER;
 BEGIN
     -- Duyệt qua các chi tiết hóa đơn
     FOR rec IN 
@@ -521,17 +550,28 @@ BEGIN
           AND current_quantity >= rec.quantity;
     END LOOP;
     
-    -- Cập nhật điểm và tổng chi tiêu cho khách hàng
-    SELECT customer_id, total_amount 
-    INTO v_customer_id, v_total_amount
+    -- Cập nhật điểm và tổng chi tiêu cho khách hàng; điểm được ghi vào sổ điểm
+    -- (loyalty_transactions) với hạn dùng p_expiry_months tháng
+    SELECT customer_id, total_amount, points_earned
+    INTO v_customer_id, v_total_amount, v_points_earned
     FROM sales_invoices 
     WHERE invoice_id = p_invoice_id;
     
     IF v_customer_id IS NOT NULL THEN
         UPDATE customers
         SET total_spending = total_spending + v_total_amount,
-            loyalty_points = loyalty_points + FLOOR(v_total_amount / 10000)
-        WHERE customer_id = v_customer_id;
+            loyalty_points = loyalty_points + v_points_earned
+        WHERE customer_id = v_customer_id
+        RETURNING loyalty_points INTO v_balance;
+        
+        IF v_points_earned > 0 THEN
+            INSERT INTO loyalty_transactions
+            (customer_id, entry_type, points, balance_after, remaining, expires_at, invoice_id, created_at)
+            VALUES (v_customer_id, 'EARN', v_points_earned, v_balance, v_points_earned,
+                    CASE WHEN p_expiry_months > 0
+                         THEN CURRENT_DATE + make_interval(months => p_expiry_months) END,
+                    p_invoice_id, CURRENT_TIMESTAMP);
+        END IF;
     END IF;
     
     COMMIT;
@@ -561,14 +601,71 @@ BEGIN
         SUM(sid.subtotal) AS total_revenue,
         AVG(sid.unit_price) AS avg_price
     FROM sales_invoice_details sid
+    JOIN sales_invoices si ON sid.invoice_id = si.invoice_id
     JOIN supermarket.products p ON sid.product_id = p.product_id
     JOIN product_categories pc ON p.category_id = pc.category_id
+    WHERE si.status = 'COMPLETED'
     GROUP BY p.product_id, p.product_code, p.product_name, pc.category_name
     ORDER BY total_sold DESC
     LIMIT p_limit;
 END;
 $$ LANGUAGE plpgsql;
 
+-- Function: Cấp số chứng từ tiếp theo (hóa đơn, phiếu trả, đơn đặt hàng, phiếu chuyển)
+-- Bộ đếm nằm trong bảng document_sequence_counters chứ không dùng SEQUENCE của PostgreSQL:
+-- SEQUENCE không rollback nên sẽ để lại số bị nhảy. Dòng bộ đếm bị khóa tới khi giao dịch
+-- gọi hàm kết thúc, nên hai giao dịch không thể nhận cùng một số, và giao dịch bị hủy trả lại số.
+-- Định dạng: tiền tố + kỳ (YYYYMMDD / YYYYMM) + "-quầy-" (nếu đánh số theo quầy) + số thứ tự
+CREATE OR REPLACE FUNCTION next_document_no(
+    p_document_type VARCHAR,
+    p_series VARCHAR DEFAULT NULL,
+    p_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+)
+RETURNS VARCHAR AS $$
+DECLARE
+    v_seq supermarket.document_sequences%ROWTYPE;
+    v_series VARCHAR(20) := '';
+    v_period VARCHAR(8) := '';
+    v_value BIGINT;
+    v_number TEXT;
+BEGIN
+    SELECT * INTO v_seq
+    FROM supermarket.document_sequences
+    WHERE document_type = p_document_type;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Chưa cấu hình đánh số cho loại chứng từ %', p_document_type;
+    END IF;
+
+    IF v_seq.per_till THEN
+        v_series := UPPER(COALESCE(NULLIF(TRIM(p_series), ''), ''));
+    END IF;
+
+    v_period := CASE v_seq.reset_policy
+        WHEN 'DAILY' THEN TO_CHAR(p_at, 'YYYYMMDD')
+        WHEN 'MONTHLY' THEN TO_CHAR(p_at, 'YYYYMM')
+        ELSE ''
+    END;
+
+    -- Tạo hoặc tăng bộ đếm của chuỗi số; ON CONFLICT khóa dòng tới hết giao dịch
+    INSERT INTO supermarket.document_sequence_counters (document_type, series_key, period_key, last_value, updated_at)
+    VALUES (p_document_type, v_series, v_period, 1, CURRENT_TIMESTAMP)
+    ON CONFLICT (document_type, series_key, period_key)
+    DO UPDATE SET last_value = supermarket.document_sequence_counters.last_value + 1,
+                  updated_at = CURRENT_TIMESTAMP
+    RETURNING last_value INTO v_value;
+
+    v_number := v_value::TEXT;
+    IF LENGTH(v_number) < v_seq.padding THEN
+        v_number := LPAD(v_number, v_seq.padding, '0');
+    END IF;
+
+    RETURN v_seq.prefix || v_period
+        || CASE WHEN v_series <> '' THEN '-' || v_series || '-' ELSE '' END
+        || v_number;
+END;
+$$ LANGUAGE plpgsql;
+
 -- ===========================================================================
 -- INDEXES cho VIEWs và queries thường xuyên
 -- ===========================================================================
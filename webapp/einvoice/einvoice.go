@@ -0,0 +1,328 @@
+// Package einvoice builds electronic invoices in the tax authority's XML format (HDon with
+// DLHDon data, NDHDon content and DSCKS signatures) and signs them with XML-DSig. It knows
+// nothing about the database: the web handlers fill an Invoice and store the signed result.
+package einvoice
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the PBan (format version) written on every invoice
+const FormatVersion = "2.0.1"
+
+// Relation says how an invoice relates to an earlier one
+type Relation int
+
+const (
+	// RelationNone is an ordinary invoice
+	RelationNone Relation = 0
+	// RelationReplacement replaces the related invoice entirely
+	RelationReplacement Relation = 1
+	// RelationAdjustment corrects amounts of the related invoice; its lines are the differences
+	RelationAdjustment Relation = 2
+)
+
+// Party is the seller or the buyer
+type Party struct {
+	Name         string
+	TaxCode      string
+	Address      string
+	Phone        string
+	Email        string
+	CustomerCode string
+}
+
+// Line is one goods line. Amounts are before VAT; adjustment invoices may carry negative values.
+type Line struct {
+	Code      string
+	Name      string
+	Unit      string
+	Quantity  float64
+	UnitPrice float64
+	Discount  float64
+	Amount    float64
+	TaxRate   float64
+	Tax       float64
+}
+
+// Related identifies the invoice a replacement or adjustment refers to
+type Related struct {
+	Relation     Relation
+	TemplateCode string
+	Series       string
+	Number       int64
+	Date         time.Time
+	Note         string
+}
+
+// Invoice is the data of one electronic invoice
+type Invoice struct {
+	TemplateCode  string // KHMSHDon, "1" for VAT invoices
+	Series        string // KHHDon, e.g. C26TAA
+	Number        int64  // SHDon
+	Date          time.Time
+	PaymentMethod string
+	Seller        Party
+	Buyer         Party
+	Lines         []Line
+	Discount      float64 // trade discount already deducted in the lines, shown for information
+	Related       *Related
+}
+
+// TotalBeforeTax is the sum of the line amounts
+func (inv *Invoice) TotalBeforeTax() float64 {
+	var total float64
+	for _, l := range inv.Lines {
+		total += l.Amount
+	}
+	return round(total)
+}
+
+// TotalTax is the sum of the line VAT
+func (inv *Invoice) TotalTax() float64 {
+	var total float64
+	for _, l := range inv.Lines {
+		total += l.Tax
+	}
+	return round(total)
+}
+
+// Total is what the buyer pays
+func (inv *Invoice) Total() float64 {
+	return round(inv.TotalBeforeTax() + inv.TotalTax())
+}
+
+// XML elements, named as in the tax authority's schema
+
+type xmlData struct {
+	XMLName xml.Name   `xml:"DLHDon"`
+	ID      string     `xml:"Id,attr"`
+	General xmlGeneral `xml:"TTChung"`
+	Content xmlContent `xml:"NDHDon"`
+}
+
+type xmlGeneral struct {
+	Version       string      `xml:"PBan"`
+	Title         string      `xml:"THDon"`
+	TemplateCode  string      `xml:"KHMSHDon"`
+	Series        string      `xml:"KHHDon"`
+	Number        int64       `xml:"SHDon"`
+	Date          string      `xml:"NLap"`
+	Currency      string      `xml:"DVTTe"`
+	ExchangeRate  int         `xml:"TGia"`
+	PaymentMethod string      `xml:"HTTToan"`
+	Related       *xmlRelated `xml:"TTHDLQuan,omitempty"`
+}
+
+type xmlRelated struct {
+	Relation     int    `xml:"TCHDon"`
+	Kind         int    `xml:"LHDCLQuan"`
+	TemplateCode string `xml:"KHMSHDCLQuan"`
+	Series       string `xml:"KHHDCLQuan"`
+	Number       int64  `xml:"SHDCLQuan"`
+	Date         string `xml:"NLHDCLQuan"`
+	Note         string `xml:"GChu,omitempty"`
+}
+
+type xmlContent struct {
+	Seller xmlParty  `xml:"NBan"`
+	Buyer  xmlParty  `xml:"NMua"`
+	Lines  []xmlLine `xml:"DSHHDVu>HHDVu"`
+	Totals xmlTotals `xml:"TToan"`
+}
+
+type xmlParty struct {
+	Name         string `xml:"Ten"`
+	TaxCode      string `xml:"MST,omitempty"`
+	Address      string `xml:"DChi,omitempty"`
+	CustomerCode string `xml:"MKHang,omitempty"`
+	Phone        string `xml:"SDThoai,omitempty"`
+	Email        string `xml:"DCTDTu,omitempty"`
+}
+
+type xmlLine struct {
+	Nature    int    `xml:"TChat"`
+	No        int    `xml:"STT"`
+	Code      string `xml:"MHHDVu,omitempty"`
+	Name      string `xml:"THHDVu"`
+	Unit      string `xml:"DVTinh,omitempty"`
+	Quantity  string `xml:"SLuong"`
+	UnitPrice string `xml:"DGia"`
+	Discount  string `xml:"STCKhau"`
+	Amount    string `xml:"ThTien"`
+	TaxRate   string `xml:"TSuat"`
+}
+
+type xmlTotals struct {
+	ByRate      []xmlRateTotal `xml:"THTTLTSuat>LTSuat"`
+	BeforeTax   string         `xml:"TgTCThue"`
+	Tax         string         `xml:"TgTThue"`
+	Discount    string         `xml:"TTCKTMai"`
+	Total       string         `xml:"TgTTTBSo"`
+	TotalInWord string         `xml:"TgTTTBChu"`
+}
+
+type xmlRateTotal struct {
+	Rate   string `xml:"TSuat"`
+	Amount string `xml:"ThTien"`
+	Tax    string `xml:"TThue"`
+}
+
+// dataID is the Id of DLHDon, the element the seller's signature covers
+const dataID = "data"
+
+// Data returns the canonical DLHDon element of the invoice: the exact bytes that are digested
+// when signing and that appear in the signed document.
+func (inv *Invoice) Data() ([]byte, error) {
+	if inv.TemplateCode == "" || inv.Series == "" || inv.Number <= 0 {
+		return nil, fmt.Errorf("thiếu ký hiệu mẫu số, ký hiệu hoặc số hóa đơn")
+	}
+	if inv.Seller.Name == "" || inv.Seller.TaxCode == "" {
+		return nil, fmt.Errorf("thiếu tên hoặc mã số thuế người bán")
+	}
+	if len(inv.Lines) == 0 {
+		return nil, fmt.Errorf("hóa đơn không có dòng hàng")
+	}
+
+	data := xmlData{
+		ID: dataID,
+		General: xmlGeneral{
+			Version:       FormatVersion,
+			Title:         "Hóa đơn giá trị gia tăng",
+			TemplateCode:  inv.TemplateCode,
+			Series:        inv.Series,
+			Number:        inv.Number,
+			Date:          inv.Date.Format("2006-01-02"),
+			Currency:      "VND",
+			ExchangeRate:  1,
+			PaymentMethod: inv.PaymentMethod,
+		},
+		Content: xmlContent{
+			Seller: party(inv.Seller),
+			Buyer:  party(inv.Buyer),
+		},
+	}
+	if data.Content.Buyer.Name == "" {
+		data.Content.Buyer.Name = "Người mua không lấy hóa đơn"
+	}
+	if r := inv.Related; r != nil && r.Relation != RelationNone {
+		data.General.Related = &xmlRelated{
+			Relation:     int(r.Relation),
+			Kind:         1, // an electronic invoice under the same regulation
+			TemplateCode: r.TemplateCode,
+			Series:       r.Series,
+			Number:       r.Number,
+			Date:         r.Date.Format("2006-01-02"),
+			Note:         r.Note,
+		}
+	}
+
+	// Lines, and the totals per VAT rate in rate order
+	byRate := map[float64]*xmlRateTotal{}
+	amounts := map[float64][2]float64{}
+	var rates []float64
+	for i, l := range inv.Lines {
+		data.Content.Lines = append(data.Content.Lines, xmlLine{
+			Nature:    1,
+			No:        i + 1,
+			Code:      l.Code,
+			Name:      l.Name,
+			Unit:      l.Unit,
+			Quantity:  number(l.Quantity),
+			UnitPrice: number(l.UnitPrice),
+			Discount:  number(l.Discount),
+			Amount:    number(l.Amount),
+			TaxRate:   rateText(l.TaxRate),
+		})
+		if _, ok := byRate[l.TaxRate]; !ok {
+			byRate[l.TaxRate] = &xmlRateTotal{Rate: rateText(l.TaxRate)}
+			rates = append(rates, l.TaxRate)
+		}
+		a := amounts[l.TaxRate]
+		amounts[l.TaxRate] = [2]float64{a[0] + l.Amount, a[1] + l.Tax}
+	}
+	sort.Float64s(rates)
+	for _, rate := range rates {
+		t := byRate[rate]
+		t.Amount = number(round(amounts[rate][0]))
+		t.Tax = number(round(amounts[rate][1]))
+		data.Content.Totals.ByRate = append(data.Content.Totals.ByRate, *t)
+	}
+	data.Content.Totals.BeforeTax = number(inv.TotalBeforeTax())
+	data.Content.Totals.Tax = number(inv.TotalTax())
+	data.Content.Totals.Discount = number(round(inv.Discount))
+	data.Content.Totals.Total = number(inv.Total())
+	data.Content.Totals.TotalInWord = AmountInWords(inv.Total())
+
+	out, err := xml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalText(out), nil
+}
+
+// Unsigned returns the full HDon document without a signature, for checking the content
+func (inv *Invoice) Unsigned() ([]byte, error) {
+	data, err := inv.Data()
+	if err != nil {
+		return nil, err
+	}
+	return document(data, nil), nil
+}
+
+// document wraps the data element and the seller's signature into HDon
+func document(data, signature []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<HDon>")
+	buf.Write(data)
+	buf.WriteString("<DSCKS><NBan>")
+	buf.Write(signature)
+	buf.WriteString("</NBan><NMua></NMua><CCKSKhac></CCKSKhac></DSCKS></HDon>\n")
+	return buf.Bytes()
+}
+
+func party(p Party) xmlParty {
+	return xmlParty{
+		Name:         p.Name,
+		TaxCode:      p.TaxCode,
+		Address:      p.Address,
+		CustomerCode: p.CustomerCode,
+		Phone:        p.Phone,
+		Email:        p.Email,
+	}
+}
+
+// canonicalText turns encoding/xml character escapes into the form XML canonicalization uses:
+// only &, <, > and carriage return stay escaped in text. Marshal never writes self-closing
+// tags and our only attribute is the fixed Id, so the output is then canonical.
+func canonicalText(b []byte) []byte {
+	r := strings.NewReplacer("&#34;", `"`, "&#39;", "'", "&#x9;", "\t", "&#xA;", "\n")
+	return []byte(r.Replace(string(b)))
+}
+
+// round rounds to whole dong, the precision of amounts on the invoice
+func round(v float64) float64 {
+	return math.Round(v)
+}
+
+// number writes an amount or quantity without exponent or trailing zeros
+func number(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	s := fmt.Sprintf("%.4f", v)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// rateText writes a VAT rate the way the schema expects: 0%, 5%, 8%, 10%
+func rateText(rate float64) string {
+	return number(rate) + "%"
+}
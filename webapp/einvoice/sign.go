@@ -0,0 +1,219 @@
+package einvoice
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// XML-DSig algorithm identifiers
+const (
+	nsDSig           = "http://www.w3.org/2000/09/xmldsig#"
+	algC14N          = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	algRSASHA256     = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algSHA256        = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algEnvelopedSign = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+)
+
+// Signer signs invoices with the seller's certificate and RSA private key
+type Signer struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// Signature describes a signed invoice for the export log
+type Signature struct {
+	Digest   string
+	Subject  string
+	Serial   string
+	SignedAt time.Time
+}
+
+// LoadSigner reads a PEM certificate and its PEM private key (PKCS#1 or PKCS#8 RSA)
+func LoadSigner(certFile, keyFile string) (*Signer, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("không đọc được chứng thư số: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s không chứa chứng thư số PEM", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("chứng thư số không hợp lệ: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("không đọc được khóa bí mật: %w", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s không chứa khóa bí mật PEM", keyFile)
+	}
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed any
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = parsed.(*rsa.PrivateKey); !ok {
+				err = fmt.Errorf("chỉ hỗ trợ khóa RSA")
+			}
+		}
+	default:
+		err = fmt.Errorf("loại khóa %s không được hỗ trợ", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("khóa bí mật không hợp lệ: %w", err)
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || !pub.Equal(&key.PublicKey) {
+		return nil, fmt.Errorf("khóa bí mật không khớp với chứng thư số")
+	}
+
+	return &Signer{cert: cert, key: key}, nil
+}
+
+// Subject is the certificate holder, as shown in the export log
+func (s *Signer) Subject() string {
+	return s.cert.Subject.String()
+}
+
+// NotAfter is the end of the certificate's validity
+func (s *Signer) NotAfter() time.Time {
+	return s.cert.NotAfter
+}
+
+// Sign builds the invoice and returns the HDon document with the seller's enveloped XML-DSig
+// signature (RSA-SHA256 over the canonical DLHDon) in DSCKS/NBan.
+func (s *Signer) Sign(inv *Invoice, at time.Time) ([]byte, *Signature, error) {
+	if at.Before(s.cert.NotBefore) || at.After(s.cert.NotAfter) {
+		return nil, nil, fmt.Errorf("chứng thư số không còn hiệu lực (%s - %s)",
+			s.cert.NotBefore.Format("02/01/2006"), s.cert.NotAfter.Format("02/01/2006"))
+	}
+
+	data, err := inv.Data()
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(data)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+
+	signedInfo := signedInfo(digest)
+	hashed := sha256.Sum256(canonicalSignedInfo(signedInfo))
+	value, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("ký hóa đơn thất bại: %w", err)
+	}
+
+	var sig bytes.Buffer
+	sig.WriteString(`<Signature xmlns="` + nsDSig + `" Id="seller">`)
+	sig.Write(signedInfo)
+	sig.WriteString("<SignatureValue>" + base64.StdEncoding.EncodeToString(value) + "</SignatureValue>")
+	sig.WriteString("<KeyInfo><X509Data><X509SubjectName>")
+	xml.EscapeText(&sig, []byte(s.Subject()))
+	sig.WriteString("</X509SubjectName><X509Certificate>")
+	sig.WriteString(base64.StdEncoding.EncodeToString(s.cert.Raw))
+	sig.WriteString("</X509Certificate></X509Data></KeyInfo></Signature>")
+
+	return document(data, sig.Bytes()), &Signature{
+		Digest:   digest,
+		Subject:  s.Subject(),
+		Serial:   s.cert.SerialNumber.Text(16),
+		SignedAt: at,
+	}, nil
+}
+
+// signedInfo is the SignedInfo element as written inside Signature (namespace inherited)
+func signedInfo(digest string) []byte {
+	return []byte(`<SignedInfo>` +
+		`<CanonicalizationMethod Algorithm="` + algC14N + `"></CanonicalizationMethod>` +
+		`<SignatureMethod Algorithm="` + algRSASHA256 + `"></SignatureMethod>` +
+		`<Reference URI="#` + dataID + `">` +
+		`<Transforms><Transform Algorithm="` + algEnvelopedSign + `"></Transform></Transforms>` +
+		`<DigestMethod Algorithm="` + algSHA256 + `"></DigestMethod>` +
+		`<DigestValue>` + digest + `</DigestValue>` +
+		`</Reference></SignedInfo>`)
+}
+
+// canonicalSignedInfo is SignedInfo as canonicalization sees it on its own: the namespace
+// declared on Signature is carried onto it
+func canonicalSignedInfo(si []byte) []byte {
+	return bytes.Replace(si, []byte("<SignedInfo>"), []byte(`<SignedInfo xmlns="`+nsDSig+`">`), 1)
+}
+
+// Verify checks a document signed by Sign: the DLHDon digest and the RSA signature against the
+// embedded certificate. It returns the signer's certificate.
+func Verify(doc []byte) (*x509.Certificate, error) {
+	data := between(doc, "<DLHDon ", "</DLHDon>")
+	si := between(doc, "<SignedInfo>", "</SignedInfo>")
+	if data == nil || si == nil {
+		return nil, fmt.Errorf("tài liệu không có dữ liệu hóa đơn hoặc chữ ký")
+	}
+
+	sum := sha256.Sum256(data)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	if !bytes.Equal(between(si, "<DigestValue>", "</DigestValue>"), []byte("<DigestValue>"+digest+"</DigestValue>")) {
+		return nil, fmt.Errorf("dữ liệu hóa đơn đã bị thay đổi sau khi ký")
+	}
+
+	value, err := base64.StdEncoding.DecodeString(string(inner(doc, "<SignatureValue>", "</SignatureValue>")))
+	if err != nil {
+		return nil, fmt.Errorf("giá trị chữ ký không hợp lệ: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(inner(doc, "<X509Certificate>", "</X509Certificate>")))
+	if err != nil {
+		return nil, fmt.Errorf("chứng thư số trong chữ ký không hợp lệ: %w", err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, fmt.Errorf("chứng thư số trong chữ ký không hợp lệ: %w", err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("chỉ hỗ trợ chữ ký RSA")
+	}
+
+	hashed := sha256.Sum256(canonicalSignedInfo(si))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], value); err != nil {
+		return nil, fmt.Errorf("chữ ký không hợp lệ: %w", err)
+	}
+	return cert, nil
+}
+
+// between returns doc from the start of open to the end of close, tags included
+func between(doc []byte, open, close string) []byte {
+	start := bytes.Index(doc, []byte(open))
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(doc[start:], []byte(close))
+	if end < 0 {
+		return nil
+	}
+	return doc[start : start+end+len(close)]
+}
+
+// inner returns the text between open and close, tags excluded
+func inner(doc []byte, open, close string) []byte {
+	b := between(doc, open, close)
+	if b == nil {
+		return nil
+	}
+	return b[len(open) : len(b)-len(close)]
+}
@@ -0,0 +1,87 @@
+package einvoice
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var digitWords = []string{"không", "một", "hai", "ba", "bốn", "năm", "sáu", "bảy", "tám", "chín"}
+
+// AmountInWords reads a VND amount in Vietnamese for TgTTTBChu: 1250000 becomes
+// "Một triệu hai trăm năm mươi nghìn đồng"
+func AmountInWords(amount float64) string {
+	n := int64(math.Round(math.Abs(amount)))
+	if n == 0 {
+		return "Không đồng"
+	}
+
+	// Split into groups of three digits, lowest first
+	var groups []int
+	for n > 0 {
+		groups = append(groups, int(n%1000))
+		n /= 1000
+	}
+
+	var words []string
+	for g := len(groups) - 1; g >= 0; g-- {
+		if groups[g] == 0 {
+			continue
+		}
+		words = append(words, readGroup(groups[g], g < len(groups)-1))
+		if unit := groupUnit(g); unit != "" {
+			words = append(words, unit)
+		}
+	}
+
+	text := strings.Join(words, " ") + " đồng"
+	if amount < 0 {
+		text = "âm " + text
+	}
+	first, size := utf8.DecodeRuneInString(text)
+	return string(unicode.ToUpper(first)) + text[size:]
+}
+
+// groupUnit names group g (0 is units): nghìn, triệu, tỷ, nghìn tỷ, ...
+func groupUnit(g int) string {
+	unit := []string{"", "nghìn", "triệu"}[g%3]
+	for i := 0; i < g/3; i++ {
+		unit = strings.TrimSpace(unit + " tỷ")
+	}
+	return unit
+}
+
+// readGroup reads a number below 1000; full reads the hundreds even when they are zero,
+// as inside a larger number (1.005 is "một nghìn không trăm linh năm")
+func readGroup(n int, full bool) string {
+	hundreds, tens, units := n/100, n/10%10, n%10
+	var words []string
+
+	if hundreds > 0 || full {
+		words = append(words, digitWords[hundreds], "trăm")
+	}
+
+	switch {
+	case tens == 0 && units > 0 && len(words) > 0:
+		words = append(words, "linh")
+	case tens == 1:
+		words = append(words, "mười")
+	case tens > 1:
+		words = append(words, digitWords[tens], "mươi")
+	}
+
+	switch {
+	case units == 0:
+	case units == 1 && tens > 1:
+		words = append(words, "mốt")
+	case units == 4 && tens > 1:
+		words = append(words, "tư")
+	case units == 5 && tens > 0:
+		words = append(words, "lăm")
+	default:
+		words = append(words, digitWords[units])
+	}
+
+	return strings.Join(words, " ")
+}
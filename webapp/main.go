@@ -6,10 +6,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/supermarket/config"
 	"github.com/supermarket/database"
 	"github.com/supermarket/web"
+	"github.com/supermarket/web/handlers"
 )
 
 func main() {
@@ -62,6 +64,28 @@ func main() {
 		log.Println("Database seeded successfully")
 	}
 
+	// POS settings and the background expiry of abandoned parked carts
+	handlers.ConfigurePOS(cfg.POS)
+	go handlers.RunParkedCartExpiry(time.Minute)
+
+	// Store header and network till printer for receipts
+	handlers.ConfigureReceipts(cfg.Receipt)
+
+	// Electronic invoice series and signing certificate
+	if err := handlers.ConfigureEInvoices(cfg.EInvoice); err != nil {
+		log.Printf("E-invoice signing disabled: %v", err)
+	}
+
+	// Write off the balance of vouchers past their expiry date
+	go handlers.RunVoucherExpiry(time.Hour)
+
+	// Loyalty redemption rate, and the expiry of points past their validity
+	handlers.ConfigureLoyalty(cfg.Loyalty)
+	go handlers.RunLoyaltyExpiry(time.Hour)
+
+	// Membership tiers are re-evaluated daily on the trailing spending window
+	go handlers.RunTierEvaluation(24 * time.Hour)
+
 	// Create and start web server
 	server := web.NewServer()
 
@@ -82,7 +106,7 @@ func main() {
 }
 
 func showHelp() {
-	log.Println(`
+	log.Print(`
 Supermarket Management System Server
 
 Usage:
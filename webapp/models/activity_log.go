@@ -24,17 +24,33 @@ func (ActivityLog) GetTableName() string {
 
 // Activity types constants
 const (
-	ActivityTypeProductCreated      = "PRODUCT_CREATED"
-	ActivityTypeProductUpdated      = "PRODUCT_UPDATED"
-	ActivityTypeProductDeleted      = "PRODUCT_DELETED"
-	ActivityTypeStockTransfer       = "STOCK_TRANSFER"
-	ActivityTypeSaleCompleted       = "SALE_COMPLETED"
-	ActivityTypeCustomerCreated     = "CUSTOMER_CREATED"
-	ActivityTypeCustomerUpdated     = "CUSTOMER_UPDATED"
-	ActivityTypeEmployeeCreated     = "EMPLOYEE_CREATED"
-	ActivityTypeEmployeeUpdated     = "EMPLOYEE_UPDATED"
-	ActivityTypeLowStockAlert       = "LOW_STOCK_ALERT"
-	ActivityTypeExpiryAlert         = "EXPIRY_ALERT"
-	ActivityTypePriceDiscount       = "PRICE_DISCOUNT"
-	ActivityTypeInventoryAdjustment = "INVENTORY_ADJUSTMENT"
+	ActivityTypeProductCreated        = "PRODUCT_CREATED"
+	ActivityTypeProductUpdated        = "PRODUCT_UPDATED"
+	ActivityTypeProductDeleted        = "PRODUCT_DELETED"
+	ActivityTypeStockTransfer         = "STOCK_TRANSFER"
+	ActivityTypeSaleCompleted         = "SALE_COMPLETED"
+	ActivityTypeSaleReturned          = "SALE_RETURNED"
+	ActivityTypeSaleVoided            = "SALE_VOIDED"
+	ActivityTypeCustomerCreated       = "CUSTOMER_CREATED"
+	ActivityTypeCustomerUpdated       = "CUSTOMER_UPDATED"
+	ActivityTypeEmployeeCreated       = "EMPLOYEE_CREATED"
+	ActivityTypeEmployeeUpdated       = "EMPLOYEE_UPDATED"
+	ActivityTypeLowStockAlert         = "LOW_STOCK_ALERT"
+	ActivityTypeExpiryAlert           = "EXPIRY_ALERT"
+	ActivityTypePriceDiscount         = "PRICE_DISCOUNT"
+	ActivityTypeInventoryAdjustment   = "INVENTORY_ADJUSTMENT"
+	ActivityTypeRegisterOpened        = "REGISTER_OPENED"
+	ActivityTypeRegisterClosed        = "REGISTER_CLOSED"
+	ActivityTypePromotionCreated      = "PROMOTION_CREATED"
+	ActivityTypeVoucherIssued         = "VOUCHER_ISSUED"
+	ActivityTypeVoucherCancelled      = "VOUCHER_CANCELLED"
+	ActivityTypeNumberingChanged      = "NUMBERING_CHANGED"
+	ActivityTypeEInvoiceExported      = "EINVOICE_EXPORTED"
+	ActivityTypePointsAdjusted        = "POINTS_ADJUSTED"
+	ActivityTypeOfflineSalesSynced    = "OFFLINE_SALES_SYNCED"
+	ActivityTypeStockShortageResolved = "STOCK_SHORTAGE_RESOLVED"
+	ActivityTypeOverrideDenied        = "OVERRIDE_DENIED"
+	ActivityTypeRestrictionOverridden = "RESTRICTION_OVERRIDDEN"
+	ActivityTypeStockDisposed         = "STOCK_DISPOSED"
+	ActivityTypeGoodsReceived         = "GOODS_RECEIVED"
 )
@@ -148,7 +148,7 @@ SELECT
     si.current_quantity,
     si.near_expiry_quantity,
     si.expired_quantity,
-    (si.current_quantity - si.near_expiry_quantity - si.expired_quantity) as healthy_quantity,
+    (si.current_quantity - si.near_expiry_quantity) as healthy_quantity,
     si.earliest_expiry_date,
     si.latest_expiry_date
 FROM shelf_inventory si
@@ -34,8 +34,11 @@ type Customer struct {
 	TotalSpending     float64   `gorm:"type:decimal(12,2);default:0" json:"total_spending"`
 	LoyaltyPoints     int       `gorm:"default:0" json:"loyalty_points"`
 	IsActive          bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
+	// TierGraceUntil is set while the customer keeps a tier they no longer qualify for
+	TierGraceUntil  *time.Time `gorm:"type:date" json:"tier_grace_until,omitempty"`
+	TierEvaluatedAt *time.Time `json:"tier_evaluated_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 
 	// Relationships
 	MembershipLevel *MembershipLevel `gorm:"foreignKey:MembershipLevelID" json:"membership_level,omitempty"`
@@ -47,3 +50,41 @@ type Customer struct {
 func (Customer) TableName() string {
 	return "customers"
 }
+
+// TierChangeType type for the membership tier history entries
+type TierChangeType string
+
+const (
+	TierUpgrade   TierChangeType = "UPGRADE"
+	TierDowngrade TierChangeType = "DOWNGRADE"
+	// TierGraceStarted records that the customer fell below their tier and keeps it for the grace period
+	TierGraceStarted TierChangeType = "GRACE_STARTED"
+	// TierGraceCleared records that the customer qualified again during the grace period
+	TierGraceCleared TierChangeType = "GRACE_CLEARED"
+	// TierManual is a tier set by staff on the customer form
+	TierManual TierChangeType = "MANUAL"
+)
+
+// MembershipTierHistory represents membership_tier_history table: every tier change of a
+// customer with the trailing-window spending it was decided on and the reason
+type MembershipTierHistory struct {
+	HistoryID      uint           `gorm:"primaryKey;column:history_id" json:"history_id"`
+	CustomerID     uint           `gorm:"not null" json:"customer_id"`
+	ChangeType     TierChangeType `gorm:"type:varchar(20);not null" json:"change_type"`
+	FromLevelID    *uint          `json:"from_level_id,omitempty"`
+	ToLevelID      *uint          `json:"to_level_id,omitempty"`
+	WindowSpending float64        `gorm:"type:decimal(14,2);not null;default:0" json:"window_spending"`
+	GraceUntil     *time.Time     `gorm:"type:date" json:"grace_until,omitempty"`
+	Reason         string         `gorm:"type:text;not null" json:"reason"`
+	CreatedAt      time.Time      `json:"created_at"`
+
+	// Relationships
+	Customer  Customer         `gorm:"foreignKey:CustomerID" json:"-"`
+	FromLevel *MembershipLevel `gorm:"foreignKey:FromLevelID" json:"from_level,omitempty"`
+	ToLevel   *MembershipLevel `gorm:"foreignKey:ToLevelID" json:"to_level,omitempty"`
+}
+
+// TableName specifies the table name for MembershipTierHistory
+func (MembershipTierHistory) TableName() string {
+	return "membership_tier_history"
+}
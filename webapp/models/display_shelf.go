@@ -79,13 +79,14 @@ func (si *ShelfInventory) UpdateSummaryFromBatches(batches []ShelfBatchInventory
 	si.LatestExpiryDate = nil
 
 	for _, batch := range batches {
-		si.CurrentQuantity += batch.Quantity
-
-		// Check expiry status
+		// Check expiry status; expired units are not sellable stock
 		if batch.IsExpired() {
 			si.ExpiredQuantity += batch.Quantity
-		} else if batch.ShouldDiscount(nearExpiryDays) {
-			si.NearExpiryQuantity += batch.Quantity
+		} else {
+			si.CurrentQuantity += batch.Quantity
+			if batch.ShouldDiscount(nearExpiryDays) {
+				si.NearExpiryQuantity += batch.Quantity
+			}
 		}
 
 		// Update earliest/latest expiry dates
@@ -113,5 +114,5 @@ func (si *ShelfInventory) HasNearExpiryItems() bool {
 
 // GetHealthyQuantity returns quantity of items that are not expired or near expiry
 func (si *ShelfInventory) GetHealthyQuantity() int {
-	return si.CurrentQuantity - si.NearExpiryQuantity - si.ExpiredQuantity
+	return si.CurrentQuantity - si.NearExpiryQuantity
 }
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Disposal represents disposals table: a write-off of stock that leaves the store without
+// being sold. Posting it takes the quantities out of the batches; the rows stay on the books.
+type Disposal struct {
+	DisposalID uint            `gorm:"primaryKey;column:disposal_id" json:"disposal_id"`
+	DisposalNo string          `gorm:"type:varchar(30);not null;unique" json:"disposal_no"`
+	Reason     StockReasonCode `gorm:"type:varchar(20);not null" json:"reason"`
+	CreatedBy  uint            `gorm:"not null" json:"created_by"`
+	ApprovedBy uint            `gorm:"not null" json:"approved_by"`
+	Notes      *string         `gorm:"type:text" json:"notes,omitempty"`
+	TotalCost  float64         `gorm:"type:decimal(15,2);not null;default:0" json:"total_cost"`
+	DisposedAt time.Time       `gorm:"not null;default:CURRENT_TIMESTAMP" json:"disposed_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+
+	// Relationships
+	Lines []DisposalLine `gorm:"foreignKey:DisposalID" json:"lines,omitempty"`
+}
+
+// TableName specifies the table name for Disposal
+func (Disposal) TableName() string {
+	return "disposals"
+}
+
+// DisposalLine represents disposal_lines table: the quantity taken out of one batch
+type DisposalLine struct {
+	LineID      uint          `gorm:"primaryKey;column:line_id" json:"line_id"`
+	DisposalID  uint          `gorm:"not null;index" json:"disposal_id"`
+	Location    StockLocation `gorm:"type:varchar(20);not null" json:"location"`
+	WarehouseID *uint         `json:"warehouse_id,omitempty"`
+	ShelfID     *uint         `json:"shelf_id,omitempty"`
+	ProductID   uint          `gorm:"not null" json:"product_id"`
+	BatchCode   string        `gorm:"type:varchar(50);not null" json:"batch_code"`
+	ExpiryDate  *time.Time    `gorm:"type:date" json:"expiry_date,omitempty"`
+	Quantity    int           `gorm:"not null" json:"quantity"`
+	UnitCost    float64       `gorm:"type:decimal(12,2);not null" json:"unit_cost"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// TableName specifies the table name for DisposalLine
+func (DisposalLine) TableName() string {
+	return "disposal_lines"
+}
+
+// LineCost returns the import cost written off by the line
+func (l *DisposalLine) LineCost() float64 {
+	return float64(l.Quantity) * l.UnitCost
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// DocumentType type for the documents numbered by the numbering service
+type DocumentType string
+
+const (
+	DocSalesInvoice  DocumentType = "SALES_INVOICE"
+	DocSalesReturn   DocumentType = "SALES_RETURN"
+	DocPurchaseOrder DocumentType = "PURCHASE_ORDER"
+	DocStockTransfer DocumentType = "STOCK_TRANSFER"
+	DocStockCount    DocumentType = "STOCK_COUNT"
+	DocDisposal      DocumentType = "DISPOSAL"
+	DocTransferOrder DocumentType = "TRANSFER_ORDER"
+	DocGoodsReceipt  DocumentType = "GOODS_RECEIPT"
+	// DocEInvoice numbers electronic invoices per series (KHHDon); its counter is the SHDon
+	DocEInvoice DocumentType = "EINVOICE"
+)
+
+// SequenceReset type for when a document series starts again from 1
+type SequenceReset string
+
+const (
+	SequenceResetNever   SequenceReset = "NEVER"
+	SequenceResetDaily   SequenceReset = "DAILY"
+	SequenceResetMonthly SequenceReset = "MONTHLY"
+)
+
+// DocumentSequence represents document_sequences table: the numbering rule of one document type.
+// Numbers are formatted as prefix + period (YYYYMMDD or YYYYMM) + "-till-" when per till + padded counter.
+type DocumentSequence struct {
+	DocumentType DocumentType  `gorm:"primaryKey;type:varchar(30)" json:"document_type"`
+	Prefix       string        `gorm:"type:varchar(10);not null" json:"prefix"`
+	ResetPolicy  SequenceReset `gorm:"type:varchar(10);not null;default:'NEVER'" json:"reset_policy"`
+	PerTill      bool          `gorm:"default:false" json:"per_till"`
+	Padding      int           `gorm:"not null;default:6;check:padding BETWEEN 1 AND 12" json:"padding"`
+	Description  *string       `gorm:"type:text" json:"description,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// TableName specifies the table name for DocumentSequence
+func (DocumentSequence) TableName() string {
+	return "document_sequences"
+}
+
+// DocumentSequenceCounter represents document_sequence_counters table: the last number handed out
+// for one series (document type, till, period). The row is locked by the allocating transaction,
+// so a rolled back document gives its number back instead of leaving a gap.
+type DocumentSequenceCounter struct {
+	CounterID    uint         `gorm:"primaryKey;column:counter_id" json:"counter_id"`
+	DocumentType DocumentType `gorm:"type:varchar(30);not null" json:"document_type"`
+	SeriesKey    string       `gorm:"type:varchar(20);not null;default:''" json:"series_key"`
+	PeriodKey    string       `gorm:"type:varchar(8);not null;default:''" json:"period_key"`
+	LastValue    int64        `gorm:"not null;default:0" json:"last_value"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+
+	// Relationships
+	Sequence DocumentSequence `gorm:"foreignKey:DocumentType" json:"sequence,omitempty"`
+}
+
+// TableName specifies the table name for DocumentSequenceCounter
+func (DocumentSequenceCounter) TableName() string {
+	return "document_sequence_counters"
+}
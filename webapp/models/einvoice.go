@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// EInvoiceExportType type for how an electronic invoice relates to earlier ones
+type EInvoiceExportType string
+
+const (
+	EInvoiceOriginal EInvoiceExportType = "ORIGINAL"
+	// EInvoiceReplacement supersedes an earlier export of the same sale entirely
+	EInvoiceReplacement EInvoiceExportType = "REPLACEMENT"
+	// EInvoiceAdjustment carries only the differences (e.g. returned goods) against an earlier export
+	EInvoiceAdjustment EInvoiceExportType = "ADJUSTMENT"
+)
+
+// EInvoiceStatus type for the export log
+type EInvoiceStatus string
+
+const (
+	EInvoiceSigned EInvoiceStatus = "SIGNED"
+	// EInvoiceReplaced is a signed export superseded by a replacement invoice
+	EInvoiceReplaced EInvoiceStatus = "REPLACED"
+	// EInvoiceFailed records an attempt that could not be built or signed; it has no number
+	EInvoiceFailed EInvoiceStatus = "FAILED"
+)
+
+// EInvoiceExport represents einvoice_exports table: the log of electronic invoices generated for
+// sales, with the signed XML. Replacements and adjustments point at the export they correct.
+type EInvoiceExport struct {
+	ExportID         uint               `gorm:"primaryKey;column:export_id" json:"export_id"`
+	InvoiceID        uint               `gorm:"not null" json:"invoice_id"`
+	ExportType       EInvoiceExportType `gorm:"type:varchar(20);not null;default:'ORIGINAL'" json:"export_type"`
+	Status           EInvoiceStatus     `gorm:"type:varchar(20);not null" json:"status"`
+	OriginalExportID *uint              `json:"original_export_id,omitempty"`
+	// DocumentNo is the number from the EINVOICE document sequence; EInvoiceNumber (SHDon) is its counter
+	DocumentNo     *string    `gorm:"type:varchar(40);unique" json:"document_no,omitempty"`
+	TemplateCode   string     `gorm:"type:varchar(10);not null" json:"template_code"`
+	Series         string     `gorm:"type:varchar(10);not null" json:"series"`
+	EInvoiceNumber *int64     `json:"einvoice_number,omitempty"`
+	BuyerName      *string    `gorm:"type:varchar(200)" json:"buyer_name,omitempty"`
+	BuyerTaxCode   *string    `gorm:"type:varchar(20)" json:"buyer_tax_code,omitempty"`
+	BuyerAddress   *string    `gorm:"type:text" json:"buyer_address,omitempty"`
+	TotalAmount    float64    `gorm:"type:decimal(12,2);default:0" json:"total_amount"`
+	TaxAmount      float64    `gorm:"type:decimal(12,2);default:0" json:"tax_amount"`
+	Reason         *string    `gorm:"type:text" json:"reason,omitempty"`
+	XMLContent     *string    `gorm:"type:text" json:"xml_content,omitempty"`
+	DigestValue    *string    `gorm:"type:varchar(100)" json:"digest_value,omitempty"`
+	CertSubject    *string    `gorm:"type:text" json:"cert_subject,omitempty"`
+	CertSerial     *string    `gorm:"type:varchar(64)" json:"cert_serial,omitempty"`
+	SignedAt       *time.Time `json:"signed_at,omitempty"`
+	ErrorMessage   *string    `gorm:"type:text" json:"error_message,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// Relationships
+	Invoice        SalesInvoice    `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	OriginalExport *EInvoiceExport `gorm:"foreignKey:OriginalExportID" json:"original_export,omitempty"`
+}
+
+// TableName specifies the table name for EInvoiceExport
+func (EInvoiceExport) TableName() string {
+	return "einvoice_exports"
+}
@@ -4,12 +4,18 @@ import "time"
 
 // Position represents positions table
 type Position struct {
-	PositionID   uint      `gorm:"primaryKey;column:position_id" json:"position_id"`
-	PositionCode string    `gorm:"type:varchar(20);not null;unique" json:"position_code"`
-	PositionName string    `gorm:"type:varchar(100);not null" json:"position_name"`
-	BaseSalary   float64   `gorm:"type:decimal(12,2);not null;check:base_salary >= 0" json:"base_salary"`
-	HourlyRate   float64   `gorm:"type:decimal(10,2);not null;check:hourly_rate >= 0" json:"hourly_rate"`
-	CreatedAt    time.Time `json:"created_at"`
+	PositionID   uint    `gorm:"primaryKey;column:position_id" json:"position_id"`
+	PositionCode string  `gorm:"type:varchar(20);not null;unique" json:"position_code"`
+	PositionName string  `gorm:"type:varchar(100);not null" json:"position_name"`
+	BaseSalary   float64 `gorm:"type:decimal(12,2);not null;check:base_salary >= 0" json:"base_salary"`
+	HourlyRate   float64 `gorm:"type:decimal(10,2);not null;check:hourly_rate >= 0" json:"hourly_rate"`
+	// Override authority: employees in the position may approve price overrides and goodwill
+	// discounts that take at most OverrideMaxPercent off the list price and at most
+	// OverrideMaxAmount VND off a line. A position with neither set cannot approve overrides;
+	// with only one set the other measure is not limited.
+	OverrideMaxPercent *float64  `gorm:"type:decimal(5,2)" json:"override_max_percent,omitempty"`
+	OverrideMaxAmount  *float64  `gorm:"type:decimal(12,2)" json:"override_max_amount,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
 
 	// Relationships - commented out to avoid circular dependency issues during migration
 	// Employees []Employee `gorm:"foreignKey:PositionID" json:"employees,omitempty"`
@@ -20,6 +26,32 @@ func (Position) TableName() string {
 	return "positions"
 }
 
+// Position codes that were allowed to approve price overrides before limits were configurable
+const (
+	PositionCodeManager    = "MGR"
+	PositionCodeSupervisor = "SUP"
+)
+
+// CanApprovePriceOverride checks if employees in this position may approve price overrides
+func (p *Position) CanApprovePriceOverride() bool {
+	return p.OverrideMaxPercent != nil || p.OverrideMaxAmount != nil
+}
+
+// WithinOverrideLimit checks a reduction of percent off the list price, worth amount VND on
+// the line, against the position's override limits
+func (p *Position) WithinOverrideLimit(percent, amount float64) bool {
+	if !p.CanApprovePriceOverride() {
+		return false
+	}
+	if p.OverrideMaxPercent != nil && percent > *p.OverrideMaxPercent+0.005 {
+		return false
+	}
+	if p.OverrideMaxAmount != nil && amount > *p.OverrideMaxAmount+0.005 {
+		return false
+	}
+	return true
+}
+
 // Employee represents employees table
 type Employee struct {
 	EmployeeID   uint      `gorm:"primaryKey;column:employee_id" json:"employee_id"`
@@ -33,8 +65,11 @@ type Employee struct {
 	IDCard       *string   `gorm:"type:varchar(20);unique" json:"id_card,omitempty"`
 	BankAccount  *string   `gorm:"type:varchar(50)" json:"bank_account,omitempty"`
 	IsActive     bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	// ApprovalPINHash is the salted hash of the PIN a supervisor enters at the till to approve
+	// an override; it is never sent to clients
+	ApprovalPINHash *string   `gorm:"type:varchar(200)" json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 
 	// Relationships
 	Position Position `gorm:"foreignKey:PositionID" json:"position,omitempty"`
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// GoodsReceipt represents goods_receipts table: one delivery received against a purchase
+// order into one warehouse. An order can be delivered over several receipts; its status is
+// derived from what they accepted.
+type GoodsReceipt struct {
+	ReceiptID   uint   `gorm:"primaryKey;column:receipt_id" json:"receipt_id"`
+	ReceiptNo   string `gorm:"type:varchar(30);not null;unique" json:"receipt_no"`
+	OrderID     uint   `gorm:"not null;index" json:"order_id"`
+	WarehouseID uint   `gorm:"not null" json:"warehouse_id"`
+	ReceivedBy  uint   `gorm:"not null" json:"received_by"`
+	// DeliveryNoteNo is the number of the supplier's delivery note
+	DeliveryNoteNo *string   `gorm:"type:varchar(50)" json:"delivery_note_no,omitempty"`
+	Notes          *string   `gorm:"type:text" json:"notes,omitempty"`
+	TotalCost      float64   `gorm:"type:decimal(15,2);not null;default:0" json:"total_cost"`
+	ReceivedAt     time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"received_at"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// Relationships
+	Lines []GoodsReceiptLine `gorm:"foreignKey:ReceiptID" json:"lines,omitempty"`
+}
+
+// TableName specifies the table name for GoodsReceipt
+func (GoodsReceipt) TableName() string {
+	return "goods_receipts"
+}
+
+// GoodsReceiptLine represents goods_receipt_lines table: one lot of a purchase order line as
+// delivered. The rejected part of the delivery goes back with the driver; the rest is booked
+// into the warehouse as a batch under the printed lot number and expiry date.
+type GoodsReceiptLine struct {
+	LineID           uint       `gorm:"primaryKey;column:line_id" json:"line_id"`
+	ReceiptID        uint       `gorm:"not null;index" json:"receipt_id"`
+	DetailID         uint       `gorm:"not null;index" json:"detail_id"`
+	ProductID        uint       `gorm:"not null" json:"product_id"`
+	BatchCode        string     `gorm:"type:varchar(50);not null" json:"batch_code"`
+	ExpiryDate       *time.Time `gorm:"type:date" json:"expiry_date,omitempty"`
+	ReceivedQuantity int        `gorm:"not null" json:"received_quantity"`
+	RejectedQuantity int        `gorm:"not null;default:0" json:"rejected_quantity"`
+	RejectReason     *string    `gorm:"type:varchar(200)" json:"reject_reason,omitempty"`
+	UnitCost         float64    `gorm:"type:decimal(12,2);not null" json:"unit_cost"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for GoodsReceiptLine
+func (GoodsReceiptLine) TableName() string {
+	return "goods_receipt_lines"
+}
+
+// AcceptedQuantity returns the quantity booked into the warehouse
+func (l *GoodsReceiptLine) AcceptedQuantity() int {
+	return l.ReceivedQuantity - l.RejectedQuantity
+}
@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// LoyaltyEntryType type for the loyalty points ledger entries
+type LoyaltyEntryType string
+
+const (
+	LoyaltyEntryEarn   LoyaltyEntryType = "EARN"
+	LoyaltyEntryRedeem LoyaltyEntryType = "REDEEM"
+	// LoyaltyEntryAdjust is a manual correction by staff, positive or negative
+	LoyaltyEntryAdjust LoyaltyEntryType = "ADJUST"
+	LoyaltyEntryExpire LoyaltyEntryType = "EXPIRE"
+	// LoyaltyEntryReverse undoes points of a voided or returned sale: earned points are taken
+	// back and redeemed points are given back
+	LoyaltyEntryReverse LoyaltyEntryType = "REVERSE"
+)
+
+// DefaultLoyaltyExpiryMonths is how long earned points stay valid unless configured otherwise
+const DefaultLoyaltyExpiryMonths = 12
+
+// LoyaltyTransaction represents loyalty_transactions table: the append-only points ledger.
+// Points are signed and customers.loyalty_points always equals their sum for the customer.
+// Entries that add points are lots: Remaining is what is left of them after redemptions,
+// reversals and expiry have taken points, oldest expiry first, and ExpiresAt is when the
+// remainder expires. Redeemed points given back by a reversal go back to the lots they were
+// taken from (see LoyaltyLotUsage), so only what those cannot take opens a new lot.
+type LoyaltyTransaction struct {
+	TransactionID uint             `gorm:"primaryKey;column:transaction_id" json:"transaction_id"`
+	CustomerID    uint             `gorm:"not null" json:"customer_id"`
+	EntryType     LoyaltyEntryType `gorm:"type:varchar(20);not null" json:"entry_type"`
+	Points        int              `gorm:"not null" json:"points"`
+	BalanceAfter  int              `gorm:"not null" json:"balance_after"`
+	Remaining     int              `gorm:"not null;default:0" json:"remaining"`
+	ExpiresAt     *time.Time       `gorm:"type:date" json:"expires_at,omitempty"`
+	InvoiceID     *uint            `json:"invoice_id,omitempty"`
+	ReturnID      *uint            `json:"return_id,omitempty"`
+	EmployeeID    *uint            `json:"employee_id,omitempty"`
+	Notes         *string          `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+
+	// Relationships
+	Customer Customer      `gorm:"foreignKey:CustomerID" json:"-"`
+	Invoice  *SalesInvoice `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	Return   *SalesReturn  `gorm:"foreignKey:ReturnID" json:"return,omitempty"`
+	Employee *Employee     `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+}
+
+// TableName specifies the table name for LoyaltyTransaction
+func (LoyaltyTransaction) TableName() string {
+	return "loyalty_transactions"
+}
+
+// LoyaltyLotUsage represents loyalty_lot_usages table: the points a ledger entry took from a
+// lot, or gave back to it when negative, so that redeemed points given back by a void or a
+// return go to the lots they came from and keep their expiry.
+type LoyaltyLotUsage struct {
+	UsageID   uint      `gorm:"primaryKey;column:usage_id" json:"usage_id"`
+	EntryID   uint      `gorm:"not null" json:"entry_id"`
+	LotID     uint      `gorm:"not null" json:"lot_id"`
+	Points    int       `gorm:"not null" json:"points"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	Entry LoyaltyTransaction `gorm:"foreignKey:EntryID" json:"-"`
+	Lot   LoyaltyTransaction `gorm:"foreignKey:LotID" json:"-"`
+}
+
+// TableName specifies the table name for LoyaltyLotUsage
+func (LoyaltyLotUsage) TableName() string {
+	return "loyalty_lot_usages"
+}
@@ -5,32 +5,65 @@ package models
 func AllModels() []interface{} {
 	return []interface{}{
 		// 1. Independent tables (no foreign keys)
-		&ProductCategory{},
+		&TaxClass{},
+		&ProductCategory{}, // depends on: TaxClass
 		&Supplier{},
 		&Warehouse{},
 		&Position{},
 		&MembershipLevel{},
+		&DocumentSequence{},
 
 		// 2. Tables with single dependencies
-		&Product{},      // depends on: ProductCategory, Supplier
+		&Product{},      // depends on: ProductCategory, Supplier, TaxClass
 		&DiscountRule{}, // depends on: ProductCategory
+		&Promotion{},    // independent, items reference products/categories
 		&DisplayShelf{}, // depends on: ProductCategory
 		&Employee{},     // depends on: Position
 		&Customer{},     // depends on: MembershipLevel
+		&Voucher{},      // depends on: Customer, Employee
 
 		// 3. Tables with multiple dependencies
-		&WarehouseInventory{},  // depends on: Warehouse, Product
-		&ShelfLayout{},         // depends on: DisplayShelf, Product
-		&ShelfInventory{},      // depends on: DisplayShelf, Product
-		&ShelfBatchInventory{}, // depends on: DisplayShelf, Product (batch tracking)
-		&EmployeeWorkHour{},    // depends on: Employee
-		&SalesInvoice{},        // depends on: Customer, Employee
-		&PurchaseOrder{},       // depends on: Supplier, Employee
+		&WarehouseInventory{},    // depends on: Warehouse, Product
+		&ShelfLayout{},           // depends on: DisplayShelf, Product
+		&ShelfInventory{},        // depends on: DisplayShelf, Product
+		&ShelfBatchInventory{},   // depends on: DisplayShelf, Product (batch tracking)
+		&EmployeeWorkHour{},      // depends on: Employee
+		&RegisterSession{},       // depends on: Employee
+		&SalesInvoice{},          // depends on: Customer, Employee, RegisterSession
+		&PurchaseOrder{},         // depends on: Supplier, Employee
+		&DamagedStock{},          // depends on: Product
+		&ProductPriceHistory{},   // depends on: Product
+		&MembershipTierHistory{}, // depends on: Customer, MembershipLevel
+		&StockCount{},            // depends on: Warehouse, DisplayShelf, ProductCategory, Employee
+		&Disposal{},              // depends on: Employee
+		&TransferOrder{},         // depends on: Warehouse, DisplayShelf, Employee
+		&GoodsReceipt{},          // depends on: PurchaseOrder, Warehouse, Employee
+		&StockTransferHeader{},   // depends on: Product, Warehouse, DisplayShelf, Employee
 
 		// 4. Detail/junction tables
-		&SalesInvoiceDetail{},  // depends on: SalesInvoice, Product
-		&PurchaseOrderDetail{}, // depends on: PurchaseOrder, Product
-		&StockTransfer{},       // depends on: Product, Warehouse, DisplayShelf, Employee
+		&PromotionItem{},          // depends on: Promotion, Product, ProductCategory
+		&SalesInvoiceDetail{},     // depends on: SalesInvoice, Product, Promotion, TaxClass
+		&SalesInvoicePromotion{},  // depends on: SalesInvoiceDetail, Promotion
+		&SalesInvoiceAllocation{}, // depends on: SalesInvoiceDetail, DisplayShelf
+		&StockShortage{},          // depends on: SalesInvoiceDetail, SalesInvoice, Product, DisplayShelf, Employee
+		&SalesInvoicePayment{},    // depends on: SalesInvoice, Voucher
+		&VoucherTransaction{},     // depends on: Voucher, SalesInvoice, Employee
+		&RegisterSessionTotal{},   // depends on: RegisterSession
+		&PosCart{},                // depends on: Employee, RegisterSession, Customer, SalesInvoice
+		&PosCartItem{},            // depends on: PosCart, Product
+		&PurchaseOrderDetail{},    // depends on: PurchaseOrder, Product
+		&StockTransfer{},          // depends on: StockTransferHeader, Product, Warehouse, DisplayShelf, Employee
+		&SalesReturn{},            // depends on: SalesInvoice, Customer, Employee
+		&SalesReturnDetail{},      // depends on: SalesReturn, SalesInvoiceDetail, Product, DisplayShelf
+		&LoyaltyTransaction{},     // depends on: Customer, SalesInvoice, SalesReturn, Employee
+		&LoyaltyLotUsage{},        // depends on: LoyaltyTransaction
+		&EInvoiceExport{},         // depends on: SalesInvoice
+		&StockCountLine{},         // depends on: StockCount, Warehouse, DisplayShelf, Product, Employee
+		&DisposalLine{},           // depends on: Disposal, Warehouse, DisplayShelf, Product
+		&TransferOrderLine{},      // depends on: TransferOrder, Product
+		&GoodsReceiptLine{},       // depends on: GoodsReceipt, PurchaseOrderDetail, Product
+
+		&DocumentSequenceCounter{}, // depends on: DocumentSequence
 
 		// 5. Audit/logging tables
 		&ActivityLog{}, // independent logging table
@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// PosCartStatus type for server-side POS cart lifecycle
+type PosCartStatus string
+
+const (
+	PosCartOpen      PosCartStatus = "OPEN"
+	PosCartCompleted PosCartStatus = "COMPLETED"
+	PosCartCancelled PosCartStatus = "CANCELLED"
+	PosCartParked    PosCartStatus = "PARKED"
+	PosCartExpired   PosCartStatus = "EXPIRED"
+)
+
+// PosCart represents pos_carts table: an in-progress sale kept on the server
+// so that a scanner client or another terminal can pick it up
+type PosCart struct {
+	CartID     uint          `gorm:"primaryKey;column:cart_id" json:"cart_id"`
+	EmployeeID uint          `gorm:"not null" json:"employee_id"`
+	SessionID  *uint         `json:"session_id,omitempty"`
+	CustomerID *uint         `json:"customer_id,omitempty"`
+	PointsUsed int           `gorm:"default:0" json:"points_used"`
+	Status     PosCartStatus `gorm:"type:varchar(20);not null;default:'OPEN'" json:"status"`
+	InvoiceID  *uint         `json:"invoice_id,omitempty"`
+	Notes      *string       `gorm:"type:text" json:"notes,omitempty"`
+
+	// Set while the basket is set aside (suspended) at a checkout
+	ParkedLabel *string    `gorm:"type:varchar(100)" json:"parked_label,omitempty"`
+	ParkedBy    *uint      `json:"parked_by,omitempty"`
+	ParkedAt    *time.Time `json:"parked_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Employee       Employee         `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+	ParkedEmployee *Employee        `gorm:"foreignKey:ParkedBy" json:"parked_employee,omitempty"`
+	Session        *RegisterSession `gorm:"foreignKey:SessionID" json:"session,omitempty"`
+	Customer       *Customer        `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
+	Invoice        *SalesInvoice    `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	Items          []PosCartItem    `gorm:"foreignKey:CartID" json:"items,omitempty"`
+}
+
+// TableName specifies the table name for PosCart
+func (PosCart) TableName() string {
+	return "pos_carts"
+}
+
+// IsEditable checks if items and customer can still be changed
+func (pc *PosCart) IsEditable() bool {
+	return pc.Status == PosCartOpen
+}
+
+// IsResumable checks if a parked cart can be picked up at a checkout
+func (pc *PosCart) IsResumable(now time.Time) bool {
+	return pc.Status == PosCartParked && (pc.ExpiresAt == nil || now.Before(*pc.ExpiresAt))
+}
+
+// PosCartItem represents pos_cart_items table (one line per product)
+type PosCartItem struct {
+	ItemID        uint      `gorm:"primaryKey;column:item_id" json:"item_id"`
+	CartID        uint      `gorm:"not null" json:"cart_id"`
+	ProductID     uint      `gorm:"not null" json:"product_id"`
+	Quantity      int       `gorm:"not null;check:quantity > 0" json:"quantity"`
+	OverridePrice *float64  `gorm:"type:decimal(12,2)" json:"override_price,omitempty"`
+	ScannedCode   *string   `gorm:"type:varchar(50)" json:"scanned_code,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// Relationships
+	Cart    PosCart `gorm:"foreignKey:CartID" json:"-"`
+	Product Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+}
+
+// TableName specifies the table name for PosCartItem
+func (PosCartItem) TableName() string {
+	return "pos_cart_items"
+}
@@ -6,25 +6,31 @@ import (
 
 // Product represents products table
 type Product struct {
-	ProductID         uint      `gorm:"primaryKey;column:product_id" json:"product_id"`
-	ProductCode       string    `gorm:"type:varchar(50);not null;unique" json:"product_code"`
-	ProductName       string    `gorm:"type:varchar(200);not null" json:"product_name"`
-	CategoryID        uint      `gorm:"not null" json:"category_id"`
-	SupplierID        uint      `gorm:"not null" json:"supplier_id"`
-	Unit              string    `gorm:"type:varchar(20);not null" json:"unit"`
-	ImportPrice       float64   `gorm:"type:decimal(12,2);not null;check:import_price > 0" json:"import_price"`
-	SellingPrice      float64   `gorm:"type:decimal(12,2);not null" json:"selling_price"`
-	ShelfLifeDays     *int      `json:"shelf_life_days,omitempty"`
-	LowStockThreshold int       `gorm:"default:10" json:"low_stock_threshold"`
-	Barcode           *string   `gorm:"type:varchar(50);unique" json:"barcode,omitempty"`
-	Description       *string   `gorm:"type:text" json:"description,omitempty"`
-	IsActive          bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
+	ProductID         uint    `gorm:"primaryKey;column:product_id" json:"product_id"`
+	ProductCode       string  `gorm:"type:varchar(50);not null;unique" json:"product_code"`
+	ProductName       string  `gorm:"type:varchar(200);not null" json:"product_name"`
+	CategoryID        uint    `gorm:"not null" json:"category_id"`
+	SupplierID        uint    `gorm:"not null" json:"supplier_id"`
+	Unit              string  `gorm:"type:varchar(20);not null" json:"unit"`
+	ImportPrice       float64 `gorm:"type:decimal(12,2);not null;check:import_price > 0" json:"import_price"`
+	SellingPrice      float64 `gorm:"type:decimal(12,2);not null" json:"selling_price"`
+	ShelfLifeDays     *int    `json:"shelf_life_days,omitempty"`
+	LowStockThreshold int     `gorm:"default:10" json:"low_stock_threshold"`
+	Barcode           *string `gorm:"type:varchar(50);unique" json:"barcode,omitempty"`
+	Brand             *string `gorm:"type:varchar(100)" json:"brand,omitempty"`
+	// TaxClassID overrides the tax class of the product's category
+	TaxClassID *uint `json:"tax_class_id,omitempty"`
+	// Sale rules of the product; unset rules fall back to the category's
+	SaleRestriction
+	Description *string   `gorm:"type:text" json:"description,omitempty"`
+	IsActive    bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 
 	// Relationships
 	Category ProductCategory `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
 	Supplier Supplier        `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	TaxClass *TaxClass       `gorm:"foreignKey:TaxClassID" json:"tax_class,omitempty"`
 	// Reverse relationships - commented out to avoid circular dependency issues during migration
 	// WarehouseInventories []WarehouseInventory  `gorm:"foreignKey:ProductID" json:"warehouse_inventories,omitempty"`
 	// ShelfLayouts         []ShelfLayout         `gorm:"foreignKey:ProductID" json:"shelf_layouts,omitempty"`
@@ -38,3 +44,20 @@ type Product struct {
 func (Product) TableName() string {
 	return "products"
 }
+
+// ProductPriceHistory represents product_price_history table: every selling price a product
+// has had and when it took effect, written by the products trigger
+type ProductPriceHistory struct {
+	HistoryID     uint      `gorm:"primaryKey;column:history_id" json:"history_id"`
+	ProductID     uint      `gorm:"not null;index" json:"product_id"`
+	SellingPrice  float64   `gorm:"type:decimal(12,2);not null" json:"selling_price"`
+	EffectiveFrom time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"effective_from"`
+
+	// Relationships
+	Product Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+}
+
+// TableName specifies the table name for ProductPriceHistory
+func (ProductPriceHistory) TableName() string {
+	return "product_price_history"
+}
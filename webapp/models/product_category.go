@@ -4,13 +4,18 @@ import "time"
 
 // ProductCategory represents product categories table
 type ProductCategory struct {
-	CategoryID   uint      `gorm:"primaryKey;column:category_id" json:"category_id"`
-	CategoryName string    `gorm:"type:varchar(100);not null;unique" json:"category_name"`
-	Description  *string   `gorm:"type:text" json:"description,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	CategoryID   uint    `gorm:"primaryKey;column:category_id" json:"category_id"`
+	CategoryName string  `gorm:"type:varchar(100);not null;unique" json:"category_name"`
+	Description  *string `gorm:"type:text" json:"description,omitempty"`
+	TaxClassID   *uint   `json:"tax_class_id,omitempty"`
+	// Sale rules of the category's products
+	SaleRestriction
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 
-	// Relationships - commented out to avoid circular dependency issues during migration
+	// Relationships
+	TaxClass *TaxClass `gorm:"foreignKey:TaxClassID" json:"tax_class,omitempty"`
+	// Reverse relationships - commented out to avoid circular dependency issues during migration
 	// Uncomment these after tables are created if you need eager loading
 	// Products       []Product      `gorm:"foreignKey:CategoryID" json:"products,omitempty"`
 	// DisplayShelves []DisplayShelf `gorm:"foreignKey:CategoryID" json:"display_shelves,omitempty"`
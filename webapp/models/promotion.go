@@ -0,0 +1,115 @@
+package models
+
+import "time"
+
+// PromotionType type for the promotion mechanics supported at checkout
+type PromotionType string
+
+const (
+	// PromotionBuyXGetY gives GetQuantity units at GetDiscountPercent off for every BuyQuantity bought
+	PromotionBuyXGetY PromotionType = "BUY_X_GET_Y"
+	// PromotionMultiBuy sells BundleQuantity units of the same product for BundlePrice
+	PromotionMultiBuy PromotionType = "MULTI_BUY"
+	// PromotionCombo sells one set of the promotion items (with their quantities) for ComboPrice
+	PromotionCombo PromotionType = "COMBO"
+	// PromotionSpendThreshold discounts the eligible lines once their value reaches MinSpend
+	PromotionSpendThreshold PromotionType = "SPEND_THRESHOLD"
+	// PromotionPercentOff takes DiscountPercent off the matching products, categories or brands
+	PromotionPercentOff PromotionType = "PERCENT_OFF"
+	// PromotionHappyHour is a percentage off that only runs inside its daily time window
+	PromotionHappyHour PromotionType = "HAPPY_HOUR"
+)
+
+// Promotion represents promotions table
+type Promotion struct {
+	PromotionID   uint          `gorm:"primaryKey;column:promotion_id" json:"promotion_id"`
+	PromotionCode string        `gorm:"type:varchar(50);not null;unique" json:"promotion_code"`
+	PromotionName string        `gorm:"type:varchar(200);not null" json:"promotion_name"`
+	PromotionType PromotionType `gorm:"type:varchar(20);not null" json:"promotion_type"`
+	Description   *string       `gorm:"type:text" json:"description,omitempty"`
+	StartDate     time.Time     `gorm:"type:date;not null" json:"start_date"`
+	EndDate       time.Time     `gorm:"type:date;not null" json:"end_date"`
+	// Higher priority promotions are evaluated first; a non-stackable promotion
+	// only applies to lines no other promotion has touched and then locks them
+	Priority  int  `gorm:"default:0" json:"priority"`
+	Stackable bool `gorm:"default:false" json:"stackable"`
+	IsActive  bool `gorm:"default:true" json:"is_active"`
+
+	// Mechanic parameters, used depending on PromotionType
+	BuyQuantity        *int     `json:"buy_quantity,omitempty"`
+	GetQuantity        *int     `json:"get_quantity,omitempty"`
+	GetDiscountPercent *float64 `gorm:"type:decimal(5,2)" json:"get_discount_percent,omitempty"`
+	BundleQuantity     *int     `json:"bundle_quantity,omitempty"`
+	BundlePrice        *float64 `gorm:"type:decimal(12,2)" json:"bundle_price,omitempty"`
+	ComboPrice         *float64 `gorm:"type:decimal(12,2)" json:"combo_price,omitempty"`
+	MinSpend           *float64 `gorm:"type:decimal(12,2)" json:"min_spend,omitempty"`
+	DiscountPercent    *float64 `gorm:"type:decimal(5,2)" json:"discount_percent,omitempty"`
+	DiscountAmount     *float64 `gorm:"type:decimal(12,2)" json:"discount_amount,omitempty"`
+	MaxDiscountAmount  *float64 `gorm:"type:decimal(12,2)" json:"max_discount_amount,omitempty"`
+
+	// Optional daily time window (required for HAPPY_HOUR), as HH:MM
+	HappyHourStart *string `gorm:"type:varchar(5)" json:"happy_hour_start,omitempty"`
+	HappyHourEnd   *string `gorm:"type:varchar(5)" json:"happy_hour_end,omitempty"`
+	// Optional ISO weekdays the promotion runs on, e.g. "1,2,3,4,5" (1 = Monday)
+	DaysOfWeek *string `gorm:"type:varchar(20)" json:"days_of_week,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Items []PromotionItem `gorm:"foreignKey:PromotionID" json:"items,omitempty"`
+}
+
+// TableName specifies the table name for Promotion
+func (Promotion) TableName() string {
+	return "promotions"
+}
+
+// IsRunning checks if the promotion is active on the date of t
+func (p *Promotion) IsRunning(t time.Time) bool {
+	day := t.Format("2006-01-02")
+	return p.IsActive && day >= p.StartDate.Format("2006-01-02") && day <= p.EndDate.Format("2006-01-02")
+}
+
+// PromotionItem represents promotion_items table: which products, categories or brands
+// a promotion covers. Exactly one of ProductID, CategoryID and Brand is set.
+// A promotion without items (spend threshold) covers the whole basket.
+type PromotionItem struct {
+	PromotionItemID uint    `gorm:"primaryKey;column:promotion_item_id" json:"promotion_item_id"`
+	PromotionID     uint    `gorm:"not null" json:"promotion_id"`
+	ProductID       *uint   `json:"product_id,omitempty"`
+	CategoryID      *uint   `json:"category_id,omitempty"`
+	Brand           *string `gorm:"type:varchar(100)" json:"brand,omitempty"`
+	// Units of the product needed for one combo set
+	Quantity int `gorm:"default:1;check:quantity > 0" json:"quantity"`
+
+	// Relationships
+	Promotion Promotion        `gorm:"foreignKey:PromotionID" json:"-"`
+	Product   *Product         `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Category  *ProductCategory `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+}
+
+// TableName specifies the table name for PromotionItem
+func (PromotionItem) TableName() string {
+	return "promotion_items"
+}
+
+// SalesInvoicePromotion represents sales_invoice_promotions table: every promotion
+// applied to an invoice line with the discount it gave (a line can stack several)
+type SalesInvoicePromotion struct {
+	InvoicePromotionID uint      `gorm:"primaryKey;column:invoice_promotion_id" json:"invoice_promotion_id"`
+	DetailID           uint      `gorm:"not null" json:"detail_id"`
+	PromotionID        uint      `gorm:"not null" json:"promotion_id"`
+	DiscountAmount     float64   `gorm:"type:decimal(12,2);not null" json:"discount_amount"`
+	FreeQuantity       int       `gorm:"default:0" json:"free_quantity"`
+	CreatedAt          time.Time `json:"created_at"`
+
+	// Relationships
+	Detail    SalesInvoiceDetail `gorm:"foreignKey:DetailID" json:"-"`
+	Promotion Promotion          `gorm:"foreignKey:PromotionID" json:"promotion,omitempty"`
+}
+
+// TableName specifies the table name for SalesInvoicePromotion
+func (SalesInvoicePromotion) TableName() string {
+	return "sales_invoice_promotions"
+}
@@ -8,10 +8,24 @@ type OrderStatus string
 const (
 	OrderPending   OrderStatus = "PENDING"
 	OrderApproved  OrderStatus = "APPROVED"
-	OrderReceived  OrderStatus = "RECEIVED"
 	OrderCancelled OrderStatus = "CANCELLED"
+	// The receiving statuses are derived from the order's goods receipts
+	OrderPartiallyReceived OrderStatus = "PARTIALLY_RECEIVED"
+	OrderReceived          OrderStatus = "RECEIVED"
+	// OrderClosedShort was closed with quantities still outstanding that will not be delivered
+	OrderClosedShort OrderStatus = "CLOSED_SHORT"
 )
 
+// AcceptsReceipts reports whether goods can still be received against an order with this status
+func (s OrderStatus) AcceptsReceipts() bool {
+	return s == OrderApproved || s == OrderPartiallyReceived
+}
+
+// HasReceipts reports whether the status is one only reached through goods receipts
+func (s OrderStatus) HasReceipts() bool {
+	return s == OrderPartiallyReceived || s == OrderReceived || s == OrderClosedShort
+}
+
 // PurchaseOrder represents purchase_orders table
 type PurchaseOrder struct {
 	OrderID      uint        `gorm:"primaryKey;column:order_id" json:"order_id"`
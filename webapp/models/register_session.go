@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// RegisterSessionStatus type for cash register session lifecycle
+type RegisterSessionStatus string
+
+const (
+	RegisterSessionOpen   RegisterSessionStatus = "OPEN"
+	RegisterSessionClosed RegisterSessionStatus = "CLOSED"
+)
+
+// RegisterSession represents register_sessions table (one till shift)
+type RegisterSession struct {
+	SessionID    uint                  `gorm:"primaryKey;column:session_id" json:"session_id"`
+	RegisterCode string                `gorm:"type:varchar(20);not null" json:"register_code"`
+	EmployeeID   uint                  `gorm:"not null" json:"employee_id"`
+	OpenedAt     time.Time             `gorm:"not null;default:CURRENT_TIMESTAMP" json:"opened_at"`
+	OpeningFloat float64               `gorm:"type:decimal(12,2);not null;default:0;check:opening_float >= 0" json:"opening_float"`
+	Status       RegisterSessionStatus `gorm:"type:varchar(20);not null;default:'OPEN'" json:"status"`
+	ClosedAt     *time.Time            `json:"closed_at,omitempty"`
+	ClosedBy     *uint                 `json:"closed_by,omitempty"`
+	ExpectedCash *float64              `gorm:"type:decimal(12,2)" json:"expected_cash,omitempty"`
+	CountedCash  *float64              `gorm:"type:decimal(12,2)" json:"counted_cash,omitempty"`
+	CashVariance *float64              `gorm:"type:decimal(12,2)" json:"cash_variance,omitempty"`
+	Notes        *string               `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt    time.Time             `json:"created_at"`
+
+	// Relationships
+	Employee       Employee  `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+	ClosedEmployee *Employee `gorm:"foreignKey:ClosedBy" json:"closed_employee,omitempty"`
+}
+
+// TableName specifies the table name for RegisterSession
+func (RegisterSession) TableName() string {
+	return "register_sessions"
+}
+
+// IsOpen checks if the session can still take sales
+func (rs *RegisterSession) IsOpen() bool {
+	return rs.Status == RegisterSessionOpen
+}
+
+// RegisterSessionTotal represents register_session_totals table:
+// the Z-report line per payment method frozen when the session is closed
+type RegisterSessionTotal struct {
+	TotalID        uint          `gorm:"primaryKey;column:total_id" json:"total_id"`
+	SessionID      uint          `gorm:"not null" json:"session_id"`
+	PaymentMethod  PaymentMethod `gorm:"type:varchar(20);not null" json:"payment_method"`
+	InvoiceCount   int           `gorm:"default:0" json:"invoice_count"`
+	ReturnCount    int           `gorm:"default:0" json:"return_count"`
+	RefundAmount   float64       `gorm:"type:decimal(12,2);not null;default:0" json:"refund_amount"`
+	ExpectedAmount float64       `gorm:"type:decimal(12,2);not null;default:0" json:"expected_amount"`
+	CountedAmount  float64       `gorm:"type:decimal(12,2);not null;default:0" json:"counted_amount"`
+	Variance       float64       `gorm:"type:decimal(12,2);not null;default:0" json:"variance"`
+	CreatedAt      time.Time     `json:"created_at"`
+
+	// Relationships
+	Session RegisterSession `gorm:"foreignKey:SessionID" json:"session,omitempty"`
+}
+
+// TableName specifies the table name for RegisterSessionTotal
+func (RegisterSessionTotal) TableName() string {
+	return "register_session_totals"
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// SaleRestrictionRule type for the rules a restricted product can break at the till
+type SaleRestrictionRule string
+
+const (
+	// RestrictionMinAge needs the cashier to confirm the buyer's age
+	RestrictionMinAge SaleRestrictionRule = "MIN_AGE"
+	// RestrictionQtyPerInvoice caps the units of the product on one invoice
+	RestrictionQtyPerInvoice SaleRestrictionRule = "MAX_QTY_PER_INVOICE"
+	// RestrictionQtyPerCustomerDay caps the units one member customer buys in a day
+	RestrictionQtyPerCustomerDay SaleRestrictionRule = "MAX_QTY_PER_CUSTOMER_DAY"
+	// RestrictionSaleHours limits the time of day the product may be sold
+	RestrictionSaleHours SaleRestrictionRule = "SALE_HOURS"
+)
+
+// SaleRestriction holds the sale rules of a product or a product category. A product's own
+// rule takes precedence over its category's, one rule at a time; nil means no rule.
+// Sale hours are HH:MM local times; an end before the start wraps past midnight.
+type SaleRestriction struct {
+	MinAge               *int    `json:"min_age,omitempty"`
+	MaxQtyPerInvoice     *int    `json:"max_qty_per_invoice,omitempty"`
+	MaxQtyPerCustomerDay *int    `json:"max_qty_per_customer_day,omitempty"`
+	SaleHoursStart       *string `gorm:"type:varchar(5)" json:"sale_hours_start,omitempty"`
+	SaleHoursEnd         *string `gorm:"type:varchar(5)" json:"sale_hours_end,omitempty"`
+}
+
+// IsEmpty reports whether no rule is set
+func (r SaleRestriction) IsEmpty() bool {
+	return r.MinAge == nil && r.MaxQtyPerInvoice == nil && r.MaxQtyPerCustomerDay == nil &&
+		r.SaleHoursStart == nil && r.SaleHoursEnd == nil
+}
+
+// Inherit fills the rules r does not set from fallback, normally the category's rules
+func (r SaleRestriction) Inherit(fallback SaleRestriction) SaleRestriction {
+	if r.MinAge == nil {
+		r.MinAge = fallback.MinAge
+	}
+	if r.MaxQtyPerInvoice == nil {
+		r.MaxQtyPerInvoice = fallback.MaxQtyPerInvoice
+	}
+	if r.MaxQtyPerCustomerDay == nil {
+		r.MaxQtyPerCustomerDay = fallback.MaxQtyPerCustomerDay
+	}
+	// Sale hours are one rule; the window is taken whole
+	if r.SaleHoursStart == nil && r.SaleHoursEnd == nil {
+		r.SaleHoursStart = fallback.SaleHoursStart
+		r.SaleHoursEnd = fallback.SaleHoursEnd
+	}
+	return r
+}
+
+// SaleAllowedAt checks t against the sale hours; without sale hours any time is allowed
+func (r SaleRestriction) SaleAllowedAt(t time.Time) bool {
+	if r.SaleHoursStart == nil || r.SaleHoursEnd == nil {
+		return true
+	}
+	now, start, end := t.Format("15:04"), *r.SaleHoursStart, *r.SaleHoursEnd
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
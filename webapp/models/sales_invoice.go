@@ -10,6 +10,30 @@ const (
 	PaymentCard     PaymentMethod = "CARD"
 	PaymentTransfer PaymentMethod = "TRANSFER"
 	PaymentVoucher  PaymentMethod = "VOUCHER"
+	// PaymentMixed marks an invoice settled with more than one tender type
+	PaymentMixed PaymentMethod = "MIXED"
+)
+
+// CashRoundingUnit is the smallest VND amount settled in cash
+const CashRoundingUnit = 1000.0
+
+// InvoiceStatus type for sales invoice lifecycle
+type InvoiceStatus string
+
+const (
+	InvoiceDraft     InvoiceStatus = "DRAFT"
+	InvoiceCompleted InvoiceStatus = "COMPLETED"
+	InvoiceVoided    InvoiceStatus = "VOIDED"
+)
+
+// OverrideType type for the approved changes to a line's price at the till
+type OverrideType string
+
+const (
+	// OverridePrice is a unit price typed in place of the list price
+	OverridePrice OverrideType = "PRICE"
+	// OverrideDiscount is a goodwill discount percentage off the list price
+	OverrideDiscount OverrideType = "DISCOUNT"
 )
 
 // SalesInvoice represents sales_invoices table
@@ -27,11 +51,32 @@ type SalesInvoice struct {
 	PointsEarned   int            `gorm:"default:0" json:"points_earned"`
 	PointsUsed     int            `gorm:"default:0" json:"points_used"`
 	Notes          *string        `gorm:"type:text" json:"notes,omitempty"`
-	CreatedAt      time.Time      `json:"created_at"`
+	Status         InvoiceStatus  `gorm:"type:varchar(20);not null;default:'COMPLETED'" json:"status"`
+	VoidedAt       *time.Time     `json:"voided_at,omitempty"`
+	VoidedBy       *uint          `json:"voided_by,omitempty"`
+	VoidReason     *string        `gorm:"type:text" json:"void_reason,omitempty"`
+	// PricingBreakdown is the explained server-side price quote the invoice was created from (JSON)
+	PricingBreakdown *string `gorm:"type:jsonb" json:"pricing_breakdown,omitempty"`
+	// Cash settlement: RoundingAdjustment is added to total_amount for the cash part, ChangeAmount is given back
+	RoundingAdjustment float64 `gorm:"type:decimal(12,2);default:0" json:"rounding_adjustment"`
+	ChangeAmount       float64 `gorm:"type:decimal(12,2);default:0" json:"change_amount"`
+	// SessionID is the register session (till shift) the sale was rung up in
+	SessionID *uint `json:"session_id,omitempty"`
+	// ClientUUID identifies a sale rung up offline by the till that uploads it; replays of the
+	// same UUID return the invoice already booked. SyncedAt is when the upload was booked.
+	ClientUUID *string    `gorm:"type:uuid" json:"client_uuid,omitempty"`
+	SyncedAt   *time.Time `json:"synced_at,omitempty"`
+	// Restricted products: AgeVerifiedBy is the cashier who confirmed the buyer's age,
+	// RestrictionOverrideBy the manager who let quantity or sale hour limits be exceeded
+	AgeVerifiedBy         *uint     `json:"age_verified_by,omitempty"`
+	RestrictionOverrideBy *uint     `json:"restriction_override_by,omitempty"`
+	CreatedAt             time.Time `json:"created_at"`
 
 	// Relationships
-	Customer *Customer `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
-	Employee Employee  `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+	Customer     *Customer        `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
+	Employee     Employee         `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+	VoidEmployee *Employee        `gorm:"foreignKey:VoidedBy" json:"void_employee,omitempty"`
+	Session      *RegisterSession `gorm:"foreignKey:SessionID" json:"session,omitempty"`
 	// Reverse relationships - commented out to avoid circular dependency issues during migration
 	// Details  []SalesInvoiceDetail `gorm:"foreignKey:InvoiceID" json:"details,omitempty"`
 }
@@ -41,24 +86,151 @@ func (SalesInvoice) TableName() string {
 	return "sales_invoices"
 }
 
+// IsVoided checks if the invoice has been voided
+func (si *SalesInvoice) IsVoided() bool {
+	return si.Status == InvoiceVoided
+}
+
 // SalesInvoiceDetail represents sales_invoice_details table
 type SalesInvoiceDetail struct {
-	DetailID           uint      `gorm:"primaryKey;column:detail_id" json:"detail_id"`
-	InvoiceID          uint      `gorm:"not null" json:"invoice_id"`
-	ProductID          uint      `gorm:"not null" json:"product_id"`
-	Quantity           int       `gorm:"not null;check:quantity > 0" json:"quantity"`
-	UnitPrice          float64   `gorm:"type:decimal(12,2);not null" json:"unit_price"`
-	DiscountPercentage float64   `gorm:"type:decimal(5,2);default:0" json:"discount_percentage"`
-	DiscountAmount     float64   `gorm:"type:decimal(12,2);default:0" json:"discount_amount"`
-	Subtotal           float64   `gorm:"type:decimal(12,2);not null" json:"subtotal"`
-	CreatedAt          time.Time `json:"created_at"`
+	DetailID           uint    `gorm:"primaryKey;column:detail_id" json:"detail_id"`
+	InvoiceID          uint    `gorm:"not null" json:"invoice_id"`
+	ProductID          uint    `gorm:"not null" json:"product_id"`
+	Quantity           int     `gorm:"not null;check:quantity > 0" json:"quantity"`
+	UnitPrice          float64 `gorm:"type:decimal(12,2);not null" json:"unit_price"`
+	DiscountPercentage float64 `gorm:"type:decimal(5,2);default:0" json:"discount_percentage"`
+	DiscountAmount     float64 `gorm:"type:decimal(12,2);default:0" json:"discount_amount"`
+	Subtotal           float64 `gorm:"type:decimal(12,2);not null" json:"subtotal"`
+	// Price breakdown from the pricing engine; ListPrice is NULL for lines priced the legacy way
+	ListPrice                *float64 `gorm:"type:decimal(12,2)" json:"list_price,omitempty"`
+	BatchDiscountAmount      float64  `gorm:"type:decimal(12,2);default:0" json:"batch_discount_amount"`
+	MembershipDiscountAmount float64  `gorm:"type:decimal(12,2);default:0" json:"membership_discount_amount"`
+	PointsDiscountAmount     float64  `gorm:"type:decimal(12,2);default:0" json:"points_discount_amount"`
+	PromotionDiscountAmount  float64  `gorm:"type:decimal(12,2);default:0" json:"promotion_discount_amount"`
+	// PromotionID is the first (highest priority) promotion applied to the line;
+	// all stacked promotions are listed in sales_invoice_promotions
+	PromotionID *uint `json:"promotion_id,omitempty"`
+	// Approved override of the line: ListPrice keeps the original price it replaced
+	OverrideApprovedBy *uint         `json:"override_approved_by,omitempty"`
+	OverrideType       *OverrideType `gorm:"type:varchar(10)" json:"override_type,omitempty"`
+	OverrideReason     *string       `gorm:"type:text" json:"override_reason,omitempty"`
+	// Tax resolved from the product or category tax class when the line was sold.
+	// TaxAmount is contained in Subtotal for INCLUSIVE lines and added on top for EXCLUSIVE ones.
+	TaxClassID *uint     `json:"tax_class_id,omitempty"`
+	TaxRate    float64   `gorm:"type:decimal(5,2);not null;default:10" json:"tax_rate"`
+	TaxMode    TaxMode   `gorm:"type:varchar(10);not null;default:'EXCLUSIVE'" json:"tax_mode"`
+	TaxAmount  float64   `gorm:"type:decimal(12,2);default:0" json:"tax_amount"`
+	CreatedAt  time.Time `json:"created_at"`
 
 	// Relationships
-	Invoice SalesInvoice `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
-	Product Product      `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Invoice          SalesInvoice `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	Product          Product      `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	OverrideApprover *Employee    `gorm:"foreignKey:OverrideApprovedBy" json:"override_approver,omitempty"`
+	Promotion        *Promotion   `gorm:"foreignKey:PromotionID" json:"promotion,omitempty"`
+	TaxClass         *TaxClass    `gorm:"foreignKey:TaxClassID" json:"tax_class,omitempty"`
 }
 
 // TableName specifies the table name for SalesInvoiceDetail
 func (SalesInvoiceDetail) TableName() string {
 	return "sales_invoice_details"
 }
+
+// SalesInvoiceAllocation represents sales_invoice_allocations table
+// Records which shelf batches (FEFO) supplied each invoice line
+type SalesInvoiceAllocation struct {
+	AllocationID    uint       `gorm:"primaryKey;column:allocation_id" json:"allocation_id"`
+	DetailID        uint       `gorm:"not null" json:"detail_id"`
+	ShelfID         uint       `gorm:"not null" json:"shelf_id"`
+	ShelfBatchID    *uint      `json:"shelf_batch_id,omitempty"`
+	BatchCode       string     `gorm:"type:varchar(50);not null" json:"batch_code"`
+	Quantity        int        `gorm:"not null;check:quantity > 0" json:"quantity"`
+	ExpiryDate      *time.Time `gorm:"type:date" json:"expiry_date,omitempty"`
+	BatchPrice      float64    `gorm:"type:decimal(12,2);not null" json:"batch_price"`
+	DiscountPercent float64    `gorm:"type:decimal(5,2);default:0" json:"discount_percent"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// Relationships
+	Detail SalesInvoiceDetail `gorm:"foreignKey:DetailID" json:"detail,omitempty"`
+	Shelf  DisplayShelf       `gorm:"foreignKey:ShelfID" json:"shelf,omitempty"`
+}
+
+// TableName specifies the table name for SalesInvoiceAllocation
+func (SalesInvoiceAllocation) TableName() string {
+	return "sales_invoice_allocations"
+}
+
+// SalesInvoicePayment represents sales_invoice_payments table (one row per tender)
+type SalesInvoicePayment struct {
+	PaymentID      uint          `gorm:"primaryKey;column:payment_id" json:"payment_id"`
+	InvoiceID      uint          `gorm:"not null" json:"invoice_id"`
+	PaymentMethod  PaymentMethod `gorm:"type:varchar(20);not null" json:"payment_method"`
+	Amount         float64       `gorm:"type:decimal(12,2);not null;check:amount >= 0" json:"amount"`
+	TenderedAmount float64       `gorm:"type:decimal(12,2);not null" json:"tendered_amount"`
+	ChangeAmount   float64       `gorm:"type:decimal(12,2);default:0" json:"change_amount"`
+	Reference      *string       `gorm:"type:varchar(100)" json:"reference,omitempty"`
+	// VoucherID is the voucher or gift card a VOUCHER tender was redeemed from
+	VoucherID *uint     `json:"voucher_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	Invoice SalesInvoice `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	Voucher *Voucher     `gorm:"foreignKey:VoucherID" json:"voucher,omitempty"`
+}
+
+// TableName specifies the table name for SalesInvoicePayment
+func (SalesInvoicePayment) TableName() string {
+	return "sales_invoice_payments"
+}
+
+// IsCash checks if the tender is cash, the only tender that can give change
+func (p *SalesInvoicePayment) IsCash() bool {
+	return p.PaymentMethod == PaymentCash
+}
+
+// StockShortageStatus type for the stock shortage lifecycle
+type StockShortageStatus string
+
+const (
+	StockShortageOpen StockShortageStatus = "OPEN"
+	// StockShortageSettled is a shortage taken out of shelf stock that arrived later
+	StockShortageSettled StockShortageStatus = "SETTLED"
+	// StockShortageDismissed is a shortage closed without touching stock, e.g. after a stock count
+	StockShortageDismissed StockShortageStatus = "DISMISSED"
+	// StockShortageCancelled is a shortage of an invoice that was voided
+	StockShortageCancelled StockShortageStatus = "CANCELLED"
+)
+
+// StockShortage represents stock_shortages table: units of an uploaded offline sale that were
+// not on the shelf when it was booked. The sale is kept and the missing units stay open on the
+// exception report until they are settled from later stock or dismissed.
+type StockShortage struct {
+	ShortageID      uint                `gorm:"primaryKey;column:shortage_id" json:"shortage_id"`
+	DetailID        uint                `gorm:"not null" json:"detail_id"`
+	InvoiceID       uint                `gorm:"not null" json:"invoice_id"`
+	ProductID       uint                `gorm:"not null" json:"product_id"`
+	ShelfID         *uint               `json:"shelf_id,omitempty"`
+	Quantity        int                 `gorm:"not null;check:quantity > 0" json:"quantity"`
+	SettledQuantity int                 `gorm:"not null;default:0" json:"settled_quantity"`
+	Status          StockShortageStatus `gorm:"type:varchar(20);not null;default:'OPEN'" json:"status"`
+	ResolvedBy      *uint               `json:"resolved_by,omitempty"`
+	ResolvedAt      *time.Time          `json:"resolved_at,omitempty"`
+	ResolutionNotes *string             `gorm:"type:text" json:"resolution_notes,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
+
+	// Relationships
+	Detail   SalesInvoiceDetail `gorm:"foreignKey:DetailID" json:"-"`
+	Invoice  SalesInvoice       `gorm:"foreignKey:InvoiceID" json:"-"`
+	Product  Product            `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Shelf    *DisplayShelf      `gorm:"foreignKey:ShelfID" json:"shelf,omitempty"`
+	Resolver *Employee          `gorm:"foreignKey:ResolvedBy" json:"resolver,omitempty"`
+}
+
+// TableName specifies the table name for StockShortage
+func (StockShortage) TableName() string {
+	return "stock_shortages"
+}
+
+// Outstanding is the part of the shortage not settled yet
+func (s *StockShortage) Outstanding() int {
+	return s.Quantity - s.SettledQuantity
+}
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// ReturnDisposition type for what happens to returned goods
+type ReturnDisposition string
+
+const (
+	ReturnRestock ReturnDisposition = "RESTOCK"
+	ReturnDamaged ReturnDisposition = "DAMAGED"
+)
+
+// SalesReturn represents sales_returns table
+type SalesReturn struct {
+	ReturnID       uint          `gorm:"primaryKey;column:return_id" json:"return_id"`
+	ReturnNo       string        `gorm:"type:varchar(30);not null;unique" json:"return_no"`
+	InvoiceID      uint          `gorm:"not null" json:"invoice_id"`
+	CustomerID     *uint         `json:"customer_id,omitempty"`
+	EmployeeID     uint          `gorm:"not null" json:"employee_id"`
+	ReturnDate     time.Time     `gorm:"not null;default:CURRENT_TIMESTAMP" json:"return_date"`
+	RefundAmount   float64       `gorm:"type:decimal(12,2);not null;default:0" json:"refund_amount"`
+	RefundMethod   PaymentMethod `gorm:"type:varchar(20);not null;default:'CASH'" json:"refund_method"`
+	SessionID      *uint         `json:"session_id,omitempty"` // Till session the refund was paid from
+	PointsReversed int           `gorm:"default:0" json:"points_reversed"`
+	PointsRestored int           `gorm:"default:0" json:"points_restored"`
+	Reason         *string       `gorm:"type:text" json:"reason,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+
+	// Relationships
+	Invoice  SalesInvoice `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	Customer *Customer    `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
+	Employee Employee     `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+}
+
+// TableName specifies the table name for SalesReturn
+func (SalesReturn) TableName() string {
+	return "sales_returns"
+}
+
+// SalesReturnDetail represents sales_return_details table
+type SalesReturnDetail struct {
+	ReturnDetailID  uint    `gorm:"primaryKey;column:return_detail_id" json:"return_detail_id"`
+	ReturnID        uint    `gorm:"not null" json:"return_id"`
+	InvoiceDetailID uint    `gorm:"not null" json:"invoice_detail_id"`
+	ProductID       uint    `gorm:"not null" json:"product_id"`
+	Quantity        int     `gorm:"not null;check:quantity > 0" json:"quantity"`
+	RefundAmount    float64 `gorm:"type:decimal(12,2);not null;default:0" json:"refund_amount"`
+	// TaxAmount is the output VAT of the invoice line given back with the refund
+	TaxAmount   float64           `gorm:"type:decimal(12,2);default:0" json:"tax_amount"`
+	Disposition ReturnDisposition `gorm:"type:varchar(20);not null;default:'RESTOCK'" json:"disposition"`
+	ShelfID     *uint             `json:"shelf_id,omitempty"`
+	BatchCode   *string           `gorm:"type:varchar(50)" json:"batch_code,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+
+	// Relationships
+	Return        SalesReturn        `gorm:"foreignKey:ReturnID" json:"return,omitempty"`
+	InvoiceDetail SalesInvoiceDetail `gorm:"foreignKey:InvoiceDetailID" json:"invoice_detail,omitempty"`
+	Product       Product            `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Shelf         *DisplayShelf      `gorm:"foreignKey:ShelfID" json:"shelf,omitempty"`
+}
+
+// TableName specifies the table name for SalesReturnDetail
+func (SalesReturnDetail) TableName() string {
+	return "sales_return_details"
+}
+
+// DamagedStock represents damaged_stock table (the damaged goods bin)
+type DamagedStock struct {
+	DamagedID  uint      `gorm:"primaryKey;column:damaged_id" json:"damaged_id"`
+	ProductID  uint      `gorm:"not null" json:"product_id"`
+	BatchCode  *string   `gorm:"type:varchar(50)" json:"batch_code,omitempty"`
+	Quantity   int       `gorm:"not null;check:quantity > 0" json:"quantity"`
+	SourceType string    `gorm:"type:varchar(30);not null" json:"source_type"`
+	SourceID   *uint     `json:"source_id,omitempty"`
+	Notes      *string   `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Relationships
+	Product Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+}
+
+// TableName specifies the table name for DamagedStock
+func (DamagedStock) TableName() string {
+	return "damaged_stock"
+}
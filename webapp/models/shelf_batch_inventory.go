@@ -2,6 +2,9 @@ package models
 
 import "time"
 
+// DefaultNearExpiryDays is the window in which a batch counts as near expiry
+const DefaultNearExpiryDays = 7
+
 // ShelfBatchInventory represents shelf_batch_inventory table
 // Chi tiết từng batch trên kệ để track expiry date và pricing
 type ShelfBatchInventory struct {
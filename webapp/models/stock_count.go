@@ -0,0 +1,160 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// StockCountScope type for what a stock count session covers
+type StockCountScope string
+
+const (
+	// StockCountWarehouse counts every batch held in one warehouse
+	StockCountWarehouse StockCountScope = "WAREHOUSE"
+	// StockCountShelf counts every batch on one display shelf
+	StockCountShelf StockCountScope = "SHELF"
+	// StockCountCategory counts the batches of one category's products in every warehouse and on every shelf
+	StockCountCategory StockCountScope = "CATEGORY"
+)
+
+// StockCountStatus type for the stock count session lifecycle
+type StockCountStatus string
+
+const (
+	// StockCountCounting takes the first count of every line
+	StockCountCounting StockCountStatus = "COUNTING"
+	// StockCountRecount takes a second count of the lines with a large variance
+	StockCountRecount StockCountStatus = "RECOUNT"
+	// StockCountSubmitted waits for a manager to review the variances and approve them
+	StockCountSubmitted StockCountStatus = "SUBMITTED"
+	// StockCountApproved has posted its variances to the inventory
+	StockCountApproved  StockCountStatus = "APPROVED"
+	StockCountCancelled StockCountStatus = "CANCELLED"
+)
+
+// StockLocation type for where a counted batch is held
+type StockLocation string
+
+const (
+	StockLocationWarehouse StockLocation = "WAREHOUSE"
+	StockLocationShelf     StockLocation = "SHELF"
+)
+
+// StockReasonCode type for why stock was adjusted or written off
+type StockReasonCode string
+
+const (
+	StockReasonDamaged        StockReasonCode = "DAMAGED"
+	StockReasonTheft          StockReasonCode = "THEFT"
+	StockReasonExpired        StockReasonCode = "EXPIRED"
+	StockReasonRecordingError StockReasonCode = "RECORDING_ERROR"
+	StockReasonFound          StockReasonCode = "FOUND"
+	StockReasonUnknown        StockReasonCode = "UNKNOWN"
+	// StockReasonDonation and StockReasonSupplierReturn only take stock out on a disposal
+	StockReasonDonation       StockReasonCode = "DONATION"
+	StockReasonSupplierReturn StockReasonCode = "SUPPLIER_RETURN"
+)
+
+// DefaultRecountThresholdPercent is the variance, in percent of the system quantity, from
+// which a counted line has to be counted again
+const DefaultRecountThresholdPercent = 10.0
+
+// StockCount represents stock_counts table: a physical or cycle count of the batches in one
+// warehouse, on one shelf or of one category. Blind sessions hide the system quantities from
+// the counters until the counts are submitted.
+type StockCount struct {
+	CountID     uint             `gorm:"primaryKey;column:count_id" json:"count_id"`
+	CountNo     string           `gorm:"type:varchar(30);not null;unique" json:"count_no"`
+	Scope       StockCountScope  `gorm:"type:varchar(20);not null" json:"scope"`
+	WarehouseID *uint            `json:"warehouse_id,omitempty"`
+	ShelfID     *uint            `json:"shelf_id,omitempty"`
+	CategoryID  *uint            `json:"category_id,omitempty"`
+	Blind       bool             `gorm:"default:false" json:"blind"`
+	Status      StockCountStatus `gorm:"type:varchar(20);not null;default:'COUNTING'" json:"status"`
+	// RecountThresholdPercent is the variance that sends a line to recount; lines with no
+	// system quantity are recounted whenever stock is found
+	RecountThresholdPercent float64    `gorm:"type:decimal(5,2);not null;default:10" json:"recount_threshold_percent"`
+	CreatedBy               uint       `gorm:"not null" json:"created_by"`
+	SubmittedAt             *time.Time `json:"submitted_at,omitempty"`
+	ApprovedBy              *uint      `json:"approved_by,omitempty"`
+	ApprovedAt              *time.Time `json:"approved_at,omitempty"`
+	Notes                   *string    `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+	UpdatedAt               time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for StockCount
+func (StockCount) TableName() string {
+	return "stock_counts"
+}
+
+// IsCounting checks if the session still takes counts
+func (sc *StockCount) IsCounting() bool {
+	return sc.Status == StockCountCounting || sc.Status == StockCountRecount
+}
+
+// HidesSystemQuantity checks if the counters must not see the system quantities yet
+func (sc *StockCount) HidesSystemQuantity() bool {
+	return sc.Blind && sc.IsCounting()
+}
+
+// StockCountLine represents stock_count_lines table: one batch in one warehouse or on one shelf.
+// SystemQuantity is the book quantity when the line was last counted, so sales and transfers
+// made before the count do not show up as variance.
+type StockCountLine struct {
+	LineID          uint             `gorm:"primaryKey;column:line_id" json:"line_id"`
+	CountID         uint             `gorm:"not null;index" json:"count_id"`
+	Location        StockLocation    `gorm:"type:varchar(20);not null" json:"location"`
+	WarehouseID     *uint            `json:"warehouse_id,omitempty"`
+	ShelfID         *uint            `json:"shelf_id,omitempty"`
+	ProductID       uint             `gorm:"not null" json:"product_id"`
+	BatchCode       string           `gorm:"type:varchar(50);not null" json:"batch_code"`
+	ExpiryDate      *time.Time       `gorm:"type:date" json:"expiry_date,omitempty"`
+	UnitCost        float64          `gorm:"type:decimal(12,2);not null;default:0" json:"unit_cost"`
+	SystemQuantity  int              `gorm:"not null;default:0" json:"system_quantity"`
+	CountedQuantity *int             `json:"counted_quantity,omitempty"`
+	CountedBy       *uint            `json:"counted_by,omitempty"`
+	CountedAt       *time.Time       `json:"counted_at,omitempty"`
+	NeedsRecount    bool             `gorm:"default:false" json:"needs_recount"`
+	RecountQuantity *int             `json:"recount_quantity,omitempty"`
+	RecountedBy     *uint            `json:"recounted_by,omitempty"`
+	RecountedAt     *time.Time       `json:"recounted_at,omitempty"`
+	ReasonCode      *StockReasonCode `gorm:"type:varchar(20)" json:"reason_code,omitempty"`
+	// AdjustedQuantity is the change posted to the batch when the session was approved
+	AdjustedQuantity *int      `json:"adjusted_quantity,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for StockCountLine
+func (StockCountLine) TableName() string {
+	return "stock_count_lines"
+}
+
+// FinalQuantity returns the quantity the line settles on: the recount when there is one
+func (l *StockCountLine) FinalQuantity() *int {
+	if l.RecountQuantity != nil {
+		return l.RecountQuantity
+	}
+	return l.CountedQuantity
+}
+
+// Variance returns the counted minus the system quantity, 0 while uncounted
+func (l *StockCountLine) Variance() int {
+	final := l.FinalQuantity()
+	if final == nil {
+		return 0
+	}
+	return *final - l.SystemQuantity
+}
+
+// ExceedsRecountThreshold checks the first count against the session's recount threshold
+func (l *StockCountLine) ExceedsRecountThreshold(thresholdPercent float64) bool {
+	if l.CountedQuantity == nil || *l.CountedQuantity == l.SystemQuantity {
+		return false
+	}
+	if l.SystemQuantity == 0 {
+		return true
+	}
+	variance := math.Abs(float64(*l.CountedQuantity - l.SystemQuantity))
+	return variance*100/float64(l.SystemQuantity) >= thresholdPercent
+}
@@ -2,10 +2,37 @@ package models
 
 import "time"
 
-// StockTransfer represents stock_transfers table
+// StockTransferHeader represents stock_transfer_headers table: one warehouse to shelf refill
+// of a product. The requested quantity is allocated over the warehouse batches first expiry
+// first out, each batch drawn on becoming one StockTransfer line.
+type StockTransferHeader struct {
+	HeaderID        uint   `gorm:"primaryKey;column:header_id" json:"header_id"`
+	TransferNo      string `gorm:"type:varchar(30);not null;unique" json:"transfer_no"`
+	ProductID       uint   `gorm:"not null" json:"product_id"`
+	FromWarehouseID uint   `gorm:"not null" json:"from_warehouse_id"`
+	ToShelfID       uint   `gorm:"not null" json:"to_shelf_id"`
+	Quantity        int    `gorm:"not null;check:quantity > 0" json:"quantity"`
+	EmployeeID      uint   `gorm:"not null" json:"employee_id"`
+	// PinnedBatchCode is the batch the user asked to draw on before the FEFO order
+	PinnedBatchCode *string   `gorm:"type:varchar(50)" json:"pinned_batch_code,omitempty"`
+	Notes           *string   `gorm:"type:text" json:"notes,omitempty"`
+	TransferDate    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"transfer_date"`
+	CreatedAt       time.Time `json:"created_at"`
+
+	// Relationships
+	Lines []StockTransfer `gorm:"foreignKey:HeaderID" json:"lines,omitempty"`
+}
+
+// TableName specifies the table name for StockTransferHeader
+func (StockTransferHeader) TableName() string {
+	return "stock_transfer_headers"
+}
+
+// StockTransfer represents stock_transfers table: the part of a transfer drawn from one batch
 type StockTransfer struct {
 	TransferID      uint       `gorm:"primaryKey;column:transfer_id" json:"transfer_id"`
 	TransferCode    string     `gorm:"type:varchar(30);not null;unique" json:"transfer_code"`
+	HeaderID        *uint      `gorm:"index" json:"header_id,omitempty"`
 	ProductID       uint       `gorm:"not null" json:"product_id"`
 	FromWarehouseID uint       `gorm:"not null" json:"from_warehouse_id"`
 	ToShelfID       uint       `gorm:"not null" json:"to_shelf_id"`
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// TaxMode type for how a tax class treats the selling price
+type TaxMode string
+
+const (
+	// TaxInclusive means the selling price already contains the tax
+	TaxInclusive TaxMode = "INCLUSIVE"
+	// TaxExclusive means the tax is added on top of the selling price
+	TaxExclusive TaxMode = "EXCLUSIVE"
+)
+
+// Default tax for products whose product and category have no tax class
+const (
+	DefaultTaxRate = 10.0
+	DefaultTaxMode = TaxExclusive
+)
+
+// TaxClass represents tax_classes table. A class is assigned to a product category and can
+// be overridden per product.
+type TaxClass struct {
+	TaxClassID  uint      `gorm:"primaryKey;column:tax_class_id" json:"tax_class_id"`
+	TaxCode     string    `gorm:"type:varchar(20);not null;unique" json:"tax_code"`
+	TaxName     string    `gorm:"type:varchar(100);not null" json:"tax_name"`
+	Rate        float64   `gorm:"type:decimal(5,2);not null;check:rate >= 0 AND rate <= 100" json:"rate"`
+	TaxMode     TaxMode   `gorm:"type:varchar(10);not null;default:'INCLUSIVE'" json:"tax_mode"`
+	Description *string   `gorm:"type:text" json:"description,omitempty"`
+	IsActive    bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for TaxClass
+func (TaxClass) TableName() string {
+	return "tax_classes"
+}
+
+// LineTax returns the tax contained in (inclusive) or added to (exclusive) a net line
+// amount, rounded like calculate_detail_subtotal does
+func LineTax(amount, rate float64, mode TaxMode) float64 {
+	var tax float64
+	if mode == TaxInclusive {
+		tax = amount * rate / (100 + rate)
+	} else {
+		tax = amount * rate / 100
+	}
+	return math.Round(tax*100) / 100
+}
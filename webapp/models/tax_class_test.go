@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestLineTax(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+		rate   float64
+		mode   TaxMode
+		want   float64
+	}{
+		{"inclusive 10%", 110000, 10, TaxInclusive, 10000},
+		{"exclusive 10%", 100000, 10, TaxExclusive, 10000},
+		{"inclusive 5%", 105000, 5, TaxInclusive, 5000},
+		{"inclusive rounds to 2 decimals", 99999, 8, TaxInclusive, 7407.33},
+		{"exclusive rounds to 2 decimals", 12345.67, 8, TaxExclusive, 987.65},
+		{"zero rate", 50000, 0, TaxInclusive, 0},
+		{"zero amount", 0, 10, TaxExclusive, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LineTax(tt.amount, tt.rate, tt.mode); got != tt.want {
+				t.Errorf("LineTax(%v, %v, %s) = %v, want %v", tt.amount, tt.rate, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
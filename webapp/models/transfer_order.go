@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// TransferOrderStatus type for the dispatch/receive lifecycle of a transfer order
+type TransferOrderStatus string
+
+const (
+	// TransferOrderInTransit has left its source; its quantities are in no location until received
+	TransferOrderInTransit TransferOrderStatus = "IN_TRANSIT"
+	// TransferOrderReceived has been booked into the destination warehouse, shortfalls written off
+	TransferOrderReceived TransferOrderStatus = "RECEIVED"
+	// TransferOrderCancelled was called back before receipt and gave its quantities back to the source batches
+	TransferOrderCancelled TransferOrderStatus = "CANCELLED"
+)
+
+// TransferOrder represents transfer_orders table: stock moved from a warehouse or a display
+// shelf into another warehouse in two steps. Dispatch takes the batches out of the source,
+// receipt books what arrived into the destination under the same batch codes and expiry dates.
+// Warehouse to shelf refills stay on StockTransfer.
+type TransferOrder struct {
+	TransferOrderID uint                `gorm:"primaryKey;column:transfer_order_id" json:"transfer_order_id"`
+	TransferNo      string              `gorm:"type:varchar(30);not null;unique" json:"transfer_no"`
+	FromLocation    StockLocation       `gorm:"type:varchar(20);not null" json:"from_location"`
+	FromWarehouseID *uint               `json:"from_warehouse_id,omitempty"`
+	FromShelfID     *uint               `json:"from_shelf_id,omitempty"`
+	ToWarehouseID   uint                `gorm:"not null" json:"to_warehouse_id"`
+	Status          TransferOrderStatus `gorm:"type:varchar(20);not null;default:'IN_TRANSIT'" json:"status"`
+	DispatchedBy    uint                `gorm:"not null" json:"dispatched_by"`
+	DispatchedAt    time.Time           `gorm:"not null;default:CURRENT_TIMESTAMP" json:"dispatched_at"`
+	ReceivedBy      *uint               `json:"received_by,omitempty"`
+	ReceivedAt      *time.Time          `json:"received_at,omitempty"`
+	Notes           *string             `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at"`
+
+	// Relationships
+	Lines []TransferOrderLine `gorm:"foreignKey:TransferOrderID" json:"lines,omitempty"`
+}
+
+// TableName specifies the table name for TransferOrder
+func (TransferOrder) TableName() string {
+	return "transfer_orders"
+}
+
+// IsInTransit reports whether the order still waits for its receipt
+func (t *TransferOrder) IsInTransit() bool {
+	return t.Status == TransferOrderInTransit
+}
+
+// TransferOrderLine represents transfer_order_lines table: the quantity of one source batch
+// sent on a transfer order and what arrived of it
+type TransferOrderLine struct {
+	LineID          uint `gorm:"primaryKey;column:line_id" json:"line_id"`
+	TransferOrderID uint `gorm:"not null;index" json:"transfer_order_id"`
+	// SourceBatchID is the inventory_id or shelf_batch_id the quantity was taken from
+	SourceBatchID      uint             `gorm:"not null" json:"source_batch_id"`
+	ProductID          uint             `gorm:"not null" json:"product_id"`
+	BatchCode          string           `gorm:"type:varchar(50);not null" json:"batch_code"`
+	ExpiryDate         *time.Time       `gorm:"type:date" json:"expiry_date,omitempty"`
+	ImportPrice        float64          `gorm:"type:decimal(12,2);not null" json:"import_price"`
+	DispatchedQuantity int              `gorm:"not null" json:"dispatched_quantity"`
+	ReceivedQuantity   *int             `json:"received_quantity,omitempty"`
+	DiscrepancyReason  *StockReasonCode `gorm:"type:varchar(20)" json:"discrepancy_reason,omitempty"`
+	CreatedAt          time.Time        `json:"created_at"`
+}
+
+// TableName specifies the table name for TransferOrderLine
+func (TransferOrderLine) TableName() string {
+	return "transfer_order_lines"
+}
+
+// InTransitQuantity returns the quantity on the way; nothing once the line is received
+func (l *TransferOrderLine) InTransitQuantity() int {
+	if l.ReceivedQuantity != nil {
+		return 0
+	}
+	return l.DispatchedQuantity
+}
+
+// ShortQuantity returns how much less arrived than was dispatched
+func (l *TransferOrderLine) ShortQuantity() int {
+	if l.ReceivedQuantity == nil {
+		return 0
+	}
+	return l.DispatchedQuantity - *l.ReceivedQuantity
+}
@@ -0,0 +1,98 @@
+package models
+
+import "time"
+
+// VoucherKind type for stored-value instruments accepted as the VOUCHER tender
+type VoucherKind string
+
+const (
+	// VoucherKindVoucher is a promotional or compensation voucher issued free of charge
+	VoucherKindVoucher VoucherKind = "VOUCHER"
+	// VoucherKindGiftCard is a gift card sold to a customer and reloadable when multi-use
+	VoucherKindGiftCard VoucherKind = "GIFT_CARD"
+)
+
+// VoucherStatus type for voucher lifecycle
+type VoucherStatus string
+
+const (
+	VoucherActive    VoucherStatus = "ACTIVE"
+	VoucherRedeemed  VoucherStatus = "REDEEMED"
+	VoucherExpired   VoucherStatus = "EXPIRED"
+	VoucherCancelled VoucherStatus = "CANCELLED"
+)
+
+// VoucherEntryType type for the voucher ledger entries
+type VoucherEntryType string
+
+const (
+	VoucherEntryIssue    VoucherEntryType = "ISSUE"
+	VoucherEntryTopUp    VoucherEntryType = "TOPUP"
+	VoucherEntryRedeem   VoucherEntryType = "REDEEM"
+	VoucherEntryReversal VoucherEntryType = "REVERSAL"
+	VoucherEntryExpire   VoucherEntryType = "EXPIRE"
+	VoucherEntryCancel   VoucherEntryType = "CANCEL"
+	// VoucherEntryForfeit writes off what is left of a single-use voucher after its redemption
+	VoucherEntryForfeit VoucherEntryType = "FORFEIT"
+)
+
+// Voucher represents vouchers table: a voucher or gift card with a unique code.
+// Balance always equals the sum of its voucher_transactions amounts.
+type Voucher struct {
+	VoucherID   uint          `gorm:"primaryKey;column:voucher_id" json:"voucher_id"`
+	VoucherCode string        `gorm:"type:varchar(50);not null;unique" json:"voucher_code"`
+	Kind        VoucherKind   `gorm:"type:varchar(20);not null" json:"kind"`
+	FaceValue   float64       `gorm:"type:decimal(12,2);not null;check:face_value > 0" json:"face_value"`
+	Balance     float64       `gorm:"type:decimal(12,2);not null;check:balance >= 0" json:"balance"`
+	MultiUse    bool          `gorm:"default:false" json:"multi_use"`
+	Status      VoucherStatus `gorm:"type:varchar(20);not null;default:'ACTIVE'" json:"status"`
+	ExpiresAt   *time.Time    `gorm:"type:date" json:"expires_at,omitempty"`
+	CustomerID  *uint         `json:"customer_id,omitempty"`
+	IssuedBy    *uint         `json:"issued_by,omitempty"`
+	Notes       *string       `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+
+	// Relationships
+	Customer *Customer `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
+	Issuer   *Employee `gorm:"foreignKey:IssuedBy" json:"issuer,omitempty"`
+}
+
+// TableName specifies the table name for Voucher
+func (Voucher) TableName() string {
+	return "vouchers"
+}
+
+// IsRedeemable checks if the voucher can pay for a sale on the date of t
+func (v *Voucher) IsRedeemable(t time.Time) bool {
+	if v.Status != VoucherActive || v.Balance <= 0 {
+		return false
+	}
+	return v.ExpiresAt == nil || t.Format("2006-01-02") <= v.ExpiresAt.Format("2006-01-02")
+}
+
+// VoucherTransaction represents voucher_transactions table: the append-only issuance and
+// redemption ledger. Amount is signed: issuance and top-ups add to the outstanding
+// liability, redemptions, expiry and cancellation take it away.
+type VoucherTransaction struct {
+	TransactionID uint             `gorm:"primaryKey;column:transaction_id" json:"transaction_id"`
+	VoucherID     uint             `gorm:"not null" json:"voucher_id"`
+	EntryType     VoucherEntryType `gorm:"type:varchar(20);not null" json:"entry_type"`
+	Amount        float64          `gorm:"type:decimal(12,2);not null" json:"amount"`
+	BalanceAfter  float64          `gorm:"type:decimal(12,2);not null" json:"balance_after"`
+	InvoiceID     *uint            `json:"invoice_id,omitempty"`
+	PaymentID     *uint            `json:"payment_id,omitempty"`
+	EmployeeID    *uint            `json:"employee_id,omitempty"`
+	Notes         *string          `gorm:"type:text" json:"notes,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+
+	// Relationships
+	Voucher  Voucher       `gorm:"foreignKey:VoucherID" json:"-"`
+	Invoice  *SalesInvoice `gorm:"foreignKey:InvoiceID" json:"invoice,omitempty"`
+	Employee *Employee     `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+}
+
+// TableName specifies the table name for VoucherTransaction
+func (VoucherTransaction) TableName() string {
+	return "voucher_transactions"
+}
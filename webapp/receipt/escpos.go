@@ -0,0 +1,238 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PaperColumns is the number of Font A characters on a line of 80mm paper
+const PaperColumns = 48
+
+// ESC/POS command bytes
+const (
+	esc = 0x1B
+	gs  = 0x1D
+	lf  = 0x0A
+)
+
+// escposWriter builds an ESC/POS job; all text goes through Fold
+type escposWriter struct {
+	buf  bytes.Buffer
+	cols int
+}
+
+func (w *escposWriter) init() {
+	w.buf.Write([]byte{esc, '@'})
+}
+
+// align sets 0 = left, 1 = center, 2 = right
+func (w *escposWriter) align(n byte) {
+	w.buf.Write([]byte{esc, 'a', n})
+}
+
+func (w *escposWriter) bold(on bool) {
+	var n byte
+	if on {
+		n = 1
+	}
+	w.buf.Write([]byte{esc, 'E', n})
+}
+
+// size sets the character size; 0x00 is normal, 0x11 double width and height
+func (w *escposWriter) size(n byte) {
+	w.buf.Write([]byte{gs, '!', n})
+}
+
+func (w *escposWriter) line(s string) {
+	w.buf.WriteString(Fold(s))
+	w.buf.WriteByte(lf)
+}
+
+// wrap prints s over as many lines as it needs at the given width
+func (w *escposWriter) wrap(s string, width int) {
+	for _, l := range wrapText(Fold(s), width) {
+		w.buf.WriteString(l)
+		w.buf.WriteByte(lf)
+	}
+}
+
+// pair prints left and right aligned text on one line, wrapping the left part if needed
+func (w *escposWriter) pair(left, right string) {
+	left, right = Fold(left), Fold(right)
+	room := w.cols - len(right) - 1
+	lines := wrapText(left, room)
+	for i, l := range lines {
+		if i < len(lines)-1 {
+			w.buf.WriteString(l)
+			w.buf.WriteByte(lf)
+			continue
+		}
+		w.buf.WriteString(l)
+		w.buf.WriteString(strings.Repeat(" ", w.cols-len(l)-len(right)))
+		w.buf.WriteString(right)
+		w.buf.WriteByte(lf)
+	}
+}
+
+func (w *escposWriter) rule(ch string) {
+	w.buf.WriteString(strings.Repeat(ch, w.cols))
+	w.buf.WriteByte(lf)
+}
+
+func (w *escposWriter) feed(n byte) {
+	w.buf.Write([]byte{esc, 'd', n})
+}
+
+// qr prints a QR code with the printer's own encoder (GS ( k, model 2, error level M)
+func (w *escposWriter) qr(data string, moduleSize byte) {
+	w.buf.Write([]byte{gs, '(', 'k', 4, 0, 49, 65, 50, 0})      // model 2
+	w.buf.Write([]byte{gs, '(', 'k', 3, 0, 49, 67, moduleSize}) // module size
+	w.buf.Write([]byte{gs, '(', 'k', 3, 0, 49, 69, 49})         // error correction M
+	n := len(data) + 3
+	w.buf.Write([]byte{gs, '(', 'k', byte(n % 256), byte(n / 256), 49, 80, 48})
+	w.buf.WriteString(data)
+	w.buf.Write([]byte{gs, '(', 'k', 3, 0, 49, 81, 48}) // print
+}
+
+// cut feeds the paper past the cutter and makes a partial cut
+func (w *escposWriter) cut() {
+	w.buf.Write([]byte{gs, 'V', 66, 0})
+}
+
+// ESCPOS renders the receipt as an ESC/POS job for an 80mm thermal printer
+func ESCPOS(r *Receipt) []byte {
+	w := &escposWriter{cols: PaperColumns}
+	w.init()
+
+	// Store header
+	w.align(1)
+	w.bold(true)
+	w.size(0x11)
+	w.wrap(r.StoreName, PaperColumns/2)
+	w.size(0x00)
+	w.bold(false)
+	w.wrap(r.StoreAddress, PaperColumns)
+	if r.StorePhone != "" {
+		w.line("ĐT: " + r.StorePhone)
+	}
+	if r.StoreTaxCode != "" {
+		w.line("MST: " + r.StoreTaxCode)
+	}
+	w.feed(1)
+	w.bold(true)
+	w.line("HÓA ĐƠN BÁN HÀNG")
+	w.bold(false)
+	if r.Status != "" && r.Status != "COMPLETED" {
+		w.line("*** " + r.Status + " ***")
+	}
+
+	// Invoice info
+	w.align(0)
+	w.line("Số HĐ: " + r.InvoiceNo)
+	w.line("Ngày: " + r.InvoiceDate.Format("02/01/2006 15:04"))
+	if r.RegisterCode != "" {
+		w.line("Quầy: " + r.RegisterCode)
+	}
+	w.line("Thu ngân: " + r.Cashier)
+	if r.CustomerName != "" {
+		w.line("Khách hàng: " + r.CustomerName)
+	}
+	w.rule("-")
+
+	// Lines: name, then quantity x price with the amount on the right
+	for _, l := range r.Lines {
+		w.wrap(l.ProductName, PaperColumns)
+		w.pair(fmt.Sprintf("%d x %s (VAT %s)", l.Quantity, Money(l.UnitPrice), Rate(l.TaxRate)), Money(l.Amount))
+		if l.Discount > 0 {
+			w.pair("Giảm giá", "-"+Money(l.Discount))
+		}
+	}
+	w.rule("-")
+
+	// Totals and tax breakdown
+	w.pair("Tạm tính", Money(r.Subtotal))
+	if r.DiscountAmount > 0 {
+		w.pair("Giảm giá", "-"+Money(r.DiscountAmount))
+	}
+	for _, t := range r.Taxes {
+		w.pair(t.label(), Money(t.Tax))
+	}
+	if r.PointsUsed > 0 {
+		w.pair("Điểm sử dụng", fmt.Sprintf("-%d", r.PointsUsed))
+	}
+	w.bold(true)
+	w.size(0x01)
+	w.pair("TỔNG CỘNG", Money(r.TotalAmount))
+	w.size(0x00)
+	w.bold(false)
+	if r.Rounding != 0 {
+		w.pair("Làm tròn tiền mặt", Money(r.Rounding))
+	}
+	for _, p := range r.Payments {
+		label := p.Label
+		if p.Reference != "" {
+			label += " (" + p.Reference + ")"
+		}
+		w.pair(label, Money(p.Amount))
+	}
+	if r.ChangeAmount > 0 {
+		w.pair("Tiền thối lại", Money(r.ChangeAmount))
+	}
+	if r.PointsEarned > 0 {
+		w.pair("Điểm tích lũy", fmt.Sprintf("+%d", r.PointsEarned))
+	}
+	if r.Notes != "" {
+		w.rule("-")
+		w.wrap(r.Notes, PaperColumns)
+	}
+
+	// QR of the invoice number, used to look the invoice up for returns
+	w.feed(1)
+	w.align(1)
+	w.qr(r.InvoiceNo, 6)
+	w.line(r.InvoiceNo)
+	w.feed(1)
+	w.line("Cảm ơn quý khách!")
+	w.feed(4)
+	w.cut()
+
+	return w.buf.Bytes()
+}
+
+// wrapText breaks ASCII text into lines of at most width characters, on spaces when possible
+func wrapText(s string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	current := ""
+	for _, word := range words {
+		for len(word) > width {
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case current == "":
+			current = word
+		case len(current)+1+len(word) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
@@ -0,0 +1,262 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The PDF is A4 and set in Courier, the monospaced standard font, so columns line up without
+// embedding a font or carrying width tables. Every standard PDF reader has Courier built in.
+const (
+	pdfPageWidth   = 595.0
+	pdfPageHeight  = 842.0
+	pdfMargin      = 50.0
+	pdfFontSize    = 9.0
+	pdfLineHeight  = 12.0
+	pdfCharWidth   = 0.6 * pdfFontSize
+	pdfColumns     = 91 // (pdfPageWidth - 2*pdfMargin) / pdfCharWidth, rounded down
+	pdfBottomLimit = 60.0
+)
+
+// pdfWriter lays out text lines over as many pages as needed
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+func (w *pdfWriter) newPage() {
+	w.page = &bytes.Buffer{}
+	w.pages = append(w.pages, w.page)
+	w.y = pdfPageHeight - pdfMargin
+}
+
+// ensure starts a new page when fewer than n lines fit on the current one
+func (w *pdfWriter) ensure(n int) {
+	if w.page == nil || w.y-float64(n)*pdfLineHeight < pdfBottomLimit {
+		w.newPage()
+	}
+}
+
+// text writes s at column col of the current line; font is F1 (regular) or F2 (bold)
+func (w *pdfWriter) text(col int, s string, font string, size float64) {
+	x := pdfMargin + float64(col)*pdfCharWidth
+	fmt.Fprintf(w.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, w.y, pdfEscape(Fold(s)))
+}
+
+// right writes s so that it ends at column end
+func (w *pdfWriter) right(end int, s string, font string) {
+	w.text(end-len(Fold(s)), s, font, pdfFontSize)
+}
+
+// center writes s centred on the page at the given size
+func (w *pdfWriter) center(s string, font string, size float64) {
+	s = Fold(s)
+	width := float64(len(s)) * 0.6 * size
+	x := (pdfPageWidth - width) / 2
+	fmt.Fprintf(w.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, w.y, pdfEscape(s))
+}
+
+func (w *pdfWriter) rule() {
+	y := w.y + pdfLineHeight/2 - 2
+	fmt.Fprintf(w.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfMargin, y, pdfPageWidth-pdfMargin, y)
+}
+
+func (w *pdfWriter) next(lines float64) {
+	w.y -= lines * pdfLineHeight
+}
+
+// pair writes a label and an amount on one line of the totals block
+func (w *pdfWriter) pair(label, value string, font string) {
+	w.ensure(1)
+	w.text(pdfColumns-40, label, font, pdfFontSize)
+	w.right(pdfColumns, value, font)
+	w.next(1)
+}
+
+// PDF renders the receipt as an A4 invoice document
+func PDF(r *Receipt) []byte {
+	w := &pdfWriter{}
+	w.newPage()
+
+	// Store header
+	w.center(r.StoreName, "F2", 16)
+	w.next(1.6)
+	for _, l := range wrapText(Fold(r.StoreAddress), pdfColumns) {
+		w.center(l, "F1", pdfFontSize)
+		w.next(1)
+	}
+	var contact []string
+	if r.StorePhone != "" {
+		contact = append(contact, "ĐT: "+r.StorePhone)
+	}
+	if r.StoreTaxCode != "" {
+		contact = append(contact, "MST: "+r.StoreTaxCode)
+	}
+	if len(contact) > 0 {
+		w.center(strings.Join(contact, "   "), "F1", pdfFontSize)
+		w.next(1)
+	}
+	w.next(1)
+	w.center("HÓA ĐƠN BÁN HÀNG", "F2", 14)
+	w.next(1.5)
+	if r.Status != "" && r.Status != "COMPLETED" {
+		w.center("*** "+r.Status+" ***", "F2", pdfFontSize)
+		w.next(1)
+	}
+
+	// Invoice info
+	w.text(0, "Số hóa đơn: "+r.InvoiceNo, "F1", pdfFontSize)
+	w.right(pdfColumns, "Ngày: "+r.InvoiceDate.Format("02/01/2006 15:04"), "F1")
+	w.next(1)
+	info := "Thu ngân: " + r.Cashier
+	if r.RegisterCode != "" {
+		info += "   Quầy: " + r.RegisterCode
+	}
+	w.text(0, info, "F1", pdfFontSize)
+	w.next(1)
+	customer := r.CustomerName
+	if customer == "" {
+		customer = "Khách lẻ"
+	}
+	w.text(0, "Khách hàng: "+customer, "F1", pdfFontSize)
+	w.next(2)
+
+	// Line table: STT, code, name, qty, unit price, VAT, amount
+	const (
+		colNo     = 0
+		colCode   = 4
+		colName   = 16
+		nameWidth = 32
+		endQty    = 54
+		endPrice  = 67
+		endRate   = 74
+	)
+	header := func() {
+		w.rule()
+		w.text(colNo, "STT", "F2", pdfFontSize)
+		w.text(colCode, "Mã SP", "F2", pdfFontSize)
+		w.text(colName, "Tên sản phẩm", "F2", pdfFontSize)
+		w.right(endQty, "SL", "F2")
+		w.right(endPrice, "Đơn giá", "F2")
+		w.right(endRate, "VAT", "F2")
+		w.right(pdfColumns, "Thành tiền", "F2")
+		w.next(1)
+		w.rule()
+	}
+	header()
+	for i, l := range r.Lines {
+		names := wrapText(Fold(l.ProductName), nameWidth)
+		rows := len(names)
+		if l.Discount > 0 {
+			rows++
+		}
+		if w.y-float64(rows)*pdfLineHeight < pdfBottomLimit {
+			w.newPage()
+			header()
+		}
+		w.text(colNo, fmt.Sprintf("%d", i+1), "F1", pdfFontSize)
+		w.text(colCode, l.ProductCode, "F1", pdfFontSize)
+		w.right(endQty, fmt.Sprintf("%d", l.Quantity), "F1")
+		w.right(endPrice, Money(l.UnitPrice), "F1")
+		w.right(endRate, Rate(l.TaxRate), "F1")
+		w.right(pdfColumns, Money(l.Amount), "F1")
+		for _, name := range names {
+			w.text(colName, name, "F1", pdfFontSize)
+			w.next(1)
+		}
+		if l.Discount > 0 {
+			w.text(colName, "Giảm giá", "F1", pdfFontSize)
+			w.right(pdfColumns, "-"+Money(l.Discount), "F1")
+			w.next(1)
+		}
+	}
+	w.rule()
+	w.next(0.5)
+
+	// Totals and tax breakdown
+	w.pair("Tạm tính", Money(r.Subtotal), "F1")
+	if r.DiscountAmount > 0 {
+		w.pair("Giảm giá", "-"+Money(r.DiscountAmount), "F1")
+	}
+	for _, t := range r.Taxes {
+		w.pair(t.label(), Money(t.Tax), "F1")
+	}
+	if r.PointsUsed > 0 {
+		w.pair("Điểm sử dụng", fmt.Sprintf("-%d", r.PointsUsed), "F1")
+	}
+	w.pair("TỔNG CỘNG", Money(r.TotalAmount), "F2")
+	if r.Rounding != 0 {
+		w.pair("Làm tròn tiền mặt", Money(r.Rounding), "F1")
+	}
+	for _, p := range r.Payments {
+		label := p.Label
+		if p.Reference != "" {
+			label += " (" + p.Reference + ")"
+		}
+		w.pair(label, Money(p.Amount), "F1")
+	}
+	if r.ChangeAmount > 0 {
+		w.pair("Tiền thối lại", Money(r.ChangeAmount), "F1")
+	}
+	if r.PointsEarned > 0 {
+		w.pair("Điểm tích lũy", fmt.Sprintf("+%d", r.PointsEarned), "F1")
+	}
+	if r.Notes != "" {
+		w.next(1)
+		for _, l := range wrapText(Fold("Ghi chú: "+r.Notes), pdfColumns) {
+			w.ensure(1)
+			w.text(0, l, "F1", pdfFontSize)
+			w.next(1)
+		}
+	}
+	w.ensure(2)
+	w.next(1)
+	w.center("Cảm ơn quý khách!", "F1", pdfFontSize)
+
+	return assemblePDF(w.pages, "Hoa don "+Fold(r.InvoiceNo))
+}
+
+// assemblePDF writes the page content streams into a PDF 1.4 file with a cross-reference table
+func assemblePDF(pages []*bytes.Buffer, title string) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-5 are fixed; each page then takes a page object and a content stream
+	const firstPage = 6
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (Supermarket) >>", pdfEscape(title)))
+	for i, content := range pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape escapes the characters that end or break a PDF literal string
+func pdfEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", " ", "\n", " ")
+	return r.Replace(s)
+}
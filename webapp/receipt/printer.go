@@ -0,0 +1,25 @@
+package receipt
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Send writes a job to a network receipt printer on a raw TCP port (usually 9100). The printer
+// starts printing as the bytes arrive, so the job is done once the connection closes cleanly.
+func Send(addr string, job []byte, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("không kết nối được máy in %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(job); err != nil {
+		return fmt.Errorf("gửi lệnh in thất bại: %w", err)
+	}
+	return nil
+}
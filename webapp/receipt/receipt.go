@@ -0,0 +1,123 @@
+// Package receipt renders a sales invoice for output devices: an ESC/POS byte stream for
+// 80mm thermal till printers and a PDF document for e-mail and archiving. Both renderers
+// take the same Receipt, which the web handlers fill from the database.
+package receipt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Receipt is everything printed on a customer receipt
+type Receipt struct {
+	StoreName    string
+	StoreAddress string
+	StorePhone   string
+	StoreTaxCode string
+
+	InvoiceNo    string
+	InvoiceDate  time.Time
+	RegisterCode string
+	Cashier      string
+	CustomerName string
+	Status       string
+
+	Lines []Line
+	Taxes []TaxLine
+
+	Subtotal       float64
+	DiscountAmount float64
+	TotalAmount    float64
+	Rounding       float64
+	Payments       []Payment
+	ChangeAmount   float64
+	PointsUsed     int
+	PointsEarned   int
+	Notes          string
+}
+
+// Line is one invoice line
+type Line struct {
+	ProductCode string
+	ProductName string
+	Unit        string
+	Quantity    int
+	UnitPrice   float64
+	Discount    float64
+	Amount      float64
+	TaxRate     float64
+}
+
+// TaxLine is the tax of one rate; Inclusive means the tax is already in the line amounts
+type TaxLine struct {
+	Rate      float64
+	Inclusive bool
+	Taxable   float64
+	Tax       float64
+}
+
+// Payment is one tender of the invoice
+type Payment struct {
+	Label     string
+	Reference string
+	Amount    float64
+}
+
+// Fold turns Vietnamese text into plain ASCII (diacritics removed, đ to d). Thermal printers
+// have no reliable Vietnamese code page and the PDF standard fonts only cover Latin-1, so both
+// renderers print folded text.
+func Fold(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			sb.WriteRune('d')
+		case r == 'Đ':
+			sb.WriteRune('D')
+		case r < 128:
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('?')
+		}
+	}
+	return sb.String()
+}
+
+// Money formats an amount the Vietnamese way, with dots between thousands: 1.234.500
+func Money(amount float64) string {
+	negative := amount < 0
+	if negative {
+		amount = -amount
+	}
+	digits := fmt.Sprintf("%.0f", amount)
+	var sb strings.Builder
+	if negative {
+		sb.WriteByte('-')
+	}
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteRune(d)
+	}
+	return sb.String()
+}
+
+// Rate formats a tax rate without trailing zeros: 5, 8, 10, 5.5
+func Rate(rate float64) string {
+	return fmt.Sprintf("%g%%", rate)
+}
+
+// label is the text of a tax breakdown row
+func (t TaxLine) label() string {
+	if t.Inclusive {
+		return fmt.Sprintf("VAT %s (đã gồm) trên %s", Rate(t.Rate), Money(t.Taxable))
+	}
+	return fmt.Sprintf("VAT %s trên %s", Rate(t.Rate), Money(t.Taxable))
+}
@@ -0,0 +1,669 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// disposalReasons are the reasons stock can be written off with
+var disposalReasons = stockReasonChoices(
+	models.StockReasonExpired, models.StockReasonDamaged, models.StockReasonTheft,
+	models.StockReasonDonation, models.StockReasonSupplierReturn,
+)
+
+// disposalItem is one batch to write off; a zero quantity takes all the batch holds
+type disposalItem struct {
+	Location models.StockLocation `json:"location" form:"location"`
+	BatchID  uint                 `json:"batch_id" form:"batch_id"`
+	Quantity int                  `json:"quantity" form:"quantity"`
+}
+
+// disposalRequest is the body of a disposal: JSON with a list of items, or the form of a
+// single batch
+type disposalRequest struct {
+	ReasonCode models.StockReasonCode `json:"reason_code" form:"reason_code"`
+	EmployeeID uint                   `json:"employee_id" form:"employee_id"`
+	ApprovedBy uint                   `json:"approved_by" form:"approved_by"`
+	Notes      string                 `json:"notes" form:"notes"`
+	Items      []disposalItem         `json:"items" form:"-"`
+	// A single batch, as the disposal form sends it
+	Location models.StockLocation `json:"location" form:"location"`
+	BatchID  uint                 `json:"batch_id" form:"batch_id"`
+	Quantity int                  `json:"quantity" form:"quantity"`
+}
+
+// stockBatch is a warehouse or shelf batch taken out by a disposal or a transfer order
+type stockBatch struct {
+	ID          uint
+	WarehouseID *uint
+	ShelfID     *uint
+	ProductID   uint
+	ProductName string
+	BatchCode   string
+	ExpiryDate  *time.Time
+	Quantity    int
+	ImportPrice float64
+}
+
+// parseDisposalRequest reads and checks a disposal request. reason is used when the request
+// does not carry one.
+func parseDisposalRequest(c *fiber.Ctx, reason models.StockReasonCode) (*disposalRequest, error) {
+	var req disposalRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return nil, fmt.Errorf("Dữ liệu không hợp lệ: %v", err)
+		}
+	}
+	if req.ReasonCode == "" {
+		req.ReasonCode = reason
+	}
+	if _, ok := disposalReasons[req.ReasonCode]; !ok {
+		return nil, fmt.Errorf("Vui lòng chọn lý do xuất hủy")
+	}
+	if req.EmployeeID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn nhân viên thực hiện")
+	}
+	if req.ApprovedBy == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn người duyệt")
+	}
+	if req.ApprovedBy == req.EmployeeID {
+		return nil, fmt.Errorf("Người duyệt phải khác người lập phiếu xuất hủy")
+	}
+	if req.BatchID != 0 {
+		req.Items = append(req.Items, disposalItem{Location: req.Location, BatchID: req.BatchID, Quantity: req.Quantity})
+	}
+	for _, item := range req.Items {
+		if item.Quantity < 0 {
+			return nil, fmt.Errorf("Số lượng xuất hủy không hợp lệ")
+		}
+	}
+	return &req, nil
+}
+
+// loadStockBatch loads a warehouse or shelf batch, locked when forUpdate is set
+func loadStockBatch(tx *gorm.DB, location models.StockLocation, batchID uint, forUpdate bool) (*stockBatch, error) {
+	var batch stockBatch
+	var err error
+	lock := func(alias string) string {
+		if forUpdate {
+			return "FOR UPDATE OF " + alias
+		}
+		return ""
+	}
+	switch location {
+	case models.StockLocationWarehouse:
+		err = tx.Raw(`
+			SELECT wi.inventory_id as id, wi.warehouse_id, wi.product_id, p.product_name, wi.batch_code,
+			       wi.expiry_date, wi.quantity, wi.import_price
+			FROM supermarket.warehouse_inventory wi
+			JOIN supermarket.products p ON wi.product_id = p.product_id
+			WHERE wi.inventory_id = $1
+		`+lock("wi"), batchID).Scan(&batch).Error
+	case models.StockLocationShelf:
+		err = tx.Raw(`
+			SELECT sbi.shelf_batch_id as id, sbi.shelf_id, sbi.product_id, p.product_name, sbi.batch_code,
+			       sbi.expiry_date, sbi.quantity, sbi.import_price
+			FROM supermarket.shelf_batch_inventory sbi
+			JOIN supermarket.products p ON sbi.product_id = p.product_id
+			WHERE sbi.shelf_batch_id = $1
+		`+lock("sbi"), batchID).Scan(&batch).Error
+	default:
+		return nil, fmt.Errorf("Vị trí lô hàng không hợp lệ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if batch.ID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy lô hàng")
+	}
+	return &batch, nil
+}
+
+// postDisposal writes a disposal document and takes its quantities out of the batches. The
+// batch rows are kept, so their cost stays traceable through the document.
+func postDisposal(tx *gorm.DB, req *disposalRequest) (*models.Disposal, error) {
+	if len(req.Items) == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn lô hàng cần xuất hủy")
+	}
+
+	var approverActive bool
+	tx.Raw("SELECT is_active FROM supermarket.employees WHERE employee_id = $1", req.ApprovedBy).Scan(&approverActive)
+	if !approverActive {
+		return nil, fmt.Errorf("Người duyệt không hợp lệ")
+	}
+
+	disposalNo, err := nextDocumentNo(tx, models.DocDisposal, "")
+	if err != nil {
+		return nil, err
+	}
+	disposal := models.Disposal{
+		DisposalNo: disposalNo,
+		Reason:     req.ReasonCode,
+		CreatedBy:  req.EmployeeID,
+		ApprovedBy: req.ApprovedBy,
+		Notes:      nullIfEmpty(req.Notes),
+		DisposedAt: time.Now(),
+	}
+	if err := tx.Create(&disposal).Error; err != nil {
+		return nil, fmt.Errorf("Không thể tạo phiếu xuất hủy: %v", err)
+	}
+
+	units := 0
+	for _, item := range req.Items {
+		batch, err := loadStockBatch(tx, item.Location, item.BatchID, true)
+		if err != nil {
+			return nil, err
+		}
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = batch.Quantity
+		}
+		if quantity == 0 {
+			continue
+		}
+		if quantity > batch.Quantity {
+			return nil, fmt.Errorf("Lô %s của %s chỉ còn %d, không thể hủy %d", batch.BatchCode, batch.ProductName, batch.Quantity, quantity)
+		}
+
+		if item.Location == models.StockLocationWarehouse {
+			err = tx.Exec(`
+				UPDATE supermarket.warehouse_inventory
+				SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP
+				WHERE inventory_id = $2
+			`, quantity, batch.ID).Error
+		} else {
+			err = tx.Exec(`
+				UPDATE supermarket.shelf_batch_inventory
+				SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP
+				WHERE shelf_batch_id = $2
+			`, quantity, batch.ID).Error
+			if err == nil {
+				err = syncShelfInventorySummary(tx, *batch.ShelfID, batch.ProductID)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Không thể trừ tồn lô %s: %v", batch.BatchCode, err)
+		}
+
+		line := models.DisposalLine{
+			DisposalID:  disposal.DisposalID,
+			Location:    item.Location,
+			WarehouseID: batch.WarehouseID,
+			ShelfID:     batch.ShelfID,
+			ProductID:   batch.ProductID,
+			BatchCode:   batch.BatchCode,
+			ExpiryDate:  batch.ExpiryDate,
+			Quantity:    quantity,
+			UnitCost:    batch.ImportPrice,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			return nil, fmt.Errorf("Không thể ghi dòng xuất hủy: %v", err)
+		}
+		disposal.Lines = append(disposal.Lines, line)
+		disposal.TotalCost += line.LineCost()
+		units += quantity
+	}
+	if len(disposal.Lines) == 0 {
+		return nil, fmt.Errorf("Các lô hàng đã chọn không còn tồn để xuất hủy")
+	}
+
+	disposal.TotalCost = roundVND(disposal.TotalCost)
+	err = tx.Model(&models.Disposal{}).Where("disposal_id = ?", disposal.DisposalID).Update("total_cost", disposal.TotalCost).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+		VALUES ($1, $2, 'disposals', $3, $4, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeStockDisposed,
+		fmt.Sprintf("Xuất hủy %s: %d sản phẩm từ %d lô, giá vốn %.0f VNĐ (%s)", disposal.DisposalNo, units,
+			len(disposal.Lines), disposal.TotalCost, stockReasonLabels[disposal.Reason]),
+		disposal.DisposalID, disposal.ApprovedBy).Error
+	if err != nil {
+		return nil, fmt.Errorf("Không thể ghi nhật ký xuất hủy: %v", err)
+	}
+	return &disposal, nil
+}
+
+// disposeOne writes off a single batch for the inventory API
+func disposeOne(c *fiber.Ctx, location models.StockLocation) error {
+	db := database.GetDB()
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "ID lô hàng không hợp lệ",
+		})
+	}
+	req, err := parseDisposalRequest(c, "")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+	req.Items = []disposalItem{{Location: location, BatchID: uint(id), Quantity: req.Quantity}}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	disposal, err := postDisposal(tx, req)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": "Không thể hủy lô hàng: " + err.Error(),
+		})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Không thể hoàn tất hủy hàng: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"disposal_id": disposal.DisposalID,
+		"disposal_no": disposal.DisposalNo,
+		"message":     "Đã hủy lô hàng theo phiếu " + disposal.DisposalNo,
+	})
+}
+
+// DeleteWarehouseInventory writes off a warehouse batch, or part of it, on a disposal document
+func DeleteWarehouseInventory(c *fiber.Ctx) error {
+	return disposeOne(c, models.StockLocationWarehouse)
+}
+
+// DeleteShelfInventory writes off a shelf batch, or part of it, on a disposal document
+func DeleteShelfInventory(c *fiber.Ctx) error {
+	return disposeOne(c, models.StockLocationShelf)
+}
+
+// DisposeAllExpired writes off every expired batch in the warehouses and on the shelves on
+// one disposal document
+func DisposeAllExpired(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	req, err := parseDisposalRequest(c, models.StockReasonExpired)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"message": err.Error(),
+		})
+	}
+	req.ReasonCode = models.StockReasonExpired
+
+	req.Items = nil
+	db.Raw(`
+		SELECT 'WAREHOUSE' as location, inventory_id as batch_id, 0 as quantity
+		FROM supermarket.warehouse_inventory
+		WHERE expiry_date < CURRENT_DATE AND quantity > 0
+		UNION ALL
+		SELECT 'SHELF' as location, shelf_batch_id as batch_id, 0 as quantity
+		FROM supermarket.shelf_batch_inventory
+		WHERE expiry_date < CURRENT_DATE AND quantity > 0
+	`).Scan(&req.Items)
+	if len(req.Items) == 0 {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Không có lô hàng hết hạn cần hủy",
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	disposal, err := postDisposal(tx, req)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Không thể hủy các lô hàng hết hạn: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "Không thể hoàn tất hủy hàng hết hạn: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"disposal_id": disposal.DisposalID,
+		"disposal_no": disposal.DisposalNo,
+		"message":     fmt.Sprintf("Đã hủy %d lô hàng hết hạn theo phiếu %s", len(disposal.Lines), disposal.DisposalNo),
+	})
+}
+
+// disposalRow is a disposal document with the names of its people
+type disposalRow struct {
+	models.Disposal
+	CreatorName  string `json:"creator_name"`
+	ApproverName string `json:"approver_name"`
+	LineCount    int    `json:"line_count"`
+	Units        int    `json:"units"`
+}
+
+// DisposalList lists the disposal documents of a period
+func DisposalList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	now := time.Now()
+	dateFrom := c.Query("date_from", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02"))
+	dateTo := c.Query("date_to", now.Format("2006-01-02"))
+	reason := strings.ToUpper(c.Query("reason"))
+	if _, ok := disposalReasons[models.StockReasonCode(reason)]; !ok {
+		reason = ""
+	}
+
+	var disposals []disposalRow
+	err := db.Raw(`
+		SELECT d.*, creator.full_name as creator_name, approver.full_name as approver_name,
+		       (SELECT COUNT(*) FROM supermarket.disposal_lines l WHERE l.disposal_id = d.disposal_id) as line_count,
+		       (SELECT COALESCE(SUM(l.quantity), 0) FROM supermarket.disposal_lines l WHERE l.disposal_id = d.disposal_id) as units
+		FROM supermarket.disposals d
+		JOIN supermarket.employees creator ON d.created_by = creator.employee_id
+		JOIN supermarket.employees approver ON d.approved_by = approver.employee_id
+		WHERE DATE(d.disposed_at) BETWEEN $1 AND $2
+		  AND ($3 = '' OR d.reason = $3)
+		ORDER BY d.disposed_at DESC, d.disposal_id DESC
+	`, dateFrom, dateTo, reason).Scan(&disposals).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách phiếu xuất hủy: " + err.Error(),
+		})
+	}
+
+	var totalCost float64
+	for _, d := range disposals {
+		totalCost += d.TotalCost
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"disposals":  disposals,
+			"total_cost": totalCost,
+		})
+	}
+
+	return c.Render("pages/inventory/disposals", fiber.Map{
+		"Title":           "Phiếu xuất hủy",
+		"Active":          "inventory",
+		"Disposals":       disposals,
+		"TotalCost":       totalCost,
+		"DateFrom":        dateFrom,
+		"DateTo":          dateTo,
+		"Reason":          reason,
+		"ReasonLabels":    disposalReasons,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// DisposalForm is the form to write off one batch, opened from the warehouse and shelf pages
+func DisposalForm(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	location := models.StockLocation(strings.ToUpper(c.Query("location")))
+	batchID, _ := strconv.ParseUint(c.Query("batch_id"), 10, 64)
+
+	batch, err := loadStockBatch(db, location, uint(batchID), false)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": err.Error(),
+		})
+	}
+
+	var locationName string
+	if batch.WarehouseID != nil {
+		db.Raw("SELECT warehouse_name FROM supermarket.warehouse WHERE warehouse_id = $1", *batch.WarehouseID).Scan(&locationName)
+	} else {
+		db.Raw("SELECT shelf_name FROM supermarket.display_shelves WHERE shelf_id = $1", *batch.ShelfID).Scan(&locationName)
+	}
+
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	reason := models.StockReasonDamaged
+	if batch.ExpiryDate != nil && batch.ExpiryDate.Before(time.Now().Truncate(24*time.Hour)) {
+		reason = models.StockReasonExpired
+	}
+
+	return c.Render("pages/inventory/disposal_form", fiber.Map{
+		"Title":           "Xuất hủy lô hàng",
+		"Active":          "inventory",
+		"Location":        location,
+		"LocationName":    locationName,
+		"Batch":           batch,
+		"Reason":          reason,
+		"ReasonLabels":    disposalReasons,
+		"Employees":       employees,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// DisposalCreate posts a disposal document from the disposal form or a JSON list of batches
+func DisposalCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	req, err := parseDisposalRequest(c, "")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	disposal, err := postDisposal(tx, req)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":  true,
+			"disposal": disposal,
+			"message":  "Đã lập phiếu xuất hủy " + disposal.DisposalNo,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/disposals/%d", disposal.DisposalID))
+}
+
+// DisposalView shows a disposal document with its batches
+func DisposalView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	disposalID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID phiếu xuất hủy không hợp lệ",
+		})
+	}
+
+	var disposal disposalRow
+	db.Raw(`
+		SELECT d.*, creator.full_name as creator_name, approver.full_name as approver_name
+		FROM supermarket.disposals d
+		JOIN supermarket.employees creator ON d.created_by = creator.employee_id
+		JOIN supermarket.employees approver ON d.approved_by = approver.employee_id
+		WHERE d.disposal_id = $1
+	`, disposalID).Scan(&disposal)
+	if disposal.DisposalID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy phiếu xuất hủy",
+		})
+	}
+
+	var lines []struct {
+		models.DisposalLine
+		ProductCode  string  `json:"product_code"`
+		ProductName  string  `json:"product_name"`
+		LocationName string  `json:"location_name"`
+		LineCost     float64 `json:"line_cost"`
+	}
+	db.Raw(`
+		SELECT l.*, p.product_code, p.product_name,
+		       COALESCE(w.warehouse_name, ds.shelf_name, '') as location_name,
+		       l.quantity * l.unit_cost as line_cost
+		FROM supermarket.disposal_lines l
+		JOIN supermarket.products p ON l.product_id = p.product_id
+		LEFT JOIN supermarket.warehouse w ON l.warehouse_id = w.warehouse_id
+		LEFT JOIN supermarket.display_shelves ds ON l.shelf_id = ds.shelf_id
+		WHERE l.disposal_id = $1
+		ORDER BY l.line_id
+	`, disposalID).Scan(&lines)
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"disposal": disposal,
+			"lines":    lines,
+		})
+	}
+
+	return c.Render("pages/inventory/disposal_view", fiber.Map{
+		"Title":           "Phiếu xuất hủy " + disposal.DisposalNo,
+		"Active":          "inventory",
+		"Disposal":        disposal,
+		"Lines":           lines,
+		"ReasonLabels":    stockReasonLabels,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// ShrinkageReport totals the stock lost in a period by month, category and reason, valued at
+// import price: disposals plus the shortages posted by stock counts and transfer receipts
+func ShrinkageReport(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	now := time.Now()
+	dateFrom := c.Query("date_from", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02"))
+	dateTo := c.Query("date_to", now.Format("2006-01-02"))
+
+	type shrinkageRow struct {
+		Period       string                 `json:"period"`
+		CategoryName string                 `json:"category_name"`
+		Reason       models.StockReasonCode `json:"reason"`
+		Source       string                 `json:"source"`
+		Quantity     int                    `json:"quantity"`
+		Cost         float64                `json:"cost"`
+	}
+
+	var rows []shrinkageRow
+	err := db.Raw(`
+		SELECT TO_CHAR(lost_at, 'YYYY-MM') as period, pc.category_name, reason, source,
+		       SUM(quantity) as quantity, SUM(quantity * unit_cost) as cost
+		FROM (
+			SELECT d.disposed_at as lost_at, d.reason, 'DISPOSAL' as source, l.product_id, l.quantity, l.unit_cost
+			FROM supermarket.disposal_lines l
+			JOIN supermarket.disposals d ON l.disposal_id = d.disposal_id
+			UNION ALL
+			SELECT sc.approved_at, l.reason_code, 'STOCK_COUNT', l.product_id, -l.adjusted_quantity, l.unit_cost
+			FROM supermarket.stock_count_lines l
+			JOIN supermarket.stock_counts sc ON l.count_id = sc.count_id
+			WHERE sc.status = $3 AND l.adjusted_quantity < 0
+			UNION ALL
+			SELECT t.received_at, l.discrepancy_reason, 'TRANSFER', l.product_id,
+			       l.dispatched_quantity - l.received_quantity, l.import_price
+			FROM supermarket.transfer_order_lines l
+			JOIN supermarket.transfer_orders t ON l.transfer_order_id = t.transfer_order_id
+			WHERE t.status = $4 AND l.received_quantity < l.dispatched_quantity
+		) losses
+		JOIN supermarket.products p ON losses.product_id = p.product_id
+		JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
+		WHERE DATE(lost_at) BETWEEN $1 AND $2
+		GROUP BY 1, 2, 3, 4
+		ORDER BY 1, 2, cost DESC
+	`, dateFrom, dateTo, models.StockCountApproved, models.TransferOrderReceived).Scan(&rows).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải báo cáo hao hụt: " + err.Error(),
+		})
+	}
+
+	type reasonTotal struct {
+		Reason   models.StockReasonCode `json:"reason"`
+		Label    string                 `json:"label"`
+		Quantity int                    `json:"quantity"`
+		Cost     float64                `json:"cost"`
+	}
+	var byReason []reasonTotal
+	index := map[models.StockReasonCode]int{}
+	var totalCost float64
+	for _, r := range rows {
+		i, ok := index[r.Reason]
+		if !ok {
+			i = len(byReason)
+			index[r.Reason] = i
+			byReason = append(byReason, reasonTotal{Reason: r.Reason, Label: stockReasonLabels[r.Reason]})
+		}
+		byReason[i].Quantity += r.Quantity
+		byReason[i].Cost += r.Cost
+		totalCost += r.Cost
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"date_from":  dateFrom,
+			"date_to":    dateTo,
+			"rows":       rows,
+			"by_reason":  byReason,
+			"total_cost": totalCost,
+		})
+	}
+
+	return c.Render("pages/reports/shrinkage", fiber.Map{
+		"Title":           "Hao hụt hàng hóa",
+		"Active":          "reports",
+		"DateFrom":        dateFrom,
+		"DateTo":          dateTo,
+		"Rows":            rows,
+		"ByReason":        byReason,
+		"TotalCost":       totalCost,
+		"ReasonLabels":    stockReasonLabels,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
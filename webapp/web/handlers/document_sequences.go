@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// documentTypeLabels names the numbered documents in the UI
+var documentTypeLabels = map[models.DocumentType]string{
+	models.DocSalesInvoice:  "Hóa đơn bán hàng",
+	models.DocSalesReturn:   "Phiếu trả hàng",
+	models.DocPurchaseOrder: "Đơn đặt hàng",
+	models.DocStockTransfer: "Phiếu chuyển hàng",
+	models.DocStockCount:    "Phiếu kiểm kê",
+	models.DocDisposal:      "Phiếu xuất hủy",
+	models.DocTransferOrder: "Phiếu điều chuyển",
+	models.DocGoodsReceipt:  "Phiếu nhập kho",
+	models.DocEInvoice:      "Hóa đơn điện tử",
+}
+
+// sequenceResetLabels names the reset policies in the UI
+var sequenceResetLabels = map[models.SequenceReset]string{
+	models.SequenceResetNever:   "Không đặt lại",
+	models.SequenceResetDaily:   "Theo ngày",
+	models.SequenceResetMonthly: "Theo tháng",
+}
+
+// nextDocumentNo allocates the next number of a document type inside tx. The counter row stays
+// locked until tx ends, so the number must be taken in the same transaction that writes the
+// document: a rollback then hands the number back. series is the till code for per-till types.
+func nextDocumentNo(tx *gorm.DB, docType models.DocumentType, series string) (string, error) {
+	var documentNo string
+	err := tx.Raw("SELECT supermarket.next_document_no($1, $2, CURRENT_TIMESTAMP)", docType, series).Scan(&documentNo).Error
+	if err != nil {
+		return "", fmt.Errorf("Không thể cấp số chứng từ: %v", err)
+	}
+	if documentNo == "" {
+		return "", fmt.Errorf("Không thể cấp số chứng từ cho %s", docType)
+	}
+	return documentNo, nil
+}
+
+// DocumentSequenceList shows the numbering rules and the latest counters
+func DocumentSequenceList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var sequences []models.DocumentSequence
+	err := db.Raw("SELECT * FROM supermarket.document_sequences ORDER BY document_type").Scan(&sequences).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải cấu hình đánh số: " + err.Error(),
+		})
+	}
+
+	var counters []models.DocumentSequenceCounter
+	db.Raw(`
+		SELECT * FROM supermarket.document_sequence_counters
+		ORDER BY updated_at DESC
+		LIMIT 50
+	`).Scan(&counters)
+
+	return c.Render("pages/document_sequences/list", fiber.Map{
+		"Title":           "Đánh số chứng từ",
+		"Active":          "sales",
+		"Sequences":       sequences,
+		"Counters":        counters,
+		"TypeLabels":      documentTypeLabels,
+		"ResetLabels":     sequenceResetLabels,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// DocumentSequenceUpdate changes the numbering rule of a document type. Counters already used
+// are kept; a new prefix or reset period simply starts new series.
+func DocumentSequenceUpdate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	docType := models.DocumentType(c.Params("type"))
+	if _, ok := documentTypeLabels[docType]; !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Loại chứng từ không hợp lệ",
+		})
+	}
+
+	prefix := strings.ToUpper(strings.TrimSpace(c.FormValue("prefix")))
+	if prefix == "" || len(prefix) > 10 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Tiền tố phải từ 1 đến 10 ký tự",
+		})
+	}
+
+	resetPolicy := models.SequenceReset(c.FormValue("reset_policy"))
+	if _, ok := sequenceResetLabels[resetPolicy]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Chu kỳ đặt lại không hợp lệ",
+		})
+	}
+
+	padding, err := strconv.Atoi(c.FormValue("padding"))
+	if err != nil || padding < 1 || padding > 12 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Số chữ số phải từ 1 đến 12",
+		})
+	}
+
+	perTill := c.FormValue("per_till") == "on" || c.FormValue("per_till") == "true"
+
+	result := db.Exec(`
+		UPDATE supermarket.document_sequences
+		SET prefix = $1, reset_policy = $2, per_till = $3, padding = $4,
+			description = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE document_type = $6
+	`, prefix, resetPolicy, perTill, padding, nullIfEmpty(strings.TrimSpace(c.FormValue("description"))), docType)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật cấu hình đánh số: " + result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Chưa có cấu hình đánh số cho loại chứng từ này",
+		})
+	}
+
+	db.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, created_at)
+		VALUES ($1, $2, 'document_sequences', CURRENT_TIMESTAMP)
+	`, models.ActivityTypeNumberingChanged, fmt.Sprintf("Đổi đánh số %s: tiền tố %s, %s, %d chữ số", docType, prefix, sequenceResetLabels[resetPolicy], padding))
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{"success": true})
+	}
+	return c.Redirect("/document-sequences")
+}
@@ -0,0 +1,686 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/config"
+	"github.com/supermarket/database"
+	"github.com/supermarket/einvoice"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// einvoiceSettings is the e-invoice series and threshold, set at startup by ConfigureEInvoices
+var einvoiceSettings = config.EInvoiceConfig{TemplateCode: "1", Threshold: 200000}
+
+// einvoiceSeries is the series (KHHDon) of an e-invoice issued at t: the configured series, or
+// C<yy>TAA with the year of issue when none is configured, so the series rolls over each year
+func einvoiceSeries(t time.Time) string {
+	if einvoiceSettings.Series != "" {
+		return einvoiceSettings.Series
+	}
+	return "C" + t.Format("06") + "TAA"
+}
+
+// einvoiceSigner signs exports; einvoiceSignerErr says why signing is unavailable when it is nil
+var (
+	einvoiceSigner    *einvoice.Signer
+	einvoiceSignerErr = fmt.Errorf("Chưa cấu hình chứng thư số (EINVOICE_CERT_FILE, EINVOICE_KEY_FILE)")
+)
+
+// ConfigureEInvoices applies the e-invoice configuration and loads the signing certificate.
+// An error leaves exporting disabled; the rest of the application keeps working.
+func ConfigureEInvoices(cfg config.EInvoiceConfig) error {
+	einvoiceSettings = cfg
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return einvoiceSignerErr
+	}
+	signer, err := einvoice.LoadSigner(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		einvoiceSignerErr = err
+		return err
+	}
+	einvoiceSigner, einvoiceSignerErr = signer, nil
+	return nil
+}
+
+// einvoiceTypeLabels names the export types in the UI
+var einvoiceTypeLabels = map[models.EInvoiceExportType]string{
+	models.EInvoiceOriginal:    "Hóa đơn gốc",
+	models.EInvoiceReplacement: "Hóa đơn thay thế",
+	models.EInvoiceAdjustment:  "Hóa đơn điều chỉnh",
+}
+
+// einvoiceStatusLabels names the export statuses in the UI
+var einvoiceStatusLabels = map[models.EInvoiceStatus]string{
+	models.EInvoiceSigned:   "Đã ký",
+	models.EInvoiceReplaced: "Đã bị thay thế",
+	models.EInvoiceFailed:   "Lỗi",
+}
+
+// einvoiceRequest is one export to generate: an original for a sale, or a replacement or
+// adjustment of an earlier signed export
+type einvoiceRequest struct {
+	InvoiceID uint
+	Type      models.EInvoiceExportType
+	Original  *models.EInvoiceExport
+	Buyer     einvoice.Party
+	Reason    *string
+}
+
+// einvoiceLineRow is a sold or returned line read for the e-invoice
+type einvoiceLineRow struct {
+	ProductCode    string         `json:"product_code"`
+	ProductName    string         `json:"product_name"`
+	Unit           string         `json:"unit"`
+	Quantity       int            `json:"quantity"`
+	UnitPrice      float64        `json:"unit_price"`
+	DiscountAmount float64        `json:"discount_amount"`
+	Subtotal       float64        `json:"subtotal"`
+	TaxAmount      float64        `json:"tax_amount"`
+	TaxRate        float64        `json:"tax_rate"`
+	TaxMode        models.TaxMode `json:"tax_mode"`
+}
+
+// preTax takes the VAT out of an amount of a tax-inclusive line; the invoice shows prices before VAT
+func (l *einvoiceLineRow) preTax(amount float64) float64 {
+	if l.TaxMode == models.TaxInclusive {
+		return roundVND(amount / (1 + l.TaxRate/100))
+	}
+	return amount
+}
+
+// einvoiceNumber reads the SHDon out of an EINVOICE document number: the counter after the series
+func einvoiceNumber(documentNo string) (int64, error) {
+	i := strings.LastIndex(documentNo, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("Dãy số hóa đơn điện tử phải đánh số theo ký hiệu (bật \"Theo quầy\" trong Đánh số chứng từ)")
+	}
+	return strconv.ParseInt(documentNo[i+1:], 10, 64)
+}
+
+// currentEInvoice returns the signed full invoice (original or replacement) of a sale, if any
+func currentEInvoice(db *gorm.DB, invoiceID uint) *models.EInvoiceExport {
+	var export models.EInvoiceExport
+	db.Raw(`
+		SELECT * FROM supermarket.einvoice_exports
+		WHERE invoice_id = $1 AND status = $2 AND export_type IN ($3, $4)
+		ORDER BY export_id DESC
+		LIMIT 1
+	`, invoiceID, models.EInvoiceSigned, models.EInvoiceOriginal, models.EInvoiceReplacement).Scan(&export)
+	if export.ExportID == 0 {
+		return nil
+	}
+	return &export
+}
+
+// buildEInvoice maps a sale to the e-invoice: the sold lines for an original or replacement,
+// or the returns not yet adjusted (as negative lines) for an adjustment
+func buildEInvoice(tx *gorm.DB, req *einvoiceRequest, sale *models.SalesInvoice) (*einvoice.Invoice, error) {
+	issuedAt := time.Now()
+	inv := &einvoice.Invoice{
+		TemplateCode: einvoiceSettings.TemplateCode,
+		Series:       einvoiceSeries(issuedAt),
+		Date:         issuedAt,
+		Discount:     sale.DiscountAmount,
+		Buyer:        req.Buyer,
+		Seller: einvoice.Party{
+			Name:    receiptSettings.StoreName,
+			TaxCode: receiptSettings.StoreTaxCode,
+			Address: receiptSettings.StoreAddress,
+			Phone:   receiptSettings.StorePhone,
+		},
+	}
+	if req.Type == models.EInvoiceOriginal {
+		inv.Date = sale.InvoiceDate
+	}
+	inv.PaymentMethod = "Tiền mặt/Chuyển khoản"
+	if sale.PaymentMethod != nil {
+		if label, ok := paymentMethodLabels[*sale.PaymentMethod]; ok {
+			inv.PaymentMethod = label
+		}
+	}
+
+	// Buyer defaults to the member on the sale
+	if sale.CustomerID != nil {
+		var customer models.Customer
+		tx.Raw("SELECT * FROM supermarket.customers WHERE customer_id = $1", *sale.CustomerID).Scan(&customer)
+		if inv.Buyer.Name == "" && customer.FullName != nil {
+			inv.Buyer.Name = *customer.FullName
+		}
+		if inv.Buyer.Address == "" && customer.Address != nil {
+			inv.Buyer.Address = *customer.Address
+		}
+		if inv.Buyer.Phone == "" && customer.Phone != nil {
+			inv.Buyer.Phone = *customer.Phone
+		}
+		if inv.Buyer.Email == "" && customer.Email != nil {
+			inv.Buyer.Email = *customer.Email
+		}
+		if customer.CustomerCode != nil {
+			inv.Buyer.CustomerCode = *customer.CustomerCode
+		}
+	}
+
+	if o := req.Original; o != nil && o.EInvoiceNumber != nil {
+		related := &einvoice.Related{
+			Relation:     einvoice.RelationReplacement,
+			TemplateCode: o.TemplateCode,
+			Series:       o.Series,
+			Number:       *o.EInvoiceNumber,
+			Date:         o.CreatedAt,
+		}
+		if o.SignedAt != nil {
+			related.Date = *o.SignedAt
+		}
+		if req.Type == models.EInvoiceAdjustment {
+			related.Relation = einvoice.RelationAdjustment
+		}
+		if req.Reason != nil {
+			related.Note = *req.Reason
+		}
+		inv.Related = related
+	}
+
+	var rows []einvoiceLineRow
+	if req.Type == models.EInvoiceAdjustment {
+		// Returns since the last signed adjustment of this sale
+		var since time.Time
+		tx.Raw(`
+			SELECT COALESCE(MAX(created_at), '1970-01-01') FROM supermarket.einvoice_exports
+			WHERE invoice_id = $1 AND export_type = $2 AND status = $3
+		`, req.InvoiceID, models.EInvoiceAdjustment, models.EInvoiceSigned).Scan(&since)
+		err := tx.Raw(`
+			SELECT p.product_code, p.product_name, COALESCE(p.unit, 'cái') as unit,
+				SUM(srd.quantity) as quantity, sid.unit_price, 0 as discount_amount,
+				SUM(srd.refund_amount) as subtotal, SUM(srd.tax_amount) as tax_amount,
+				sid.tax_rate, sid.tax_mode
+			FROM supermarket.sales_return_details srd
+			JOIN supermarket.sales_returns sr ON srd.return_id = sr.return_id
+			JOIN supermarket.sales_invoice_details sid ON srd.invoice_detail_id = sid.detail_id
+			JOIN supermarket.products p ON sid.product_id = p.product_id
+			WHERE sr.invoice_id = $1 AND sr.created_at > $2
+			GROUP BY sid.detail_id, p.product_code, p.product_name, p.unit, sid.unit_price, sid.tax_rate, sid.tax_mode
+			ORDER BY sid.detail_id
+		`, req.InvoiceID, since).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("Không có hàng trả lại nào cần điều chỉnh")
+		}
+		for _, r := range rows {
+			// A refund is the payable amount, so it includes the VAT in both tax modes
+			inv.Lines = append(inv.Lines, einvoice.Line{
+				Code:      r.ProductCode,
+				Name:      r.ProductName + " (trả lại)",
+				Unit:      r.Unit,
+				Quantity:  float64(-r.Quantity),
+				UnitPrice: r.preTax(r.UnitPrice),
+				Amount:    -roundVND(r.Subtotal - r.TaxAmount),
+				TaxRate:   r.TaxRate,
+				Tax:       -r.TaxAmount,
+			})
+		}
+		inv.Discount = 0
+		return inv, nil
+	}
+
+	err := tx.Raw(`
+		SELECT p.product_code, p.product_name, COALESCE(p.unit, 'cái') as unit,
+			sid.quantity, sid.unit_price, sid.discount_amount, sid.subtotal, sid.tax_amount,
+			sid.tax_rate, sid.tax_mode
+		FROM supermarket.sales_invoice_details sid
+		JOIN supermarket.products p ON sid.product_id = p.product_id
+		WHERE sid.invoice_id = $1
+		ORDER BY sid.detail_id
+	`, req.InvoiceID).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		amount := r.Subtotal
+		if r.TaxMode == models.TaxInclusive {
+			amount -= r.TaxAmount
+		}
+		inv.Lines = append(inv.Lines, einvoice.Line{
+			Code:      r.ProductCode,
+			Name:      r.ProductName,
+			Unit:      r.Unit,
+			Quantity:  float64(r.Quantity),
+			UnitPrice: r.preTax(r.UnitPrice),
+			Discount:  r.preTax(r.DiscountAmount),
+			Amount:    roundVND(amount),
+			TaxRate:   r.TaxRate,
+			Tax:       r.TaxAmount,
+		})
+	}
+	return inv, nil
+}
+
+// exportEInvoice numbers, builds, signs and logs one e-invoice inside tx. The sale row is locked
+// so two exports of the same sale cannot both become its current invoice.
+func exportEInvoice(tx *gorm.DB, req *einvoiceRequest) (*models.EInvoiceExport, error) {
+	if einvoiceSigner == nil {
+		return nil, einvoiceSignerErr
+	}
+
+	var sale models.SalesInvoice
+	if err := tx.Raw("SELECT * FROM supermarket.sales_invoices WHERE invoice_id = $1 FOR UPDATE", req.InvoiceID).Scan(&sale).Error; err != nil || sale.InvoiceID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy hóa đơn bán hàng")
+	}
+
+	current := currentEInvoice(tx, req.InvoiceID)
+	switch req.Type {
+	case models.EInvoiceOriginal:
+		if sale.Status != models.InvoiceCompleted {
+			return nil, fmt.Errorf("Chỉ xuất hóa đơn điện tử cho hóa đơn đã hoàn thành")
+		}
+		if current != nil {
+			return nil, fmt.Errorf("Hóa đơn đã có hóa đơn điện tử số %d, hãy lập hóa đơn thay thế hoặc điều chỉnh", *current.EInvoiceNumber)
+		}
+	case models.EInvoiceReplacement, models.EInvoiceAdjustment:
+		if req.Original == nil || current == nil || current.ExportID != req.Original.ExportID {
+			return nil, fmt.Errorf("Chỉ thay thế hoặc điều chỉnh hóa đơn điện tử hiện hành của hóa đơn")
+		}
+		if req.Reason == nil {
+			return nil, fmt.Errorf("Vui lòng nhập lý do thay thế hoặc điều chỉnh")
+		}
+	}
+
+	inv, err := buildEInvoice(tx, req, &sale)
+	if err != nil {
+		return nil, err
+	}
+
+	documentNo, err := nextDocumentNo(tx, models.DocEInvoice, inv.Series)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Number, err = einvoiceNumber(documentNo); err != nil {
+		return nil, err
+	}
+
+	signedAt := time.Now()
+	doc, sig, err := einvoiceSigner.Sign(inv, signedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlContent := string(doc)
+	export := &models.EInvoiceExport{
+		InvoiceID:      req.InvoiceID,
+		ExportType:     req.Type,
+		Status:         models.EInvoiceSigned,
+		DocumentNo:     &documentNo,
+		TemplateCode:   inv.TemplateCode,
+		Series:         inv.Series,
+		EInvoiceNumber: &inv.Number,
+		BuyerName:      nullIfEmpty(inv.Buyer.Name),
+		BuyerTaxCode:   nullIfEmpty(inv.Buyer.TaxCode),
+		BuyerAddress:   nullIfEmpty(inv.Buyer.Address),
+		TotalAmount:    inv.Total(),
+		TaxAmount:      inv.TotalTax(),
+		Reason:         req.Reason,
+		XMLContent:     &xmlContent,
+		DigestValue:    &sig.Digest,
+		CertSubject:    &sig.Subject,
+		CertSerial:     &sig.Serial,
+		SignedAt:       &sig.SignedAt,
+	}
+	if req.Original != nil {
+		export.OriginalExportID = &req.Original.ExportID
+	}
+	err = tx.Raw(`
+		INSERT INTO supermarket.einvoice_exports
+		(invoice_id, export_type, status, original_export_id, document_no, template_code, series, einvoice_number,
+		 buyer_name, buyer_tax_code, buyer_address, total_amount, tax_amount, reason, xml_content,
+		 digest_value, cert_subject, cert_serial, signed_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, CURRENT_TIMESTAMP)
+		RETURNING export_id, created_at
+	`, export.InvoiceID, export.ExportType, export.Status, export.OriginalExportID, export.DocumentNo, export.TemplateCode,
+		export.Series, export.EInvoiceNumber, export.BuyerName, export.BuyerTaxCode, export.BuyerAddress,
+		export.TotalAmount, export.TaxAmount, export.Reason, export.XMLContent, export.DigestValue,
+		export.CertSubject, export.CertSerial, export.SignedAt).Row().Scan(&export.ExportID, &export.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("Không thể lưu nhật ký xuất hóa đơn: %v", err)
+	}
+
+	if req.Type == models.EInvoiceReplacement {
+		if err := tx.Exec("UPDATE supermarket.einvoice_exports SET status = $1 WHERE export_id = $2",
+			models.EInvoiceReplaced, req.Original.ExportID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'einvoice_exports', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeEInvoiceExported, fmt.Sprintf("Xuất %s %s số %d cho hóa đơn %s",
+		strings.ToLower(einvoiceTypeLabels[req.Type]), inv.Series, inv.Number, sale.InvoiceNo), export.ExportID)
+
+	return export, nil
+}
+
+// logFailedEInvoice keeps a failed attempt in the export log; it has no number
+func logFailedEInvoice(db *gorm.DB, req *einvoiceRequest, cause error) {
+	var originalID *uint
+	if req.Original != nil {
+		originalID = &req.Original.ExportID
+	}
+	db.Exec(`
+		INSERT INTO supermarket.einvoice_exports
+		(invoice_id, export_type, status, original_export_id, template_code, series,
+		 buyer_name, buyer_tax_code, buyer_address, reason, error_message, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CURRENT_TIMESTAMP)
+	`, req.InvoiceID, req.Type, models.EInvoiceFailed, originalID, einvoiceSettings.TemplateCode, einvoiceSeries(time.Now()),
+		nullIfEmpty(req.Buyer.Name), nullIfEmpty(req.Buyer.TaxCode), nullIfEmpty(req.Buyer.Address), req.Reason, cause.Error())
+}
+
+// runEInvoiceExport runs an export in its own transaction and answers the request
+func runEInvoiceExport(c *fiber.Ctx, req *einvoiceRequest) error {
+	db := database.GetDB()
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	export, err := exportEInvoice(tx, req)
+	if err != nil {
+		tx.Rollback()
+		logFailedEInvoice(db, req, err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể xuất hóa đơn điện tử: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":         true,
+			"export_id":       export.ExportID,
+			"einvoice_number": export.EInvoiceNumber,
+			"message":         fmt.Sprintf("Đã xuất %s số %d", strings.ToLower(einvoiceTypeLabels[export.ExportType]), *export.EInvoiceNumber),
+		})
+	}
+	return c.Redirect(fmt.Sprintf("/einvoices/%d", export.ExportID))
+}
+
+// einvoiceBuyer reads the buyer fields of the export forms
+func einvoiceBuyer(c *fiber.Ctx) einvoice.Party {
+	return einvoice.Party{
+		Name:    strings.TrimSpace(c.FormValue("buyer_name")),
+		TaxCode: strings.TrimSpace(c.FormValue("buyer_tax_code")),
+		Address: strings.TrimSpace(c.FormValue("buyer_address")),
+		Email:   strings.TrimSpace(c.FormValue("buyer_email")),
+	}
+}
+
+// loadEInvoiceExport reads an export for the replace and adjust actions
+func loadEInvoiceExport(db *gorm.DB, id string) (*models.EInvoiceExport, error) {
+	var export models.EInvoiceExport
+	if err := db.Raw("SELECT * FROM supermarket.einvoice_exports WHERE export_id = $1", id).Scan(&export).Error; err != nil || export.ExportID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy hóa đơn điện tử")
+	}
+	return &export, nil
+}
+
+// EInvoiceCreate exports the original e-invoice of a sale
+func EInvoiceCreate(c *fiber.Ctx) error {
+	invoiceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID hóa đơn không hợp lệ",
+		})
+	}
+
+	// A missing sale has nothing to log a failed attempt against
+	var exists bool
+	database.GetDB().Raw("SELECT EXISTS (SELECT 1 FROM supermarket.sales_invoices WHERE invoice_id = $1)", invoiceID).Scan(&exists)
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy hóa đơn bán hàng",
+		})
+	}
+
+	return runEInvoiceExport(c, &einvoiceRequest{
+		InvoiceID: uint(invoiceID),
+		Type:      models.EInvoiceOriginal,
+		Buyer:     einvoiceBuyer(c),
+	})
+}
+
+// EInvoiceReplace issues a replacement for a signed e-invoice, e.g. to correct the buyer
+func EInvoiceReplace(c *fiber.Ctx) error {
+	original, err := loadEInvoiceExport(database.GetDB(), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	buyer := einvoiceBuyer(c)
+	if buyer.Name == "" && original.BuyerName != nil {
+		buyer.Name = *original.BuyerName
+	}
+	if buyer.TaxCode == "" && original.BuyerTaxCode != nil {
+		buyer.TaxCode = *original.BuyerTaxCode
+	}
+	if buyer.Address == "" && original.BuyerAddress != nil {
+		buyer.Address = *original.BuyerAddress
+	}
+
+	return runEInvoiceExport(c, &einvoiceRequest{
+		InvoiceID: original.InvoiceID,
+		Type:      models.EInvoiceReplacement,
+		Original:  original,
+		Buyer:     buyer,
+		Reason:    nullIfEmpty(strings.TrimSpace(c.FormValue("reason"))),
+	})
+}
+
+// EInvoiceAdjust issues an adjustment invoice for the goods returned against a signed e-invoice
+func EInvoiceAdjust(c *fiber.Ctx) error {
+	original, err := loadEInvoiceExport(database.GetDB(), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	buyer := einvoice.Party{}
+	if original.BuyerName != nil {
+		buyer.Name = *original.BuyerName
+	}
+	if original.BuyerTaxCode != nil {
+		buyer.TaxCode = *original.BuyerTaxCode
+	}
+	if original.BuyerAddress != nil {
+		buyer.Address = *original.BuyerAddress
+	}
+
+	return runEInvoiceExport(c, &einvoiceRequest{
+		InvoiceID: original.InvoiceID,
+		Type:      models.EInvoiceAdjustment,
+		Original:  original,
+		Buyer:     buyer,
+		Reason:    nullIfEmpty(strings.TrimSpace(c.FormValue("reason"))),
+	})
+}
+
+// einvoiceExportRow is an export log row with its sale
+type einvoiceExportRow struct {
+	models.EInvoiceExport
+	InvoiceNo      string  `json:"invoice_no"`
+	OriginalNumber *int64  `json:"original_number"`
+	SaleTotal      float64 `json:"sale_total"`
+	TypeLabel      string  `json:"type_label" gorm:"-"`
+	StatusLabel    string  `json:"status_label" gorm:"-"`
+}
+
+const einvoiceExportSelect = `
+	SELECT ee.*, si.invoice_no, si.total_amount as sale_total, oe.einvoice_number as original_number
+	FROM supermarket.einvoice_exports ee
+	JOIN supermarket.sales_invoices si ON ee.invoice_id = si.invoice_id
+	LEFT JOIN supermarket.einvoice_exports oe ON ee.original_export_id = oe.export_id
+`
+
+func labelEInvoiceRows(rows []einvoiceExportRow) {
+	for i := range rows {
+		rows[i].TypeLabel = einvoiceTypeLabels[rows[i].ExportType]
+		rows[i].StatusLabel = einvoiceStatusLabels[rows[i].Status]
+	}
+}
+
+// EInvoiceList shows the export log and the recent sales above the threshold without an e-invoice
+func EInvoiceList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	status := c.Query("status")
+	exportType := c.Query("type")
+
+	query := einvoiceExportSelect + " WHERE 1=1"
+	args := []interface{}{}
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND ee.status = $%d", len(args))
+	}
+	if exportType != "" {
+		args = append(args, exportType)
+		query += fmt.Sprintf(" AND ee.export_type = $%d", len(args))
+	}
+	query += " ORDER BY ee.created_at DESC, ee.export_id DESC LIMIT 200"
+
+	var exports []einvoiceExportRow
+	if err := db.Raw(query, args...).Scan(&exports).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải nhật ký hóa đơn điện tử: " + err.Error(),
+		})
+	}
+	labelEInvoiceRows(exports)
+
+	var pending []struct {
+		InvoiceID    uint      `json:"invoice_id"`
+		InvoiceNo    string    `json:"invoice_no"`
+		InvoiceDate  time.Time `json:"invoice_date"`
+		TotalAmount  float64   `json:"total_amount"`
+		CustomerName string    `json:"customer_name"`
+	}
+	db.Raw(`
+		SELECT si.invoice_id, si.invoice_no, si.invoice_date, si.total_amount, COALESCE(c.full_name, '') as customer_name
+		FROM supermarket.sales_invoices si
+		LEFT JOIN supermarket.customers c ON si.customer_id = c.customer_id
+		WHERE si.status = $1
+			AND si.total_amount >= $2
+			AND si.invoice_date >= CURRENT_DATE - INTERVAL '30 days'
+			AND NOT EXISTS (
+				SELECT 1 FROM supermarket.einvoice_exports ee
+				WHERE ee.invoice_id = si.invoice_id AND ee.status <> $3
+			)
+		ORDER BY si.invoice_date DESC
+		LIMIT 100
+	`, models.InvoiceCompleted, einvoiceSettings.Threshold, models.EInvoiceFailed).Scan(&pending)
+
+	signerInfo := ""
+	if einvoiceSigner != nil {
+		signerInfo = fmt.Sprintf("%s (hiệu lực đến %s)", einvoiceSigner.Subject(), einvoiceSigner.NotAfter().Format("02/01/2006"))
+	}
+
+	return c.Render("pages/einvoices/list", fiber.Map{
+		"Title":           "Hóa đơn điện tử",
+		"Active":          "sales",
+		"Exports":         exports,
+		"Pending":         pending,
+		"Status":          status,
+		"Type":            exportType,
+		"TypeLabels":      einvoiceTypeLabels,
+		"StatusLabels":    einvoiceStatusLabels,
+		"Settings":        einvoiceSettings,
+		"Series":          einvoiceSeries(time.Now()),
+		"SignerInfo":      signerInfo,
+		"SignerError":     errorText(einvoiceSignerErr),
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// EInvoiceView shows one export with its signature check and the related exports of the sale
+func EInvoiceView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var export einvoiceExportRow
+	err := db.Raw(einvoiceExportSelect+" WHERE ee.export_id = $1", c.Params("id")).Scan(&export).Error
+	if err != nil || export.ExportID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy hóa đơn điện tử",
+			"Code":  404,
+		})
+	}
+	export.TypeLabel = einvoiceTypeLabels[export.ExportType]
+	export.StatusLabel = einvoiceStatusLabels[export.Status]
+
+	// Check the stored XML against its signature
+	verifyError := ""
+	xmlContent := ""
+	if export.XMLContent != nil {
+		xmlContent = *export.XMLContent
+		if _, err := einvoice.Verify([]byte(xmlContent)); err != nil {
+			verifyError = err.Error()
+		}
+	}
+
+	var related []einvoiceExportRow
+	db.Raw(einvoiceExportSelect+" WHERE ee.invoice_id = $1 ORDER BY ee.created_at, ee.export_id", export.InvoiceID).Scan(&related)
+	labelEInvoiceRows(related)
+
+	current := currentEInvoice(db, export.InvoiceID)
+	isCurrent := current != nil && current.ExportID == export.ExportID
+
+	return c.Render("pages/einvoices/view", fiber.Map{
+		"Title":           "Hóa đơn điện tử",
+		"Active":          "sales",
+		"Export":          export,
+		"XMLContent":      xmlContent,
+		"VerifyError":     verifyError,
+		"Related":         related,
+		"IsCurrent":       isCurrent,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// EInvoiceDownload downloads the signed XML of an export
+func EInvoiceDownload(c *fiber.Ctx) error {
+	export, err := loadEInvoiceExport(database.GetDB(), c.Params("id"))
+	if err != nil || export.XMLContent == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy hóa đơn điện tử đã ký",
+		})
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s_%s_%d.xml"`,
+		export.TemplateCode, export.Series, *export.EInvoiceNumber))
+	return c.SendString(*export.XMLContent)
+}
+
+// errorText is the message of err, or empty when there is none
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/supermarket/config"
+)
+
+func TestEInvoiceSeries(t *testing.T) {
+	saved := einvoiceSettings
+	t.Cleanup(func() { einvoiceSettings = saved })
+
+	issuedAt := time.Date(2027, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	einvoiceSettings = config.EInvoiceConfig{}
+	if got := einvoiceSeries(issuedAt); got != "C27TAA" {
+		t.Errorf("derived series = %q, want C27TAA", got)
+	}
+	if got := einvoiceSeries(issuedAt.AddDate(-1, 0, 0)); got != "C26TAA" {
+		t.Errorf("derived series a year earlier = %q, want C26TAA", got)
+	}
+
+	einvoiceSettings = config.EInvoiceConfig{Series: "K26TBB"}
+	if got := einvoiceSeries(issuedAt); got != "K26TBB" {
+		t.Errorf("configured series = %q, want K26TBB", got)
+	}
+}
@@ -0,0 +1,598 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// purchaseOrderStatusLabels are the display names of the purchase order statuses
+var purchaseOrderStatusLabels = map[models.OrderStatus]string{
+	models.OrderPending:           "Chờ duyệt",
+	models.OrderApproved:          "Đã duyệt",
+	models.OrderPartiallyReceived: "Nhận một phần",
+	models.OrderReceived:          "Đã nhận đủ",
+	models.OrderClosedShort:       "Đóng đơn thiếu",
+	models.OrderCancelled:         "Đã hủy",
+}
+
+// goodsReceiptLineInput is one lot of a purchase order line as delivered
+type goodsReceiptLineInput struct {
+	DetailID         uint   `json:"detail_id"`
+	BatchCode        string `json:"batch_code"`
+	ExpiryDate       string `json:"expiry_date"`
+	ReceivedQuantity int    `json:"received_quantity"`
+	RejectedQuantity int    `json:"rejected_quantity"`
+	RejectReason     string `json:"reject_reason"`
+}
+
+// goodsReceiptRequest is the body of a goods receipt: JSON with a list of lots, several per
+// order line if need be, or the receipt form with one lot per order line
+type goodsReceiptRequest struct {
+	WarehouseID    uint                    `json:"warehouse_id" form:"warehouse_id"`
+	EmployeeID     uint                    `json:"employee_id" form:"employee_id"`
+	DeliveryNoteNo string                  `json:"delivery_note_no" form:"delivery_note_no"`
+	Notes          string                  `json:"notes" form:"notes"`
+	Lines          []goodsReceiptLineInput `json:"lines" form:"-"`
+}
+
+// purchaseOrderLineRow is a purchase order line with what its receipts accepted so far
+type purchaseOrderLineRow struct {
+	models.PurchaseOrderDetail
+	ProductCode      string `json:"product_code"`
+	ProductName      string `json:"product_name"`
+	Unit             string `json:"unit"`
+	ShelfLifeDays    *int   `json:"shelf_life_days,omitempty"`
+	AcceptedQuantity int    `json:"accepted_quantity"`
+	RejectedQuantity int    `json:"rejected_quantity"`
+	Outstanding      int    `json:"outstanding"`
+	OverQuantity     int    `json:"over_quantity"`
+}
+
+// goodsReceiptRow is a goods receipt with its warehouse and receiver
+type goodsReceiptRow struct {
+	models.GoodsReceipt
+	OrderNo       string `json:"order_no"`
+	WarehouseName string `json:"warehouse_name"`
+	ReceiverName  string `json:"receiver_name"`
+	Accepted      int    `json:"accepted"`
+	Rejected      int    `json:"rejected"`
+}
+
+// lockPurchaseOrder loads a purchase order for update
+func lockPurchaseOrder(tx *gorm.DB, orderID uint) (*models.PurchaseOrder, int, error) {
+	var order models.PurchaseOrder
+	err := tx.Raw(`
+		SELECT * FROM supermarket.purchase_orders
+		WHERE order_id = $1
+		FOR UPDATE
+	`, orderID).Scan(&order).Error
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, err
+	}
+	if order.OrderID == 0 {
+		return nil, fiber.StatusNotFound, fmt.Errorf("Không tìm thấy đơn đặt hàng")
+	}
+	return &order, fiber.StatusOK, nil
+}
+
+// loadPurchaseOrderLines loads the lines of a purchase order with their received totals
+func loadPurchaseOrderLines(db *gorm.DB, orderID uint) ([]purchaseOrderLineRow, error) {
+	var lines []purchaseOrderLineRow
+	err := db.Raw(`
+		SELECT d.*, p.product_code, p.product_name, p.unit, p.shelf_life_days,
+		       COALESCE(SUM(l.received_quantity - l.rejected_quantity), 0) as accepted_quantity,
+		       COALESCE(SUM(l.rejected_quantity), 0) as rejected_quantity
+		FROM supermarket.purchase_order_details d
+		JOIN supermarket.products p ON d.product_id = p.product_id
+		LEFT JOIN supermarket.goods_receipt_lines l ON l.detail_id = d.detail_id
+		WHERE d.order_id = $1
+		GROUP BY d.detail_id, p.product_id
+		ORDER BY d.detail_id
+	`, orderID).Scan(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		lines[i].Outstanding = max(lines[i].Quantity-lines[i].AcceptedQuantity, 0)
+		lines[i].OverQuantity = max(lines[i].AcceptedQuantity-lines[i].Quantity, 0)
+	}
+	return lines, nil
+}
+
+// derivePurchaseOrderStatus works out an order's receiving status from what its receipts
+// accepted. Closing an order that is still short marks it closed short.
+func derivePurchaseOrderStatus(lines []purchaseOrderLineRow, closing bool) models.OrderStatus {
+	accepted, outstanding := 0, 0
+	for _, line := range lines {
+		accepted += line.AcceptedQuantity
+		outstanding += line.Outstanding
+	}
+	switch {
+	case outstanding == 0:
+		return models.OrderReceived
+	case closing:
+		return models.OrderClosedShort
+	case accepted > 0:
+		return models.OrderPartiallyReceived
+	default:
+		return models.OrderApproved
+	}
+}
+
+// parseGoodsReceiptRequest reads and checks a goods receipt request
+func parseGoodsReceiptRequest(c *fiber.Ctx, lines []purchaseOrderLineRow) (*goodsReceiptRequest, error) {
+	var req goodsReceiptRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, fmt.Errorf("Dữ liệu không hợp lệ: %v", err)
+	}
+	if req.WarehouseID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn kho nhập")
+	}
+	if req.EmployeeID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn nhân viên nhận hàng")
+	}
+
+	if c.Get("Content-Type") != "application/json" {
+		for _, line := range lines {
+			value := strings.TrimSpace(c.FormValue(fmt.Sprintf("received_%d", line.DetailID)))
+			if value == "" || value == "0" {
+				continue
+			}
+			received, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Số lượng giao của %s không hợp lệ", line.ProductName)
+			}
+			rejected := 0
+			if value := strings.TrimSpace(c.FormValue(fmt.Sprintf("rejected_%d", line.DetailID))); value != "" {
+				if rejected, err = strconv.Atoi(value); err != nil {
+					return nil, fmt.Errorf("Số lượng từ chối của %s không hợp lệ", line.ProductName)
+				}
+			}
+			req.Lines = append(req.Lines, goodsReceiptLineInput{
+				DetailID:         line.DetailID,
+				BatchCode:        c.FormValue(fmt.Sprintf("batch_%d", line.DetailID)),
+				ExpiryDate:       c.FormValue(fmt.Sprintf("expiry_%d", line.DetailID)),
+				ReceivedQuantity: received,
+				RejectedQuantity: rejected,
+				RejectReason:     c.FormValue(fmt.Sprintf("reject_reason_%d", line.DetailID)),
+			})
+		}
+	}
+	if len(req.Lines) == 0 {
+		return nil, fmt.Errorf("Vui lòng nhập số lượng đã giao")
+	}
+	for _, input := range req.Lines {
+		if input.ReceivedQuantity <= 0 {
+			return nil, fmt.Errorf("Số lượng giao phải lớn hơn 0")
+		}
+		if input.RejectedQuantity < 0 || input.RejectedQuantity > input.ReceivedQuantity {
+			return nil, fmt.Errorf("Số lượng từ chối phải từ 0 đến số lượng giao")
+		}
+		if input.RejectedQuantity > 0 && strings.TrimSpace(input.RejectReason) == "" {
+			return nil, fmt.Errorf("Vui lòng ghi lý do từ chối nhận hàng")
+		}
+	}
+	return &req, nil
+}
+
+// stockReceivedBatch adds an accepted quantity to a warehouse batch, creating the batch on
+// its first receipt. A lot number already in the warehouse must carry the same expiry date.
+func stockReceivedBatch(tx *gorm.DB, warehouseID uint, line *models.GoodsReceiptLine) error {
+	var existing models.WarehouseInventory
+	err := tx.Raw(`
+		SELECT * FROM supermarket.warehouse_inventory
+		WHERE warehouse_id = $1 AND product_id = $2 AND batch_code = $3
+		FOR UPDATE
+	`, warehouseID, line.ProductID, line.BatchCode).Scan(&existing).Error
+	if err != nil {
+		return err
+	}
+
+	if existing.InventoryID == 0 {
+		return tx.Exec(`
+			INSERT INTO supermarket.warehouse_inventory (warehouse_id, product_id, batch_code, quantity, import_date,
+			                                             expiry_date, import_price, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, CURRENT_DATE, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		`, warehouseID, line.ProductID, line.BatchCode, line.AcceptedQuantity(), line.ExpiryDate, line.UnitCost).Error
+	}
+
+	sameExpiry := (existing.ExpiryDate == nil && line.ExpiryDate == nil) ||
+		(existing.ExpiryDate != nil && line.ExpiryDate != nil && existing.ExpiryDate.Format("2006-01-02") == line.ExpiryDate.Format("2006-01-02"))
+	if !sameExpiry {
+		return fmt.Errorf("Lô %s đã có trong kho với hạn sử dụng khác, vui lòng nhập đúng số lô in trên bao bì", line.BatchCode)
+	}
+	return tx.Exec(`
+		UPDATE supermarket.warehouse_inventory
+		SET quantity = quantity + $1, updated_at = CURRENT_TIMESTAMP
+		WHERE inventory_id = $2
+	`, line.AcceptedQuantity(), existing.InventoryID).Error
+}
+
+// GoodsReceiptForm is the form to receive a delivery against a purchase order
+func GoodsReceiptForm(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	orderID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID đơn đặt hàng không hợp lệ",
+		})
+	}
+
+	var order models.PurchaseOrder
+	if err := db.Preload("Supplier").First(&order, orderID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy đơn đặt hàng",
+		})
+	}
+	if !order.Status.AcceptsReceipts() {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Chỉ nhập kho được đơn hàng đã duyệt và chưa nhận đủ",
+		})
+	}
+
+	lines, err := loadPurchaseOrderLines(db, order.OrderID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải chi tiết đơn hàng: " + err.Error(),
+		})
+	}
+
+	data := stockCountFormData(db)
+	data["Title"] = "Nhập kho theo đơn " + order.OrderNo
+	data["Active"] = "purchase-orders"
+	data["Order"] = order
+	data["Lines"] = lines
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/purchase_orders/receipt_form", data, "layouts/base")
+}
+
+// GoodsReceiptCreate posts a goods receipt: the accepted quantities go into the chosen
+// warehouse as batches under the delivered lot numbers and expiry dates, and the order's
+// status is derived again from all its receipts
+func GoodsReceiptCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	orderID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID đơn đặt hàng không hợp lệ"})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, status, err := lockPurchaseOrder(tx, uint(orderID))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !order.Status.AcceptsReceipts() {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Chỉ nhập kho được đơn hàng đã duyệt và chưa nhận đủ"})
+	}
+
+	lines, err := loadPurchaseOrderLines(tx, order.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	req, err := parseGoodsReceiptRequest(c, lines)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var warehouseName string
+	tx.Raw("SELECT warehouse_name FROM supermarket.warehouse WHERE warehouse_id = $1", req.WarehouseID).Scan(&warehouseName)
+	if warehouseName == "" {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không tìm thấy kho nhập"})
+	}
+
+	orderLines := make(map[uint]*purchaseOrderLineRow, len(lines))
+	for i := range lines {
+		orderLines[lines[i].DetailID] = &lines[i]
+	}
+
+	receiptNo, err := nextDocumentNo(tx, models.DocGoodsReceipt, "")
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	receipt := models.GoodsReceipt{
+		ReceiptNo:      receiptNo,
+		OrderID:        order.OrderID,
+		WarehouseID:    req.WarehouseID,
+		ReceivedBy:     req.EmployeeID,
+		DeliveryNoteNo: nullIfEmpty(strings.TrimSpace(req.DeliveryNoteNo)),
+		Notes:          nullIfEmpty(req.Notes),
+		ReceivedAt:     now,
+	}
+	if err := tx.Create(&receipt).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tạo phiếu nhập kho: " + err.Error(),
+		})
+	}
+
+	today := now.Truncate(24 * time.Hour)
+	accepted, rejected := 0, 0
+	for _, input := range req.Lines {
+		orderLine, ok := orderLines[input.DetailID]
+		if !ok {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Sản phẩm nhận không thuộc đơn đặt hàng"})
+		}
+
+		line := models.GoodsReceiptLine{
+			ReceiptID:        receipt.ReceiptID,
+			DetailID:         orderLine.DetailID,
+			ProductID:        orderLine.ProductID,
+			BatchCode:        strings.TrimSpace(input.BatchCode),
+			ReceivedQuantity: input.ReceivedQuantity,
+			RejectedQuantity: input.RejectedQuantity,
+			RejectReason:     nullIfEmpty(strings.TrimSpace(input.RejectReason)),
+			UnitCost:         orderLine.UnitPrice,
+		}
+		if line.BatchCode == "" {
+			line.BatchCode = fmt.Sprintf("%s-%d", order.OrderNo, orderLine.DetailID)
+		}
+		if input.ExpiryDate != "" {
+			expiry, err := time.Parse("2006-01-02", input.ExpiryDate)
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Hạn sử dụng của %s không hợp lệ", orderLine.ProductName),
+				})
+			}
+			line.ExpiryDate = &expiry
+		} else if orderLine.ShelfLifeDays != nil {
+			// No printed date: the product's shelf life from today
+			expiry := today.AddDate(0, 0, *orderLine.ShelfLifeDays)
+			line.ExpiryDate = &expiry
+		}
+		if line.ExpiryDate != nil && line.ExpiryDate.Before(today) && line.AcceptedQuantity() > 0 {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Lô %s của %s đã hết hạn, vui lòng từ chối nhận", line.BatchCode, orderLine.ProductName),
+			})
+		}
+
+		if line.AcceptedQuantity() > 0 {
+			if err := stockReceivedBatch(tx, req.WarehouseID, &line); err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Không thể nhập kho %s: %v", orderLine.ProductName, err),
+				})
+			}
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể ghi dòng nhập kho: " + err.Error(),
+			})
+		}
+		receipt.TotalCost += float64(line.AcceptedQuantity()) * line.UnitCost
+		accepted += line.AcceptedQuantity()
+		rejected += line.RejectedQuantity
+	}
+
+	receipt.TotalCost = roundVND(receipt.TotalCost)
+	err = tx.Model(&models.GoodsReceipt{}).Where("receipt_id = ?", receipt.ReceiptID).Update("total_cost", receipt.TotalCost).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	lines, err = loadPurchaseOrderLines(tx, order.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	orderStatus := derivePurchaseOrderStatus(lines, false)
+	err = tx.Model(&models.PurchaseOrder{}).Where("order_id = ?", order.OrderID).Updates(map[string]interface{}{
+		"status":     orderStatus,
+		"updated_at": now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật trạng thái đơn hàng: " + err.Error(),
+		})
+	}
+
+	description := fmt.Sprintf("Nhập kho %s theo đơn %s vào %s: nhận %d", receipt.ReceiptNo, order.OrderNo, warehouseName, accepted)
+	if rejected > 0 {
+		description += fmt.Sprintf(", từ chối %d", rejected)
+	}
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+		VALUES ($1, $2, 'goods_receipts', $3, $4, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeGoodsReceived, description, receipt.ReceiptID, receipt.ReceivedBy).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi nhật ký nhập kho: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":      true,
+			"receipt":      receipt,
+			"order_status": orderStatus,
+			"message":      description,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/purchase-orders/%d/receipts/%d", order.OrderID, receipt.ReceiptID))
+}
+
+// GoodsReceiptView shows a goods receipt with its lots
+func GoodsReceiptView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	receiptID, err := strconv.ParseUint(c.Params("receipt_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID phiếu nhập kho không hợp lệ",
+		})
+	}
+
+	var receipt goodsReceiptRow
+	db.Raw(`
+		SELECT r.*, po.order_no, w.warehouse_name, e.full_name as receiver_name
+		FROM supermarket.goods_receipts r
+		JOIN supermarket.purchase_orders po ON r.order_id = po.order_id
+		JOIN supermarket.warehouse w ON r.warehouse_id = w.warehouse_id
+		JOIN supermarket.employees e ON r.received_by = e.employee_id
+		WHERE r.receipt_id = $1 AND r.order_id = $2
+	`, receiptID, c.Params("id")).Scan(&receipt)
+	if receipt.ReceiptID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy phiếu nhập kho",
+		})
+	}
+
+	var lines []struct {
+		models.GoodsReceiptLine
+		ProductCode      string  `json:"product_code"`
+		ProductName      string  `json:"product_name"`
+		AcceptedQuantity int     `json:"accepted_quantity"`
+		LineCost         float64 `json:"line_cost"`
+	}
+	db.Raw(`
+		SELECT l.*, p.product_code, p.product_name,
+		       l.received_quantity - l.rejected_quantity as accepted_quantity,
+		       (l.received_quantity - l.rejected_quantity) * l.unit_cost as line_cost
+		FROM supermarket.goods_receipt_lines l
+		JOIN supermarket.products p ON l.product_id = p.product_id
+		WHERE l.receipt_id = $1
+		ORDER BY l.line_id
+	`, receiptID).Scan(&lines)
+	for _, line := range lines {
+		receipt.Accepted += line.AcceptedQuantity
+		receipt.Rejected += line.RejectedQuantity
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"receipt": receipt,
+			"lines":   lines,
+		})
+	}
+
+	return c.Render("pages/purchase_orders/receipt_view", fiber.Map{
+		"Title":           "Phiếu nhập kho " + receipt.ReceiptNo,
+		"Active":          "purchase-orders",
+		"Receipt":         receipt,
+		"Lines":           lines,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// PurchaseOrderClose closes a partially received order whose remaining quantities will not
+// be delivered
+func PurchaseOrderClose(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	orderID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID đơn đặt hàng không hợp lệ"})
+	}
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, status, err := lockPurchaseOrder(tx, uint(orderID))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if order.Status != models.OrderPartiallyReceived {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Chỉ đóng được đơn hàng đã nhận một phần; đơn chưa nhận hàng thì hủy đơn",
+		})
+	}
+
+	lines, err := loadPurchaseOrderLines(tx, order.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	outstanding := 0
+	for _, line := range lines {
+		outstanding += line.Outstanding
+	}
+	orderStatus := derivePurchaseOrderStatus(lines, true)
+
+	err = tx.Model(&models.PurchaseOrder{}).Where("order_id = ?", order.OrderID).Updates(map[string]interface{}{
+		"status":     orderStatus,
+		"updated_at": time.Now(),
+	}).Error
+	if err == nil {
+		err = tx.Exec(`
+			INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+			VALUES ($1, $2, 'purchase_orders', $3, $4, CURRENT_TIMESTAMP)
+		`, models.ActivityTypeGoodsReceived,
+			fmt.Sprintf("Đóng đơn %s, %d sản phẩm không được giao", order.OrderNo, outstanding),
+			order.OrderID, employeeID).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể đóng đơn hàng: " + err.Error(),
+		})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":     true,
+			"status":      orderStatus,
+			"outstanding": outstanding,
+			"message":     "Đã đóng đơn hàng " + order.OrderNo,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/purchase-orders/%d", order.OrderID))
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/supermarket/models"
+)
+
+func TestDerivePurchaseOrderStatus(t *testing.T) {
+	line := func(accepted, outstanding int) purchaseOrderLineRow {
+		return purchaseOrderLineRow{AcceptedQuantity: accepted, Outstanding: outstanding}
+	}
+
+	tests := []struct {
+		name    string
+		lines   []purchaseOrderLineRow
+		closing bool
+		want    models.OrderStatus
+	}{
+		{"nothing received", []purchaseOrderLineRow{line(0, 10), line(0, 5)}, false, models.OrderApproved},
+		{"partly received", []purchaseOrderLineRow{line(4, 6), line(0, 5)}, false, models.OrderPartiallyReceived},
+		{"fully received", []purchaseOrderLineRow{line(10, 0), line(5, 0)}, false, models.OrderReceived},
+		{"over-delivered line", []purchaseOrderLineRow{line(12, 0), line(5, 0)}, false, models.OrderReceived},
+		{"closed short", []purchaseOrderLineRow{line(4, 6), line(5, 0)}, true, models.OrderClosedShort},
+		{"closed with nothing received", []purchaseOrderLineRow{line(0, 10)}, true, models.OrderClosedShort},
+		{"closing a fully received order", []purchaseOrderLineRow{line(10, 0)}, true, models.OrderReceived},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := derivePurchaseOrderStatus(tt.lines, tt.closing); got != tt.want {
+				t.Errorf("derivePurchaseOrderStatus = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
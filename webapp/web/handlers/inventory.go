@@ -2,12 +2,12 @@ package handlers
 
 import (
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/supermarket/database"
 	"github.com/supermarket/models"
+	"gorm.io/gorm"
 )
 
 // InventoryOverview displays the main inventory dashboard
@@ -860,6 +860,14 @@ func ExpiredProducts(c *fiber.Ctx) error {
 		totalPotentialRevenue += product.PotentialRevenue
 	}
 
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
 	return c.Render("pages/inventory/expired", fiber.Map{
 		"Title":                 "Quản lý hàng hết hạn",
 		"Active":                "inventory",
@@ -869,6 +877,7 @@ func ExpiredProducts(c *fiber.Ctx) error {
 		"TotalNearExpiry":       len(nearExpiryProducts),
 		"TotalLoss":             totalLoss,
 		"TotalPotentialRevenue": totalPotentialRevenue,
+		"Employees":             employees,
 		"SQLQueries":            c.Locals("SQLQueries"),
 		"TotalSQLQueries":       c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
@@ -1362,93 +1371,65 @@ func DeleteDiscountRule(c *fiber.Ctx) error {
 	})
 }
 
-// DeleteWarehouseInventory deletes a warehouse inventory batch by inventory_id
-func DeleteWarehouseInventory(c *fiber.Ctx) error {
-	db := database.GetDB()
-
-	idStr := c.Params("id")
-	id, err := strconv.ParseUint(idStr, 10, 64)
-	if err != nil || id == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid inventory id",
-		})
-	}
-
-	// Set quantity to 0 to mark as disposed, then delete row
-	// Prefer hard delete to reflect disposal immediately in UI
-	if err := db.Exec("DELETE FROM supermarket.warehouse_inventory WHERE inventory_id = ?", id).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Không thể hủy lô hàng kho: " + err.Error(),
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Đã hủy lô hàng kho",
-	})
-}
-
-// DeleteShelfInventory deletes a shelf batch inventory by shelf_batch_id
-func DeleteShelfInventory(c *fiber.Ctx) error {
-	db := database.GetDB()
-
-	idStr := c.Params("id")
-	id, err := strconv.ParseUint(idStr, 10, 64)
-	if err != nil || id == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid shelf batch id",
-		})
-	}
-
-	if err := db.Exec("DELETE FROM supermarket.shelf_batch_inventory WHERE shelf_batch_id = ?", id).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Không thể hủy lô hàng kệ: " + err.Error(),
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Đã hủy lô hàng kệ",
-	})
+// syncShelfInventorySummary recalculates a shelf_inventory summary row from its batches
+func syncShelfInventorySummary(tx *gorm.DB, shelfID, productID uint) error {
+	var batches []models.ShelfBatchInventory
+	if err := tx.Where("shelf_id = ? AND product_id = ?", shelfID, productID).Find(&batches).Error; err != nil {
+		return err
+	}
+
+	var summary models.ShelfInventory
+	summary.UpdateSummaryFromBatches(batches, models.DefaultNearExpiryDays)
+
+	// Expired batches stay on the shelf until disposed but cannot be sold, so the
+	// sellable quantity uses the same cut-off as the FEFO allocation in the triggers
+	var totals struct {
+		Sellable int
+		Expired  int
+	}
+	if err := tx.Raw(`
+		SELECT COALESCE(SUM(quantity) FILTER (WHERE expiry_date IS NULL OR expiry_date >= CURRENT_DATE), 0) as sellable,
+		       COALESCE(SUM(quantity) FILTER (WHERE expiry_date < CURRENT_DATE), 0) as expired
+		FROM supermarket.shelf_batch_inventory
+		WHERE shelf_id = $1 AND product_id = $2
+	`, shelfID, productID).Scan(&totals).Error; err != nil {
+		return err
+	}
+	summary.CurrentQuantity = totals.Sellable
+	summary.ExpiredQuantity = totals.Expired
+
+	return tx.Model(&models.ShelfInventory{}).
+		Where("shelf_id = ? AND product_id = ?", shelfID, productID).
+		Updates(map[string]interface{}{
+			"current_quantity":     summary.CurrentQuantity,
+			"near_expiry_quantity": summary.NearExpiryQuantity,
+			"expired_quantity":     summary.ExpiredQuantity,
+			"earliest_expiry_date": summary.EarliestExpiryDate,
+			"latest_expiry_date":   summary.LatestExpiryDate,
+			"updated_at":           summary.UpdatedAt,
+		}).Error
 }
 
-// DisposeAllExpired disposes all expired batches in both warehouse and shelf
-func DisposeAllExpired(c *fiber.Ctx) error {
-	db := database.GetDB()
-
-	tx := db.Begin()
-
-	// Delete expired warehouse batches
-	if err := tx.Exec("DELETE FROM supermarket.warehouse_inventory WHERE expiry_date < CURRENT_DATE").Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Không thể hủy các lô hàng kho hết hạn: " + err.Error(),
-		})
-	}
-
-	// Delete expired shelf batches
-	if err := tx.Exec("DELETE FROM supermarket.shelf_batch_inventory WHERE expiry_date < CURRENT_DATE").Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Không thể hủy các lô hàng kệ hết hạn: " + err.Error(),
-		})
+// syncInvoiceShelfSummaries re-syncs the summary rows of every shelf an invoice was allocated from
+func syncInvoiceShelfSummaries(tx *gorm.DB, invoiceID uint) error {
+	var pairs []struct {
+		ShelfID   uint
+		ProductID uint
+	}
+	err := tx.Raw(`
+		SELECT DISTINCT a.shelf_id, d.product_id
+		FROM supermarket.sales_invoice_allocations a
+		JOIN supermarket.sales_invoice_details d ON d.detail_id = a.detail_id
+		WHERE d.invoice_id = $1
+	`, invoiceID).Scan(&pairs).Error
+	if err != nil {
+		return err
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Không thể hoàn tất hủy hàng hết hạn: " + err.Error(),
-		})
+	for _, p := range pairs {
+		if err := syncShelfInventorySummary(tx, p.ShelfID, p.ProductID); err != nil {
+			return err
+		}
 	}
-
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Đã hủy tất cả lô hàng hết hạn",
-	})
+	return nil
 }
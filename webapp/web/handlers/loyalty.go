@@ -0,0 +1,609 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/config"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// loyaltySettings is the redemption rate and validity of points, set at startup by ConfigureLoyalty
+var loyaltySettings = config.LoyaltyConfig{
+	RedeemPoints: 1000,
+	RedeemValue:  1,
+	ExpiryMonths: models.DefaultLoyaltyExpiryMonths,
+}
+
+// ConfigureLoyalty applies the loyalty configuration loaded at startup
+func ConfigureLoyalty(cfg config.LoyaltyConfig) {
+	if cfg.RedeemPoints <= 0 || cfg.RedeemValue <= 0 {
+		cfg.RedeemPoints, cfg.RedeemValue = loyaltySettings.RedeemPoints, loyaltySettings.RedeemValue
+	}
+	if cfg.ExpiryMonths < 0 {
+		cfg.ExpiryMonths = 0
+	}
+	loyaltySettings = cfg
+}
+
+// loyaltyEntryLabels are the display names of the points ledger entry types
+var loyaltyEntryLabels = map[models.LoyaltyEntryType]string{
+	models.LoyaltyEntryEarn:    "Tích điểm",
+	models.LoyaltyEntryRedeem:  "Đổi điểm",
+	models.LoyaltyEntryAdjust:  "Điều chỉnh",
+	models.LoyaltyEntryExpire:  "Hết hạn",
+	models.LoyaltyEntryReverse: "Hoàn tác",
+}
+
+// loyaltyEntryRow is a ledger entry with its documents and employee
+type loyaltyEntryRow struct {
+	models.LoyaltyTransaction
+	InvoiceNo    string `json:"invoice_no"`
+	ReturnNo     string `json:"return_no"`
+	EmployeeName string `json:"employee_name"`
+}
+
+// pointsValue is the amount in VND that redeeming points takes off a sale
+func pointsValue(points int) float64 {
+	return roundVND(float64(points) * loyaltySettings.RedeemValue / float64(loyaltySettings.RedeemPoints))
+}
+
+// pointsForValue is the largest number of points whose value does not exceed amount
+func pointsForValue(amount float64) int {
+	if amount <= 0 {
+		return 0
+	}
+	points := int(math.Floor(amount*float64(loyaltySettings.RedeemPoints)/loyaltySettings.RedeemValue + 1e-9))
+	for points > 0 && pointsValue(points) > amount {
+		points--
+	}
+	return points
+}
+
+// loyaltyExpiry is the expiry date of points added at t, nil when points do not expire
+func loyaltyExpiry(t time.Time) *time.Time {
+	if loyaltySettings.ExpiryMonths <= 0 {
+		return nil
+	}
+	expiresAt := t.AddDate(0, loyaltySettings.ExpiryMonths, 0)
+	return &expiresAt
+}
+
+// lockCustomerPoints locks a customer row for a points change and returns the balance
+func lockCustomerPoints(tx *gorm.DB, customerID uint) (int, error) {
+	var customer struct {
+		CustomerID    uint
+		LoyaltyPoints int
+	}
+	err := tx.Raw(`
+		SELECT customer_id, loyalty_points FROM supermarket.customers
+		WHERE customer_id = $1
+		FOR UPDATE
+	`, customerID).Scan(&customer).Error
+	if err != nil {
+		return 0, err
+	}
+	if customer.CustomerID == 0 {
+		return 0, fmt.Errorf("Không tìm thấy khách hàng")
+	}
+	return customer.LoyaltyPoints, nil
+}
+
+// writeLoyaltyEntry appends an entry to the points ledger
+func writeLoyaltyEntry(tx *gorm.DB, e *models.LoyaltyTransaction) error {
+	return tx.Raw(`
+		INSERT INTO supermarket.loyalty_transactions
+		(customer_id, entry_type, points, balance_after, remaining, expires_at, invoice_id, return_id, employee_id, notes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP)
+		RETURNING transaction_id
+	`, e.CustomerID, e.EntryType, e.Points, e.BalanceAfter, e.Remaining, e.ExpiresAt,
+		e.InvoiceID, e.ReturnID, e.EmployeeID, e.Notes).Scan(&e.TransactionID).Error
+}
+
+// setCustomerPoints stores the balance written by the last ledger entry
+func setCustomerPoints(tx *gorm.DB, customerID uint, balance int) error {
+	return tx.Exec(`
+		UPDATE supermarket.customers
+		SET loyalty_points = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE customer_id = $2
+	`, balance, customerID).Error
+}
+
+// lotUsage is the points an entry takes from a lot, or gives back to it when negative
+type lotUsage struct {
+	LotID  uint
+	Points int
+}
+
+// takeLoyaltyLots consumes points from the customer's open lots, those of the invoice first
+// (so a reversal takes back the very points the sale earned) and then the soonest to expire
+func takeLoyaltyLots(tx *gorm.DB, customerID uint, points int, invoiceID *uint) ([]lotUsage, error) {
+	var lots []struct {
+		TransactionID uint
+		Remaining     int
+	}
+	err := tx.Raw(`
+		SELECT transaction_id, remaining
+		FROM supermarket.loyalty_transactions
+		WHERE customer_id = $1 AND remaining > 0
+		ORDER BY COALESCE(invoice_id = $2, false) DESC, expires_at NULLS LAST, transaction_id
+		FOR UPDATE
+	`, customerID, invoiceID).Scan(&lots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []lotUsage
+	for _, lot := range lots {
+		if points <= 0 {
+			break
+		}
+		take := min(lot.Remaining, points)
+		err := tx.Exec(`
+			UPDATE supermarket.loyalty_transactions SET remaining = remaining - $1
+			WHERE transaction_id = $2
+		`, take, lot.TransactionID).Error
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, lotUsage{LotID: lot.TransactionID, Points: take})
+		points -= take
+	}
+	return usages, nil
+}
+
+// returnLoyaltyLots gives redeemed points of an invoice back to the lots its redemption took
+// them from, latest expiry first, so they keep their original expiry (a lot already past it
+// is written off again by the next expiry run). Points the lots cannot take back, such as
+// redemptions booked before lot usages were recorded, are left to the caller.
+func returnLoyaltyLots(tx *gorm.DB, customerID, invoiceID uint, points int) ([]lotUsage, error) {
+	var lots []lotUsage
+	err := tx.Raw(`
+		SELECT u.lot_id, SUM(u.points) as points
+		FROM supermarket.loyalty_lot_usages u
+		JOIN supermarket.loyalty_transactions e ON e.transaction_id = u.entry_id
+		JOIN supermarket.loyalty_transactions l ON l.transaction_id = u.lot_id
+		WHERE e.customer_id = $1 AND e.invoice_id = $2
+		  AND (e.entry_type = $3 OR (e.entry_type = $4 AND e.points > 0))
+		GROUP BY u.lot_id, l.expires_at
+		HAVING SUM(u.points) > 0
+		ORDER BY l.expires_at DESC NULLS FIRST, u.lot_id DESC
+	`, customerID, invoiceID, models.LoyaltyEntryRedeem, models.LoyaltyEntryReverse).Scan(&lots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []lotUsage
+	for _, lot := range lots {
+		if points <= 0 {
+			break
+		}
+		give := min(lot.Points, points)
+		err := tx.Exec(`
+			UPDATE supermarket.loyalty_transactions SET remaining = remaining + $1
+			WHERE transaction_id = $2
+		`, give, lot.LotID).Error
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, lotUsage{LotID: lot.LotID, Points: -give})
+		points -= give
+	}
+	return usages, nil
+}
+
+// recordLotUsages links a ledger entry to the lots it took points from or gave them back to
+func recordLotUsages(tx *gorm.DB, entryID uint, usages []lotUsage) error {
+	for _, u := range usages {
+		err := tx.Exec(`
+			INSERT INTO supermarket.loyalty_lot_usages (entry_id, lot_id, points, created_at)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		`, entryID, u.LotID, u.Points).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postLoyaltyPoints books a points change for e.CustomerID: it writes the ledger entry and the
+// new balance. Added points open a lot that expires after the configured validity, except
+// redeemed points given back by a reversal, which return to the lots they were taken from;
+// taken points come out of the open lots. Redemptions and adjustments fail when the balance
+// is too small, reversals take what is left, so e.Points is the change actually booked
+// (0 writes nothing).
+func postLoyaltyPoints(tx *gorm.DB, e *models.LoyaltyTransaction) error {
+	balance, err := lockCustomerPoints(tx, e.CustomerID)
+	if err != nil {
+		return err
+	}
+
+	var usages []lotUsage
+	if e.Points < 0 {
+		if -e.Points > balance {
+			if e.EntryType != models.LoyaltyEntryReverse {
+				return fmt.Errorf("Khách hàng chỉ còn %d điểm", balance)
+			}
+			e.Points = -balance
+		}
+		if usages, err = takeLoyaltyLots(tx, e.CustomerID, -e.Points, e.InvoiceID); err != nil {
+			return err
+		}
+	} else {
+		e.Remaining = e.Points
+		if e.EntryType == models.LoyaltyEntryReverse && e.InvoiceID != nil {
+			if usages, err = returnLoyaltyLots(tx, e.CustomerID, *e.InvoiceID, e.Points); err != nil {
+				return err
+			}
+			for _, u := range usages {
+				e.Remaining += u.Points
+			}
+		}
+		if e.Remaining > 0 && e.ExpiresAt == nil {
+			e.ExpiresAt = loyaltyExpiry(time.Now())
+		}
+	}
+	if e.Points == 0 {
+		return nil
+	}
+
+	e.BalanceAfter = balance + e.Points
+	if err := writeLoyaltyEntry(tx, e); err != nil {
+		return err
+	}
+	if err := recordLotUsages(tx, e.TransactionID, usages); err != nil {
+		return err
+	}
+	return setCustomerPoints(tx, e.CustomerID, e.BalanceAfter)
+}
+
+// bookSaleLoyalty posts the points redeemed on a new sale and those it earned, which the
+// customer metrics trigger has computed from the final total and the membership multiplier
+func bookSaleLoyalty(tx *gorm.DB, invoiceID, customerID uint, pointsUsed int, employeeID uint) error {
+	if pointsUsed > 0 {
+		err := postLoyaltyPoints(tx, &models.LoyaltyTransaction{
+			CustomerID: customerID,
+			EntryType:  models.LoyaltyEntryRedeem,
+			Points:     -pointsUsed,
+			InvoiceID:  &invoiceID,
+			EmployeeID: &employeeID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var pointsEarned int
+	if err := tx.Raw("SELECT points_earned FROM supermarket.sales_invoices WHERE invoice_id = $1", invoiceID).Scan(&pointsEarned).Error; err != nil {
+		return err
+	}
+	if pointsEarned <= 0 {
+		return nil
+	}
+	return postLoyaltyPoints(tx, &models.LoyaltyTransaction{
+		CustomerID: customerID,
+		EntryType:  models.LoyaltyEntryEarn,
+		Points:     pointsEarned,
+		InvoiceID:  &invoiceID,
+		EmployeeID: &employeeID,
+	})
+}
+
+// reverseSaleLoyalty undoes points of a voided or returned sale: the redeemed points are given
+// back first, then the earned points are taken back as far as the balance allows. It returns
+// the points actually taken back and given back.
+func reverseSaleLoyalty(tx *gorm.DB, invoiceID, customerID uint, returnID *uint, earned, used int, employeeID *uint, notes string) (int, int, error) {
+	restored := 0
+	if used > 0 {
+		e := &models.LoyaltyTransaction{
+			CustomerID: customerID,
+			EntryType:  models.LoyaltyEntryReverse,
+			Points:     used,
+			InvoiceID:  &invoiceID,
+			ReturnID:   returnID,
+			EmployeeID: employeeID,
+			Notes:      &notes,
+		}
+		if err := postLoyaltyPoints(tx, e); err != nil {
+			return 0, 0, err
+		}
+		restored = e.Points
+	}
+
+	reversed := 0
+	if earned > 0 {
+		e := &models.LoyaltyTransaction{
+			CustomerID: customerID,
+			EntryType:  models.LoyaltyEntryReverse,
+			Points:     -earned,
+			InvoiceID:  &invoiceID,
+			ReturnID:   returnID,
+			EmployeeID: employeeID,
+			Notes:      &notes,
+		}
+		if err := postLoyaltyPoints(tx, e); err != nil {
+			return 0, 0, err
+		}
+		reversed = -e.Points
+	}
+
+	return reversed, restored, nil
+}
+
+// expireLoyaltyPoints writes off the remainder of every lot past its expiry date and returns
+// the number of points expired. Customers are locked before their lots, like postLoyaltyPoints.
+func expireLoyaltyPoints(db *gorm.DB) (int, error) {
+	var customerIDs []uint
+	err := db.Raw(`
+		SELECT DISTINCT customer_id FROM supermarket.loyalty_transactions
+		WHERE remaining > 0 AND expires_at < CURRENT_DATE
+		ORDER BY customer_id
+	`).Scan(&customerIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, customerID := range customerIDs {
+		points, err := expireCustomerPoints(db, customerID)
+		if err != nil {
+			return expired, err
+		}
+		expired += points
+	}
+	return expired, nil
+}
+
+// expireCustomerPoints expires the lots of one customer in its own transaction
+func expireCustomerPoints(db *gorm.DB, customerID uint) (int, error) {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	balance, err := lockCustomerPoints(tx, customerID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var lots []models.LoyaltyTransaction
+	err = tx.Raw(`
+		SELECT * FROM supermarket.loyalty_transactions
+		WHERE customer_id = $1 AND remaining > 0 AND expires_at < CURRENT_DATE
+		ORDER BY expires_at, transaction_id
+		FOR UPDATE
+	`, customerID).Scan(&lots).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	expired := 0
+	for _, lot := range lots {
+		points := min(lot.Remaining, balance)
+		err := tx.Exec("UPDATE supermarket.loyalty_transactions SET remaining = 0 WHERE transaction_id = $1", lot.TransactionID).Error
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if points <= 0 {
+			continue
+		}
+
+		balance -= points
+		notes := fmt.Sprintf("Điểm hết hạn ngày %s", lot.ExpiresAt.Format("02/01/2006"))
+		err = writeLoyaltyEntry(tx, &models.LoyaltyTransaction{
+			CustomerID:   customerID,
+			EntryType:    models.LoyaltyEntryExpire,
+			Points:       -points,
+			BalanceAfter: balance,
+			Notes:        &notes,
+		})
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		expired += points
+	}
+
+	if err := setCustomerPoints(tx, customerID, balance); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// RunLoyaltyExpiry expires points past their validity every interval until the process exits
+func RunLoyaltyExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := expireLoyaltyPoints(database.GetDB())
+		if err != nil {
+			log.Printf("Failed to expire loyalty points: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d loyalty point(s)", expired)
+		}
+	}
+}
+
+// CustomerPoints shows the points statement of a customer: the ledger, the open lots and
+// when they expire
+func CustomerPoints(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	customerID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID khách hàng không hợp lệ",
+			"Code":  400,
+		})
+	}
+
+	var customer struct {
+		models.Customer
+		LevelName        *string
+		PointsMultiplier float64
+	}
+	result := db.Raw(`
+		SELECT c.*, ml.level_name, COALESCE(ml.points_multiplier, 1) as points_multiplier
+		FROM supermarket.customers c
+		LEFT JOIN supermarket.membership_levels ml ON c.membership_level_id = ml.level_id
+		WHERE c.customer_id = $1
+	`, customerID).Scan(&customer)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy khách hàng",
+			"Code":  404,
+		})
+	}
+
+	var entries []loyaltyEntryRow
+	db.Raw(`
+		SELECT lt.*, COALESCE(si.invoice_no, '') as invoice_no,
+			COALESCE(sr.return_no, '') as return_no, COALESCE(e.full_name, '') as employee_name
+		FROM supermarket.loyalty_transactions lt
+		LEFT JOIN supermarket.sales_invoices si ON lt.invoice_id = si.invoice_id
+		LEFT JOIN supermarket.sales_returns sr ON lt.return_id = sr.return_id
+		LEFT JOIN supermarket.employees e ON lt.employee_id = e.employee_id
+		WHERE lt.customer_id = $1
+		ORDER BY lt.created_at DESC, lt.transaction_id DESC
+	`, customerID).Scan(&entries)
+
+	// Open lots, soonest to expire first
+	var lots []loyaltyEntryRow
+	db.Raw(`
+		SELECT lt.*, COALESCE(si.invoice_no, '') as invoice_no
+		FROM supermarket.loyalty_transactions lt
+		LEFT JOIN supermarket.sales_invoices si ON lt.invoice_id = si.invoice_id
+		WHERE lt.customer_id = $1 AND lt.remaining > 0
+		ORDER BY lt.expires_at NULLS LAST, lt.transaction_id
+	`, customerID).Scan(&lots)
+
+	var expiringSoon int
+	db.Raw(`
+		SELECT COALESCE(SUM(remaining), 0) FROM supermarket.loyalty_transactions
+		WHERE customer_id = $1 AND remaining > 0 AND expires_at < CURRENT_DATE + 30
+	`, customerID).Scan(&expiringSoon)
+
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	return c.Render("pages/customers/points", fiber.Map{
+		"Title":           "Sổ điểm khách hàng",
+		"Active":          "customers",
+		"Customer":        customer,
+		"Entries":         entries,
+		"Lots":            lots,
+		"ExpiringSoon":    expiringSoon,
+		"BalanceValue":    pointsValue(customer.LoyaltyPoints),
+		"Settings":        loyaltySettings,
+		"EntryLabels":     loyaltyEntryLabels,
+		"Employees":       employees,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// CustomerPointsAdjust adds or takes points by hand, with a required reason
+func CustomerPointsAdjust(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	customerID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID khách hàng không hợp lệ",
+		})
+	}
+
+	points, err := strconv.Atoi(strings.TrimSpace(c.FormValue("points")))
+	if err != nil || points == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Số điểm điều chỉnh phải là số nguyên khác 0",
+		})
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập lý do điều chỉnh",
+		})
+	}
+
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	entry := &models.LoyaltyTransaction{
+		CustomerID: uint(customerID),
+		EntryType:  models.LoyaltyEntryAdjust,
+		Points:     points,
+		EmployeeID: &employeeID,
+		Notes:      &reason,
+	}
+	if err := postLoyaltyPoints(tx, entry); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể điều chỉnh điểm: " + err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'loyalty_transactions', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypePointsAdjusted,
+		fmt.Sprintf("Điều chỉnh %+d điểm cho khách hàng #%d - Lý do: %s", points, customerID, reason),
+		entry.TransactionID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi nhật ký: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":       true,
+			"points":        points,
+			"balance_after": entry.BalanceAfter,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/customers/%d/points", customerID))
+}
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// tierChangeLabels are the display names of the membership tier history entries
+var tierChangeLabels = map[models.TierChangeType]string{
+	models.TierUpgrade:      "Lên hạng",
+	models.TierDowngrade:    "Xuống hạng",
+	models.TierGraceStarted: "Gia hạn giữ hạng",
+	models.TierGraceCleared: "Đạt lại hạng",
+	models.TierManual:       "Đổi hạng thủ công",
+}
+
+// tierLevel is a membership level as the tier evaluation sees it
+type tierLevel struct {
+	LevelID     uint
+	LevelName   string
+	MinSpending float64
+}
+
+// tierDecision is a change the evaluation makes to a customer's tier
+type tierDecision struct {
+	ChangeType models.TierChangeType
+	To         *tierLevel
+	GraceUntil *time.Time
+	Reason     string
+}
+
+// tierHistoryRow is a tier history entry with the level names
+type tierHistoryRow struct {
+	models.MembershipTierHistory
+	FromLevelName string `json:"from_level_name"`
+	ToLevelName   string `json:"to_level_name"`
+}
+
+// loadTierLevels reads the membership levels, lowest threshold first
+func loadTierLevels(db *gorm.DB) ([]tierLevel, error) {
+	var levels []tierLevel
+	err := db.Raw(`
+		SELECT level_id, level_name, min_spending
+		FROM supermarket.membership_levels
+		ORDER BY min_spending, level_id
+	`).Scan(&levels).Error
+	return levels, err
+}
+
+// qualifyingLevel is the highest level whose threshold the spending reaches, nil if none
+func qualifyingLevel(levels []tierLevel, spending float64) *tierLevel {
+	var best *tierLevel
+	for i := range levels {
+		if levels[i].MinSpending <= spending {
+			best = &levels[i]
+		}
+	}
+	return best
+}
+
+// findTierLevel looks a level up by id, nil when the customer has none
+func findTierLevel(levels []tierLevel, levelID *uint) *tierLevel {
+	if levelID == nil {
+		return nil
+	}
+	for i := range levels {
+		if levels[i].LevelID == *levelID {
+			return &levels[i]
+		}
+	}
+	return nil
+}
+
+// nextTierLevel is the level right above current, nil at the top
+func nextTierLevel(levels []tierLevel, current *tierLevel) *tierLevel {
+	for i := range levels {
+		if current == nil || levels[i].MinSpending > current.MinSpending {
+			return &levels[i]
+		}
+	}
+	return nil
+}
+
+// tierRank orders levels by threshold; no level ranks below all of them
+func tierRank(l *tierLevel) float64 {
+	if l == nil {
+		return -1
+	}
+	return l.MinSpending
+}
+
+func tierLevelName(l *tierLevel) string {
+	if l == nil {
+		return "không hạng"
+	}
+	return l.LevelName
+}
+
+// tierWindowStart is the start of the trailing spending window that ends at t
+func tierWindowStart(t time.Time) time.Time {
+	return t.AddDate(0, -loyaltySettings.TierWindowMonths, 0)
+}
+
+// customerWindowSpending is what a customer spent since the window start: completed sales
+// less the refunds of returns against those sales. A refund of a sale made before the window
+// is not taken off, since that sale's spending was never counted in it.
+func customerWindowSpending(db *gorm.DB, customerID uint, since time.Time) (float64, error) {
+	var spending float64
+	err := db.Raw(`
+		SELECT
+			COALESCE((SELECT SUM(total_amount) FROM supermarket.sales_invoices
+				WHERE customer_id = $1 AND status = $2 AND invoice_date >= $3), 0)
+			- COALESCE((SELECT SUM(sr.refund_amount)
+				FROM supermarket.sales_returns sr
+				JOIN supermarket.sales_invoices si ON sr.invoice_id = si.invoice_id
+				WHERE si.customer_id = $1 AND si.status = $2 AND si.invoice_date >= $3), 0)
+	`, customerID, models.InvoiceCompleted, since).Scan(&spending).Error
+	return spending, err
+}
+
+// decideTier compares a customer's tier with the window spending. Customers who reach a higher
+// tier move up at once. Customers below their tier keep it for the grace period and are then
+// moved down to the tier they do reach; meeting the threshold again clears the grace period.
+// Without allowDowngrade (at checkout) only upgrades and cleared grace periods are decided.
+// It returns nil when nothing changes.
+func decideTier(levels []tierLevel, current *tierLevel, graceUntil *time.Time, spending float64, now time.Time, allowDowngrade bool) *tierDecision {
+	months := loyaltySettings.TierWindowMonths
+	qualified := qualifyingLevel(levels, spending)
+
+	switch {
+	case tierRank(qualified) > tierRank(current):
+		return &tierDecision{
+			ChangeType: models.TierUpgrade,
+			To:         qualified,
+			Reason: fmt.Sprintf("Chi tiêu %d tháng gần nhất %.0f VNĐ đạt mức %.0f VNĐ của hạng %s",
+				months, spending, qualified.MinSpending, qualified.LevelName),
+		}
+
+	case tierRank(qualified) == tierRank(current):
+		if graceUntil == nil {
+			return nil
+		}
+		return &tierDecision{
+			ChangeType: models.TierGraceCleared,
+			To:         current,
+			Reason: fmt.Sprintf("Chi tiêu %d tháng gần nhất %.0f VNĐ đạt lại mức %.0f VNĐ của hạng %s",
+				months, spending, current.MinSpending, current.LevelName),
+		}
+	}
+
+	if !allowDowngrade {
+		return nil
+	}
+
+	below := fmt.Sprintf("Chi tiêu %d tháng gần nhất %.0f VNĐ dưới mức %.0f VNĐ của hạng %s",
+		months, spending, current.MinSpending, current.LevelName)
+	today := now.Truncate(24 * time.Hour)
+
+	if graceUntil == nil && loyaltySettings.TierGraceDays > 0 {
+		until := today.AddDate(0, 0, loyaltySettings.TierGraceDays)
+		return &tierDecision{
+			ChangeType: models.TierGraceStarted,
+			To:         current,
+			GraceUntil: &until,
+			Reason:     fmt.Sprintf("%s, giữ hạng đến %s", below, until.Format("02/01/2006")),
+		}
+	}
+	if graceUntil != nil && !today.After(*graceUntil) {
+		return nil
+	}
+
+	reason := below
+	if graceUntil != nil {
+		reason = fmt.Sprintf("Hết hạn giữ hạng ngày %s: %s", graceUntil.Format("02/01/2006"), below)
+	}
+	return &tierDecision{
+		ChangeType: models.TierDowngrade,
+		To:         qualified,
+		Reason:     fmt.Sprintf("%s, chuyển về %s", reason, tierLevelName(qualified)),
+	}
+}
+
+// writeTierHistory appends an entry to the membership tier history
+func writeTierHistory(tx *gorm.DB, customerID uint, changeType models.TierChangeType, from, to *uint,
+	spending float64, graceUntil *time.Time, reason string) error {
+	return tx.Exec(`
+		INSERT INTO supermarket.membership_tier_history
+		(customer_id, change_type, from_level_id, to_level_id, window_spending, grace_until, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+	`, customerID, changeType, from, to, roundVND(spending), graceUntil, reason).Error
+}
+
+// evaluateCustomerTier re-evaluates one customer's tier on the trailing window inside tx,
+// stores the decision with its history entry and returns it (nil when nothing changed)
+func evaluateCustomerTier(tx *gorm.DB, customerID uint, now time.Time, allowDowngrade bool) (*tierDecision, error) {
+	var customer struct {
+		CustomerID        uint
+		MembershipLevelID *uint
+		TierGraceUntil    *time.Time
+	}
+	err := tx.Raw(`
+		SELECT customer_id, membership_level_id, tier_grace_until
+		FROM supermarket.customers
+		WHERE customer_id = $1
+		FOR UPDATE
+	`, customerID).Scan(&customer).Error
+	if err != nil {
+		return nil, err
+	}
+	if customer.CustomerID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy khách hàng")
+	}
+
+	levels, err := loadTierLevels(tx)
+	if err != nil {
+		return nil, err
+	}
+	spending, err := customerWindowSpending(tx, customerID, tierWindowStart(now))
+	if err != nil {
+		return nil, err
+	}
+
+	current := findTierLevel(levels, customer.MembershipLevelID)
+	decision := decideTier(levels, current, customer.TierGraceUntil, spending, now, allowDowngrade)
+	if decision == nil {
+		err := tx.Exec("UPDATE supermarket.customers SET tier_evaluated_at = $1 WHERE customer_id = $2", now, customerID).Error
+		return nil, err
+	}
+
+	var toLevelID *uint
+	if decision.To != nil {
+		toLevelID = &decision.To.LevelID
+	}
+	err = tx.Exec(`
+		UPDATE supermarket.customers
+		SET membership_level_id = $1, tier_grace_until = $2, tier_evaluated_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE customer_id = $4
+	`, toLevelID, decision.GraceUntil, now, customerID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeTierHistory(tx, customerID, decision.ChangeType, customer.MembershipLevelID, toLevelID,
+		spending, decision.GraceUntil, decision.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+// evaluateMembershipTiers re-evaluates every active customer, each in its own transaction,
+// and returns the number of tier changes recorded
+func evaluateMembershipTiers(db *gorm.DB) (int, error) {
+	var customerIDs []uint
+	err := db.Raw("SELECT customer_id FROM supermarket.customers WHERE is_active = true ORDER BY customer_id").Scan(&customerIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	changes := 0
+	for _, customerID := range customerIDs {
+		tx := db.Begin()
+		decision, err := evaluateCustomerTier(tx, customerID, now, true)
+		if err != nil {
+			tx.Rollback()
+			return changes, fmt.Errorf("khách hàng #%d: %w", customerID, err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return changes, err
+		}
+		if decision != nil {
+			changes++
+		}
+	}
+	return changes, nil
+}
+
+// RunTierEvaluation re-evaluates the membership tiers at startup and then every interval
+// until the process exits
+func RunTierEvaluation(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		changes, err := evaluateMembershipTiers(database.GetDB())
+		if err != nil {
+			log.Printf("Failed to evaluate membership tiers: %v", err)
+		} else if changes > 0 {
+			log.Printf("Recorded %d membership tier change(s)", changes)
+		}
+		<-ticker.C
+	}
+}
+
+// loadTierHistory reads the tier history of a customer, or of all customers when customerID is 0
+func loadTierHistory(db *gorm.DB, customerID uint, limit int) []tierHistoryRow {
+	var rows []tierHistoryRow
+	db.Raw(`
+		SELECT h.*, COALESCE(fl.level_name, '') as from_level_name, COALESCE(tl.level_name, '') as to_level_name
+		FROM supermarket.membership_tier_history h
+		LEFT JOIN supermarket.membership_levels fl ON h.from_level_id = fl.level_id
+		LEFT JOIN supermarket.membership_levels tl ON h.to_level_id = tl.level_id
+		WHERE $1 = 0 OR h.customer_id = $1
+		ORDER BY h.created_at DESC, h.history_id DESC
+		LIMIT $2
+	`, customerID, limit).Scan(&rows)
+	return rows
+}
+
+// customerTierProgress is what CustomerView shows about the tier: the window spending, the
+// next tier and how much is still needed to reach it
+func customerTierProgress(db *gorm.DB, customerID uint, levelID *uint) fiber.Map {
+	levels, _ := loadTierLevels(db)
+	spending, _ := customerWindowSpending(db, customerID, tierWindowStart(time.Now()))
+	current := findTierLevel(levels, levelID)
+
+	progress := fiber.Map{
+		"WindowMonths":   loyaltySettings.TierWindowMonths,
+		"WindowSpending": spending,
+		"Qualified":      tierLevelName(qualifyingLevel(levels, spending)),
+	}
+	if next := nextTierLevel(levels, current); next != nil {
+		percent := 100.0
+		if next.MinSpending > 0 {
+			percent = min(100, max(0, spending/next.MinSpending*100))
+		}
+		progress["NextLevel"] = next.LevelName
+		progress["NextMinSpending"] = next.MinSpending
+		progress["Remaining"] = max(0, next.MinSpending-spending)
+		progress["Percent"] = int(percent)
+	}
+	return progress
+}
+
+// MembershipTierEvaluate runs the tier evaluation for all customers now
+func MembershipTierEvaluate(c *fiber.Ctx) error {
+	changes, err := evaluateMembershipTiers(database.GetDB())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể đánh giá hạng thành viên: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"changes": changes,
+			"message": fmt.Sprintf("Đã đánh giá hạng thành viên, %d thay đổi", changes),
+		})
+	}
+
+	return c.Redirect("/membership-levels")
+}
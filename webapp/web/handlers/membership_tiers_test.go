@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/supermarket/config"
+	"github.com/supermarket/models"
+)
+
+func TestDecideTier(t *testing.T) {
+	withLoyaltySettings(t, config.LoyaltyConfig{
+		RedeemPoints:     1000,
+		RedeemValue:      1,
+		TierWindowMonths: 12,
+		TierGraceDays:    60,
+	})
+
+	levels := []tierLevel{
+		{LevelID: 1, LevelName: "Silver", MinSpending: 1000000},
+		{LevelID: 2, LevelName: "Gold", MinSpending: 5000000},
+	}
+	silver, gold := &levels[0], &levels[1]
+	now := time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC)
+	today := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	graceAhead := today.AddDate(0, 0, 10)
+	graceOver := today.AddDate(0, 0, -1)
+
+	tests := []struct {
+		name           string
+		current        *tierLevel
+		graceUntil     *time.Time
+		spending       float64
+		allowDowngrade bool
+		wantChange     models.TierChangeType
+		wantTo         *tierLevel
+		wantGrace      *time.Time
+	}{
+		{"first tier", nil, nil, 2000000, false, models.TierUpgrade, silver, nil},
+		{"upgrade", silver, nil, 6000000, false, models.TierUpgrade, gold, nil},
+		{"upgrade clears grace", silver, &graceAhead, 6000000, true, models.TierUpgrade, gold, nil},
+		{"stays on tier", silver, nil, 2000000, true, "", nil, nil},
+		{"threshold met again during grace", silver, &graceAhead, 2000000, false, models.TierGraceCleared, silver, nil},
+		{"below tier at checkout", gold, nil, 2000000, false, "", nil, nil},
+		{"below tier starts grace", gold, nil, 2000000, true, models.TierGraceStarted, gold, ptrTime(today.AddDate(0, 0, 60))},
+		{"below tier within grace", gold, &graceAhead, 2000000, true, "", nil, nil},
+		{"grace over downgrades", gold, &graceOver, 2000000, true, models.TierDowngrade, silver, nil},
+		{"grace over below every tier", silver, &graceOver, 500000, true, models.TierDowngrade, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decideTier(levels, tt.current, tt.graceUntil, tt.spending, now, tt.allowDowngrade)
+			if tt.wantChange == "" {
+				if got != nil {
+					t.Fatalf("decideTier = %s to %s, want no change", got.ChangeType, tierLevelName(got.To))
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("decideTier = no change, want %s", tt.wantChange)
+			}
+			if got.ChangeType != tt.wantChange || got.To != tt.wantTo {
+				t.Errorf("decideTier = %s to %s, want %s to %s",
+					got.ChangeType, tierLevelName(got.To), tt.wantChange, tierLevelName(tt.wantTo))
+			}
+			switch {
+			case tt.wantGrace == nil && got.GraceUntil != nil:
+				t.Errorf("grace until %v, want none", *got.GraceUntil)
+			case tt.wantGrace != nil && (got.GraceUntil == nil || !got.GraceUntil.Equal(*tt.wantGrace)):
+				t.Errorf("grace until %v, want %v", got.GraceUntil, *tt.wantGrace)
+			}
+		})
+	}
+}
+
+func TestDecideTierWithoutGracePeriod(t *testing.T) {
+	withLoyaltySettings(t, config.LoyaltyConfig{RedeemPoints: 1000, RedeemValue: 1, TierWindowMonths: 12})
+
+	levels := []tierLevel{{LevelID: 1, LevelName: "Silver", MinSpending: 1000000}}
+	got := decideTier(levels, &levels[0], nil, 0, time.Now(), true)
+	if got == nil || got.ChangeType != models.TierDowngrade || got.To != nil {
+		t.Fatalf("decideTier = %+v, want a downgrade to no tier", got)
+	}
+}
+
+func ptrTime(t time.Time) *time.Time { return &t }
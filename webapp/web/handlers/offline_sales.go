@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxOfflineBatch is the most sales one upload may carry
+	maxOfflineBatch = 500
+	// offlineClockSkew is how far ahead of the server clock a till may stamp a sale
+	offlineClockSkew = 5 * time.Minute
+)
+
+// Outcomes of one uploaded offline sale
+const (
+	offlineSaleCreated   = "CREATED"
+	offlineSaleDuplicate = "DUPLICATE"
+	offlineSaleRejected  = "REJECTED"
+)
+
+var clientUUIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// stockShortageLabels are the display names of the shortage statuses
+var stockShortageLabels = map[models.StockShortageStatus]string{
+	models.StockShortageOpen:      "Chưa xử lý",
+	models.StockShortageSettled:   "Đã trừ tồn",
+	models.StockShortageDismissed: "Đã bỏ qua",
+	models.StockShortageCancelled: "Đã hủy theo hóa đơn",
+}
+
+// offlineSaleLine is one product line of an uploaded sale. UnitPrice is the list price the
+// till charged; it is checked against the price in effect when the sale was rung up, and only
+// a different price is an override that needs the sale's manager approval.
+type offlineSaleLine struct {
+	ProductID uint     `json:"product_id"`
+	Quantity  int      `json:"quantity"`
+	UnitPrice *float64 `json:"unit_price"`
+}
+
+// offlineSale is a sale rung up by a till while it was disconnected
+type offlineSale struct {
+	ClientUUID string     `json:"client_uuid"`
+	SoldAt     *time.Time `json:"sold_at"`
+	EmployeeID uint       `json:"employee_id"`
+	// SessionID is the till session the sale was rung up in, which may be closed by upload time
+	SessionID          *uint  `json:"session_id"`
+	CustomerID         *uint  `json:"customer_id"`
+	PointsUsed         int    `json:"points_used"`
+	OverrideApprovedBy *uint  `json:"override_approved_by"`
+	OverridePIN        string `json:"override_pin"`
+	OverrideReason     string `json:"override_reason"`
+	// The till's clearances of the sale rules of restricted products
+	AgeVerified          bool              `json:"age_verified"`
+	OverrideRestrictions bool              `json:"override_restrictions"`
+	Notes                string            `json:"notes"`
+	Items                []offlineSaleLine `json:"items"`
+	Tenders              []posTender       `json:"tenders"`
+}
+
+// offlineSaleShortage is a line the shelf could not cover when the sale was booked
+type offlineSaleShortage struct {
+	ProductID   uint   `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+}
+
+// offlineSaleResult is what happened to one uploaded sale
+type offlineSaleResult struct {
+	ClientUUID  string                `json:"client_uuid"`
+	Status      string                `json:"status"`
+	InvoiceID   uint                  `json:"invoice_id,omitempty"`
+	InvoiceNo   string                `json:"invoice_no,omitempty"`
+	TotalAmount float64               `json:"total_amount,omitempty"`
+	Shortages   []offlineSaleShortage `json:"shortages,omitempty"`
+	Error       string                `json:"error,omitempty"`
+	// Violations are the sale rules a rejected sale broke
+	Violations []saleRestrictionViolation `json:"violations,omitempty"`
+}
+
+// stockShortageRow is a stock shortage with its invoice, product and shelf for the exception report
+type stockShortageRow struct {
+	models.StockShortage
+	InvoiceNo    string    `json:"invoice_no"`
+	SoldAt       time.Time `json:"sold_at"`
+	ProductCode  string    `json:"product_code"`
+	ProductName  string    `json:"product_name"`
+	ShelfName    string    `json:"shelf_name"`
+	OnShelf      int       `json:"on_shelf"`
+	ResolverName string    `json:"resolver_name"`
+}
+
+// findOfflineSale returns the invoice already booked for a client UUID, if any
+func findOfflineSale(db *gorm.DB, clientUUID string) (*offlineSaleResult, error) {
+	var existing struct {
+		InvoiceID   uint
+		InvoiceNo   string
+		TotalAmount float64
+	}
+	err := db.Raw(`
+		SELECT invoice_id, invoice_no, total_amount
+		FROM supermarket.sales_invoices
+		WHERE client_uuid = $1
+	`, clientUUID).Scan(&existing).Error
+	if err != nil || existing.InvoiceID == 0 {
+		return nil, err
+	}
+	return &offlineSaleResult{
+		ClientUUID:  clientUUID,
+		Status:      offlineSaleDuplicate,
+		InvoiceID:   existing.InvoiceID,
+		InvoiceNo:   existing.InvoiceNo,
+		TotalAmount: existing.TotalAmount,
+	}, nil
+}
+
+// validateOfflineSale checks an uploaded sale before anything is booked
+func validateOfflineSale(sale *offlineSale, now time.Time) error {
+	if !clientUUIDPattern.MatchString(sale.ClientUUID) {
+		return fmt.Errorf("Mã UUID của hóa đơn không hợp lệ")
+	}
+	if sale.SoldAt == nil || sale.SoldAt.IsZero() {
+		return fmt.Errorf("Thiếu thời điểm bán hàng")
+	}
+	if sale.SoldAt.After(now.Add(offlineClockSkew)) {
+		return fmt.Errorf("Thời điểm bán hàng %s nằm trong tương lai", sale.SoldAt.Format("02/01/2006 15:04"))
+	}
+	if sale.EmployeeID == 0 {
+		return fmt.Errorf("Vui lòng chọn nhân viên bán hàng")
+	}
+	if sale.SessionID == nil || *sale.SessionID == 0 {
+		return fmt.Errorf("Thiếu ca thu ngân của hóa đơn")
+	}
+	if sale.PointsUsed < 0 {
+		return fmt.Errorf("Số điểm sử dụng không hợp lệ")
+	}
+	if len(sale.Items) == 0 {
+		return fmt.Errorf("Vui lòng chọn ít nhất một sản phẩm")
+	}
+	for _, item := range sale.Items {
+		if item.ProductID == 0 || item.Quantity <= 0 {
+			return fmt.Errorf("Số lượng không hợp lệ cho sản phẩm #%d", item.ProductID)
+		}
+		if item.UnitPrice != nil && *item.UnitPrice <= 0 {
+			return fmt.Errorf("Giá đơn vị không hợp lệ cho sản phẩm #%d", item.ProductID)
+		}
+	}
+	return nil
+}
+
+// resolveOfflineSession returns the till session an offline sale was rung up in. The till may
+// have closed it before it came back online, so a closed session is accepted as long as the
+// sale falls within it, allowing for the till clock's skew.
+func resolveOfflineSession(tx *gorm.DB, sessionIDStr string, soldAt time.Time) (uint, error) {
+	sessionID, err := strconv.ParseUint(sessionIDStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ID ca thu ngân không hợp lệ")
+	}
+
+	var session models.RegisterSession
+	err = tx.Raw(`
+		SELECT * FROM supermarket.register_sessions
+		WHERE session_id = $1
+		FOR SHARE
+	`, sessionID).Scan(&session).Error
+	if err != nil {
+		return 0, err
+	}
+	if session.SessionID == 0 {
+		return 0, fmt.Errorf("Không tìm thấy ca thu ngân")
+	}
+
+	if soldAt.Before(session.OpenedAt.Add(-offlineClockSkew)) ||
+		(session.ClosedAt != nil && soldAt.After(session.ClosedAt.Add(offlineClockSkew))) {
+		return 0, fmt.Errorf("Thời điểm bán hàng %s nằm ngoài ca thu ngân %s", soldAt.Format("02/01/2006 15:04"), session.RegisterCode)
+	}
+
+	return session.SessionID, nil
+}
+
+// ingestOfflineSale books one uploaded sale in its own transaction. A UUID that was already
+// booked returns the existing invoice; stock the shelf no longer has is recorded as a shortage
+// instead of rejecting a sale that has already happened.
+func ingestOfflineSale(db *gorm.DB, sale offlineSale, now time.Time) (result offlineSaleResult) {
+	sale.ClientUUID = strings.ToLower(strings.TrimSpace(sale.ClientUUID))
+	result = offlineSaleResult{ClientUUID: sale.ClientUUID, Status: offlineSaleRejected}
+
+	if err := validateOfflineSale(&sale, now); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if existing, err := findOfflineSale(db, sale.ClientUUID); err != nil {
+		result.Error = "Không thể kiểm tra hóa đơn trùng: " + err.Error()
+		return result
+	} else if existing != nil {
+		return *existing
+	}
+
+	tenders, err := posTenderRequests(sale.Tenders)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	saleReq := &saleRequest{
+		CustomerID:           sale.CustomerID,
+		PointsUsed:           sale.PointsUsed,
+		OverrideApprovedBy:   sale.OverrideApprovedBy,
+		OverridePIN:          sale.OverridePIN,
+		OverrideReason:       strings.TrimSpace(sale.OverrideReason),
+		AgeVerified:          sale.AgeVerified,
+		OverrideRestrictions: sale.OverrideRestrictions,
+		ClientUUID:           &sale.ClientUUID,
+		SoldAt:               sale.SoldAt,
+		AllowShortage:        true,
+	}
+	for _, item := range sale.Items {
+		saleReq.Lines = append(saleReq.Lines, saleRequestLine{
+			ProductID:     item.ProductID,
+			Quantity:      item.Quantity,
+			OverridePrice: item.UnitPrice,
+		})
+	}
+
+	sessionIDStr := strconv.FormatUint(uint64(*sale.SessionID), 10)
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			result.Status = offlineSaleRejected
+			result.Error = fmt.Sprintf("Lỗi không xác định: %v", r)
+		}
+	}()
+
+	// Let the stock trigger record what the shelf cannot cover for this sale only
+	if err := tx.Exec("SET LOCAL supermarket.allow_stock_shortage = 'on'").Error; err != nil {
+		tx.Rollback()
+		result.Error = "Không thể khởi tạo giao dịch: " + err.Error()
+		return result
+	}
+
+	booked, _, err := createSaleInvoice(tx, sale.EmployeeID, sessionIDStr, sale.Notes, saleReq, tenders)
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		// A concurrent upload of the same sale wins the unique index; report its invoice
+		if existing, _ := findOfflineSale(db, sale.ClientUUID); existing != nil {
+			return *existing
+		}
+		result.Error = err.Error()
+		var restrictionErr *saleRestrictionError
+		if errors.As(err, &restrictionErr) {
+			result.Violations = restrictionErr.Violations
+		}
+		return result
+	}
+
+	result.Status = offlineSaleCreated
+	result.InvoiceID = booked.InvoiceID
+	result.InvoiceNo = booked.InvoiceNo
+	result.TotalAmount = booked.Quote.TotalAmount
+	for _, line := range booked.Quote.Lines {
+		if line.Shortage > 0 {
+			result.Shortages = append(result.Shortages, offlineSaleShortage{
+				ProductID:   line.ProductID,
+				ProductName: line.ProductName,
+				Quantity:    line.Shortage,
+			})
+		}
+	}
+	return result
+}
+
+// OfflineSalesIngest books a batch of sales uploaded by a till that was offline. Every sale
+// carries the till's UUID and the time it was rung up; sales are booked one by one so one bad
+// sale does not hold back the others, and uploading the same batch again is safe.
+func OfflineSalesIngest(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var body struct {
+		Sales []offlineSale `json:"sales"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dữ liệu không hợp lệ",
+		})
+	}
+	if len(body.Sales) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không có hóa đơn nào để đồng bộ",
+		})
+	}
+	if len(body.Sales) > maxOfflineBatch {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Mỗi lần đồng bộ tối đa %d hóa đơn", maxOfflineBatch),
+		})
+	}
+
+	now := time.Now()
+	results := make([]offlineSaleResult, 0, len(body.Sales))
+	counts := map[string]int{}
+	shortages := 0
+	for _, sale := range body.Sales {
+		result := ingestOfflineSale(db, sale, now)
+		counts[result.Status]++
+		shortages += len(result.Shortages)
+		results = append(results, result)
+	}
+
+	if counts[offlineSaleCreated] > 0 {
+		err := db.Exec(`
+			INSERT INTO supermarket.activity_logs (activity_type, description, table_name, created_at)
+			VALUES ($1, $2, 'sales_invoices', CURRENT_TIMESTAMP)
+		`, models.ActivityTypeOfflineSalesSynced,
+			fmt.Sprintf("Đồng bộ %d hóa đơn offline: %d mới, %d trùng, %d lỗi, %d dòng thiếu hàng",
+				len(body.Sales), counts[offlineSaleCreated], counts[offlineSaleDuplicate], counts[offlineSaleRejected], shortages)).Error
+		if err != nil {
+			log.Printf("Failed to log offline sales upload: %v", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success":    counts[offlineSaleRejected] == 0,
+		"created":    counts[offlineSaleCreated],
+		"duplicates": counts[offlineSaleDuplicate],
+		"rejected":   counts[offlineSaleRejected],
+		"shortages":  shortages,
+		"results":    results,
+	})
+}
+
+// lockStockShortage loads an open stock shortage for update
+func lockStockShortage(tx *gorm.DB, shortageID uint) (*models.StockShortage, int, error) {
+	var shortage models.StockShortage
+	err := tx.Raw(`
+		SELECT * FROM supermarket.stock_shortages
+		WHERE shortage_id = $1
+		FOR UPDATE
+	`, shortageID).Scan(&shortage).Error
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, err
+	}
+	if shortage.ShortageID == 0 {
+		return nil, fiber.StatusNotFound, fmt.Errorf("Không tìm thấy dòng thiếu hàng")
+	}
+	if shortage.Status != models.StockShortageOpen {
+		return nil, fiber.StatusBadRequest, fmt.Errorf("Dòng thiếu hàng đã được xử lý")
+	}
+	return &shortage, fiber.StatusOK, nil
+}
+
+// settleStockShortage takes the outstanding units of a shortage out of the shelf stock now
+// available, first expiry first like a sale, and allocates them to the invoice line so a
+// later void puts them back. It returns the units settled; what the shelf still cannot cover
+// stays open.
+func settleStockShortage(tx *gorm.DB, shortage *models.StockShortage, employeeID uint) (int, error) {
+	var batches []struct {
+		ShelfBatchID    uint
+		ShelfID         uint
+		BatchCode       string
+		Quantity        int
+		ExpiryDate      *time.Time
+		CurrentPrice    float64
+		DiscountPercent float64
+	}
+	err := tx.Raw(`
+		SELECT shelf_batch_id, shelf_id, batch_code, quantity, expiry_date, current_price,
+		       COALESCE(discount_percent, 0) as discount_percent
+		FROM supermarket.shelf_batch_inventory
+		WHERE product_id = $1
+		  AND quantity > 0
+		  AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+		ORDER BY expiry_date ASC NULLS LAST, stocked_date ASC, shelf_batch_id ASC
+		FOR UPDATE
+	`, shortage.ProductID).Scan(&batches).Error
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := shortage.Outstanding()
+	shelves := map[uint]bool{}
+	for _, b := range batches {
+		if remaining <= 0 {
+			break
+		}
+		take := min(b.Quantity, remaining)
+
+		err := tx.Exec(`
+			UPDATE supermarket.shelf_batch_inventory
+			SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP
+			WHERE shelf_batch_id = $2
+		`, take, b.ShelfBatchID).Error
+		if err != nil {
+			return 0, err
+		}
+
+		err = tx.Exec(`
+			INSERT INTO supermarket.sales_invoice_allocations
+			(detail_id, shelf_id, shelf_batch_id, batch_code, quantity, expiry_date, batch_price, discount_percent, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+		`, shortage.DetailID, b.ShelfID, b.ShelfBatchID, b.BatchCode, take, b.ExpiryDate, b.CurrentPrice, b.DiscountPercent).Error
+		if err != nil {
+			return 0, err
+		}
+
+		shelves[b.ShelfID] = true
+		remaining -= take
+	}
+
+	settled := shortage.Outstanding() - remaining
+	if settled == 0 {
+		return 0, nil
+	}
+
+	for shelfID := range shelves {
+		if err := syncShelfInventorySummary(tx, shelfID, shortage.ProductID); err != nil {
+			return 0, err
+		}
+	}
+
+	status := models.StockShortageOpen
+	var resolvedBy *uint
+	if remaining == 0 {
+		status = models.StockShortageSettled
+		resolvedBy = &employeeID
+	}
+	err = tx.Exec(`
+		UPDATE supermarket.stock_shortages
+		SET settled_quantity = settled_quantity + $1, status = $2, resolved_by = $3,
+		    resolved_at = CASE WHEN $2 = 'OPEN' THEN NULL ELSE CURRENT_TIMESTAMP END
+		WHERE shortage_id = $4
+	`, settled, status, resolvedBy, shortage.ShortageID).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+		VALUES ($1, $2, 'stock_shortages', $3, $4, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeStockShortageResolved,
+		fmt.Sprintf("Trừ tồn %d/%d sản phẩm #%d thiếu khi bán offline (hóa đơn #%d)",
+			settled, shortage.Outstanding(), shortage.ProductID, shortage.InvoiceID),
+		shortage.ShortageID, employeeID).Error
+	return settled, err
+}
+
+// parseShortageID reads the :id route parameter
+func parseShortageID(c *fiber.Ctx) (uint, error) {
+	shortageID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ID dòng thiếu hàng không hợp lệ")
+	}
+	return uint(shortageID), nil
+}
+
+// StockShortageList is the exception report of offline sale lines that were booked without
+// shelf stock
+func StockShortageList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	status := strings.ToUpper(c.Query("status", string(models.StockShortageOpen)))
+	if _, ok := stockShortageLabels[models.StockShortageStatus(status)]; !ok {
+		status = ""
+	}
+
+	var rows []stockShortageRow
+	db.Raw(`
+		SELECT s.*, si.invoice_no, si.invoice_date as sold_at, p.product_code, p.product_name,
+		       COALESCE(ds.shelf_name, '') as shelf_name, COALESCE(e.full_name, '') as resolver_name,
+		       COALESCE((SELECT SUM(b.quantity) FROM supermarket.shelf_batch_inventory b
+		                 WHERE b.product_id = s.product_id AND b.quantity > 0
+		                   AND (b.expiry_date IS NULL OR b.expiry_date >= CURRENT_DATE)), 0) as on_shelf
+		FROM supermarket.stock_shortages s
+		JOIN supermarket.sales_invoices si ON s.invoice_id = si.invoice_id
+		JOIN supermarket.products p ON s.product_id = p.product_id
+		LEFT JOIN supermarket.display_shelves ds ON s.shelf_id = ds.shelf_id
+		LEFT JOIN supermarket.employees e ON s.resolved_by = e.employee_id
+		WHERE $1 = '' OR s.status = $1
+		ORDER BY s.created_at DESC, s.shortage_id DESC
+		LIMIT 500
+	`, status).Scan(&rows)
+
+	var summary struct {
+		OpenLines    int
+		OpenUnits    int
+		OpenProducts int
+	}
+	db.Raw(`
+		SELECT COUNT(*) as open_lines, COALESCE(SUM(quantity - settled_quantity), 0) as open_units,
+		       COUNT(DISTINCT product_id) as open_products
+		FROM supermarket.stock_shortages
+		WHERE status = $1
+	`, models.StockShortageOpen).Scan(&summary)
+
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	return c.Render("pages/sales/shortages", fiber.Map{
+		"Title":           "Thiếu hàng khi bán offline",
+		"Active":          "sales",
+		"Shortages":       rows,
+		"Summary":         summary,
+		"Status":          status,
+		"StatusLabels":    stockShortageLabels,
+		"Employees":       employees,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// StockShortageSettle takes an open shortage out of the shelf stock available now
+func StockShortageSettle(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	shortageID, err := parseShortageID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	shortage, status, err := lockStockShortage(tx, shortageID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	settled, err := settleStockShortage(tx, shortage, employeeID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể trừ tồn cho dòng thiếu hàng: " + err.Error(),
+		})
+	}
+	if settled == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Trên quầy chưa có hàng để trừ cho dòng thiếu hàng này",
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":     true,
+			"settled":     settled,
+			"outstanding": shortage.Outstanding() - settled,
+			"message":     fmt.Sprintf("Đã trừ tồn %d sản phẩm", settled),
+		})
+	}
+
+	return c.Redirect("/sales/shortages")
+}
+
+// StockShortageSettleAll settles every open shortage as far as the shelf stock allows,
+// oldest first
+func StockShortageSettleAll(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var shortageIDs []uint
+	db.Raw(`
+		SELECT shortage_id FROM supermarket.stock_shortages
+		WHERE status = $1
+		ORDER BY created_at, shortage_id
+	`, models.StockShortageOpen).Scan(&shortageIDs)
+
+	settledLines, settledUnits := 0, 0
+	for _, shortageID := range shortageIDs {
+		tx := db.Begin()
+		shortage, _, err := lockStockShortage(tx, shortageID)
+		if err != nil {
+			// Resolved by someone else in the meantime
+			tx.Rollback()
+			continue
+		}
+		settled, err := settleStockShortage(tx, shortage, employeeID)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Không thể trừ tồn cho dòng thiếu hàng #%d: %v", shortageID, err),
+			})
+		}
+		if err := tx.Commit().Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+			})
+		}
+		if settled > 0 {
+			settledLines++
+			settledUnits += settled
+		}
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"lines":   settledLines,
+			"units":   settledUnits,
+			"message": fmt.Sprintf("Đã trừ tồn %d sản phẩm cho %d dòng thiếu hàng", settledUnits, settledLines),
+		})
+	}
+
+	return c.Redirect("/sales/shortages")
+}
+
+// StockShortageDismiss closes an open shortage without touching stock, e.g. when a stock
+// count already corrected the shelf
+func StockShortageDismiss(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	shortageID, err := parseShortageID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Vui lòng nhập lý do bỏ qua"})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	shortage, status, err := lockStockShortage(tx, shortageID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.stock_shortages
+		SET status = $1, resolved_by = $2, resolved_at = CURRENT_TIMESTAMP, resolution_notes = $3
+		WHERE shortage_id = $4
+	`, models.StockShortageDismissed, employeeID, reason, shortage.ShortageID).Error
+	if err == nil {
+		err = tx.Exec(`
+			INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+			VALUES ($1, $2, 'stock_shortages', $3, $4, CURRENT_TIMESTAMP)
+		`, models.ActivityTypeStockShortageResolved,
+			fmt.Sprintf("Bỏ qua %d sản phẩm #%d thiếu khi bán offline (hóa đơn #%d) - Lý do: %s",
+				shortage.Outstanding(), shortage.ProductID, shortage.InvoiceID, reason),
+			shortage.ShortageID, employeeID).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể bỏ qua dòng thiếu hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Đã bỏ qua dòng thiếu hàng",
+		})
+	}
+
+	return c.Redirect("/sales/shortages")
+}
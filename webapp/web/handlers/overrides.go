@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// approvalPINIterations is the PBKDF2 work factor of the stored approval PINs
+const approvalPINIterations = 100000
+
+var approvalPINPattern = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+// overrideTypeLabels are the display names of the override types
+var overrideTypeLabels = map[models.OverrideType]string{
+	models.OverridePrice:    "Đổi giá",
+	models.OverrideDiscount: "Giảm giá thiện chí",
+}
+
+// overrideApprover is the employee who approves the overrides of a sale, with the limits of
+// their position
+type overrideApprover struct {
+	EmployeeID      uint
+	FullName        string
+	IsActive        bool
+	ApprovalPINHash *string
+	models.Position
+}
+
+// hashApprovalPIN salts and hashes an approval PIN for storage
+func hashApprovalPIN(pin string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, pin, salt, approvalPINIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", approvalPINIterations, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+// checkApprovalPIN compares a PIN with a hash made by hashApprovalPIN
+func checkApprovalPIN(stored, pin string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, pin, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// approvalPINFormValue reads the approval PIN fields of the employee form. It returns nil
+// when the PIN is left as it is, and an empty string when it is to be removed.
+func approvalPINFormValue(c *fiber.Ctx) (*string, error) {
+	if c.FormValue("clear_approval_pin") == "on" {
+		empty := ""
+		return &empty, nil
+	}
+	pin := strings.TrimSpace(c.FormValue("approval_pin"))
+	if pin == "" {
+		return nil, nil
+	}
+	if !approvalPINPattern.MatchString(pin) {
+		return nil, fmt.Errorf("Mã PIN phê duyệt phải gồm 4 đến 8 chữ số")
+	}
+	hash, err := hashApprovalPIN(pin)
+	if err != nil {
+		return nil, fmt.Errorf("Không thể lưu mã PIN phê duyệt: %v", err)
+	}
+	return &hash, nil
+}
+
+// loadOverrideApprover loads the approver chosen for a sale's overrides
+func loadOverrideApprover(tx *gorm.DB, employeeID uint) (*overrideApprover, error) {
+	var approver overrideApprover
+	err := tx.Raw(`
+		SELECT e.employee_id, e.full_name, e.is_active, e.approval_pin_hash,
+		       p.position_id, p.position_code, p.position_name, p.override_max_percent, p.override_max_amount
+		FROM supermarket.employees e
+		JOIN supermarket.positions p ON e.position_id = p.position_id
+		WHERE e.employee_id = $1
+	`, employeeID).Scan(&approver).Error
+	if err != nil || approver.EmployeeID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy quản lý phê duyệt")
+	}
+	if !approver.IsActive || !approver.Position.CanApprovePriceOverride() {
+		return nil, fmt.Errorf("Nhân viên %s không có quyền phê duyệt thay đổi giá", approver.FullName)
+	}
+	return &approver, nil
+}
+
+// overrideLimitText describes the override limits of a position
+func overrideLimitText(p *models.Position) string {
+	var limits []string
+	if p.OverrideMaxPercent != nil {
+		limits = append(limits, fmt.Sprintf("%g%%", *p.OverrideMaxPercent))
+	}
+	if p.OverrideMaxAmount != nil {
+		limits = append(limits, fmt.Sprintf("%.0f VNĐ mỗi dòng", *p.OverrideMaxAmount))
+	}
+	return strings.Join(limits, ", ")
+}
+
+// checkOverrideLimit checks the reduction an override gives on a line against the approver's
+// position; raising a price is never limited
+func checkOverrideLimit(approver *overrideApprover, line *pricedLine) error {
+	reduction := line.ListPrice - line.UnitPrice
+	if reduction <= 0 || line.ListPrice <= 0 {
+		return nil
+	}
+	percent := reduction / line.ListPrice * 100
+	amount := roundVND(reduction * float64(line.Quantity))
+	if approver.Position.WithinOverrideLimit(percent, amount) {
+		return nil
+	}
+	return fmt.Errorf("Giảm %.1f%% (%.0f VNĐ) cho sản phẩm %s vượt hạn mức phê duyệt của %s (%s: %s)",
+		percent, amount, line.ProductName, approver.FullName, approver.PositionName, overrideLimitText(&approver.Position))
+}
+
+// authorizeOverrides verifies the approver's PIN and the reason before a sale with overridden
+// lines is booked
+func authorizeOverrides(tx *gorm.DB, req *saleRequest, quote *priceQuote) error {
+	overridden := false
+	for _, line := range quote.Lines {
+		overridden = overridden || line.Overridden
+	}
+	if !overridden {
+		return nil
+	}
+	_, err := verifyOverrideApprover(tx, req)
+	return err
+}
+
+// verifyOverrideApprover checks the approver, reason and PIN a sale carries for anything a
+// manager has to approve. Refused PINs are logged outside the sale's transaction so they
+// survive its rollback.
+func verifyOverrideApprover(tx *gorm.DB, req *saleRequest) (*overrideApprover, error) {
+	if req.OverrideApprovedBy == nil {
+		return nil, fmt.Errorf("Vui lòng chọn quản lý phê duyệt")
+	}
+	if strings.TrimSpace(req.OverrideReason) == "" {
+		return nil, fmt.Errorf("Vui lòng nhập lý do điều chỉnh")
+	}
+
+	approver, err := loadOverrideApprover(tx, *req.OverrideApprovedBy)
+	if err != nil {
+		return nil, err
+	}
+	if approver.ApprovalPINHash == nil || !checkApprovalPIN(*approver.ApprovalPINHash, req.OverridePIN) {
+		err := database.GetDB().Exec(`
+			INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+			VALUES ($1, $2, 'employees', $3, $3, CURRENT_TIMESTAMP)
+		`, models.ActivityTypeOverrideDenied,
+			fmt.Sprintf("Sai mã PIN phê duyệt của %s", approver.FullName), approver.EmployeeID).Error
+		if err != nil {
+			log.Printf("Failed to log refused override approval: %v", err)
+		}
+		return nil, fmt.Errorf("Mã PIN phê duyệt của %s không đúng", approver.FullName)
+	}
+	return approver, nil
+}
+
+// OverrideReport lists the approved price overrides and goodwill discounts of one day, with
+// the totals per approver
+func OverrideReport(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	date := c.Query("date", time.Now().Format("2006-01-02"))
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		date = time.Now().Format("2006-01-02")
+	}
+
+	var lines []struct {
+		DetailID       uint                 `json:"detail_id"`
+		InvoiceID      uint                 `json:"invoice_id"`
+		InvoiceNo      string               `json:"invoice_no"`
+		InvoiceDate    time.Time            `json:"invoice_date"`
+		Status         models.InvoiceStatus `json:"status"`
+		CashierName    string               `json:"cashier_name"`
+		ApproverName   string               `json:"approver_name"`
+		ProductCode    string               `json:"product_code"`
+		ProductName    string               `json:"product_name"`
+		OverrideType   models.OverrideType  `json:"override_type"`
+		OverrideReason string               `json:"override_reason"`
+		Quantity       int                  `json:"quantity"`
+		OriginalPrice  float64              `json:"original_price"`
+		UnitPrice      float64              `json:"unit_price"`
+		Reduction      float64              `json:"reduction"`
+		ReductionPct   float64              `json:"reduction_pct"`
+	}
+	err := db.Raw(`
+		SELECT sid.detail_id, si.invoice_id, si.invoice_no, si.invoice_date, si.status,
+		       cashier.full_name as cashier_name, approver.full_name as approver_name,
+		       p.product_code, p.product_name,
+		       COALESCE(sid.override_type, $2) as override_type, COALESCE(sid.override_reason, '') as override_reason,
+		       sid.quantity, COALESCE(sid.list_price, sid.unit_price) as original_price, sid.unit_price,
+		       (COALESCE(sid.list_price, sid.unit_price) - sid.unit_price) * sid.quantity as reduction,
+		       CASE WHEN COALESCE(sid.list_price, 0) > 0
+		            THEN (sid.list_price - sid.unit_price) / sid.list_price * 100 ELSE 0 END as reduction_pct
+		FROM supermarket.sales_invoice_details sid
+		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+		JOIN supermarket.products p ON sid.product_id = p.product_id
+		JOIN supermarket.employees cashier ON si.employee_id = cashier.employee_id
+		JOIN supermarket.employees approver ON sid.override_approved_by = approver.employee_id
+		WHERE sid.override_approved_by IS NOT NULL
+		  AND DATE(si.invoice_date) = $1
+		ORDER BY si.invoice_date, sid.detail_id
+	`, date, models.OverridePrice).Scan(&lines).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải báo cáo điều chỉnh giá: " + err.Error(),
+		})
+	}
+
+	type approverTotal struct {
+		ApproverName string  `json:"approver_name"`
+		LineCount    int     `json:"line_count"`
+		Reduction    float64 `json:"reduction"`
+	}
+	var byApprover []approverTotal
+	index := map[string]int{}
+	var totalReduction float64
+	for _, l := range lines {
+		// Voided sales keep their overrides on record but gave nothing away
+		if l.Status != models.InvoiceCompleted {
+			continue
+		}
+		i, ok := index[l.ApproverName]
+		if !ok {
+			i = len(byApprover)
+			index[l.ApproverName] = i
+			byApprover = append(byApprover, approverTotal{ApproverName: l.ApproverName})
+		}
+		byApprover[i].LineCount++
+		byApprover[i].Reduction += l.Reduction
+		totalReduction += l.Reduction
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"date":            date,
+			"lines":           lines,
+			"by_approver":     byApprover,
+			"total_reduction": totalReduction,
+		})
+	}
+
+	return c.Render("pages/reports/overrides", fiber.Map{
+		"Title":           "Điều chỉnh giá trong ngày",
+		"Active":          "reports",
+		"Date":            date,
+		"Lines":           lines,
+		"ByApprover":      byApprover,
+		"TotalReduction":  totalReduction,
+		"TypeLabels":      overrideTypeLabels,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+)
+
+// SalesPark sets the basket of the sales form aside as a parked cart. A basket that was
+// resumed from a cart (cart_id) is parked again under the same cart.
+func SalesPark(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	employeeID, err := strconv.ParseUint(c.FormValue("employee_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên bán hàng",
+		})
+	}
+
+	label := strings.TrimSpace(c.FormValue("parked_label"))
+	if label == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập nhãn cho giỏ hàng tạm giữ",
+		})
+	}
+
+	saleReq, err := parseSaleRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var cartID uint
+	if cartIDStr := c.FormValue("cart_id"); cartIDStr != "" {
+		id, err := strconv.ParseUint(cartIDStr, 10, 64)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "ID giỏ hàng không hợp lệ",
+			})
+		}
+		if _, status, err := lockOpenCart(tx, uint(id)); err != nil {
+			tx.Rollback()
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		cartID = uint(id)
+
+		// The form holds the whole basket, so it replaces the stored lines
+		err = tx.Exec(`
+			UPDATE supermarket.pos_carts
+			SET employee_id = $1, customer_id = $2, points_used = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE cart_id = $4
+		`, employeeID, saleReq.CustomerID, saleReq.PointsUsed, cartID).Error
+		if err == nil {
+			err = tx.Exec("DELETE FROM supermarket.pos_cart_items WHERE cart_id = $1", cartID).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể cập nhật giỏ hàng: " + err.Error(),
+			})
+		}
+	} else {
+		var sessionID *uint
+		if sid, err := resolveRegisterSession(tx, c.FormValue("session_id"), uint(employeeID)); err == nil {
+			sessionID = &sid
+		}
+		err = tx.Raw(`
+			INSERT INTO supermarket.pos_carts
+			(employee_id, session_id, customer_id, points_used, status, notes, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			RETURNING cart_id
+		`, employeeID, sessionID, saleReq.CustomerID, saleReq.PointsUsed, models.PosCartOpen,
+			nullIfEmpty(c.FormValue("notes"))).Scan(&cartID).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể tạo giỏ hàng: " + err.Error(),
+			})
+		}
+	}
+
+	for _, line := range saleReq.Lines {
+		err = tx.Exec(`
+			INSERT INTO supermarket.pos_cart_items (cart_id, product_id, quantity, override_price, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (cart_id, product_id) DO UPDATE
+			SET quantity = pos_cart_items.quantity + EXCLUDED.quantity, updated_at = CURRENT_TIMESTAMP
+		`, cartID, line.ProductID, line.Quantity, line.OverridePrice).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể lưu sản phẩm vào giỏ: " + err.Error(),
+			})
+		}
+	}
+
+	expiresAt, status, err := parkCart(tx, cartID, label, uint(employeeID))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":    true,
+			"cart_id":    cartID,
+			"expires_at": expiresAt,
+			"message":    "Đã tạm giữ giỏ hàng",
+		})
+	}
+
+	return c.Redirect("/sales/parked")
+}
+
+// SalesParkedList displays the parked carts that can still be resumed
+func SalesParkedList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	if _, err := expireParkedCarts(db); err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể cập nhật giỏ hàng hết hạn: " + err.Error(),
+		})
+	}
+
+	var carts []struct {
+		CartID         uint      `json:"cart_id"`
+		ParkedLabel    string    `json:"parked_label"`
+		ParkedByName   string    `json:"parked_by_name"`
+		CustomerName   *string   `json:"customer_name"`
+		ParkedAt       time.Time `json:"parked_at"`
+		ExpiresAt      time.Time `json:"expires_at"`
+		LineCount      int       `json:"line_count"`
+		ItemCount      int       `json:"item_count"`
+		EstimatedTotal float64   `json:"estimated_total"`
+	}
+	err := db.Raw(`
+		SELECT pc.cart_id, pc.parked_label, e.full_name as parked_by_name, cu.full_name as customer_name,
+			pc.parked_at, pc.expires_at,
+			COUNT(pci.item_id) as line_count,
+			COALESCE(SUM(pci.quantity), 0) as item_count,
+			COALESCE(SUM(pci.quantity * COALESCE(pci.override_price, p.selling_price)), 0) as estimated_total
+		FROM supermarket.pos_carts pc
+		LEFT JOIN supermarket.employees e ON pc.parked_by = e.employee_id
+		LEFT JOIN supermarket.customers cu ON pc.customer_id = cu.customer_id
+		LEFT JOIN supermarket.pos_cart_items pci ON pc.cart_id = pci.cart_id
+		LEFT JOIN supermarket.products p ON pci.product_id = p.product_id
+		WHERE pc.status = $1
+		GROUP BY pc.cart_id, e.full_name, cu.full_name
+		ORDER BY pc.parked_at
+	`, models.PosCartParked).Scan(&carts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách giỏ hàng tạm giữ: " + err.Error(),
+		})
+	}
+
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	return c.Render("pages/sales/parked", fiber.Map{
+		"Title":           "Giỏ hàng tạm giữ",
+		"Active":          "sales",
+		"Carts":           carts,
+		"Employees":       employees,
+		"ParkedCartTTL":   posSettings.ParkedCartTTL.String(),
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// SalesParkedResume picks a parked cart up at this checkout and reopens it in the sales form
+func SalesParkedResume(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	employeeID, err := strconv.ParseUint(c.FormValue("employee_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên bán hàng",
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if status, err := resumeCart(tx, cartID, uint(employeeID), c.FormValue("session_id")); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"cart_id": cartID,
+			"message": "Đã mở lại giỏ hàng",
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/sales/new?cart_id=%d", cartID))
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// tenderRequest is one tender submitted at checkout. A nil Amount means "the rest of the bill".
+type tenderRequest struct {
+	Method    models.PaymentMethod
+	Amount    *float64
+	Reference *string
+}
+
+// tenderSettlement is the result of applying the tenders to an invoice total
+type tenderSettlement struct {
+	Payments           []models.SalesInvoicePayment `json:"payments"`
+	AmountDue          float64                      `json:"amount_due"`
+	RoundingAdjustment float64                      `json:"rounding_adjustment"`
+	TenderedTotal      float64                      `json:"tendered_total"`
+	ChangeAmount       float64                      `json:"change_amount"`
+	HeaderMethod       models.PaymentMethod         `json:"payment_method"`
+}
+
+// roundCashVND rounds a cash amount to the nearest CashRoundingUnit
+func roundCashVND(amount float64) float64 {
+	return math.Round(amount/models.CashRoundingUnit) * models.CashRoundingUnit
+}
+
+// isTenderMethod checks if the method can be used as a tender
+func isTenderMethod(method models.PaymentMethod) bool {
+	switch method {
+	case models.PaymentCash, models.PaymentCard, models.PaymentTransfer, models.PaymentVoucher:
+		return true
+	}
+	return false
+}
+
+// parseTenders reads tender_methods, tender_amounts and tender_references (comma separated).
+// Forms that only send payment_method are treated as a single tender for the whole bill.
+func parseTenders(c *fiber.Ctx) ([]tenderRequest, error) {
+	methods := c.FormValue("tender_methods")
+	if methods == "" {
+		method := models.PaymentMethod(c.FormValue("payment_method"))
+		if method == "" {
+			method = models.PaymentCash
+		}
+		if !isTenderMethod(method) {
+			return nil, fmt.Errorf("Phương thức thanh toán không hợp lệ: %s", method)
+		}
+		return []tenderRequest{{Method: method}}, nil
+	}
+
+	// Empty entries are meaningful here (no amount / no reference), so keep positions
+	methodList := strings.Split(methods, ",")
+	amountList := strings.Split(c.FormValue("tender_amounts"), ",")
+	referenceList := strings.Split(c.FormValue("tender_references"), ",")
+
+	tenders := make([]tenderRequest, 0, len(methodList))
+	for i, m := range methodList {
+		method := models.PaymentMethod(strings.ToUpper(strings.TrimSpace(m)))
+		if !isTenderMethod(method) {
+			return nil, fmt.Errorf("Phương thức thanh toán không hợp lệ: %s", m)
+		}
+		tender := tenderRequest{Method: method}
+
+		if i < len(amountList) && strings.TrimSpace(amountList[i]) != "" {
+			amount, err := strconv.ParseFloat(strings.TrimSpace(amountList[i]), 64)
+			if err != nil || amount < 0 {
+				return nil, fmt.Errorf("Số tiền thanh toán không hợp lệ: %s", amountList[i])
+			}
+			tender.Amount = &amount
+		}
+		if i < len(referenceList) && strings.TrimSpace(referenceList[i]) != "" {
+			ref := strings.TrimSpace(referenceList[i])
+			tender.Reference = &ref
+		}
+		tenders = append(tenders, tender)
+	}
+
+	return tenders, nil
+}
+
+// settleTenders applies the tenders to an invoice total. Card, transfer and voucher tenders
+// can never exceed what is left to pay; only cash gives change. When cash is used, the cash
+// part of the bill is rounded to CashRoundingUnit and the difference is the rounding adjustment.
+func settleTenders(total float64, tenders []tenderRequest) (*tenderSettlement, error) {
+	if len(tenders) == 0 {
+		return nil, fmt.Errorf("Vui lòng nhập ít nhất một hình thức thanh toán")
+	}
+
+	var nonCash, cashTendered float64
+	hasCash := false
+	open := -1
+	for i, t := range tenders {
+		if t.Method == models.PaymentCash {
+			hasCash = true
+		}
+		if t.Amount == nil {
+			if open >= 0 {
+				return nil, fmt.Errorf("Chỉ được để trống số tiền cho một hình thức thanh toán")
+			}
+			open = i
+			continue
+		}
+		if t.Method == models.PaymentCash {
+			cashTendered += *t.Amount
+		} else {
+			nonCash += *t.Amount
+		}
+	}
+
+	// The open tender takes whatever is still owed; for cash that is the rounded amount
+	if open >= 0 {
+		var rest float64
+		if tenders[open].Method == models.PaymentCash {
+			rest = math.Max(roundCashVND(total-nonCash)-cashTendered, 0)
+			cashTendered += rest
+		} else {
+			rest = math.Max(roundVND(total-nonCash-cashTendered), 0)
+			nonCash += rest
+		}
+		rest = roundVND(rest)
+		tenders[open].Amount = &rest
+	}
+
+	if nonCash > total+0.005 {
+		return nil, fmt.Errorf("Thanh toán không dùng tiền mặt (%.0f) vượt quá số tiền cần thanh toán (%.0f)", nonCash, total)
+	}
+
+	result := &tenderSettlement{AmountDue: roundVND(total)}
+	cashDue := roundVND(total - nonCash)
+	if hasCash {
+		roundedCashDue := roundCashVND(cashDue)
+		result.RoundingAdjustment = roundVND(roundedCashDue - cashDue)
+		cashDue = roundedCashDue
+		result.AmountDue = roundVND(total + result.RoundingAdjustment)
+	}
+
+	if !hasCash && nonCash+0.005 < total {
+		return nil, fmt.Errorf("Số tiền thanh toán chưa đủ. Còn thiếu %.0f VND", total-nonCash)
+	}
+	if hasCash && cashTendered+0.005 < cashDue {
+		return nil, fmt.Errorf("Số tiền khách đưa chưa đủ. Còn thiếu %.0f VND", cashDue-cashTendered)
+	}
+
+	result.TenderedTotal = roundVND(nonCash + cashTendered)
+	result.ChangeAmount = roundVND(math.Max(cashTendered-cashDue, 0))
+
+	// Cash tenders are applied to the cash due in order; the change is handed back from the last one
+	remainingCash := cashDue
+	lastCash := -1
+	methods := make(map[models.PaymentMethod]bool)
+	for _, t := range tenders {
+		payment := models.SalesInvoicePayment{
+			PaymentMethod:  t.Method,
+			TenderedAmount: roundVND(*t.Amount),
+			Amount:         roundVND(*t.Amount),
+			Reference:      t.Reference,
+		}
+		if t.Method == models.PaymentCash {
+			payment.Amount = roundVND(math.Min(*t.Amount, remainingCash))
+			remainingCash = roundVND(remainingCash - payment.Amount)
+			lastCash = len(result.Payments)
+		}
+		methods[t.Method] = true
+		result.Payments = append(result.Payments, payment)
+	}
+	if lastCash >= 0 {
+		result.Payments[lastCash].ChangeAmount = result.ChangeAmount
+	}
+
+	result.HeaderMethod = models.PaymentMixed
+	if len(methods) == 1 {
+		result.HeaderMethod = tenders[0].Method
+	}
+
+	return result, nil
+}
+
+// recordInvoicePayments settles the tenders against the invoice total computed by the
+// totals trigger and stores one sales_invoice_payments row per tender. Voucher tenders
+// carry the voucher code as their reference and are redeemed in the same transaction.
+func recordInvoicePayments(tx *gorm.DB, invoiceID uint, tenders []tenderRequest) (*tenderSettlement, error) {
+	var invoice struct {
+		TotalAmount float64
+		EmployeeID  uint
+	}
+	err := tx.Raw("SELECT total_amount, employee_id FROM supermarket.sales_invoices WHERE invoice_id = $1", invoiceID).Scan(&invoice).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tenders {
+		if t.Method == models.PaymentVoucher && t.Reference == nil {
+			return nil, fmt.Errorf("Vui lòng nhập mã voucher cho hình thức thanh toán voucher")
+		}
+	}
+
+	settlement, err := settleTenders(invoice.TotalAmount, tenders)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range settlement.Payments {
+		p := &settlement.Payments[i]
+		p.InvoiceID = invoiceID
+		err := tx.Raw(`
+			INSERT INTO supermarket.sales_invoice_payments
+			(invoice_id, payment_method, amount, tendered_amount, change_amount, reference, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+			RETURNING payment_id
+		`, invoiceID, p.PaymentMethod, p.Amount, p.TenderedAmount, p.ChangeAmount, p.Reference).Scan(&p.PaymentID).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if p.PaymentMethod == models.PaymentVoucher && p.Amount > 0 {
+			voucherID, err := redeemVoucher(tx, *p.Reference, p.Amount, invoiceID, p.PaymentID, invoice.EmployeeID)
+			if err != nil {
+				return nil, err
+			}
+			p.VoucherID = &voucherID
+		}
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.sales_invoices
+		SET payment_method = $1, rounding_adjustment = $2, change_amount = $3
+		WHERE invoice_id = $4
+	`, settlement.HeaderMethod, settlement.RoundingAdjustment, settlement.ChangeAmount, invoiceID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return settlement, nil
+}
+
+// loadInvoicePayments returns the tenders recorded for an invoice
+func loadInvoicePayments(db *gorm.DB, invoiceID uint64) []models.SalesInvoicePayment {
+	var payments []models.SalesInvoicePayment
+	db.Raw(`
+		SELECT * FROM supermarket.sales_invoice_payments
+		WHERE invoice_id = $1
+		ORDER BY payment_id
+	`, invoiceID).Scan(&payments)
+	return payments
+}
@@ -0,0 +1,1123 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/config"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// posCartItemView is a cart line with the product and its live shelf availability
+type posCartItemView struct {
+	ItemID        uint                 `json:"item_id"`
+	ProductID     uint                 `json:"product_id"`
+	ProductCode   string               `json:"product_code"`
+	ProductName   string               `json:"product_name"`
+	Barcode       *string              `json:"barcode,omitempty"`
+	Quantity      int                  `json:"quantity"`
+	OverridePrice *float64             `json:"override_price,omitempty"`
+	ScannedCode   *string              `json:"scanned_code,omitempty"`
+	Availability  *productAvailability `json:"availability"`
+	Short         bool                 `json:"short"`
+}
+
+// posCartCustomer is the customer attached to a cart
+type posCartCustomer struct {
+	CustomerID       uint    `json:"customer_id"`
+	FullName         *string `json:"full_name"`
+	Phone            *string `json:"phone"`
+	MembershipCardNo *string `json:"membership_card_no"`
+	LoyaltyPoints    int     `json:"loyalty_points"`
+	LevelName        *string `json:"level_name"`
+}
+
+// posCartView is the full state of a cart returned by every POS endpoint
+type posCartView struct {
+	Cart       models.PosCart    `json:"cart"`
+	Customer   *posCartCustomer  `json:"customer"`
+	Items      []posCartItemView `json:"items"`
+	ItemCount  int               `json:"item_count"`
+	Quote      *priceQuote       `json:"quote"`
+	QuoteError string            `json:"quote_error,omitempty"`
+}
+
+// posTender is one tender of a POS finalize request
+type posTender struct {
+	Method    string   `json:"method"`
+	Amount    *float64 `json:"amount"`
+	Reference string   `json:"reference"`
+}
+
+// posTenderRequests validates the tenders of a JSON sale; no tenders means the whole bill
+// is paid in cash
+func posTenderRequests(body []posTender) ([]tenderRequest, error) {
+	if len(body) == 0 {
+		return []tenderRequest{{Method: models.PaymentCash}}, nil
+	}
+
+	tenders := make([]tenderRequest, 0, len(body))
+	for _, t := range body {
+		method := models.PaymentMethod(strings.ToUpper(strings.TrimSpace(t.Method)))
+		if !isTenderMethod(method) {
+			return nil, fmt.Errorf("Phương thức thanh toán không hợp lệ: %s", t.Method)
+		}
+		if t.Amount != nil && *t.Amount < 0 {
+			return nil, fmt.Errorf("Số tiền thanh toán không hợp lệ: %.0f", *t.Amount)
+		}
+		tenders = append(tenders, tenderRequest{
+			Method:    method,
+			Amount:    t.Amount,
+			Reference: nullIfEmpty(strings.TrimSpace(t.Reference)),
+		})
+	}
+	return tenders, nil
+}
+
+// parseCartID reads the :id route parameter
+func parseCartID(c *fiber.Ctx) (uint, error) {
+	cartID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ID giỏ hàng không hợp lệ")
+	}
+	return uint(cartID), nil
+}
+
+// posSettings is the POS configuration, set at startup by ConfigurePOS
+var posSettings = config.POSConfig{ParkedCartTTL: 2 * time.Hour}
+
+// ConfigurePOS applies the POS configuration loaded at startup
+func ConfigurePOS(cfg config.POSConfig) {
+	if cfg.ParkedCartTTL > 0 {
+		posSettings = cfg
+	}
+}
+
+// RunParkedCartExpiry expires abandoned parked carts every interval until the process exits
+func RunParkedCartExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := expireParkedCarts(database.GetDB())
+		if err != nil {
+			log.Printf("Failed to expire parked carts: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d parked cart(s)", expired)
+		}
+	}
+}
+
+// lockCart locks a cart row for an update
+func lockCart(tx *gorm.DB, cartID uint) (*models.PosCart, int, error) {
+	var cart models.PosCart
+	err := tx.Raw(`
+		SELECT * FROM supermarket.pos_carts
+		WHERE cart_id = $1
+		FOR UPDATE
+	`, cartID).Scan(&cart).Error
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, err
+	}
+	if cart.CartID == 0 {
+		return nil, fiber.StatusNotFound, fmt.Errorf("Không tìm thấy giỏ hàng")
+	}
+	return &cart, fiber.StatusOK, nil
+}
+
+// lockOpenCart locks a cart for an update and checks it can still be edited
+func lockOpenCart(tx *gorm.DB, cartID uint) (*models.PosCart, int, error) {
+	cart, status, err := lockCart(tx, cartID)
+	if err != nil {
+		return nil, status, err
+	}
+	if !cart.IsEditable() {
+		return nil, fiber.StatusConflict, fmt.Errorf("Giỏ hàng đã ở trạng thái %s, không thể thay đổi", cart.Status)
+	}
+	return cart, fiber.StatusOK, nil
+}
+
+// expireParkedCarts marks parked carts past their expiry as EXPIRED. Nothing was deducted
+// for a parked cart, so expiring it only releases the basket.
+func expireParkedCarts(db *gorm.DB) (int64, error) {
+	result := db.Exec(`
+		UPDATE supermarket.pos_carts
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE status = $2 AND expires_at < CURRENT_TIMESTAMP
+	`, models.PosCartExpired, models.PosCartParked)
+	return result.RowsAffected, result.Error
+}
+
+// parkCart sets an open cart aside under a label; it expires after the configured TTL
+func parkCart(tx *gorm.DB, cartID uint, label string, parkedBy uint) (time.Time, int, error) {
+	if _, status, err := lockOpenCart(tx, cartID); err != nil {
+		return time.Time{}, status, err
+	}
+
+	var itemCount int64
+	tx.Raw("SELECT COUNT(*) FROM supermarket.pos_cart_items WHERE cart_id = $1", cartID).Scan(&itemCount)
+	if itemCount == 0 {
+		return time.Time{}, fiber.StatusBadRequest, fmt.Errorf("Giỏ hàng đang trống, không cần tạm giữ")
+	}
+
+	expiresAt := time.Now().Add(posSettings.ParkedCartTTL)
+	err := tx.Exec(`
+		UPDATE supermarket.pos_carts
+		SET status = $1, parked_label = $2, parked_by = $3, parked_at = CURRENT_TIMESTAMP,
+			expires_at = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE cart_id = $5
+	`, models.PosCartParked, label, parkedBy, expiresAt, cartID).Error
+	if err != nil {
+		return time.Time{}, fiber.StatusInternalServerError, fmt.Errorf("Không thể tạm giữ giỏ hàng: %v", err)
+	}
+	return expiresAt, fiber.StatusOK, nil
+}
+
+// resumeCart picks a parked cart up at a checkout: the resuming employee takes it over,
+// on the requested till or their own open one, and it becomes editable again
+func resumeCart(tx *gorm.DB, cartID, employeeID uint, sessionIDStr string) (int, error) {
+	cart, status, err := lockCart(tx, cartID)
+	if err != nil {
+		return status, err
+	}
+	if !cart.IsResumable(time.Now()) {
+		if cart.Status == models.PosCartParked {
+			return fiber.StatusGone, fmt.Errorf("Giỏ hàng tạm giữ đã hết hạn")
+		}
+		return fiber.StatusConflict, fmt.Errorf("Giỏ hàng không ở trạng thái tạm giữ (%s)", cart.Status)
+	}
+
+	var sessionID *uint
+	if sid, err := resolveRegisterSession(tx, sessionIDStr, employeeID); err == nil {
+		sessionID = &sid
+	} else if sessionIDStr != "" {
+		return fiber.StatusBadRequest, err
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.pos_carts
+		SET status = $1, employee_id = $2, session_id = $3, expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE cart_id = $4
+	`, models.PosCartOpen, employeeID, sessionID, cartID).Error
+	if err != nil {
+		return fiber.StatusInternalServerError, fmt.Errorf("Không thể mở lại giỏ hàng: %v", err)
+	}
+	return fiber.StatusOK, nil
+}
+
+// completeCart records the invoice a cart was finalized into
+func completeCart(tx *gorm.DB, cartID uint, result *saleResult) error {
+	return tx.Exec(`
+		UPDATE supermarket.pos_carts
+		SET status = $1, invoice_id = $2, session_id = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE cart_id = $4
+	`, models.PosCartCompleted, result.InvoiceID, result.SessionID, cartID).Error
+}
+
+// touchCart marks a cart as changed so other terminals can see it moved
+func touchCart(tx *gorm.DB, cartID uint) error {
+	return tx.Exec("UPDATE supermarket.pos_carts SET updated_at = CURRENT_TIMESTAMP WHERE cart_id = $1", cartID).Error
+}
+
+// cartSaleRequest turns the cart lines into the request the pricing engine understands
+func cartSaleRequest(cart *models.PosCart, items []posCartItemView) *saleRequest {
+	req := &saleRequest{
+		CustomerID: cart.CustomerID,
+		PointsUsed: cart.PointsUsed,
+	}
+	for _, item := range items {
+		req.Lines = append(req.Lines, saleRequestLine{
+			ProductID:     item.ProductID,
+			Quantity:      item.Quantity,
+			OverridePrice: item.OverridePrice,
+		})
+	}
+	return req
+}
+
+// loadPosCart reads a cart with its customer, lines, live availability and a price quote
+func loadPosCart(db *gorm.DB, cartID uint) (*posCartView, error) {
+	view := &posCartView{}
+	if err := db.Raw("SELECT * FROM supermarket.pos_carts WHERE cart_id = $1", cartID).Scan(&view.Cart).Error; err != nil {
+		return nil, err
+	}
+	if view.Cart.CartID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy giỏ hàng")
+	}
+
+	if view.Cart.CustomerID != nil {
+		var customer posCartCustomer
+		db.Raw(`
+			SELECT c.customer_id, c.full_name, c.phone, c.membership_card_no, c.loyalty_points, ml.level_name
+			FROM supermarket.customers c
+			LEFT JOIN supermarket.membership_levels ml ON c.membership_level_id = ml.level_id
+			WHERE c.customer_id = $1
+		`, *view.Cart.CustomerID).Scan(&customer)
+		if customer.CustomerID != 0 {
+			view.Customer = &customer
+		}
+	}
+
+	err := db.Raw(`
+		SELECT pci.item_id, pci.product_id, p.product_code, p.product_name, p.barcode,
+			pci.quantity, pci.override_price, pci.scanned_code
+		FROM supermarket.pos_cart_items pci
+		JOIN supermarket.products p ON pci.product_id = p.product_id
+		WHERE pci.cart_id = $1
+		ORDER BY pci.item_id
+	`, cartID).Scan(&view.Items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range view.Items {
+		item := &view.Items[i]
+		availability, err := loadProductAvailability(db, uint64(item.ProductID))
+		if err != nil {
+			return nil, err
+		}
+		item.Availability = availability
+		item.Short = int64(item.Quantity) > availability.ShelfQuantity
+		view.ItemCount += item.Quantity
+	}
+
+	// Quote only while the sale is still being built; a finalized cart points at its invoice
+	if view.Cart.IsEditable() && len(view.Items) > 0 {
+		quote, err := priceSale(db, cartSaleRequest(&view.Cart, view.Items), false)
+		if err != nil {
+			view.QuoteError = err.Error()
+		} else {
+			view.Quote = quote
+		}
+	}
+
+	return view, nil
+}
+
+// respondPosCart sends the current state of a cart
+func respondPosCart(c *fiber.Ctx, db *gorm.DB, cartID uint, extra fiber.Map) error {
+	view, err := loadPosCart(db, cartID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tải giỏ hàng: " + err.Error(),
+		})
+	}
+
+	response := fiber.Map{
+		"success":     true,
+		"cart":        view.Cart,
+		"customer":    view.Customer,
+		"items":       view.Items,
+		"item_count":  view.ItemCount,
+		"quote":       view.Quote,
+		"quote_error": view.QuoteError,
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+	return c.JSON(response)
+}
+
+// PosCartList lists carts, open ones by default, so another terminal can pick one up
+func PosCartList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	if _, err := expireParkedCarts(db); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật giỏ hàng hết hạn: " + err.Error(),
+		})
+	}
+
+	status := strings.ToUpper(c.Query("status", string(models.PosCartOpen)))
+	query := `
+		SELECT pc.cart_id, pc.employee_id, e.full_name as employee_name, pc.session_id,
+			pc.customer_id, cu.full_name as customer_name, pc.status, pc.invoice_id,
+			pc.parked_label, pc.parked_at, pc.expires_at, pc.created_at, pc.updated_at,
+			COALESCE(SUM(pci.quantity), 0) as item_count
+		FROM supermarket.pos_carts pc
+		JOIN supermarket.employees e ON pc.employee_id = e.employee_id
+		LEFT JOIN supermarket.customers cu ON pc.customer_id = cu.customer_id
+		LEFT JOIN supermarket.pos_cart_items pci ON pc.cart_id = pci.cart_id
+		WHERE pc.status = $1
+	`
+	args := []interface{}{status}
+	if employeeID := c.Query("employee_id"); employeeID != "" {
+		query += " AND pc.employee_id = $2"
+		args = append(args, employeeID)
+	}
+	query += `
+		GROUP BY pc.cart_id, e.full_name, cu.full_name
+		ORDER BY pc.updated_at DESC
+		LIMIT 100
+	`
+
+	var carts []struct {
+		CartID       uint       `json:"cart_id"`
+		EmployeeID   uint       `json:"employee_id"`
+		EmployeeName string     `json:"employee_name"`
+		SessionID    *uint      `json:"session_id"`
+		CustomerID   *uint      `json:"customer_id"`
+		CustomerName *string    `json:"customer_name"`
+		Status       string     `json:"status"`
+		InvoiceID    *uint      `json:"invoice_id"`
+		ParkedLabel  *string    `json:"parked_label"`
+		ParkedAt     *time.Time `json:"parked_at"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		CreatedAt    time.Time  `json:"created_at"`
+		UpdatedAt    time.Time  `json:"updated_at"`
+		ItemCount    int        `json:"item_count"`
+	}
+	if err := db.Raw(query, args...).Scan(&carts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tải danh sách giỏ hàng: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"carts": carts,
+		"total": len(carts),
+	})
+}
+
+// PosCartCreate opens an empty cart for an employee, on their open till session if they have one
+func PosCartCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var body struct {
+		EmployeeID uint    `json:"employee_id"`
+		SessionID  *uint   `json:"session_id"`
+		Notes      *string `json:"notes"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.EmployeeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên bán hàng",
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var employee models.Employee
+	tx.Raw("SELECT employee_id, is_active FROM supermarket.employees WHERE employee_id = $1", body.EmployeeID).Scan(&employee)
+	if employee.EmployeeID == 0 || !employee.IsActive {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Nhân viên không tồn tại hoặc đã nghỉ việc",
+		})
+	}
+
+	// An explicitly chosen till must be open; otherwise the employee's own open till is used if any
+	var sessionID *uint
+	sessionIDStr := ""
+	if body.SessionID != nil {
+		sessionIDStr = strconv.FormatUint(uint64(*body.SessionID), 10)
+	}
+	if sid, err := resolveRegisterSession(tx, sessionIDStr, body.EmployeeID); err == nil {
+		sessionID = &sid
+	} else if body.SessionID != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var cartID uint
+	err := tx.Raw(`
+		INSERT INTO supermarket.pos_carts (employee_id, session_id, status, notes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING cart_id
+	`, body.EmployeeID, sessionID, models.PosCartOpen, body.Notes).Scan(&cartID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tạo giỏ hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	c.Status(fiber.StatusCreated)
+	return respondPosCart(c, db, cartID, nil)
+}
+
+// PosCartGet returns a cart with live availability and pricing; any terminal can resume it
+func PosCartGet(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var exists int64
+	db.Raw("SELECT COUNT(*) FROM supermarket.pos_carts WHERE cart_id = $1", cartID).Scan(&exists)
+	if exists == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy giỏ hàng",
+		})
+	}
+
+	return respondPosCart(c, db, cartID, nil)
+}
+
+// PosCartScan adds a product to the cart by barcode or product code
+func PosCartScan(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var body struct {
+		Code     string `json:"code"`
+		Quantity int    `json:"quantity"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dữ liệu không hợp lệ",
+		})
+	}
+	code := strings.TrimSpace(body.Code)
+	if code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập mã vạch hoặc mã sản phẩm",
+		})
+	}
+	if body.Quantity == 0 {
+		body.Quantity = 1
+	}
+	if body.Quantity < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Số lượng không hợp lệ",
+		})
+	}
+
+	// A barcode match wins over a product code match
+	var product struct {
+		ProductID   uint
+		ProductCode string
+		ProductName string
+		IsActive    bool
+	}
+	err = db.Raw(`
+		SELECT product_id, product_code, product_name, is_active
+		FROM supermarket.products
+		WHERE barcode = $1 OR product_code = $1
+		ORDER BY (barcode = $1) DESC NULLS LAST
+		LIMIT 1
+	`, code).Scan(&product).Error
+	if err != nil || product.ProductID == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Không tìm thấy sản phẩm với mã %s", code),
+		})
+	}
+	if !product.IsActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Sản phẩm %s đã ngừng kinh doanh", product.ProductName),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, status, err := lockOpenCart(tx, cartID); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.pos_cart_items (cart_id, product_id, quantity, scanned_code, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (cart_id, product_id) DO UPDATE
+		SET quantity = pos_cart_items.quantity + EXCLUDED.quantity,
+			scanned_code = EXCLUDED.scanned_code,
+			updated_at = CURRENT_TIMESTAMP
+	`, cartID, product.ProductID, body.Quantity, code).Error
+	if err == nil {
+		err = touchCart(tx, cartID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể thêm sản phẩm vào giỏ: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, fiber.Map{
+		"scanned_product_id": product.ProductID,
+	})
+}
+
+// PosCartUpdateItem sets the quantity (0 removes the line) and optional override price of a line
+func PosCartUpdateItem(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	productID, err := strconv.ParseUint(c.Params("productId"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID sản phẩm không hợp lệ",
+		})
+	}
+
+	var body struct {
+		Quantity      int      `json:"quantity"`
+		OverridePrice *float64 `json:"override_price"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Quantity < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Số lượng không hợp lệ",
+		})
+	}
+	if body.OverridePrice != nil && *body.OverridePrice <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Giá đơn vị không hợp lệ",
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, status, err := lockOpenCart(tx, cartID); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var result *gorm.DB
+	if body.Quantity == 0 {
+		result = tx.Exec("DELETE FROM supermarket.pos_cart_items WHERE cart_id = $1 AND product_id = $2", cartID, productID)
+	} else {
+		result = tx.Exec(`
+			UPDATE supermarket.pos_cart_items
+			SET quantity = $1, override_price = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE cart_id = $3 AND product_id = $4
+		`, body.Quantity, body.OverridePrice, cartID, productID)
+	}
+	if result.Error != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật giỏ hàng: " + result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Sản phẩm không có trong giỏ hàng",
+		})
+	}
+	if err := touchCart(tx, cartID); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật giỏ hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, nil)
+}
+
+// PosCartRemoveItem removes a product line from the cart
+func PosCartRemoveItem(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, status, err := lockOpenCart(tx, cartID); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = tx.Exec("DELETE FROM supermarket.pos_cart_items WHERE cart_id = $1 AND product_id = $2", cartID, c.Params("productId")).Error
+	if err == nil {
+		err = touchCart(tx, cartID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật giỏ hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, nil)
+}
+
+// PosCartAttachCustomer attaches a customer found by phone or membership card number
+func PosCartAttachCustomer(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var body struct {
+		Phone            string `json:"phone"`
+		MembershipCardNo string `json:"membership_card_no"`
+		PointsUsed       int    `json:"points_used"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.PointsUsed < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dữ liệu không hợp lệ",
+		})
+	}
+
+	var customer struct {
+		CustomerID    uint
+		LoyaltyPoints int
+	}
+	switch {
+	case strings.TrimSpace(body.MembershipCardNo) != "":
+		db.Raw("SELECT customer_id, loyalty_points FROM supermarket.customers WHERE membership_card_no = $1",
+			strings.TrimSpace(body.MembershipCardNo)).Scan(&customer)
+	case strings.TrimSpace(body.Phone) != "":
+		db.Raw("SELECT customer_id, loyalty_points FROM supermarket.customers WHERE phone = $1",
+			strings.TrimSpace(body.Phone)).Scan(&customer)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập số điện thoại hoặc số thẻ thành viên",
+		})
+	}
+	if customer.CustomerID == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy khách hàng",
+		})
+	}
+	if body.PointsUsed > customer.LoyaltyPoints {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Điểm sử dụng vượt quá số điểm hiện có",
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, status, err := lockOpenCart(tx, cartID); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.pos_carts
+		SET customer_id = $1, points_used = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE cart_id = $3
+	`, customer.CustomerID, body.PointsUsed, cartID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể gắn khách hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, nil)
+}
+
+// PosCartDetachCustomer turns the cart back into a walk-in sale
+func PosCartDetachCustomer(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, status, err := lockOpenCart(tx, cartID); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.pos_carts
+		SET customer_id = NULL, points_used = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE cart_id = $1
+	`, cartID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể bỏ khách hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, nil)
+}
+
+// PosCartCancel abandons an open or parked cart; nothing was deducted so there is nothing to put back
+func PosCartCancel(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	cart, status, err := lockCart(tx, cartID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if cart.Status != models.PosCartOpen && cart.Status != models.PosCartParked {
+		tx.Rollback()
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Giỏ hàng đã ở trạng thái %s, không thể hủy", cart.Status),
+		})
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.pos_carts
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE cart_id = $2
+	`, models.PosCartCancelled, cartID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hủy giỏ hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"cart_id": cartID,
+		"message": "Đã hủy giỏ hàng",
+	})
+}
+
+// PosCartFinalize turns the cart into a sales invoice through the same path as SalesCreate
+func PosCartFinalize(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var body struct {
+		SessionID          *uint  `json:"session_id"`
+		OverrideApprovedBy *uint  `json:"override_approved_by"`
+		OverridePIN        string `json:"override_pin"`
+		OverrideReason     string `json:"override_reason"`
+		// Clears the sale rules of restricted products, see enforceSaleRestrictions
+		AgeVerified          bool        `json:"age_verified"`
+		OverrideRestrictions bool        `json:"override_restrictions"`
+		Notes                string      `json:"notes"`
+		Tenders              []posTender `json:"tenders"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dữ liệu không hợp lệ",
+		})
+	}
+
+	tenders, err := posTenderRequests(body.Tenders)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	cart, status, err := lockOpenCart(tx, cartID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var items []posCartItemView
+	err = tx.Raw(`
+		SELECT item_id, product_id, quantity, override_price
+		FROM supermarket.pos_cart_items
+		WHERE cart_id = $1
+		ORDER BY item_id
+	`, cartID).Scan(&items).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tải giỏ hàng: " + err.Error(),
+		})
+	}
+	if len(items) == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Giỏ hàng đang trống",
+		})
+	}
+
+	saleReq := cartSaleRequest(cart, items)
+	saleReq.OverrideApprovedBy = body.OverrideApprovedBy
+	saleReq.OverridePIN = body.OverridePIN
+	saleReq.OverrideReason = strings.TrimSpace(body.OverrideReason)
+	saleReq.AgeVerified = body.AgeVerified
+	saleReq.OverrideRestrictions = body.OverrideRestrictions
+
+	sessionIDStr := ""
+	if body.SessionID != nil {
+		sessionIDStr = strconv.FormatUint(uint64(*body.SessionID), 10)
+	} else if cart.SessionID != nil {
+		sessionIDStr = strconv.FormatUint(uint64(*cart.SessionID), 10)
+	}
+
+	notes := body.Notes
+	if notes == "" && cart.Notes != nil {
+		notes = *cart.Notes
+	}
+
+	result, status, err := createSaleInvoice(tx, cart.EmployeeID, sessionIDStr, notes, saleReq, tenders)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(saleErrorJSON(err))
+	}
+
+	if err := completeCart(tx, cartID, result); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể đóng giỏ hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"cart_id":    cartID,
+		"invoice_id": result.InvoiceID,
+		"invoice_no": result.InvoiceNo,
+		"session_id": result.SessionID,
+		"pricing":    result.Quote,
+		"payment":    result.Settlement,
+		"message":    "Tạo hóa đơn thành công",
+	})
+}
+
+// PosCartPark suspends an open cart under a label so any checkout can resume it later
+func PosCartPark(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var body struct {
+		Label      string `json:"label"`
+		EmployeeID uint   `json:"employee_id"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dữ liệu không hợp lệ",
+		})
+	}
+	label := strings.TrimSpace(body.Label)
+	if label == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập nhãn cho giỏ hàng tạm giữ",
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// The employee parking defaults to the one working the cart
+	parkedBy := body.EmployeeID
+	if parkedBy == 0 {
+		tx.Raw("SELECT employee_id FROM supermarket.pos_carts WHERE cart_id = $1", cartID).Scan(&parkedBy)
+	}
+
+	expiresAt, status, err := parkCart(tx, cartID, label, parkedBy)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, fiber.Map{
+		"expires_at": expiresAt,
+		"message":    "Đã tạm giữ giỏ hàng",
+	})
+}
+
+// PosCartResume picks a parked cart up at the calling checkout
+func PosCartResume(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	cartID, err := parseCartID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var body struct {
+		EmployeeID uint  `json:"employee_id"`
+		SessionID  *uint `json:"session_id"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.EmployeeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên bán hàng",
+		})
+	}
+	sessionIDStr := ""
+	if body.SessionID != nil {
+		sessionIDStr = strconv.FormatUint(uint64(*body.SessionID), 10)
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if status, err := resumeCart(tx, cartID, body.EmployeeID, sessionIDStr); err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return respondPosCart(c, db, cartID, nil)
+}
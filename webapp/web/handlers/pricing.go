@@ -0,0 +1,675 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// Price step codes used in the explained breakdown
+const (
+	priceStepListPrice     = "LIST_PRICE"
+	priceStepOverride      = "MANAGER_OVERRIDE"
+	priceStepGoodwill      = "GOODWILL_DISCOUNT"
+	priceStepBatchDiscount = "BATCH_DISCOUNT"
+	priceStepPromotion     = "PROMOTION"
+	priceStepMembership    = "MEMBERSHIP_DISCOUNT"
+	priceStepPoints        = "POINTS_REDEMPTION"
+)
+
+// saleRequest is what the POS submits: products and quantities, never prices,
+// except client prices sent as override requests
+type saleRequest struct {
+	CustomerID         *uint
+	PointsUsed         int
+	OverrideApprovedBy *uint
+	// OverridePIN is the approver's PIN, checked when the sale is booked; OverrideReason is
+	// stored on every overridden line
+	OverridePIN    string
+	OverrideReason string
+	// AgeVerified is the cashier's confirmation of the buyer's age for age-restricted products;
+	// OverrideRestrictions asks the approver to let quantity and sale hour limits be exceeded
+	AgeVerified          bool
+	OverrideRestrictions bool
+	Lines                []saleRequestLine
+	// Set for sales uploaded by an offline till: SoldAt is when the sale was rung up and
+	// prices it, AllowShortage books lines the shelf cannot fully cover
+	ClientUUID    *string
+	SoldAt        *time.Time
+	AllowShortage bool
+}
+
+// saleRequestLine is one product line of a sale request
+type saleRequestLine struct {
+	ProductID     uint
+	Quantity      int
+	OverridePrice *float64
+	// DiscountPercent is a goodwill discount off the list price; like OverridePrice it needs
+	// a manager's approval, and a line takes one or the other
+	DiscountPercent *float64
+}
+
+// priceStep is one explained adjustment of a line's price
+type priceStep struct {
+	Code        string  `json:"code"`
+	Description string  `json:"description"`
+	Percent     float64 `json:"percent,omitempty"`
+	Amount      float64 `json:"amount"`
+}
+
+// priceBatch is a shelf batch the line is expected to be allocated from
+type priceBatch struct {
+	ShelfID         uint       `json:"shelf_id"`
+	BatchCode       string     `json:"batch_code"`
+	Quantity        int        `json:"quantity"`
+	ExpiryDate      *time.Time `json:"expiry_date,omitempty"`
+	DiscountPercent float64    `json:"discount_percent"`
+}
+
+// pricedLine is a sale line priced by the server
+type pricedLine struct {
+	ProductID   uint    `json:"product_id"`
+	ProductCode string  `json:"product_code"`
+	ProductName string  `json:"product_name"`
+	CategoryID  uint    `json:"category_id"`
+	Brand       *string `json:"brand,omitempty"`
+	Quantity    int     `json:"quantity"`
+	ListPrice   float64 `json:"list_price"`
+	UnitPrice   float64 `json:"unit_price"`
+	Overridden  bool    `json:"overridden"`
+	// OverrideType says whether an overridden line had its price replaced or a goodwill
+	// discount; ListPrice keeps the original price either way
+	OverrideType             *models.OverrideType `json:"override_type,omitempty"`
+	GrossAmount              float64              `json:"gross_amount"`
+	BatchDiscountAmount      float64              `json:"batch_discount_amount"`
+	PromotionDiscountAmount  float64              `json:"promotion_discount_amount"`
+	MembershipDiscountAmount float64              `json:"membership_discount_amount"`
+	PointsDiscountAmount     float64              `json:"points_discount_amount"`
+	DiscountAmount           float64              `json:"discount_amount"`
+	DiscountPercentage       float64              `json:"discount_percentage"`
+	NetAmount                float64              `json:"net_amount"`
+	// Tax of the product's (or its category's) tax class; TaxAmount is part of
+	// NetAmount for INCLUSIVE lines and comes on top of it for EXCLUSIVE ones
+	TaxClassID *uint          `json:"tax_class_id,omitempty"`
+	TaxRate    float64        `json:"tax_rate"`
+	TaxMode    models.TaxMode `json:"tax_mode"`
+	TaxAmount  float64        `json:"tax_amount"`
+	Batches    []priceBatch   `json:"batches"`
+	Steps      []priceStep    `json:"steps"`
+	// Shortage is the part of an offline sale line the shelf could not cover
+	Shortage int `json:"shortage,omitempty"`
+	// PromotionID is the first promotion applied; Promotions lists every one that stacked
+	PromotionID *uint              `json:"promotion_id,omitempty"`
+	Promotions  []appliedPromotion `json:"promotions,omitempty"`
+	// promotionLocked is set once a non-stackable promotion took the line
+	promotionLocked bool
+}
+
+// priceQuote is the full explained price of a sale
+type priceQuote struct {
+	CustomerID         *uint              `json:"customer_id,omitempty"`
+	MembershipLevel    string             `json:"membership_level,omitempty"`
+	MembershipPercent  float64            `json:"membership_percent"`
+	PointsUsed         int                `json:"points_used"`
+	PointsDiscount     float64            `json:"points_discount"`
+	PromotionDiscount  float64            `json:"promotion_discount"`
+	Promotions         []appliedPromotion `json:"promotions,omitempty"`
+	OverrideApprovedBy *uint              `json:"override_approved_by,omitempty"`
+	OverrideApprover   string             `json:"override_approver,omitempty"`
+	OverrideReason     string             `json:"override_reason,omitempty"`
+	// Restrictions are the sale rules of restricted products the sale breaks
+	Restrictions   []saleRestrictionViolation `json:"restrictions,omitempty"`
+	Lines          []pricedLine               `json:"lines"`
+	Subtotal       float64                    `json:"subtotal"`
+	DiscountAmount float64                    `json:"discount_amount"`
+	TaxAmount      float64                    `json:"tax_amount"`
+	TaxBreakdown   []taxBreakdownRow          `json:"tax_breakdown"`
+	TotalAmount    float64                    `json:"total_amount"`
+	PricedAt       time.Time                  `json:"priced_at"`
+}
+
+// taxBreakdownRow sums the lines of one tax rate and mode
+type taxBreakdownRow struct {
+	TaxRate       float64        `json:"tax_rate"`
+	TaxMode       models.TaxMode `json:"tax_mode"`
+	TaxableAmount float64        `json:"taxable_amount"`
+	TaxAmount     float64        `json:"tax_amount"`
+}
+
+// roundVND rounds an amount to 2 decimals like the DECIMAL(12,2) columns
+func roundVND(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// parseSaleRequest reads the comma separated POS form fields into a sale request
+func parseSaleRequest(c *fiber.Ctx) (*saleRequest, error) {
+	req := &saleRequest{}
+
+	if customerIDStr := c.FormValue("customer_id"); customerIDStr != "" {
+		customerID, err := strconv.ParseUint(customerIDStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ID khách hàng không hợp lệ")
+		}
+		cid := uint(customerID)
+		req.CustomerID = &cid
+	}
+
+	if pointsUsedStr := c.FormValue("points_used"); pointsUsedStr != "" {
+		pointsUsed, err := strconv.Atoi(pointsUsedStr)
+		if err != nil || pointsUsed < 0 {
+			return nil, fmt.Errorf("Số điểm sử dụng không hợp lệ")
+		}
+		req.PointsUsed = pointsUsed
+	}
+
+	if approverStr := c.FormValue("override_approved_by"); approverStr != "" {
+		approverID, err := strconv.ParseUint(approverStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ID quản lý phê duyệt không hợp lệ")
+		}
+		aid := uint(approverID)
+		req.OverrideApprovedBy = &aid
+	}
+	req.OverridePIN = strings.TrimSpace(c.FormValue("override_pin"))
+	req.OverrideReason = strings.TrimSpace(c.FormValue("override_reason"))
+	req.AgeVerified = c.FormValue("age_verified") == "on" || c.FormValue("age_verified") == "true"
+	req.OverrideRestrictions = c.FormValue("override_restrictions") == "on" || c.FormValue("override_restrictions") == "true"
+
+	productIDs := c.FormValue("product_ids")
+	quantities := c.FormValue("quantities")
+	if productIDs == "" || quantities == "" {
+		return nil, fmt.Errorf("Vui lòng chọn ít nhất một sản phẩm")
+	}
+
+	productIDList := parseStringArray(productIDs)
+	quantityList := parseStringArray(quantities)
+	// unit_prices is optional; an empty entry means no override for that line,
+	// so empty entries are kept to preserve positions
+	var unitPriceList []string
+	if unitPrices := c.FormValue("unit_prices"); unitPrices != "" {
+		unitPriceList = strings.Split(unitPrices, ",")
+	}
+	var discountPercentList []string
+	if discountPercents := c.FormValue("discount_percents"); discountPercents != "" {
+		discountPercentList = strings.Split(discountPercents, ",")
+	}
+
+	if len(productIDList) != len(quantityList) {
+		return nil, fmt.Errorf("Dữ liệu sản phẩm không hợp lệ")
+	}
+
+	for i, productIDStr := range productIDList {
+		productID, err := strconv.ParseUint(productIDStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ID sản phẩm không hợp lệ: %s", productIDStr)
+		}
+
+		quantity, err := strconv.Atoi(quantityList[i])
+		if err != nil || quantity <= 0 {
+			return nil, fmt.Errorf("Số lượng không hợp lệ: %s", quantityList[i])
+		}
+
+		line := saleRequestLine{ProductID: uint(productID), Quantity: quantity}
+		if i < len(unitPriceList) && strings.TrimSpace(unitPriceList[i]) != "" {
+			price, err := strconv.ParseFloat(strings.TrimSpace(unitPriceList[i]), 64)
+			if err != nil || price <= 0 {
+				return nil, fmt.Errorf("Giá đơn vị không hợp lệ: %s", unitPriceList[i])
+			}
+			line.OverridePrice = &price
+		}
+		if i < len(discountPercentList) && strings.TrimSpace(discountPercentList[i]) != "" {
+			percent, err := strconv.ParseFloat(strings.TrimSpace(discountPercentList[i]), 64)
+			if err != nil || percent < 0 || percent >= 100 {
+				return nil, fmt.Errorf("Phần trăm giảm giá không hợp lệ: %s", discountPercentList[i])
+			}
+			if percent > 0 {
+				line.DiscountPercent = &percent
+			}
+		}
+		req.Lines = append(req.Lines, line)
+	}
+
+	return req, nil
+}
+
+// previewBatchAllocation returns the shelf batches a line will be taken from, in the
+// same FEFO order deduct_sales_line_stock uses. Pass lock inside the sale transaction
+// so the trigger allocates exactly the batches that were priced. taken holds the units
+// already claimed by earlier lines of the same sale.
+func previewBatchAllocation(tx *gorm.DB, productID uint, quantity int, lock bool, taken map[string]int) ([]priceBatch, int, error) {
+	query := `
+		SELECT shelf_id, batch_code, quantity, expiry_date, COALESCE(discount_percent, 0) as discount_percent
+		FROM supermarket.shelf_batch_inventory
+		WHERE product_id = $1
+		  AND quantity > 0
+		  AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+		ORDER BY expiry_date ASC NULLS LAST, stocked_date ASC, shelf_batch_id ASC
+	`
+	if lock {
+		query += " FOR UPDATE"
+	}
+
+	var available []priceBatch
+	if err := tx.Raw(query, productID).Scan(&available).Error; err != nil {
+		return nil, 0, err
+	}
+
+	batches := make([]priceBatch, 0, len(available))
+	remaining := quantity
+	for _, b := range available {
+		if remaining <= 0 {
+			break
+		}
+		key := fmt.Sprintf("%d/%s", b.ShelfID, b.BatchCode)
+		take := b.Quantity - taken[key]
+		if take <= 0 {
+			continue
+		}
+		if take > remaining {
+			take = remaining
+		}
+		taken[key] += take
+		b.Quantity = take
+		batches = append(batches, b)
+		remaining -= take
+	}
+
+	return batches, quantity - remaining, nil
+}
+
+// capPointsRedemption limits a redemption to the net amount of the sale. When the points are
+// worth more, only the points that cover the net amount are redeemed; it returns the points
+// redeemed and their value.
+func capPointsRedemption(points int, net float64) (int, float64) {
+	if points <= 0 {
+		return 0, 0
+	}
+	if value := pointsValue(points); value <= net {
+		return points, value
+	}
+	used := pointsForValue(net)
+	return used, pointsValue(used)
+}
+
+// spreadPointsDiscount spreads a points discount over lines in proportion to their net
+// amount; the last line takes the rounding remainder
+func spreadPointsDiscount(discount float64, nets []float64) []float64 {
+	shares := make([]float64, len(nets))
+	var total float64
+	for _, net := range nets {
+		total += net
+	}
+	if discount <= 0 || total <= 0 {
+		return shares
+	}
+
+	remaining := discount
+	for i, net := range nets {
+		share := roundVND(discount * net / total)
+		if i == len(nets)-1 || share > remaining {
+			share = remaining
+		}
+		remaining = roundVND(remaining - share)
+		shares[i] = share
+	}
+	return shares
+}
+
+// listPriceAt returns the selling price a product had at the given time from the price
+// history, or current when the history does not reach back that far
+func listPriceAt(tx *gorm.DB, productID uint, at time.Time, current float64) (float64, error) {
+	var prices []float64
+	err := tx.Raw(`
+		SELECT selling_price FROM supermarket.product_price_history
+		WHERE product_id = $1 AND effective_from <= $2
+		ORDER BY effective_from DESC, history_id DESC
+		LIMIT 1
+	`, productID, at).Scan(&prices).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(prices) == 0 {
+		return current, nil
+	}
+	return prices[0], nil
+}
+
+// priceSale derives every price of a sale on the server:
+// list price (products.selling_price, or for an offline sale the price in effect when it was
+// rung up), then the allocated batch's expiry discount,
+// then the running promotions, then the membership discount, then loyalty points
+// redemption spread over the lines.
+// A client price replaces the list price only when a manager approved the override.
+func priceSale(tx *gorm.DB, req *saleRequest, lock bool) (*priceQuote, error) {
+	if len(req.Lines) == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn ít nhất một sản phẩm")
+	}
+
+	quote := &priceQuote{
+		CustomerID:         req.CustomerID,
+		PointsUsed:         req.PointsUsed,
+		OverrideApprovedBy: req.OverrideApprovedBy,
+		PricedAt:           time.Now(),
+	}
+	if req.SoldAt != nil {
+		quote.PricedAt = *req.SoldAt
+	}
+
+	if req.CustomerID != nil {
+		var cust struct {
+			LoyaltyPoints      int
+			LevelName          *string
+			MembershipDiscount float64
+		}
+		err := tx.Raw(`
+			SELECT
+				c.loyalty_points,
+				ml.level_name,
+				COALESCE(ml.discount_percentage, 0) AS membership_discount
+			FROM supermarket.customers c
+			LEFT JOIN supermarket.membership_levels ml ON c.membership_level_id = ml.level_id
+			WHERE c.customer_id = $1
+		`, *req.CustomerID).Scan(&cust).Error
+		if err != nil {
+			return nil, fmt.Errorf("Không thể tải thông tin khách hàng: %v", err)
+		}
+		if req.PointsUsed > cust.LoyaltyPoints {
+			return nil, fmt.Errorf("Điểm sử dụng vượt quá số điểm hiện có")
+		}
+		if cust.LevelName != nil {
+			quote.MembershipLevel = *cust.LevelName
+		}
+		quote.MembershipPercent = cust.MembershipDiscount
+	} else if req.PointsUsed > 0 {
+		return nil, fmt.Errorf("Chỉ khách hàng thành viên mới được sử dụng điểm")
+	}
+
+	var approver *overrideApprover
+	if req.OverrideApprovedBy != nil {
+		var err error
+		if approver, err = loadOverrideApprover(tx, *req.OverrideApprovedBy); err != nil {
+			return nil, err
+		}
+		quote.OverrideApprover = approver.FullName
+		quote.OverrideReason = req.OverrideReason
+	}
+
+	taken := make(map[string]int)
+	for _, reqLine := range req.Lines {
+		var product struct {
+			ProductCode  string
+			ProductName  string
+			CategoryID   uint
+			Brand        *string
+			SellingPrice float64
+			IsActive     bool
+			TaxClassID   *uint
+			TaxRate      *float64
+			TaxMode      *models.TaxMode
+		}
+		err := tx.Raw(`
+			SELECT p.product_code, p.product_name, p.category_id, p.brand, p.selling_price, p.is_active,
+				tc.tax_class_id, tc.rate as tax_rate, tc.tax_mode
+			FROM supermarket.products p
+			JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
+			LEFT JOIN supermarket.tax_classes tc ON tc.tax_class_id = COALESCE(p.tax_class_id, pc.tax_class_id)
+			WHERE p.product_id = $1
+		`, reqLine.ProductID).Scan(&product).Error
+		if err != nil || product.ProductCode == "" {
+			return nil, fmt.Errorf("Không tìm thấy sản phẩm %d", reqLine.ProductID)
+		}
+		if !product.IsActive {
+			return nil, fmt.Errorf("Sản phẩm %s đã ngừng kinh doanh", product.ProductName)
+		}
+		// A sale rung up earlier is priced at the list price in effect at the time
+		if req.SoldAt != nil {
+			if product.SellingPrice, err = listPriceAt(tx, reqLine.ProductID, *req.SoldAt, product.SellingPrice); err != nil {
+				return nil, fmt.Errorf("Không thể tải lịch sử giá sản phẩm %s: %v", product.ProductName, err)
+			}
+		}
+
+		line := pricedLine{
+			ProductID:   reqLine.ProductID,
+			ProductCode: product.ProductCode,
+			ProductName: product.ProductName,
+			CategoryID:  product.CategoryID,
+			Brand:       product.Brand,
+			Quantity:    reqLine.Quantity,
+			ListPrice:   product.SellingPrice,
+			UnitPrice:   product.SellingPrice,
+			TaxRate:     models.DefaultTaxRate,
+			TaxMode:     models.DefaultTaxMode,
+		}
+		if product.TaxClassID != nil {
+			line.TaxClassID = product.TaxClassID
+			line.TaxRate = *product.TaxRate
+			line.TaxMode = *product.TaxMode
+		}
+		line.Steps = append(line.Steps, priceStep{
+			Code:        priceStepListPrice,
+			Description: fmt.Sprintf("Giá niêm yết %.0f x %d", product.SellingPrice, reqLine.Quantity),
+			Amount:      roundVND(product.SellingPrice * float64(reqLine.Quantity)),
+		})
+
+		priceChanged := reqLine.OverridePrice != nil && math.Abs(*reqLine.OverridePrice-product.SellingPrice) >= 0.01
+		if priceChanged && reqLine.DiscountPercent != nil {
+			return nil, fmt.Errorf("Sản phẩm %s chỉ được đổi giá hoặc giảm giá, không được cả hai", product.ProductName)
+		}
+		if priceChanged {
+			if approver == nil {
+				return nil, fmt.Errorf("Giá %.0f của sản phẩm %s khác giá niêm yết %.0f, cần quản lý phê duyệt",
+					*reqLine.OverridePrice, product.ProductName, product.SellingPrice)
+			}
+			overrideType := models.OverridePrice
+			line.UnitPrice = *reqLine.OverridePrice
+			line.Overridden = true
+			line.OverrideType = &overrideType
+			line.Steps = append(line.Steps, priceStep{
+				Code:        priceStepOverride,
+				Description: fmt.Sprintf("Giá điều chỉnh %.0f x %d (phê duyệt: %s)", line.UnitPrice, reqLine.Quantity, quote.OverrideApprover),
+				Amount:      roundVND((line.UnitPrice - product.SellingPrice) * float64(reqLine.Quantity)),
+			})
+		} else if reqLine.DiscountPercent != nil {
+			if approver == nil {
+				return nil, fmt.Errorf("Giảm giá %g%% cho sản phẩm %s cần quản lý phê duyệt",
+					*reqLine.DiscountPercent, product.ProductName)
+			}
+			overrideType := models.OverrideDiscount
+			line.UnitPrice = roundVND(product.SellingPrice * (1 - *reqLine.DiscountPercent/100))
+			line.Overridden = true
+			line.OverrideType = &overrideType
+			line.Steps = append(line.Steps, priceStep{
+				Code:        priceStepGoodwill,
+				Description: fmt.Sprintf("Giảm giá thiện chí x %d (phê duyệt: %s)", reqLine.Quantity, quote.OverrideApprover),
+				Percent:     *reqLine.DiscountPercent,
+				Amount:      roundVND((line.UnitPrice - product.SellingPrice) * float64(reqLine.Quantity)),
+			})
+		}
+		if line.Overridden {
+			if err := checkOverrideLimit(approver, &line); err != nil {
+				return nil, err
+			}
+		}
+		line.GrossAmount = roundVND(line.UnitPrice * float64(line.Quantity))
+
+		batches, allocated, err := previewBatchAllocation(tx, reqLine.ProductID, reqLine.Quantity, lock, taken)
+		if err != nil {
+			return nil, fmt.Errorf("Không thể kiểm tra tồn kho quầy: %v", err)
+		}
+		if allocated < reqLine.Quantity && req.AllowShortage {
+			line.Shortage = reqLine.Quantity - allocated
+		} else if allocated < reqLine.Quantity {
+			return nil, fmt.Errorf("Không đủ hàng trên quầy cho sản phẩm %s. Còn: %d, yêu cầu: %d",
+				product.ProductName, allocated, reqLine.Quantity)
+		}
+		line.Batches = batches
+
+		// An approved override is the final shelf price, so batch discounts do not stack on it
+		if !line.Overridden {
+			for _, b := range batches {
+				if b.DiscountPercent <= 0 {
+					continue
+				}
+				amount := roundVND(line.UnitPrice * float64(b.Quantity) * b.DiscountPercent / 100)
+				line.BatchDiscountAmount += amount
+				line.Steps = append(line.Steps, priceStep{
+					Code:        priceStepBatchDiscount,
+					Description: fmt.Sprintf("Giảm giá cận hạn lô %s x %d", b.BatchCode, b.Quantity),
+					Percent:     b.DiscountPercent,
+					Amount:      -amount,
+				})
+			}
+		}
+
+		quote.Lines = append(quote.Lines, line)
+	}
+
+	// Promotions see the whole basket (combos and spend thresholds span several lines)
+	promotions, err := loadRunningPromotions(tx, quote.PricedAt)
+	if err != nil {
+		return nil, fmt.Errorf("Không thể tải chương trình khuyến mãi: %v", err)
+	}
+	applyPromotions(quote, promotions)
+
+	var totalNetBeforePoints float64
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+		if quote.MembershipPercent > 0 {
+			line.MembershipDiscountAmount = roundVND(line.promotedAmount() * quote.MembershipPercent / 100)
+			line.Steps = append(line.Steps, priceStep{
+				Code:        priceStepMembership,
+				Description: fmt.Sprintf("Ưu đãi thành viên %s", quote.MembershipLevel),
+				Percent:     quote.MembershipPercent,
+				Amount:      -line.MembershipDiscountAmount,
+			})
+		}
+		totalNetBeforePoints += line.promotedAmount() - line.MembershipDiscountAmount
+	}
+
+	// Only the points the net amount can absorb are redeemed, and those are what the sale
+	// stores and debits from the customer
+	pointsUsed, pointsDiscount := capPointsRedemption(req.PointsUsed, totalNetBeforePoints)
+	quote.PointsUsed = pointsUsed
+	quote.PointsDiscount = pointsDiscount
+
+	nets := make([]float64, len(quote.Lines))
+	for i := range quote.Lines {
+		nets[i] = quote.Lines[i].promotedAmount() - quote.Lines[i].MembershipDiscountAmount
+	}
+	shares := spreadPointsDiscount(pointsDiscount, nets)
+
+	var addedTax float64
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+
+		if shares[i] > 0 {
+			line.PointsDiscountAmount = shares[i]
+			line.Steps = append(line.Steps, priceStep{
+				Code:        priceStepPoints,
+				Description: fmt.Sprintf("Đổi điểm (%d điểm = %.0f VND, phân bổ theo giá trị dòng)", pointsUsed, pointsDiscount),
+				Amount:      -shares[i],
+			})
+		}
+
+		line.DiscountAmount = roundVND(line.BatchDiscountAmount + line.PromotionDiscountAmount + line.MembershipDiscountAmount + line.PointsDiscountAmount)
+		line.NetAmount = roundVND(line.GrossAmount - line.DiscountAmount)
+		if line.GrossAmount > 0 {
+			line.DiscountPercentage = math.Round(line.DiscountAmount/line.GrossAmount*10000) / 100
+		}
+		line.TaxAmount = models.LineTax(line.NetAmount, line.TaxRate, line.TaxMode)
+
+		quote.Subtotal += line.GrossAmount
+		quote.DiscountAmount += line.DiscountAmount
+		quote.TaxAmount += line.TaxAmount
+		if line.TaxMode == models.TaxExclusive {
+			addedTax += line.TaxAmount
+		}
+	}
+
+	// Same totals as calculate_invoice_totals: only exclusive tax is added on top
+	quote.Subtotal = roundVND(quote.Subtotal)
+	quote.DiscountAmount = roundVND(quote.DiscountAmount)
+	quote.TaxAmount = roundVND(quote.TaxAmount)
+	net := math.Max(quote.Subtotal-quote.DiscountAmount, 0)
+	quote.TotalAmount = roundVND(net + addedTax)
+	quote.TaxBreakdown = quoteTaxBreakdown(quote.Lines)
+
+	return quote, nil
+}
+
+// quoteTaxBreakdown groups the priced lines by tax rate and mode. The taxable amount
+// is the net amount without VAT.
+func quoteTaxBreakdown(lines []pricedLine) []taxBreakdownRow {
+	var rows []taxBreakdownRow
+	for _, line := range lines {
+		taxable := line.NetAmount
+		if line.TaxMode == models.TaxInclusive {
+			taxable -= line.TaxAmount
+		}
+
+		found := false
+		for i := range rows {
+			if rows[i].TaxRate == line.TaxRate && rows[i].TaxMode == line.TaxMode {
+				rows[i].TaxableAmount = roundVND(rows[i].TaxableAmount + taxable)
+				rows[i].TaxAmount = roundVND(rows[i].TaxAmount + line.TaxAmount)
+				found = true
+				break
+			}
+		}
+		if !found {
+			rows = append(rows, taxBreakdownRow{
+				TaxRate:       line.TaxRate,
+				TaxMode:       line.TaxMode,
+				TaxableAmount: roundVND(taxable),
+				TaxAmount:     line.TaxAmount,
+			})
+		}
+	}
+	return rows
+}
+
+// marshalPriceQuote serialises a quote for sales_invoices.pricing_breakdown
+func marshalPriceQuote(quote *priceQuote) (string, error) {
+	data, err := json.Marshal(quote)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PricingQuote prices a cart without creating an invoice so the POS can show the breakdown
+func PricingQuote(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	req, err := parseSaleRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	quote, err := priceSale(db, req, false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The broken sale rules are shown so the cashier can confirm the age or call a manager
+	quote.Restrictions, err = checkSaleRestrictions(db, req, quote)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể kiểm tra giới hạn bán hàng: " + err.Error(),
+		})
+	}
+
+	return c.JSON(quote)
+}
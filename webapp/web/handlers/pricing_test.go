@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/supermarket/config"
+)
+
+// withLoyaltySettings swaps the loyalty settings for the duration of a test
+func withLoyaltySettings(t *testing.T, cfg config.LoyaltyConfig) {
+	t.Helper()
+	saved := loyaltySettings
+	loyaltySettings = cfg
+	t.Cleanup(func() { loyaltySettings = saved })
+}
+
+func TestRoundVND(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   float64
+	}{
+		{1000, 1000},
+		{1000.004, 1000},
+		{1000.005, 1000.01},
+		{3.3333333, 3.33},
+		{-2.675, -2.68},
+	}
+
+	for _, tt := range tests {
+		if got := roundVND(tt.amount); got != tt.want {
+			t.Errorf("roundVND(%v) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCapPointsRedemption(t *testing.T) {
+	// 1 point = 100 VND
+	withLoyaltySettings(t, config.LoyaltyConfig{RedeemPoints: 1, RedeemValue: 100})
+
+	tests := []struct {
+		name      string
+		points    int
+		net       float64
+		wantUsed  int
+		wantValue float64
+	}{
+		{"below the net amount", 50, 10000, 50, 5000},
+		{"exactly the net amount", 100, 10000, 100, 10000},
+		{"above the net amount uses only what is absorbed", 200, 12345, 123, 12300},
+		{"nothing to absorb", 10, 0, 0, 0},
+		{"no points", 0, 10000, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used, value := capPointsRedemption(tt.points, tt.net)
+			if used != tt.wantUsed || value != tt.wantValue {
+				t.Errorf("capPointsRedemption(%d, %v) = (%d, %v), want (%d, %v)",
+					tt.points, tt.net, used, value, tt.wantUsed, tt.wantValue)
+			}
+			if value > tt.net {
+				t.Errorf("discount %v exceeds net amount %v", value, tt.net)
+			}
+			if pointsValue(used) != value {
+				t.Errorf("stored points %d are worth %v, not the discount %v", used, pointsValue(used), value)
+			}
+		})
+	}
+}
+
+func TestCapPointsRedemptionFractionalRate(t *testing.T) {
+	// 1000 points = 1 VND
+	withLoyaltySettings(t, config.LoyaltyConfig{RedeemPoints: 1000, RedeemValue: 1})
+
+	used, value := capPointsRedemption(5000, 2.5)
+	if used != 2500 || value != 2.5 {
+		t.Errorf("capPointsRedemption(5000, 2.5) = (%d, %v), want (2500, 2.5)", used, value)
+	}
+}
+
+func TestSpreadPointsDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount float64
+		nets     []float64
+		want     []float64
+	}{
+		{"proportional", 100, []float64{50, 30, 20}, []float64{50, 30, 20}},
+		{"last line takes the remainder", 10, []float64{1, 1, 1}, []float64{3.33, 3.33, 3.34}},
+		{"single line", 12300, []float64{12345}, []float64{12300}},
+		{"no discount", 0, []float64{100, 200}, []float64{0, 0}},
+		{"nothing to spread over", 50, []float64{0, 0}, []float64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spreadPointsDiscount(tt.discount, tt.nets)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d shares, want %d", len(got), len(tt.want))
+			}
+			var sum float64
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("share %d = %v, want %v", i, got[i], tt.want[i])
+				}
+				sum += got[i]
+			}
+			if tt.want[0] != 0 && roundVND(sum) != tt.discount {
+				t.Errorf("shares sum to %v, want %v", roundVND(sum), tt.discount)
+			}
+		})
+	}
+}
@@ -80,6 +80,7 @@ func ProductNew(c *fiber.Ctx) error {
 		"Active":          "products",
 		"Categories":      categories,
 		"Suppliers":       suppliers,
+		"TaxClasses":      loadTaxClasses(db),
 		"IsNew":           true,
 		"SQLQueries":      c.Locals("SQLQueries"),
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
@@ -97,6 +98,18 @@ func ProductCreate(c *fiber.Ctx) error {
 	supplierID, _ := strconv.ParseUint(c.FormValue("supplier_id"), 10, 64)
 	minStock, _ := strconv.ParseInt(c.FormValue("min_stock_level"), 10, 64)
 	shelfLife, _ := strconv.ParseInt(c.FormValue("shelf_life_days"), 10, 64)
+	taxClassID, err := optionalTaxClassID(c, "tax_class_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	restriction, err := parseSaleRestrictionForm(c, "")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
 	// Validate price constraint
 	if sellingPrice <= importPrice {
@@ -109,13 +122,14 @@ func ProductCreate(c *fiber.Ctx) error {
 	query := `
 		INSERT INTO supermarket.products 
 		(product_code, product_name, category_id, supplier_id, 
-		 import_price, selling_price, min_stock_level, shelf_life_days)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 import_price, selling_price, min_stock_level, shelf_life_days, brand, tax_class_id,
+		 min_age, max_qty_per_invoice, max_qty_per_customer_day, sale_hours_start, sale_hours_end)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 		RETURNING product_id
 	`
 
 	var productID uint
-	err := db.Raw(query,
+	err = db.Raw(query,
 		c.FormValue("product_code"),
 		c.FormValue("product_name"),
 		categoryID,
@@ -124,6 +138,13 @@ func ProductCreate(c *fiber.Ctx) error {
 		sellingPrice,
 		minStock,
 		shelfLife,
+		nullIfEmpty(c.FormValue("brand")),
+		taxClassID,
+		restriction.MinAge,
+		restriction.MaxQtyPerInvoice,
+		restriction.MaxQtyPerCustomerDay,
+		restriction.SaleHoursStart,
+		restriction.SaleHoursEnd,
 	).Scan(&productID).Error
 
 	if err != nil {
@@ -144,17 +165,24 @@ func ProductView(c *fiber.Ctx) error {
 		models.Product
 		CategoryName string
 		SupplierName string
+		TaxCode      string
+		TaxRate      float64
+		TaxMode      string
+		TaxInherited bool
 	}
 
 	query := `
-		SELECT p.*, c.category_name, s.supplier_name as supplier_name
+		SELECT p.*, c.category_name, s.supplier_name as supplier_name,
+			COALESCE(tc.tax_code, '') as tax_code, COALESCE(tc.rate, $2) as tax_rate,
+			COALESCE(tc.tax_mode, $3) as tax_mode, p.tax_class_id IS NULL as tax_inherited
 		FROM supermarket.products p
 		LEFT JOIN supermarket.product_categories c ON p.category_id = c.category_id
 		LEFT JOIN supermarket.suppliers s ON p.supplier_id = s.supplier_id
+		LEFT JOIN supermarket.tax_classes tc ON tc.tax_class_id = COALESCE(p.tax_class_id, c.tax_class_id)
 		WHERE p.product_id = $1
 	`
 
-	err := db.Raw(query, id).Scan(&product).Error
+	err := db.Raw(query, id, models.DefaultTaxRate, models.DefaultTaxMode).Scan(&product).Error
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
 			"Title": "Lỗi",
@@ -213,12 +241,19 @@ func ProductEdit(c *fiber.Ctx) error {
 	var suppliers []models.Supplier
 	db.Raw("SELECT * FROM supermarket.suppliers ORDER BY supplier_name").Scan(&suppliers)
 
+	var productTaxClassID uint
+	if product.TaxClassID != nil {
+		productTaxClassID = *product.TaxClassID
+	}
+
 	return c.Render("pages/products/form", fiber.Map{
 		"Title":           "Chỉnh sửa sản phẩm",
 		"Active":          "products",
 		"Product":         product,
 		"Categories":      categories,
 		"Suppliers":       suppliers,
+		"TaxClasses":      loadTaxClasses(db),
+		"TaxClassID":      productTaxClassID,
 		"IsNew":           false,
 		"SQLQueries":      c.Locals("SQLQueries"),
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
@@ -237,6 +272,18 @@ func ProductUpdate(c *fiber.Ctx) error {
 	supplierID, _ := strconv.ParseUint(c.FormValue("supplier_id"), 10, 64)
 	minStock, _ := strconv.ParseInt(c.FormValue("min_stock_level"), 10, 64)
 	shelfLife, _ := strconv.ParseInt(c.FormValue("shelf_life_days"), 10, 64)
+	taxClassID, err := optionalTaxClassID(c, "tax_class_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	restriction, err := parseSaleRestrictionForm(c, "")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
 	// Validate price constraint
 	if sellingPrice <= importPrice {
@@ -250,11 +297,13 @@ func ProductUpdate(c *fiber.Ctx) error {
 		UPDATE supermarket.products 
 		SET product_code = $1, product_name = $2, category_id = $3, 
 		    supplier_id = $4, import_price = $5, selling_price = $6,
-		    min_stock_level = $7, shelf_life_days = $8
-		WHERE product_id = $9
+		    min_stock_level = $7, shelf_life_days = $8, brand = $9, tax_class_id = $10,
+		    min_age = $12, max_qty_per_invoice = $13, max_qty_per_customer_day = $14,
+		    sale_hours_start = $15, sale_hours_end = $16
+		WHERE product_id = $11
 	`
 
-	err := db.Exec(query,
+	err = db.Exec(query,
 		c.FormValue("product_code"),
 		c.FormValue("product_name"),
 		categoryID,
@@ -263,7 +312,14 @@ func ProductUpdate(c *fiber.Ctx) error {
 		sellingPrice,
 		minStock,
 		shelfLife,
+		nullIfEmpty(c.FormValue("brand")),
+		taxClassID,
 		id,
+		restriction.MinAge,
+		restriction.MaxQtyPerInvoice,
+		restriction.MaxQtyPerCustomerDay,
+		restriction.SaleHoursStart,
+		restriction.SaleHoursEnd,
 	).Error
 
 	if err != nil {
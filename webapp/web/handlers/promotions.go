@@ -0,0 +1,981 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// promotionTypeLabels names the promotion mechanics in the admin pages and receipts
+var promotionTypeLabels = map[models.PromotionType]string{
+	models.PromotionBuyXGetY:       "Mua X tặng Y",
+	models.PromotionMultiBuy:       "Mua nhiều giá tốt",
+	models.PromotionCombo:          "Combo giá cố định",
+	models.PromotionSpendThreshold: "Giảm theo ngưỡng hóa đơn",
+	models.PromotionPercentOff:     "Giảm % theo sản phẩm/danh mục/thương hiệu",
+	models.PromotionHappyHour:      "Giờ vàng",
+}
+
+// appliedPromotion is a promotion applied to a priced line (or, on the quote, to the whole sale)
+type appliedPromotion struct {
+	PromotionID    uint                 `json:"promotion_id"`
+	PromotionCode  string               `json:"promotion_code"`
+	PromotionName  string               `json:"promotion_name"`
+	PromotionType  models.PromotionType `json:"promotion_type"`
+	DiscountAmount float64              `json:"discount_amount"`
+	FreeQuantity   int                  `json:"free_quantity,omitempty"`
+}
+
+// promotedAmount is what is left of the line after batch and promotion discounts
+func (l *pricedLine) promotedAmount() float64 {
+	return l.GrossAmount - l.BatchDiscountAmount - l.PromotionDiscountAmount
+}
+
+// loadRunningPromotions returns the active promotions whose dates and time window cover at,
+// highest priority first
+func loadRunningPromotions(tx *gorm.DB, at time.Time) ([]models.Promotion, error) {
+	day := at.Format("2006-01-02")
+
+	var promotions []models.Promotion
+	err := tx.Raw(`
+		SELECT *
+		FROM supermarket.promotions
+		WHERE is_active = true AND $1::date BETWEEN start_date AND end_date
+		ORDER BY priority DESC, promotion_id
+	`, day).Scan(&promotions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.PromotionItem
+	err = tx.Raw(`
+		SELECT pi.*
+		FROM supermarket.promotion_items pi
+		JOIN supermarket.promotions p ON pi.promotion_id = p.promotion_id
+		WHERE p.is_active = true AND $1::date BETWEEN p.start_date AND p.end_date
+		ORDER BY pi.promotion_item_id
+	`, day).Scan(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	running := make([]models.Promotion, 0, len(promotions))
+	for _, p := range promotions {
+		if !promotionInWindow(&p, at) {
+			continue
+		}
+		for _, item := range items {
+			if item.PromotionID == p.PromotionID {
+				p.Items = append(p.Items, item)
+			}
+		}
+		running = append(running, p)
+	}
+	return running, nil
+}
+
+// promotionInWindow checks the optional weekday and daily time window of a promotion.
+// A window whose end is before its start runs over midnight.
+func promotionInWindow(p *models.Promotion, at time.Time) bool {
+	if p.DaysOfWeek != nil && strings.TrimSpace(*p.DaysOfWeek) != "" {
+		weekday := int(at.Weekday())
+		if weekday == 0 {
+			weekday = 7
+		}
+		found := false
+		for _, d := range strings.Split(*p.DaysOfWeek, ",") {
+			if strings.TrimSpace(d) == strconv.Itoa(weekday) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if p.HappyHourStart == nil || p.HappyHourEnd == nil {
+		return p.PromotionType != models.PromotionHappyHour
+	}
+	now := at.Format("15:04")
+	start, end := *p.HappyHourStart, *p.HappyHourEnd
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// promotionMatchesLine checks if a line is covered by the promotion items.
+// A promotion without items covers every line.
+func promotionMatchesLine(p *models.Promotion, line *pricedLine) bool {
+	if len(p.Items) == 0 {
+		return true
+	}
+	for _, item := range p.Items {
+		switch {
+		case item.ProductID != nil && *item.ProductID == line.ProductID:
+			return true
+		case item.CategoryID != nil && *item.CategoryID == line.CategoryID:
+			return true
+		case item.Brand != nil && line.Brand != nil && strings.EqualFold(*item.Brand, *line.Brand):
+			return true
+		}
+	}
+	return false
+}
+
+// promotionEligible checks the stacking rules: overridden lines get no promotion,
+// a line taken by a non-stackable promotion gets no further promotion, and a
+// non-stackable promotion only takes lines no other promotion touched
+func promotionEligible(p *models.Promotion, line *pricedLine) bool {
+	if line.Overridden || line.promotionLocked || line.promotedAmount() <= 0 {
+		return false
+	}
+	if !p.Stackable && len(line.Promotions) > 0 {
+		return false
+	}
+	return promotionMatchesLine(p, line)
+}
+
+// grantPromotion books a promotion discount on a line, capped at what is left of the line
+func grantPromotion(quote *priceQuote, p *models.Promotion, line *pricedLine, amount float64, freeQuantity int) {
+	amount = roundVND(amount)
+	if remaining := roundVND(line.promotedAmount()); amount > remaining {
+		amount = remaining
+	}
+	if amount <= 0 {
+		return
+	}
+
+	line.PromotionDiscountAmount = roundVND(line.PromotionDiscountAmount + amount)
+	if line.PromotionID == nil {
+		id := p.PromotionID
+		line.PromotionID = &id
+	}
+	if !p.Stackable {
+		line.promotionLocked = true
+	}
+	line.Promotions = append(line.Promotions, appliedPromotion{
+		PromotionID:    p.PromotionID,
+		PromotionCode:  p.PromotionCode,
+		PromotionName:  p.PromotionName,
+		PromotionType:  p.PromotionType,
+		DiscountAmount: amount,
+		FreeQuantity:   freeQuantity,
+	})
+
+	description := fmt.Sprintf("Khuyến mãi %s - %s", p.PromotionCode, p.PromotionName)
+	if freeQuantity > 0 {
+		description += fmt.Sprintf(" (tặng %d)", freeQuantity)
+	}
+	line.Steps = append(line.Steps, priceStep{
+		Code:        priceStepPromotion,
+		Description: description,
+		Amount:      -amount,
+	})
+
+	quote.PromotionDiscount = roundVND(quote.PromotionDiscount + amount)
+	for i := range quote.Promotions {
+		if quote.Promotions[i].PromotionID == p.PromotionID {
+			quote.Promotions[i].DiscountAmount = roundVND(quote.Promotions[i].DiscountAmount + amount)
+			quote.Promotions[i].FreeQuantity += freeQuantity
+			return
+		}
+	}
+	applied := line.Promotions[len(line.Promotions)-1]
+	quote.Promotions = append(quote.Promotions, applied)
+}
+
+// spreadPromotion shares a basket-level discount over lines in proportion to their weights;
+// the last line takes the rounding remainder
+func spreadPromotion(quote *priceQuote, p *models.Promotion, lines []*pricedLine, weights []float64, total float64) {
+	var totalWeight float64
+	for _, w := range weights {
+		totalWeight += w
+	}
+	if total <= 0 || totalWeight <= 0 {
+		return
+	}
+
+	remaining := roundVND(total)
+	for i, line := range lines {
+		share := roundVND(total * weights[i] / totalWeight)
+		if i == len(lines)-1 || share > remaining {
+			share = remaining
+		}
+		remaining = roundVND(remaining - share)
+		grantPromotion(quote, p, line, share, 0)
+	}
+}
+
+// netUnitPrice is a line's unit price after the discounts applied so far
+func netUnitPrice(line *pricedLine) float64 {
+	return line.promotedAmount() / float64(line.Quantity)
+}
+
+// applyPromotions evaluates the running promotions against the priced basket in priority order
+func applyPromotions(quote *priceQuote, promotions []models.Promotion) {
+	for i := range promotions {
+		p := &promotions[i]
+
+		var lines []*pricedLine
+		for j := range quote.Lines {
+			if promotionEligible(p, &quote.Lines[j]) {
+				lines = append(lines, &quote.Lines[j])
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		switch p.PromotionType {
+		case models.PromotionBuyXGetY:
+			if p.BuyQuantity == nil || *p.BuyQuantity <= 0 {
+				continue
+			}
+			get := 1
+			if p.GetQuantity != nil && *p.GetQuantity > 0 {
+				get = *p.GetQuantity
+			}
+			percent := 100.0
+			if p.GetDiscountPercent != nil && *p.GetDiscountPercent > 0 {
+				percent = *p.GetDiscountPercent
+			}
+			for _, line := range lines {
+				free := line.Quantity / (*p.BuyQuantity + get) * get
+				if free > 0 {
+					grantPromotion(quote, p, line, netUnitPrice(line)*float64(free)*percent/100, free)
+				}
+			}
+
+		case models.PromotionMultiBuy:
+			if p.BundleQuantity == nil || *p.BundleQuantity <= 1 || p.BundlePrice == nil {
+				continue
+			}
+			for _, line := range lines {
+				bundles := line.Quantity / *p.BundleQuantity
+				if bundles == 0 {
+					continue
+				}
+				saving := float64(bundles) * (netUnitPrice(line)*float64(*p.BundleQuantity) - *p.BundlePrice)
+				grantPromotion(quote, p, line, saving, 0)
+			}
+
+		case models.PromotionCombo:
+			if p.ComboPrice == nil || len(p.Items) == 0 {
+				continue
+			}
+			// Every component product must be in the basket; the number of
+			// sets is limited by the scarcest component
+			sets := -1
+			var components []*pricedLine
+			var weights []float64
+			for _, item := range p.Items {
+				if item.ProductID == nil {
+					sets = 0
+					break
+				}
+				var match *pricedLine
+				for _, line := range lines {
+					if line.ProductID == *item.ProductID {
+						match = line
+						break
+					}
+				}
+				if match == nil {
+					sets = 0
+					break
+				}
+				if n := match.Quantity / item.Quantity; sets < 0 || n < sets {
+					sets = n
+				}
+				components = append(components, match)
+				weights = append(weights, netUnitPrice(match)*float64(item.Quantity))
+			}
+			if sets <= 0 {
+				continue
+			}
+			var setValue float64
+			for _, w := range weights {
+				setValue += w
+			}
+			spreadPromotion(quote, p, components, weights, float64(sets)*(setValue-*p.ComboPrice))
+
+		case models.PromotionSpendThreshold:
+			var base float64
+			weights := make([]float64, len(lines))
+			for j, line := range lines {
+				weights[j] = line.promotedAmount()
+				base += weights[j]
+			}
+			if p.MinSpend == nil || base < *p.MinSpend {
+				continue
+			}
+			var total float64
+			if p.DiscountAmount != nil && *p.DiscountAmount > 0 {
+				total = *p.DiscountAmount
+			} else if p.DiscountPercent != nil {
+				total = base * *p.DiscountPercent / 100
+			}
+			if p.MaxDiscountAmount != nil && *p.MaxDiscountAmount > 0 && total > *p.MaxDiscountAmount {
+				total = *p.MaxDiscountAmount
+			}
+			spreadPromotion(quote, p, lines, weights, total)
+
+		case models.PromotionPercentOff, models.PromotionHappyHour:
+			if p.DiscountPercent == nil || *p.DiscountPercent <= 0 {
+				continue
+			}
+			var base float64
+			weights := make([]float64, len(lines))
+			for j, line := range lines {
+				weights[j] = line.promotedAmount()
+				base += weights[j]
+			}
+			total := base * *p.DiscountPercent / 100
+			if p.MaxDiscountAmount != nil && *p.MaxDiscountAmount > 0 && total > *p.MaxDiscountAmount {
+				total = *p.MaxDiscountAmount
+			}
+			spreadPromotion(quote, p, lines, weights, total)
+		}
+	}
+}
+
+// recordInvoicePromotions writes every promotion applied to an invoice line
+func recordInvoicePromotions(tx *gorm.DB, detailID uint, line *pricedLine) error {
+	for _, applied := range line.Promotions {
+		err := tx.Exec(`
+			INSERT INTO supermarket.sales_invoice_promotions
+			(detail_id, promotion_id, discount_amount, free_quantity, created_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		`, detailID, applied.PromotionID, applied.DiscountAmount, applied.FreeQuantity).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promotionItemInput is one target row of the promotion form (sent as items_json)
+type promotionItemInput struct {
+	ProductID  *uint   `json:"product_id"`
+	CategoryID *uint   `json:"category_id"`
+	Brand      *string `json:"brand"`
+	Quantity   int     `json:"quantity"`
+}
+
+// optionalFormInt reads an optional integer form field
+func optionalFormInt(c *fiber.Ctx, name string) (*int, error) {
+	value := strings.TrimSpace(c.FormValue(name))
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("Giá trị %s không hợp lệ: %s", name, value)
+	}
+	return &n, nil
+}
+
+// optionalFormFloat reads an optional decimal form field
+func optionalFormFloat(c *fiber.Ctx, name string) (*float64, error) {
+	value := strings.TrimSpace(c.FormValue(name))
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return nil, fmt.Errorf("Giá trị %s không hợp lệ: %s", name, value)
+	}
+	return &f, nil
+}
+
+// parsePromotionForm reads and validates the promotion form
+func parsePromotionForm(c *fiber.Ctx) (*models.Promotion, []models.PromotionItem, error) {
+	p := &models.Promotion{
+		PromotionCode: strings.TrimSpace(c.FormValue("promotion_code")),
+		PromotionName: strings.TrimSpace(c.FormValue("promotion_name")),
+		PromotionType: models.PromotionType(c.FormValue("promotion_type")),
+		Description:   nullIfEmpty(c.FormValue("description")),
+		Stackable:     c.FormValue("stackable") == "on" || c.FormValue("stackable") == "true",
+		IsActive:      c.FormValue("is_active") == "on" || c.FormValue("is_active") == "true",
+	}
+	if p.PromotionCode == "" || p.PromotionName == "" {
+		return nil, nil, fmt.Errorf("Vui lòng nhập mã và tên chương trình")
+	}
+	if _, ok := promotionTypeLabels[p.PromotionType]; !ok {
+		return nil, nil, fmt.Errorf("Loại khuyến mãi không hợp lệ")
+	}
+
+	var err error
+	if p.StartDate, err = time.Parse("2006-01-02", c.FormValue("start_date")); err != nil {
+		return nil, nil, fmt.Errorf("Ngày bắt đầu không hợp lệ")
+	}
+	if p.EndDate, err = time.Parse("2006-01-02", c.FormValue("end_date")); err != nil {
+		return nil, nil, fmt.Errorf("Ngày kết thúc không hợp lệ")
+	}
+	if p.EndDate.Before(p.StartDate) {
+		return nil, nil, fmt.Errorf("Ngày kết thúc phải sau ngày bắt đầu")
+	}
+	if priority := c.FormValue("priority"); priority != "" {
+		if p.Priority, err = strconv.Atoi(priority); err != nil {
+			return nil, nil, fmt.Errorf("Độ ưu tiên không hợp lệ")
+		}
+	}
+
+	for name, dst := range map[string]**int{
+		"buy_quantity":    &p.BuyQuantity,
+		"get_quantity":    &p.GetQuantity,
+		"bundle_quantity": &p.BundleQuantity,
+	} {
+		if *dst, err = optionalFormInt(c, name); err != nil {
+			return nil, nil, err
+		}
+	}
+	for name, dst := range map[string]**float64{
+		"get_discount_percent": &p.GetDiscountPercent,
+		"bundle_price":         &p.BundlePrice,
+		"combo_price":          &p.ComboPrice,
+		"min_spend":            &p.MinSpend,
+		"discount_percent":     &p.DiscountPercent,
+		"discount_amount":      &p.DiscountAmount,
+		"max_discount_amount":  &p.MaxDiscountAmount,
+	} {
+		if *dst, err = optionalFormFloat(c, name); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, percent := range []*float64{p.GetDiscountPercent, p.DiscountPercent} {
+		if percent != nil && *percent > 100 {
+			return nil, nil, fmt.Errorf("Phần trăm giảm không được vượt quá 100")
+		}
+	}
+
+	p.HappyHourStart = nullIfEmpty(c.FormValue("happy_hour_start"))
+	p.HappyHourEnd = nullIfEmpty(c.FormValue("happy_hour_end"))
+	for _, t := range []*string{p.HappyHourStart, p.HappyHourEnd} {
+		if t == nil {
+			continue
+		}
+		if _, err := time.Parse("15:04", *t); err != nil {
+			return nil, nil, fmt.Errorf("Khung giờ không hợp lệ: %s", *t)
+		}
+	}
+	if (p.HappyHourStart == nil) != (p.HappyHourEnd == nil) {
+		return nil, nil, fmt.Errorf("Vui lòng nhập cả giờ bắt đầu và giờ kết thúc")
+	}
+	if days := strings.TrimSpace(c.FormValue("days_of_week")); days != "" {
+		for _, d := range strings.Split(days, ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(d)); err != nil || n < 1 || n > 7 {
+				return nil, nil, fmt.Errorf("Ngày trong tuần không hợp lệ: %s", d)
+			}
+		}
+		p.DaysOfWeek = &days
+	}
+
+	var inputs []promotionItemInput
+	if itemsJSON := c.FormValue("items_json"); itemsJSON != "" {
+		if err := json.Unmarshal([]byte(itemsJSON), &inputs); err != nil {
+			return nil, nil, fmt.Errorf("Danh sách sản phẩm áp dụng không hợp lệ")
+		}
+	}
+	items := make([]models.PromotionItem, 0, len(inputs))
+	for _, in := range inputs {
+		if in.Brand != nil && strings.TrimSpace(*in.Brand) == "" {
+			in.Brand = nil
+		}
+		targets := 0
+		for _, set := range []bool{in.ProductID != nil, in.CategoryID != nil, in.Brand != nil} {
+			if set {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return nil, nil, fmt.Errorf("Mỗi dòng áp dụng phải chọn đúng một sản phẩm, danh mục hoặc thương hiệu")
+		}
+		if in.Quantity <= 0 {
+			in.Quantity = 1
+		}
+		items = append(items, models.PromotionItem{
+			ProductID:  in.ProductID,
+			CategoryID: in.CategoryID,
+			Brand:      in.Brand,
+			Quantity:   in.Quantity,
+		})
+	}
+
+	if err := validatePromotion(p, items); err != nil {
+		return nil, nil, err
+	}
+	return p, items, nil
+}
+
+// validatePromotion checks that the parameters of the promotion's mechanic are set
+func validatePromotion(p *models.Promotion, items []models.PromotionItem) error {
+	switch p.PromotionType {
+	case models.PromotionBuyXGetY:
+		if p.BuyQuantity == nil || *p.BuyQuantity < 1 || p.GetQuantity == nil || *p.GetQuantity < 1 {
+			return fmt.Errorf("Mua X tặng Y cần số lượng mua và số lượng tặng")
+		}
+	case models.PromotionMultiBuy:
+		if p.BundleQuantity == nil || *p.BundleQuantity < 2 || p.BundlePrice == nil || *p.BundlePrice <= 0 {
+			return fmt.Errorf("Mua nhiều giá tốt cần số lượng (từ 2) và giá trọn gói")
+		}
+	case models.PromotionCombo:
+		if p.ComboPrice == nil || *p.ComboPrice <= 0 {
+			return fmt.Errorf("Combo cần giá combo")
+		}
+		if len(items) < 2 {
+			return fmt.Errorf("Combo cần ít nhất hai sản phẩm")
+		}
+		for _, item := range items {
+			if item.ProductID == nil {
+				return fmt.Errorf("Combo chỉ gồm các sản phẩm cụ thể")
+			}
+		}
+	case models.PromotionSpendThreshold:
+		if p.MinSpend == nil || *p.MinSpend <= 0 {
+			return fmt.Errorf("Giảm theo ngưỡng cần giá trị hóa đơn tối thiểu")
+		}
+		if (p.DiscountPercent == nil || *p.DiscountPercent <= 0) == (p.DiscountAmount == nil || *p.DiscountAmount <= 0) {
+			return fmt.Errorf("Giảm theo ngưỡng cần đúng một trong phần trăm giảm hoặc số tiền giảm")
+		}
+	case models.PromotionPercentOff:
+		if p.DiscountPercent == nil || *p.DiscountPercent <= 0 {
+			return fmt.Errorf("Cần nhập phần trăm giảm")
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("Cần chọn sản phẩm, danh mục hoặc thương hiệu được giảm")
+		}
+	case models.PromotionHappyHour:
+		if p.DiscountPercent == nil || *p.DiscountPercent <= 0 {
+			return fmt.Errorf("Cần nhập phần trăm giảm")
+		}
+		if p.HappyHourStart == nil {
+			return fmt.Errorf("Giờ vàng cần khung giờ áp dụng")
+		}
+	}
+	return nil
+}
+
+// savePromotionItems replaces the items of a promotion
+func savePromotionItems(tx *gorm.DB, promotionID uint, items []models.PromotionItem) error {
+	if err := tx.Exec("DELETE FROM supermarket.promotion_items WHERE promotion_id = $1", promotionID).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		err := tx.Exec(`
+			INSERT INTO supermarket.promotion_items (promotion_id, product_id, category_id, brand, quantity)
+			VALUES ($1, $2, $3, $4, $5)
+		`, promotionID, item.ProductID, item.CategoryID, item.Brand, item.Quantity).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promotionFormData loads the lists the promotion form picks targets from
+func promotionFormData(db *gorm.DB) fiber.Map {
+	var products []models.Product
+	db.Raw(`
+		SELECT product_id, product_code, product_name, brand
+		FROM supermarket.products
+		WHERE is_active = true
+		ORDER BY product_name
+	`).Scan(&products)
+
+	var categories []models.ProductCategory
+	db.Raw("SELECT category_id, category_name FROM supermarket.product_categories ORDER BY category_name").Scan(&categories)
+
+	var brands []string
+	db.Raw(`
+		SELECT DISTINCT brand
+		FROM supermarket.products
+		WHERE brand IS NOT NULL AND brand <> ''
+		ORDER BY brand
+	`).Scan(&brands)
+
+	types := make([]fiber.Map, 0, len(promotionTypeLabels))
+	for t, label := range promotionTypeLabels {
+		types = append(types, fiber.Map{"Value": string(t), "Label": label})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i]["Value"].(string) < types[j]["Value"].(string) })
+
+	return fiber.Map{
+		"Products":   products,
+		"Categories": categories,
+		"Brands":     brands,
+		"Types":      types,
+	}
+}
+
+// promotionRow is a promotion with its usage totals for the list page
+type promotionRow struct {
+	models.Promotion
+	TypeLabel     string  `json:"type_label"`
+	ItemCount     int     `json:"item_count"`
+	LineCount     int     `json:"line_count"`
+	DiscountGiven float64 `json:"discount_given"`
+	Status        string  `json:"status"`
+}
+
+// PromotionList displays all promotions with their status and cost so far
+func PromotionList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var rows []promotionRow
+	err := db.Raw(`
+		SELECT p.*,
+			(SELECT COUNT(*) FROM supermarket.promotion_items pi WHERE pi.promotion_id = p.promotion_id) as item_count,
+			COALESCE(u.line_count, 0) as line_count,
+			COALESCE(u.discount_given, 0) as discount_given
+		FROM supermarket.promotions p
+		LEFT JOIN (
+			SELECT sip.promotion_id, COUNT(*) as line_count, SUM(sip.discount_amount) as discount_given
+			FROM supermarket.sales_invoice_promotions sip
+			JOIN supermarket.sales_invoice_details sid ON sip.detail_id = sid.detail_id
+			JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+			WHERE si.status = 'COMPLETED'
+			GROUP BY sip.promotion_id
+		) u ON u.promotion_id = p.promotion_id
+		ORDER BY p.is_active DESC, p.end_date DESC, p.priority DESC
+	`).Scan(&rows).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách khuyến mãi: " + err.Error(),
+		})
+	}
+
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	for i := range rows {
+		rows[i].TypeLabel = promotionTypeLabels[rows[i].PromotionType]
+		switch {
+		case !rows[i].IsActive:
+			rows[i].Status = "INACTIVE"
+		case rows[i].StartDate.Format("2006-01-02") > today:
+			rows[i].Status = "SCHEDULED"
+		case rows[i].EndDate.Format("2006-01-02") < today:
+			rows[i].Status = "ENDED"
+		default:
+			rows[i].Status = "RUNNING"
+		}
+	}
+
+	return c.Render("pages/promotions/list", fiber.Map{
+		"Title":           "Chương trình khuyến mãi",
+		"Active":          "promotions",
+		"Promotions":      rows,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// PromotionNew displays the new promotion form
+func PromotionNew(c *fiber.Ctx) error {
+	data := promotionFormData(database.GetDB())
+	data["Title"] = "Thêm chương trình khuyến mãi"
+	data["Active"] = "promotions"
+	data["IsNew"] = true
+	data["ItemsJSON"] = "[]"
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/promotions/form", data, "layouts/base")
+}
+
+// PromotionCreate creates a promotion with its items
+func PromotionCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	p, items, err := parsePromotionForm(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var promotionID uint
+	err = tx.Raw(`
+		INSERT INTO supermarket.promotions
+		(promotion_code, promotion_name, promotion_type, description, start_date, end_date, priority, stackable, is_active,
+		 buy_quantity, get_quantity, get_discount_percent, bundle_quantity, bundle_price, combo_price,
+		 min_spend, discount_percent, discount_amount, max_discount_amount,
+		 happy_hour_start, happy_hour_end, days_of_week, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+		        CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING promotion_id
+	`, p.PromotionCode, p.PromotionName, p.PromotionType, p.Description, p.StartDate, p.EndDate, p.Priority, p.Stackable, p.IsActive,
+		p.BuyQuantity, p.GetQuantity, p.GetDiscountPercent, p.BundleQuantity, p.BundlePrice, p.ComboPrice,
+		p.MinSpend, p.DiscountPercent, p.DiscountAmount, p.MaxDiscountAmount,
+		p.HappyHourStart, p.HappyHourEnd, p.DaysOfWeek).Scan(&promotionID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể tạo chương trình khuyến mãi: " + err.Error(),
+		})
+	}
+
+	if err := savePromotionItems(tx, promotionID, items); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể lưu sản phẩm áp dụng: " + err.Error(),
+		})
+	}
+
+	tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'promotions', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypePromotionCreated, fmt.Sprintf("Tạo khuyến mãi %s (%s)", p.PromotionCode, p.PromotionType), promotionID)
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/promotions/%d", promotionID))
+}
+
+// promotionItemView is a promotion item with the name of its target
+type promotionItemView struct {
+	models.PromotionItem
+	ProductCode  *string `json:"product_code"`
+	ProductName  *string `json:"product_name"`
+	CategoryName *string `json:"category_name"`
+}
+
+// loadPromotion loads a promotion and its items
+func loadPromotion(db *gorm.DB, id string) (*models.Promotion, []promotionItemView, error) {
+	var p models.Promotion
+	if err := db.Raw("SELECT * FROM supermarket.promotions WHERE promotion_id = $1", id).Scan(&p).Error; err != nil {
+		return nil, nil, err
+	}
+	if p.PromotionID == 0 {
+		return nil, nil, fmt.Errorf("Không tìm thấy chương trình khuyến mãi")
+	}
+
+	var items []promotionItemView
+	err := db.Raw(`
+		SELECT pi.*, pr.product_code, pr.product_name, pc.category_name
+		FROM supermarket.promotion_items pi
+		LEFT JOIN supermarket.products pr ON pi.product_id = pr.product_id
+		LEFT JOIN supermarket.product_categories pc ON pi.category_id = pc.category_id
+		WHERE pi.promotion_id = $1
+		ORDER BY pi.promotion_item_id
+	`, p.PromotionID).Scan(&items).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return &p, items, nil
+}
+
+// PromotionView displays a promotion, its targets and the invoices it was applied to
+func PromotionView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	p, items, err := loadPromotion(db, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": err.Error(),
+			"Code":  404,
+		})
+	}
+
+	var usage struct {
+		InvoiceCount   int64   `json:"invoice_count"`
+		UnitsSold      int64   `json:"units_sold"`
+		FreeUnits      int64   `json:"free_units"`
+		DiscountAmount float64 `json:"discount_amount"`
+		Revenue        float64 `json:"revenue"`
+	}
+	db.Raw(`
+		SELECT COUNT(DISTINCT si.invoice_id) as invoice_count,
+			COALESCE(SUM(sid.quantity), 0) as units_sold,
+			COALESCE(SUM(sip.free_quantity), 0) as free_units,
+			COALESCE(SUM(sip.discount_amount), 0) as discount_amount,
+			COALESCE(SUM(sid.subtotal), 0) as revenue
+		FROM supermarket.sales_invoice_promotions sip
+		JOIN supermarket.sales_invoice_details sid ON sip.detail_id = sid.detail_id
+		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+		WHERE sip.promotion_id = $1 AND si.status = 'COMPLETED'
+	`, p.PromotionID).Scan(&usage)
+
+	var recent []struct {
+		InvoiceID      uint      `json:"invoice_id"`
+		InvoiceNo      string    `json:"invoice_no"`
+		InvoiceDate    time.Time `json:"invoice_date"`
+		ProductName    string    `json:"product_name"`
+		Quantity       int       `json:"quantity"`
+		FreeQuantity   int       `json:"free_quantity"`
+		DiscountAmount float64   `json:"discount_amount"`
+	}
+	db.Raw(`
+		SELECT si.invoice_id, si.invoice_no, si.invoice_date, pr.product_name,
+			sid.quantity, sip.free_quantity, sip.discount_amount
+		FROM supermarket.sales_invoice_promotions sip
+		JOIN supermarket.sales_invoice_details sid ON sip.detail_id = sid.detail_id
+		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+		JOIN supermarket.products pr ON sid.product_id = pr.product_id
+		WHERE sip.promotion_id = $1
+		ORDER BY si.invoice_date DESC
+		LIMIT 50
+	`, p.PromotionID).Scan(&recent)
+
+	return c.Render("pages/promotions/view", fiber.Map{
+		"Title":           "Chi tiết khuyến mãi",
+		"Active":          "promotions",
+		"Promotion":       p,
+		"TypeLabel":       promotionTypeLabels[p.PromotionType],
+		"Items":           items,
+		"Usage":           usage,
+		"Recent":          recent,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// PromotionEdit displays the edit form of a promotion
+func PromotionEdit(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	p, items, err := loadPromotion(db, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": err.Error(),
+			"Code":  404,
+		})
+	}
+
+	inputs := make([]promotionItemInput, 0, len(items))
+	for _, item := range items {
+		inputs = append(inputs, promotionItemInput{
+			ProductID:  item.ProductID,
+			CategoryID: item.CategoryID,
+			Brand:      item.Brand,
+			Quantity:   item.Quantity,
+		})
+	}
+	itemsJSON, _ := json.Marshal(inputs)
+
+	data := promotionFormData(db)
+	data["Title"] = "Sửa chương trình khuyến mãi"
+	data["Active"] = "promotions"
+	data["IsNew"] = false
+	data["Promotion"] = p
+	data["ItemsJSON"] = string(itemsJSON)
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/promotions/form", data, "layouts/base")
+}
+
+// PromotionUpdate updates a promotion and replaces its items. Invoices keep the
+// discounts they were sold with.
+func PromotionUpdate(c *fiber.Ctx) error {
+	db := database.GetDB()
+	id := c.Params("id")
+
+	p, items, err := parsePromotionForm(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var promotionID uint
+	err = tx.Raw(`
+		UPDATE supermarket.promotions
+		SET promotion_code = $1, promotion_name = $2, promotion_type = $3, description = $4,
+			start_date = $5, end_date = $6, priority = $7, stackable = $8, is_active = $9,
+			buy_quantity = $10, get_quantity = $11, get_discount_percent = $12,
+			bundle_quantity = $13, bundle_price = $14, combo_price = $15,
+			min_spend = $16, discount_percent = $17, discount_amount = $18, max_discount_amount = $19,
+			happy_hour_start = $20, happy_hour_end = $21, days_of_week = $22,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE promotion_id = $23
+		RETURNING promotion_id
+	`, p.PromotionCode, p.PromotionName, p.PromotionType, p.Description, p.StartDate, p.EndDate, p.Priority, p.Stackable, p.IsActive,
+		p.BuyQuantity, p.GetQuantity, p.GetDiscountPercent, p.BundleQuantity, p.BundlePrice, p.ComboPrice,
+		p.MinSpend, p.DiscountPercent, p.DiscountAmount, p.MaxDiscountAmount,
+		p.HappyHourStart, p.HappyHourEnd, p.DaysOfWeek, id).Scan(&promotionID).Error
+	if err != nil || promotionID == 0 {
+		tx.Rollback()
+		message := "Không tìm thấy chương trình khuyến mãi"
+		if err != nil {
+			message = "Không thể cập nhật chương trình khuyến mãi: " + err.Error()
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	if err := savePromotionItems(tx, promotionID, items); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể lưu sản phẩm áp dụng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/promotions/%d", promotionID))
+}
+
+// PromotionDelete deletes a promotion that was never applied; used promotions are
+// deactivated instead so the invoices keep their history
+func PromotionDelete(c *fiber.Ctx) error {
+	db := database.GetDB()
+	id := c.Params("id")
+
+	var used int64
+	db.Raw("SELECT COUNT(*) FROM supermarket.sales_invoice_promotions WHERE promotion_id = $1", id).Scan(&used)
+	if used > 0 {
+		if err := db.Exec("UPDATE supermarket.promotions SET is_active = false, updated_at = CURRENT_TIMESTAMP WHERE promotion_id = $1", id).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể tắt chương trình khuyến mãi: " + err.Error()})
+		}
+		return c.JSON(fiber.Map{"success": true, "deactivated": true, "message": "Chương trình đã được áp dụng cho hóa đơn nên chỉ được tắt"})
+	}
+
+	tx := db.Begin()
+	if err := tx.Exec("DELETE FROM supermarket.promotion_items WHERE promotion_id = $1", id).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa chương trình khuyến mãi: " + err.Error()})
+	}
+	if err := tx.Exec("DELETE FROM supermarket.promotions WHERE promotion_id = $1", id).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa chương trình khuyến mãi: " + err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể hoàn tất giao dịch: " + err.Error()})
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
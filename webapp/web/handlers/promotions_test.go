@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/supermarket/models"
+)
+
+func intPtr(v int) *int { return &v }
+
+func uintPtr(v uint) *uint { return &v }
+
+func floatPtr(v float64) *float64 { return &v }
+
+func TestApplyPromotions(t *testing.T) {
+	newQuote := func() *priceQuote {
+		return &priceQuote{Lines: []pricedLine{
+			{ProductID: 1, CategoryID: 1, Quantity: 3, UnitPrice: 10000, GrossAmount: 30000},
+			{ProductID: 2, CategoryID: 2, Quantity: 2, UnitPrice: 10000, GrossAmount: 20000},
+		}}
+	}
+	buyTwoGetOne := models.Promotion{
+		PromotionID:   1,
+		PromotionType: models.PromotionBuyXGetY,
+		BuyQuantity:   intPtr(2),
+		GetQuantity:   intPtr(1),
+		Items:         []models.PromotionItem{{ProductID: uintPtr(1)}},
+	}
+	spendThreshold := models.Promotion{
+		PromotionID:    2,
+		PromotionType:  models.PromotionSpendThreshold,
+		MinSpend:       floatPtr(50000),
+		DiscountAmount: floatPtr(5000),
+		Stackable:      true,
+	}
+	percentOff := models.Promotion{
+		PromotionID:     3,
+		PromotionType:   models.PromotionPercentOff,
+		DiscountPercent: floatPtr(10),
+	}
+
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		want       []float64
+		total      float64
+	}{
+		{"buy 2 get 1 free", []models.Promotion{buyTwoGetOne}, []float64{10000, 0}, 10000},
+		{"spend threshold spread by line amount", []models.Promotion{spendThreshold}, []float64{3000, 2000}, 5000},
+		{"spend threshold not reached after buy x get y", []models.Promotion{buyTwoGetOne, spendThreshold}, []float64{10000, 0}, 10000},
+		{"non-stackable promotion skips promoted lines", []models.Promotion{buyTwoGetOne, percentOff}, []float64{10000, 2000}, 12000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := newQuote()
+			applyPromotions(quote, tt.promotions)
+			for i, want := range tt.want {
+				if got := quote.Lines[i].PromotionDiscountAmount; got != want {
+					t.Errorf("line %d promotion discount = %v, want %v", i, got, want)
+				}
+			}
+			if quote.PromotionDiscount != tt.total {
+				t.Errorf("quote promotion discount = %v, want %v", quote.PromotionDiscount, tt.total)
+			}
+		})
+	}
+}
@@ -107,15 +107,8 @@ func PurchaseOrderCreate(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid employee ID"})
 	}
 
-	// Generate order number
-	var count int64
-	database.DB.Model(&models.PurchaseOrder{}).Count(&count)
-	orderCounter := int(count) + 1
-	orderNo := fmt.Sprintf("PO%s%03d", time.Now().Format("200601"), orderCounter)
-
 	// Create purchase order
 	order = models.PurchaseOrder{
-		OrderNo:    orderNo,
 		SupplierID: uint(supplierID),
 		EmployeeID: uint(employeeID),
 		OrderDate:  time.Now(),
@@ -133,6 +126,13 @@ func PurchaseOrderCreate(c *fiber.Ctx) error {
 	// Start transaction
 	tx := database.DB.Begin()
 
+	// Allocate the order number in the same transaction as the order
+	order.OrderNo, err = nextDocumentNo(tx, models.DocPurchaseOrder, "")
+	if err != nil {
+		tx.Rollback()
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	// Create the order
 	if err := tx.Create(&order).Error; err != nil {
 		tx.Rollback()
@@ -213,16 +213,42 @@ func PurchaseOrderView(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "Purchase order not found"})
 	}
 
-	// Get order details
-	if err := database.DB.Preload("Product").Where("order_id = ?", order.OrderID).Find(&details).Error; err != nil {
+	// Get order details, in the order of the receiving progress below
+	if err := database.DB.Preload("Product").Where("order_id = ?", order.OrderID).Order("detail_id").Find(&details).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order details"})
 	}
 
+	// Receiving progress per line and the receipts posted so far
+	lines, err := loadPurchaseOrderLines(database.DB, order.OrderID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch receiving progress"})
+	}
+	var receipts []goodsReceiptRow
+	database.DB.Raw(`
+		SELECT r.*, w.warehouse_name, e.full_name as receiver_name,
+		       COALESCE(SUM(l.received_quantity - l.rejected_quantity), 0) as accepted,
+		       COALESCE(SUM(l.rejected_quantity), 0) as rejected
+		FROM supermarket.goods_receipts r
+		JOIN supermarket.warehouse w ON r.warehouse_id = w.warehouse_id
+		JOIN supermarket.employees e ON r.received_by = e.employee_id
+		LEFT JOIN supermarket.goods_receipt_lines l ON l.receipt_id = r.receipt_id
+		WHERE r.order_id = $1
+		GROUP BY r.receipt_id, w.warehouse_name, e.full_name
+		ORDER BY r.received_at
+	`, order.OrderID).Scan(&receipts)
+
+	var employees []models.Employee
+	database.DB.Where("is_active = ?", true).Order("full_name").Find(&employees)
+
 	return c.Render("pages/purchase_orders/view", fiber.Map{
 		"Title":           "Chi tiết đơn đặt hàng",
 		"Active":          "purchase-orders",
 		"Order":           order,
 		"Details":         details,
+		"Lines":           lines,
+		"Receipts":        receipts,
+		"Employees":       employees,
+		"StatusLabels":    purchaseOrderStatusLabels,
 		"SQLQueries":      c.Locals("SQLQueries"),
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
@@ -302,8 +328,15 @@ func PurchaseOrderUpdate(c *fiber.Ctx) error {
 		}
 	}
 
-	if status := c.FormValue("status"); status != "" {
-		order.Status = models.OrderStatus(status)
+	// Once goods have been received the status follows the receipts, and the lines they
+	// were received against can no longer change
+	hasReceipts := order.Status.HasReceipts()
+	if status := c.FormValue("status"); status != "" && models.OrderStatus(status) != order.Status {
+		next := models.OrderStatus(status)
+		if hasReceipts || next.HasReceipts() {
+			return c.Status(400).JSON(fiber.Map{"error": "Trạng thái nhận hàng được cập nhật theo phiếu nhập kho"})
+		}
+		order.Status = next
 	}
 
 	if notes := c.FormValue("notes"); notes != "" {
@@ -332,6 +365,11 @@ func PurchaseOrderUpdate(c *fiber.Ctx) error {
 	unitPriceStr := c.FormValue("unit_price[]")
 
 	if productIDStr != "" && quantityStr != "" && unitPriceStr != "" {
+		if hasReceipts {
+			tx.Rollback()
+			return c.Status(400).JSON(fiber.Map{"error": "Không thể sửa sản phẩm của đơn hàng đã nhập kho"})
+		}
+
 		// Delete existing details
 		if err := tx.Where("order_id = ?", order.OrderID).Delete(&models.PurchaseOrderDetail{}).Error; err != nil {
 			tx.Rollback()
@@ -392,6 +430,12 @@ func PurchaseOrderDelete(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid order ID"})
 	}
 
+	var receipts int64
+	database.DB.Model(&models.GoodsReceipt{}).Where("order_id = ?", id).Count(&receipts)
+	if receipts > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Không thể xóa đơn hàng đã có phiếu nhập kho"})
+	}
+
 	// Start transaction
 	tx := database.DB.Begin()
 
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/config"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"github.com/supermarket/receipt"
+	"gorm.io/gorm"
+)
+
+// receiptSettings is the store header and till printer, set at startup by ConfigureReceipts
+var receiptSettings = config.ReceiptConfig{
+	StoreName:      "Siêu thị ABC",
+	StoreAddress:   "123 Đường ABC, Quận 1, TP.HCM",
+	StorePhone:     "(028) 1234-5678",
+	PrinterTimeout: 5 * time.Second,
+}
+
+// ConfigureReceipts applies the receipt configuration loaded at startup
+func ConfigureReceipts(cfg config.ReceiptConfig) {
+	if cfg.PrinterTimeout <= 0 {
+		cfg.PrinterTimeout = receiptSettings.PrinterTimeout
+	}
+	receiptSettings = cfg
+}
+
+// paymentMethodLabels are the tender names printed on receipts
+var paymentMethodLabels = map[models.PaymentMethod]string{
+	models.PaymentCash:     "Tiền mặt",
+	models.PaymentCard:     "Thẻ",
+	models.PaymentTransfer: "Chuyển khoản",
+	models.PaymentVoucher:  "Voucher",
+	models.PaymentMixed:    "Nhiều hình thức",
+}
+
+// loadReceipt reads an invoice with its lines, tax breakdown and tenders into a printable receipt
+func loadReceipt(db *gorm.DB, invoiceID uint64) (*receipt.Receipt, error) {
+	var invoice struct {
+		models.SalesInvoice
+		CustomerName *string `json:"customer_name"`
+		EmployeeName string  `json:"employee_name"`
+		RegisterCode *string `json:"register_code"`
+	}
+	result := db.Raw(`
+		SELECT si.*, c.full_name as customer_name, e.full_name as employee_name, rs.register_code
+		FROM supermarket.sales_invoices si
+		LEFT JOIN supermarket.customers c ON si.customer_id = c.customer_id
+		LEFT JOIN supermarket.register_sessions rs ON si.session_id = rs.session_id
+		JOIN supermarket.employees e ON si.employee_id = e.employee_id
+		WHERE si.invoice_id = $1
+	`, invoiceID).Scan(&invoice)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("không tìm thấy hóa đơn")
+	}
+
+	r := &receipt.Receipt{
+		StoreName:      receiptSettings.StoreName,
+		StoreAddress:   receiptSettings.StoreAddress,
+		StorePhone:     receiptSettings.StorePhone,
+		StoreTaxCode:   receiptSettings.StoreTaxCode,
+		InvoiceNo:      invoice.InvoiceNo,
+		InvoiceDate:    invoice.InvoiceDate,
+		Cashier:        invoice.EmployeeName,
+		Status:         string(invoice.Status),
+		Subtotal:       invoice.Subtotal,
+		DiscountAmount: invoice.DiscountAmount,
+		TotalAmount:    invoice.TotalAmount,
+		Rounding:       invoice.RoundingAdjustment,
+		ChangeAmount:   invoice.ChangeAmount,
+		PointsUsed:     invoice.PointsUsed,
+		PointsEarned:   invoice.PointsEarned,
+	}
+	if invoice.CustomerName != nil {
+		r.CustomerName = *invoice.CustomerName
+	}
+	if invoice.RegisterCode != nil {
+		r.RegisterCode = *invoice.RegisterCode
+	}
+	if invoice.Notes != nil {
+		r.Notes = *invoice.Notes
+	}
+
+	var lines []struct {
+		ProductCode    string  `json:"product_code"`
+		ProductName    string  `json:"product_name"`
+		Unit           string  `json:"unit"`
+		Quantity       int     `json:"quantity"`
+		UnitPrice      float64 `json:"unit_price"`
+		DiscountAmount float64 `json:"discount_amount"`
+		Subtotal       float64 `json:"subtotal"`
+		TaxRate        float64 `json:"tax_rate"`
+	}
+	if err := db.Raw(`
+		SELECT p.product_code, p.product_name, COALESCE(p.unit, 'cái') as unit,
+			sid.quantity, sid.unit_price, sid.discount_amount, sid.subtotal, sid.tax_rate
+		FROM supermarket.sales_invoice_details sid
+		JOIN supermarket.products p ON sid.product_id = p.product_id
+		WHERE sid.invoice_id = $1
+		ORDER BY sid.detail_id
+	`, invoiceID).Scan(&lines).Error; err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		r.Lines = append(r.Lines, receipt.Line{
+			ProductCode: l.ProductCode,
+			ProductName: l.ProductName,
+			Unit:        l.Unit,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+			Discount:    l.DiscountAmount,
+			Amount:      l.Subtotal,
+			TaxRate:     l.TaxRate,
+		})
+	}
+
+	for _, t := range loadInvoiceTaxBreakdown(db, invoiceID) {
+		r.Taxes = append(r.Taxes, receipt.TaxLine{
+			Rate:      t.TaxRate,
+			Inclusive: t.TaxMode == models.TaxInclusive,
+			Taxable:   t.TaxableAmount,
+			Tax:       t.TaxAmount,
+		})
+	}
+
+	for _, p := range loadInvoicePayments(db, invoiceID) {
+		label, ok := paymentMethodLabels[p.PaymentMethod]
+		if !ok {
+			label = string(p.PaymentMethod)
+		}
+		payment := receipt.Payment{Label: label, Amount: p.Amount}
+		if p.Reference != nil {
+			payment.Reference = *p.Reference
+		}
+		r.Payments = append(r.Payments, payment)
+	}
+
+	return r, nil
+}
+
+// receiptFromRequest loads the receipt of the :id invoice, answering the request itself on failure
+func receiptFromRequest(c *fiber.Ctx) (*receipt.Receipt, error) {
+	invoiceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID hóa đơn không hợp lệ"})
+	}
+	r, err := loadReceipt(database.GetDB(), invoiceID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Không tìm thấy hóa đơn"})
+	}
+	return r, nil
+}
+
+// SalesReceiptESCPOS downloads the invoice as an ESC/POS print job for 80mm thermal printers
+func SalesReceiptESCPOS(c *fiber.Ctx) error {
+	r, err := receiptFromRequest(c)
+	if r == nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.bin"`, r.InvoiceNo))
+	return c.Send(receipt.ESCPOS(r))
+}
+
+// SalesReceiptPDF downloads the invoice as a PDF document
+func SalesReceiptPDF(c *fiber.Ctx) error {
+	r, err := receiptFromRequest(c)
+	if r == nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, r.InvoiceNo))
+	return c.Send(receipt.PDF(r))
+}
+
+// SalesReceiptPrint sends the receipt to the configured network till printer
+func SalesReceiptPrint(c *fiber.Ctx) error {
+	if receiptSettings.PrinterAddr == "" {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Chưa cấu hình máy in hóa đơn (RECEIPT_PRINTER_ADDR)",
+		})
+	}
+	r, err := receiptFromRequest(c)
+	if r == nil {
+		return err
+	}
+
+	job := receipt.ESCPOS(r)
+	if err := receipt.Send(receiptSettings.PrinterAddr, job, receiptSettings.PrinterTimeout); err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": fmt.Sprintf("Đã gửi hóa đơn %s tới máy in %s", r.InvoiceNo, receiptSettings.PrinterAddr),
+		"bytes":   len(job),
+	})
+}
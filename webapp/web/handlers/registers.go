@@ -0,0 +1,566 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// registerTenderMethods are the lines shown on every X/Z report, in display order
+var registerTenderMethods = []models.PaymentMethod{
+	models.PaymentCash,
+	models.PaymentCard,
+	models.PaymentTransfer,
+	models.PaymentVoucher,
+}
+
+// registerReportLine is the expected vs counted amount for one payment method of a session
+type registerReportLine struct {
+	PaymentMethod  models.PaymentMethod `json:"payment_method"`
+	InvoiceCount   int                  `json:"invoice_count"`
+	ReturnCount    int                  `json:"return_count"`
+	RefundAmount   float64              `json:"refund_amount"`
+	ExpectedAmount float64              `json:"expected_amount"`
+	CountedAmount  float64              `json:"counted_amount"`
+	Variance       float64              `json:"variance"`
+}
+
+// registerSessionRow is a session with the names and sales figures used on the list and report pages
+type registerSessionRow struct {
+	models.RegisterSession
+	EmployeeName   string  `json:"employee_name"`
+	ClosedByName   *string `json:"closed_by_name"`
+	Variance       float64 `json:"variance"`
+	InvoiceCount   int     `json:"invoice_count"`
+	SalesTotal     float64 `json:"sales_total"`
+	ChangeGiven    float64 `json:"change_given"`
+	RoundingAmount float64 `json:"rounding_amount"`
+}
+
+const registerSessionSelect = `
+	SELECT rs.*,
+		e.full_name as employee_name,
+		ce.full_name as closed_by_name,
+		COALESCE(rs.cash_variance, 0) as variance,
+		COALESCE(s.invoice_count, 0) as invoice_count,
+		COALESCE(s.sales_total, 0) as sales_total,
+		COALESCE(s.change_given, 0) as change_given,
+		COALESCE(s.rounding_amount, 0) as rounding_amount
+	FROM supermarket.register_sessions rs
+	JOIN supermarket.employees e ON rs.employee_id = e.employee_id
+	LEFT JOIN supermarket.employees ce ON rs.closed_by = ce.employee_id
+	LEFT JOIN (
+		SELECT session_id,
+			COUNT(*) as invoice_count,
+			SUM(total_amount) as sales_total,
+			SUM(change_amount) as change_given,
+			SUM(rounding_adjustment) as rounding_amount
+		FROM supermarket.sales_invoices
+		WHERE status = 'COMPLETED' AND session_id IS NOT NULL
+		GROUP BY session_id
+	) s ON s.session_id = rs.session_id
+`
+
+// resolveRegisterSession returns the open session a sale should be booked on: the requested
+// one, or the employee's own open session. The row is locked FOR SHARE so the till cannot be
+// closed while the sale is being written.
+func resolveRegisterSession(tx *gorm.DB, sessionIDStr string, employeeID uint) (uint, error) {
+	var session models.RegisterSession
+
+	if sessionIDStr != "" {
+		sessionID, err := strconv.ParseUint(sessionIDStr, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("ID ca thu ngân không hợp lệ")
+		}
+		err = tx.Raw(`
+			SELECT * FROM supermarket.register_sessions
+			WHERE session_id = $1
+			FOR SHARE
+		`, sessionID).Scan(&session).Error
+		if err != nil {
+			return 0, err
+		}
+		if session.SessionID == 0 {
+			return 0, fmt.Errorf("Không tìm thấy ca thu ngân")
+		}
+	} else {
+		err := tx.Raw(`
+			SELECT * FROM supermarket.register_sessions
+			WHERE employee_id = $1 AND status = $2
+			ORDER BY opened_at DESC
+			LIMIT 1
+			FOR SHARE
+		`, employeeID, models.RegisterSessionOpen).Scan(&session).Error
+		if err != nil {
+			return 0, err
+		}
+		if session.SessionID == 0 {
+			return 0, fmt.Errorf("Chưa mở ca thu ngân cho nhân viên này. Vui lòng mở ca trước khi bán hàng")
+		}
+	}
+
+	if !session.IsOpen() {
+		return 0, fmt.Errorf("Ca thu ngân %s đã đóng, không thể ghi nhận thêm hóa đơn", session.RegisterCode)
+	}
+
+	return session.SessionID, nil
+}
+
+// registerExpectedTotals computes what should be in the till for each payment method:
+// the tenders of the session's completed invoices less the refunds paid out of the session
+// for returns, plus the opening float for cash
+func registerExpectedTotals(db *gorm.DB, session models.RegisterSession) ([]registerReportLine, error) {
+	var rows []struct {
+		PaymentMethod  models.PaymentMethod
+		InvoiceCount   int
+		ExpectedAmount float64
+	}
+	err := db.Raw(`
+		SELECT sip.payment_method,
+			COUNT(DISTINCT sip.invoice_id) as invoice_count,
+			COALESCE(SUM(sip.amount), 0) as expected_amount
+		FROM supermarket.sales_invoice_payments sip
+		JOIN supermarket.sales_invoices si ON sip.invoice_id = si.invoice_id
+		WHERE si.session_id = $1 AND si.status = 'COMPLETED'
+		GROUP BY sip.payment_method
+	`, session.SessionID).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var refunds []struct {
+		RefundMethod models.PaymentMethod
+		ReturnCount  int
+		RefundAmount float64
+	}
+	err = db.Raw(`
+		SELECT refund_method,
+			COUNT(*) as return_count,
+			COALESCE(SUM(refund_amount), 0) as refund_amount
+		FROM supermarket.sales_returns
+		WHERE session_id = $1
+		GROUP BY refund_method
+	`, session.SessionID).Scan(&refunds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byMethod := make(map[models.PaymentMethod]int)
+	lines := make([]registerReportLine, 0, len(registerTenderMethods))
+	for _, method := range registerTenderMethods {
+		byMethod[method] = len(lines)
+		lines = append(lines, registerReportLine{PaymentMethod: method})
+	}
+	lineFor := func(method models.PaymentMethod) *registerReportLine {
+		i, ok := byMethod[method]
+		if !ok {
+			byMethod[method] = len(lines)
+			lines = append(lines, registerReportLine{PaymentMethod: method})
+			i = len(lines) - 1
+		}
+		return &lines[i]
+	}
+	for _, r := range rows {
+		line := lineFor(r.PaymentMethod)
+		line.InvoiceCount = r.InvoiceCount
+		line.ExpectedAmount = roundVND(r.ExpectedAmount)
+	}
+	for _, r := range refunds {
+		line := lineFor(r.RefundMethod)
+		line.ReturnCount = r.ReturnCount
+		line.RefundAmount = roundVND(r.RefundAmount)
+		line.ExpectedAmount = roundVND(line.ExpectedAmount - r.RefundAmount)
+	}
+	lines[byMethod[models.PaymentCash]].ExpectedAmount = roundVND(lines[byMethod[models.PaymentCash]].ExpectedAmount + session.OpeningFloat)
+
+	return lines, nil
+}
+
+// RegisterSessionList displays the till sessions and the form to open a new one
+func RegisterSessionList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	status := c.Query("status")
+
+	query := registerSessionSelect
+	args := []interface{}{}
+	if status != "" {
+		query += " WHERE rs.status = $1"
+		args = append(args, status)
+	}
+	query += " ORDER BY rs.status DESC, rs.opened_at DESC LIMIT 100"
+
+	var sessions []registerSessionRow
+	if err := db.Raw(query, args...).Scan(&sessions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách ca thu ngân: " + err.Error(),
+		})
+	}
+
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	return c.Render("pages/registers/list", fiber.Map{
+		"Title":           "Ca thu ngân",
+		"Active":          "sales",
+		"Sessions":        sessions,
+		"Employees":       employees,
+		"Status":          status,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// RegisterSessionOpen opens a till session for an employee with a starting float
+func RegisterSessionOpen(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	registerCode := strings.ToUpper(strings.TrimSpace(c.FormValue("register_code")))
+	if registerCode == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập mã quầy thu ngân",
+		})
+	}
+
+	employeeID, err := strconv.ParseUint(c.FormValue("employee_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên thu ngân",
+		})
+	}
+
+	openingFloat := 0.0
+	if v := strings.TrimSpace(c.FormValue("opening_float")); v != "" {
+		openingFloat, err = strconv.ParseFloat(v, 64)
+		if err != nil || openingFloat < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Tiền đầu ca không hợp lệ",
+			})
+		}
+	}
+	notes := c.FormValue("notes")
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var employee models.Employee
+	err = tx.Raw("SELECT employee_id, full_name, is_active FROM supermarket.employees WHERE employee_id = $1", employeeID).Scan(&employee).Error
+	if err != nil || employee.EmployeeID == 0 || !employee.IsActive {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Nhân viên không tồn tại hoặc đã nghỉ việc",
+		})
+	}
+
+	var openCount int64
+	tx.Raw(`
+		SELECT COUNT(*) FROM supermarket.register_sessions
+		WHERE register_code = $1 AND status = $2
+	`, registerCode, models.RegisterSessionOpen).Scan(&openCount)
+	if openCount > 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Quầy %s đang có ca chưa đóng", registerCode),
+		})
+	}
+
+	var sessionID uint
+	err = tx.Raw(`
+		INSERT INTO supermarket.register_sessions
+		(register_code, employee_id, opened_at, opening_float, status, notes, created_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING session_id
+	`, registerCode, employeeID, openingFloat, models.RegisterSessionOpen, nullIfEmpty(notes)).Scan(&sessionID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể mở ca thu ngân: " + err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'register_sessions', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeRegisterOpened,
+		fmt.Sprintf("Mở ca quầy %s - %s - Tiền đầu ca: %.0f VNĐ", registerCode, employee.FullName, openingFloat),
+		sessionID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi nhật ký: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":    true,
+			"session_id": sessionID,
+			"message":    "Mở ca thu ngân thành công",
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/registers/%d", sessionID))
+}
+
+// RegisterSessionView displays the X report of an open session or the Z report of a closed one
+func RegisterSessionView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID ca thu ngân không hợp lệ",
+			"Code":  400,
+		})
+	}
+
+	var session registerSessionRow
+	err = db.Raw(registerSessionSelect+" WHERE rs.session_id = $1", sessionID).Scan(&session).Error
+	if err != nil || session.SessionID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy ca thu ngân",
+			"Code":  404,
+		})
+	}
+
+	// A closed session reports the totals frozen at closing; an open one is computed live
+	var lines []registerReportLine
+	if session.IsOpen() {
+		lines, err = registerExpectedTotals(db, session.RegisterSession)
+	} else {
+		err = db.Raw(`
+			SELECT payment_method, invoice_count, return_count, refund_amount, expected_amount, counted_amount, variance
+			FROM supermarket.register_session_totals
+			WHERE session_id = $1
+			ORDER BY total_id
+		`, sessionID).Scan(&lines).Error
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tính báo cáo ca: " + err.Error(),
+		})
+	}
+
+	var expectedTotal, countedTotal, varianceTotal float64
+	for _, l := range lines {
+		expectedTotal += l.ExpectedAmount
+		countedTotal += l.CountedAmount
+		varianceTotal += l.Variance
+	}
+
+	var invoices []struct {
+		InvoiceID     uint    `json:"invoice_id"`
+		InvoiceNo     string  `json:"invoice_no"`
+		InvoiceDate   string  `json:"invoice_date"`
+		Status        string  `json:"status"`
+		PaymentMethod string  `json:"payment_method"`
+		TotalAmount   float64 `json:"total_amount"`
+	}
+	db.Raw(`
+		SELECT invoice_id, invoice_no, TO_CHAR(invoice_date, 'DD/MM/YYYY HH24:MI') as invoice_date,
+			status, payment_method, total_amount
+		FROM supermarket.sales_invoices
+		WHERE session_id = $1
+		ORDER BY invoice_date DESC
+	`, sessionID).Scan(&invoices)
+
+	reportType := "Z"
+	if session.IsOpen() {
+		reportType = "X"
+	}
+
+	return c.Render("pages/registers/view", fiber.Map{
+		"Title":           fmt.Sprintf("Báo cáo %s - Quầy %s", reportType, session.RegisterCode),
+		"Active":          "sales",
+		"Session":         session,
+		"ReportType":      reportType,
+		"Lines":           lines,
+		"ExpectedTotal":   expectedTotal,
+		"CountedTotal":    countedTotal,
+		"VarianceTotal":   varianceTotal,
+		"Invoices":        invoices,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// RegisterSessionClose closes a session with the counted amounts and freezes the Z report.
+// Once closed, the session lock trigger rejects any further invoice on it.
+func RegisterSessionClose(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID ca thu ngân không hợp lệ",
+		})
+	}
+
+	countedCashStr := strings.TrimSpace(c.FormValue("counted_cash"))
+	if countedCashStr == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập số tiền mặt đếm được",
+		})
+	}
+
+	var closedBy *uint
+	if employeeIDStr := c.FormValue("closed_by"); employeeIDStr != "" {
+		employeeID, err := strconv.ParseUint(employeeIDStr, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "ID nhân viên không hợp lệ",
+			})
+		}
+		eid := uint(employeeID)
+		closedBy = &eid
+	}
+	notes := strings.TrimSpace(c.FormValue("notes"))
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Lock the session so no sale can be booked on it while it is being counted
+	var session models.RegisterSession
+	err = tx.Raw(`
+		SELECT * FROM supermarket.register_sessions
+		WHERE session_id = $1
+		FOR UPDATE
+	`, sessionID).Scan(&session).Error
+	if err != nil || session.SessionID == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy ca thu ngân",
+		})
+	}
+	if !session.IsOpen() {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Ca thu ngân đã được đóng",
+		})
+	}
+	if closedBy == nil {
+		closedBy = &session.EmployeeID
+	}
+
+	lines, err := registerExpectedTotals(tx, session)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tính báo cáo ca: " + err.Error(),
+		})
+	}
+
+	// Cash must be counted; other methods default to the expected amount (settlement slips)
+	var expectedCash, countedCash float64
+	for i := range lines {
+		l := &lines[i]
+		l.CountedAmount = l.ExpectedAmount
+		field := "counted_" + strings.ToLower(string(l.PaymentMethod))
+		if v := strings.TrimSpace(c.FormValue(field)); v != "" {
+			counted, err := strconv.ParseFloat(v, 64)
+			if err != nil || counted < 0 {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Số tiền đếm được không hợp lệ: %s", v),
+				})
+			}
+			l.CountedAmount = roundVND(counted)
+		}
+		l.Variance = roundVND(l.CountedAmount - l.ExpectedAmount)
+
+		if l.PaymentMethod == models.PaymentCash {
+			expectedCash = l.ExpectedAmount
+			countedCash = l.CountedAmount
+		}
+
+		err = tx.Exec(`
+			INSERT INTO supermarket.register_session_totals
+			(session_id, payment_method, invoice_count, return_count, refund_amount, expected_amount, counted_amount, variance, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+		`, session.SessionID, l.PaymentMethod, l.InvoiceCount, l.ReturnCount, l.RefundAmount, l.ExpectedAmount, l.CountedAmount, l.Variance).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể lưu báo cáo ca: " + err.Error(),
+			})
+		}
+	}
+	cashVariance := roundVND(countedCash - expectedCash)
+
+	err = tx.Exec(`
+		UPDATE supermarket.register_sessions
+		SET status = $1, closed_at = CURRENT_TIMESTAMP, closed_by = $2,
+			expected_cash = $3, counted_cash = $4, cash_variance = $5,
+			notes = COALESCE($6, notes)
+		WHERE session_id = $7
+	`, models.RegisterSessionClosed, closedBy, expectedCash, countedCash, cashVariance,
+		nullIfEmpty(notes), session.SessionID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể đóng ca thu ngân: " + err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'register_sessions', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeRegisterClosed,
+		fmt.Sprintf("Đóng ca quầy %s - Tiền mặt dự kiến: %.0f, thực đếm: %.0f, chênh lệch: %.0f VNĐ",
+			session.RegisterCode, expectedCash, countedCash, cashVariance),
+		session.SessionID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi nhật ký: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":       true,
+			"session_id":    session.SessionID,
+			"expected_cash": expectedCash,
+			"counted_cash":  countedCash,
+			"cash_variance": cashVariance,
+			"totals":        lines,
+			"message":       "Đóng ca thu ngân thành công",
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/registers/%d", session.SessionID))
+}
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// defaultRefillThreshold is the fill percentage at or below which a shelf position is refilled
+const defaultRefillThreshold = 50
+
+// replenishmentFilter narrows the replenishment plan. WarehouseID restricts where stock is
+// picked from; ShelfID and CategoryID restrict the shelves refilled.
+type replenishmentFilter struct {
+	WarehouseID uint
+	ShelfID     uint
+	CategoryID  uint
+	Threshold   int
+}
+
+// replenishmentPosition is a shelf position to refill with what the warehouses can cover
+type replenishmentPosition struct {
+	LayoutID        uint    `json:"layout_id"`
+	ShelfID         uint    `json:"shelf_id"`
+	ShelfCode       string  `json:"shelf_code"`
+	ShelfName       string  `json:"shelf_name"`
+	PositionCode    string  `json:"position_code"`
+	ProductID       uint    `json:"product_id"`
+	ProductCode     string  `json:"product_code"`
+	ProductName     string  `json:"product_name"`
+	MaxQuantity     int     `json:"max_quantity"`
+	CurrentQuantity int     `json:"current_quantity"`
+	FillPercentage  float64 `json:"fill_percentage"`
+	RefillQuantity  int     `json:"refill_quantity"`
+	Planned         int     `json:"planned"`
+	Shortfall       int     `json:"shortfall"`
+}
+
+// replenishmentBatch is a warehouse batch the planner can pick from
+type replenishmentBatch struct {
+	transferAllocation
+	WarehouseID   uint
+	WarehouseName string
+	ProductID     uint
+}
+
+// pickListLine is one batch to pick for one shelf position
+type pickListLine struct {
+	LayoutID      uint       `json:"layout_id"`
+	InventoryID   uint       `json:"inventory_id"`
+	WarehouseID   uint       `json:"warehouse_id"`
+	WarehouseName string     `json:"warehouse_name"`
+	ShelfCode     string     `json:"shelf_code"`
+	ShelfName     string     `json:"shelf_name"`
+	PositionCode  string     `json:"position_code"`
+	ProductCode   string     `json:"product_code"`
+	ProductName   string     `json:"product_name"`
+	BatchCode     string     `json:"batch_code"`
+	ExpiryDate    *time.Time `json:"expiry_date,omitempty"`
+	Quantity      int        `json:"quantity"`
+}
+
+// pickListShelf is the part of a pick list going to one shelf
+type pickListShelf struct {
+	ShelfCode string         `json:"shelf_code"`
+	ShelfName string         `json:"shelf_name"`
+	Lines     []pickListLine `json:"lines"`
+}
+
+// pickListWarehouse is the part of a pick list picked in one warehouse
+type pickListWarehouse struct {
+	WarehouseID   uint            `json:"warehouse_id"`
+	WarehouseName string          `json:"warehouse_name"`
+	Units         int             `json:"units"`
+	Shelves       []pickListShelf `json:"shelves"`
+}
+
+// replenishmentPick is a confirmed pick list line
+type replenishmentPick struct {
+	LayoutID    uint `json:"layout_id"`
+	InventoryID uint `json:"inventory_id"`
+	Quantity    int  `json:"quantity"`
+}
+
+// replenishmentConfirmRequest is the body of a pick list confirmation: JSON with a list of
+// picks, or the pick list form with a pick_<layout id>_<inventory id> quantity per line
+type replenishmentConfirmRequest struct {
+	EmployeeID uint                `json:"employee_id" form:"employee_id"`
+	Picks      []replenishmentPick `json:"picks" form:"-"`
+}
+
+func parseReplenishmentFilter(c *fiber.Ctx) replenishmentFilter {
+	filter := replenishmentFilter{
+		WarehouseID: uint(c.QueryInt("warehouse_id")),
+		ShelfID:     uint(c.QueryInt("shelf_id")),
+		CategoryID:  uint(c.QueryInt("category_id")),
+		Threshold:   c.QueryInt("threshold", defaultRefillThreshold),
+	}
+	if filter.Threshold < 1 || filter.Threshold > 100 {
+		filter.Threshold = defaultRefillThreshold
+	}
+	return filter
+}
+
+// planReplenishment lists the shelf positions at or below the fill threshold, plus those of
+// products the low shelf view flags, and allocates their refill quantities over the unexpired
+// warehouse batches first expiry first out. The emptiest positions are served first when
+// positions compete for the same stock.
+func planReplenishment(db *gorm.DB, filter replenishmentFilter) ([]replenishmentPosition, []pickListLine, error) {
+	conditions := ""
+	args := []interface{}{filter.Threshold}
+	if filter.ShelfID != 0 {
+		args = append(args, filter.ShelfID)
+		conditions += fmt.Sprintf(" AND ds.shelf_id = $%d", len(args))
+	}
+	if filter.CategoryID != 0 {
+		args = append(args, filter.CategoryID)
+		conditions += fmt.Sprintf(" AND ds.category_id = $%d", len(args))
+	}
+
+	var positions []replenishmentPosition
+	err := db.Raw(`
+		SELECT sl.layout_id, sl.shelf_id, ds.shelf_code, ds.shelf_name, sl.position_code,
+		       sl.product_id, p.product_code, p.product_name, sl.max_quantity,
+		       COALESCE(si.current_quantity, 0) as current_quantity,
+		       ROUND(100.0 * COALESCE(si.current_quantity, 0) / sl.max_quantity, 2) as fill_percentage
+		FROM supermarket.shelf_layout sl
+		JOIN supermarket.display_shelves ds ON sl.shelf_id = ds.shelf_id
+		JOIN supermarket.products p ON sl.product_id = p.product_id
+		LEFT JOIN supermarket.shelf_inventory si ON sl.shelf_id = si.shelf_id AND sl.product_id = si.product_id
+		WHERE ds.is_active = true AND p.is_active = true
+		  AND COALESCE(si.current_quantity, 0) < sl.max_quantity
+		  AND (COALESCE(si.current_quantity, 0) * 100 <= sl.max_quantity * $1
+		       OR sl.product_id IN (SELECT product_id FROM supermarket.v_low_shelf_products))
+		`+conditions+`
+		ORDER BY fill_percentage, ds.shelf_code, sl.position_code
+	`, args...).Scan(&positions).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("Không thể tải vị trí cần bổ sung: %v", err)
+	}
+	if len(positions) == 0 {
+		return positions, nil, nil
+	}
+
+	warehouseCondition := ""
+	batchArgs := []interface{}{}
+	if filter.WarehouseID != 0 {
+		batchArgs = append(batchArgs, filter.WarehouseID)
+		warehouseCondition = " AND wi.warehouse_id = $1"
+	}
+	var rows []replenishmentBatch
+	err = db.Raw(`
+		SELECT wi.inventory_id, wi.warehouse_id, w.warehouse_name, wi.product_id, wi.batch_code,
+		       wi.expiry_date, wi.import_price, wi.quantity as available
+		FROM supermarket.warehouse_inventory wi
+		JOIN supermarket.warehouse w ON wi.warehouse_id = w.warehouse_id
+		WHERE wi.quantity > 0
+		  AND (wi.expiry_date IS NULL OR wi.expiry_date >= CURRENT_DATE)
+		  AND wi.product_id IN (SELECT product_id FROM supermarket.shelf_layout)
+		`+warehouseCondition+`
+		ORDER BY wi.product_id, wi.expiry_date NULLS LAST, wi.import_date, wi.inventory_id
+	`, batchArgs...).Scan(&rows).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("Không thể tải tồn kho theo lô: %v", err)
+	}
+
+	batchesByProduct := make(map[uint][]transferAllocation)
+	warehouses := make(map[uint]replenishmentBatch, len(rows))
+	for _, row := range rows {
+		batchesByProduct[row.ProductID] = append(batchesByProduct[row.ProductID], row.transferAllocation)
+		warehouses[row.InventoryID] = row
+	}
+
+	var pickList []pickListLine
+	for i := range positions {
+		position := &positions[i]
+		position.RefillQuantity = position.MaxQuantity - position.CurrentQuantity
+		allocation, shortfall, _ := allocateTransferBatches(batchesByProduct[position.ProductID], position.RefillQuantity, "")
+		position.Shortfall = shortfall
+		position.Planned = position.RefillQuantity - shortfall
+		for _, line := range allocation {
+			batch := warehouses[line.InventoryID]
+			pickList = append(pickList, pickListLine{
+				LayoutID:      position.LayoutID,
+				InventoryID:   line.InventoryID,
+				WarehouseID:   batch.WarehouseID,
+				WarehouseName: batch.WarehouseName,
+				ShelfCode:     position.ShelfCode,
+				ShelfName:     position.ShelfName,
+				PositionCode:  position.PositionCode,
+				ProductCode:   position.ProductCode,
+				ProductName:   position.ProductName,
+				BatchCode:     line.BatchCode,
+				ExpiryDate:    line.ExpiryDate,
+				Quantity:      line.Quantity,
+			})
+		}
+	}
+
+	// Pick in walking order: warehouse, then shelf, then position
+	sort.SliceStable(pickList, func(a, b int) bool {
+		x, y := pickList[a], pickList[b]
+		if x.WarehouseName != y.WarehouseName {
+			return x.WarehouseName < y.WarehouseName
+		}
+		if x.WarehouseID != y.WarehouseID {
+			return x.WarehouseID < y.WarehouseID
+		}
+		if x.ShelfCode != y.ShelfCode {
+			return x.ShelfCode < y.ShelfCode
+		}
+		return x.PositionCode < y.PositionCode
+	})
+	return positions, pickList, nil
+}
+
+// groupPickList groups a sorted pick list by warehouse and then shelf
+func groupPickList(lines []pickListLine) []pickListWarehouse {
+	var groups []pickListWarehouse
+	for _, line := range lines {
+		if len(groups) == 0 || groups[len(groups)-1].WarehouseID != line.WarehouseID {
+			groups = append(groups, pickListWarehouse{WarehouseID: line.WarehouseID, WarehouseName: line.WarehouseName})
+		}
+		warehouse := &groups[len(groups)-1]
+		if len(warehouse.Shelves) == 0 || warehouse.Shelves[len(warehouse.Shelves)-1].ShelfCode != line.ShelfCode {
+			warehouse.Shelves = append(warehouse.Shelves, pickListShelf{ShelfCode: line.ShelfCode, ShelfName: line.ShelfName})
+		}
+		shelf := &warehouse.Shelves[len(warehouse.Shelves)-1]
+		shelf.Lines = append(shelf.Lines, line)
+		warehouse.Units += line.Quantity
+	}
+	return groups
+}
+
+// ReplenishmentPlan shows the shelf positions to refill and the pick list that covers them
+func ReplenishmentPlan(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	filter := parseReplenishmentFilter(c)
+	positions, pickList, err := planReplenishment(db, filter)
+	if err != nil {
+		if c.Get("Accept") == "application/json" {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": err.Error(),
+		})
+	}
+	groups := groupPickList(pickList)
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"positions": positions,
+			"pick_list": groups,
+		})
+	}
+
+	refill, planned, short := 0, 0, 0
+	for _, position := range positions {
+		refill += position.RefillQuantity
+		planned += position.Planned
+		short += position.Shortfall
+	}
+
+	data := stockCountFormData(db)
+	data["Title"] = "Bổ sung hàng lên kệ"
+	data["Active"] = "inventory"
+	data["Filter"] = filter
+	data["Positions"] = positions
+	data["PositionCount"] = len(positions)
+	data["PickList"] = groups
+	data["RefillUnits"] = refill
+	data["PlannedUnits"] = planned
+	data["ShortUnits"] = short
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/inventory/replenishment", data, "layouts/base")
+}
+
+// parseReplenishmentConfirmRequest reads and checks a pick list confirmation
+func parseReplenishmentConfirmRequest(c *fiber.Ctx) (*replenishmentConfirmRequest, error) {
+	var req replenishmentConfirmRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, fmt.Errorf("Dữ liệu không hợp lệ: %v", err)
+	}
+	if req.EmployeeID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn nhân viên soạn hàng")
+	}
+
+	if c.Get("Content-Type") != "application/json" {
+		var parseErr error
+		c.Request().PostArgs().VisitAll(func(key, value []byte) {
+			name := string(key)
+			if !strings.HasPrefix(name, "pick_") || parseErr != nil {
+				return
+			}
+			ids := strings.Split(strings.TrimPrefix(name, "pick_"), "_")
+			if len(ids) != 2 {
+				return
+			}
+			layoutID, err1 := strconv.ParseUint(ids[0], 10, 64)
+			inventoryID, err2 := strconv.ParseUint(ids[1], 10, 64)
+			quantity, err3 := strconv.Atoi(strings.TrimSpace(string(value)))
+			if err1 != nil || err2 != nil || err3 != nil {
+				parseErr = fmt.Errorf("Số lượng soạn không hợp lệ")
+				return
+			}
+			req.Picks = append(req.Picks, replenishmentPick{
+				LayoutID:    uint(layoutID),
+				InventoryID: uint(inventoryID),
+				Quantity:    quantity,
+			})
+		})
+		if parseErr != nil {
+			return nil, parseErr
+		}
+	}
+
+	picks := req.Picks[:0]
+	for _, pick := range req.Picks {
+		if pick.Quantity < 0 {
+			return nil, fmt.Errorf("Số lượng soạn không được âm")
+		}
+		// A line set to zero was not picked
+		if pick.Quantity > 0 {
+			picks = append(picks, pick)
+		}
+	}
+	req.Picks = picks
+	if len(req.Picks) == 0 {
+		return nil, fmt.Errorf("Phiếu soạn hàng không có dòng nào")
+	}
+	return &req, nil
+}
+
+// ReplenishmentConfirm executes a confirmed pick list: the lines of each shelf position picked
+// in the same warehouse become one stock transfer with a line per batch. Either the whole
+// pick list is transferred or nothing is.
+func ReplenishmentConfirm(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	req, err := parseReplenishmentConfirmRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	type transferKey struct {
+		LayoutID    uint
+		WarehouseID uint
+	}
+	// Transfers are written in the order the picks came in, the pick list's walking order
+	var keys []transferKey
+	transfers := make(map[transferKey]*stockTransferRequest)
+	allocations := make(map[transferKey][]transferAllocation)
+	layouts := make(map[uint]*models.ShelfLayout)
+
+	for _, pick := range req.Picks {
+		layout, ok := layouts[pick.LayoutID]
+		if !ok {
+			var found models.ShelfLayout
+			tx.Raw("SELECT * FROM supermarket.shelf_layout WHERE layout_id = $1", pick.LayoutID).Scan(&found)
+			if found.LayoutID == 0 {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không tìm thấy vị trí trên kệ"})
+			}
+			layout = &found
+			layouts[pick.LayoutID] = layout
+		}
+
+		batch, err := loadStockBatch(tx, models.StockLocationWarehouse, pick.InventoryID, true)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if batch.ProductID != layout.ProductID {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Lô %s không phải sản phẩm của vị trí %s", batch.BatchCode, layout.PositionCode),
+			})
+		}
+		if batch.ExpiryDate != nil && batch.ExpiryDate.Before(time.Now().Truncate(24*time.Hour)) {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Lô %s của %s đã hết hạn", batch.BatchCode, batch.ProductName),
+			})
+		}
+
+		key := transferKey{LayoutID: layout.LayoutID, WarehouseID: *batch.WarehouseID}
+		if _, ok := transfers[key]; !ok {
+			keys = append(keys, key)
+			transfers[key] = &stockTransferRequest{
+				ProductID:       layout.ProductID,
+				FromWarehouseID: *batch.WarehouseID,
+				ToShelfID:       layout.ShelfID,
+				EmployeeID:      req.EmployeeID,
+				Notes:           "Bổ sung theo phiếu soạn hàng, vị trí " + layout.PositionCode,
+			}
+		}
+		allocations[key] = append(allocations[key], transferAllocation{
+			InventoryID: batch.ID,
+			BatchCode:   batch.BatchCode,
+			ExpiryDate:  batch.ExpiryDate,
+			ImportPrice: batch.ImportPrice,
+			Available:   batch.Quantity,
+			Quantity:    pick.Quantity,
+		})
+	}
+
+	// A batch picked for several positions must hold all of them
+	picked := make(map[uint]int)
+	for _, lines := range allocations {
+		for _, line := range lines {
+			picked[line.InventoryID] += line.Quantity
+			if picked[line.InventoryID] > line.Available {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Lô %s chỉ còn %d sản phẩm, vui lòng lập lại phiếu soạn hàng", line.BatchCode, line.Available),
+				})
+			}
+		}
+	}
+
+	var headers []*models.StockTransferHeader
+	units := 0
+	for _, key := range keys {
+		header, err := executeStockTransfer(tx, transfers[key], allocations[key])
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Không thể bổ sung vị trí %s: %v", layouts[key.LayoutID].PositionCode, err),
+			})
+		}
+		headers = append(headers, header)
+		units += header.Quantity
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	message := fmt.Sprintf("Đã bổ sung %d sản phẩm cho %d vị trí bằng %d phiếu chuyển hàng", units, len(layouts), len(headers))
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":   true,
+			"transfers": headers,
+			"message":   message,
+		})
+	}
+
+	return c.Redirect("/inventory/transfers")
+}
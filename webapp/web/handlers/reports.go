@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/supermarket/database"
+	"github.com/supermarket/models"
 )
 
 // ReportsOverview displays the main reports dashboard
@@ -34,7 +36,7 @@ func ReportsOverview(c *fiber.Ctx) error {
 			COUNT(DISTINCT customer_id) as total_customers,
 			COALESCE(AVG(total_amount), 0) as avg_invoice_value
 		FROM supermarket.sales_invoices
-		WHERE DATE(invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(invoice_date) BETWEEN $1 AND $2 AND status = 'COMPLETED'
 	`, dateFrom, dateTo).Scan(&stats).Error
 
 	if err != nil {
@@ -53,7 +55,7 @@ func ReportsOverview(c *fiber.Ctx) error {
 		FROM supermarket.sales_invoice_details sid
 		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
 		JOIN supermarket.products p ON sid.product_id = p.product_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 		GROUP BY p.product_id, p.product_name
 		ORDER BY SUM(sid.quantity) DESC
 		LIMIT 1
@@ -70,7 +72,7 @@ func ReportsOverview(c *fiber.Ctx) error {
 		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
 		JOIN supermarket.products p ON sid.product_id = p.product_id
 		LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 		GROUP BY pc.category_id, pc.category_name
 		ORDER BY SUM(sid.subtotal) DESC
 		LIMIT 1
@@ -85,7 +87,7 @@ func ReportsOverview(c *fiber.Ctx) error {
 		SELECT e.full_name as employee_name
 		FROM supermarket.sales_invoices si
 		JOIN supermarket.employees e ON si.employee_id = e.employee_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 		GROUP BY e.employee_id, e.full_name
 		ORDER BY SUM(si.total_amount) DESC
 		LIMIT 1
@@ -105,7 +107,7 @@ func ReportsOverview(c *fiber.Ctx) error {
             SUM(total_amount) as revenue,
             COUNT(invoice_id) as invoices
         FROM supermarket.sales_invoices
-        WHERE DATE(invoice_date) BETWEEN $1 AND $2
+        WHERE DATE(invoice_date) BETWEEN $1 AND $2 AND status = 'COMPLETED'
         GROUP BY DATE(invoice_date)
         ORDER BY DATE(invoice_date) DESC
         LIMIT 7
@@ -149,6 +151,9 @@ func SalesReport(c *fiber.Ctx) error {
 		TotalTax        float64 `json:"total_tax"`
 		PointsEarned    int64   `json:"points_earned"`
 		PointsUsed      int64   `json:"points_used"`
+		TotalRefunds    float64 `json:"total_refunds"`
+		NetRevenue      float64 `json:"net_revenue"`
+		CashRounding    float64 `json:"cash_rounding"`
 	}
 
 	err := db.Raw(`
@@ -160,9 +165,10 @@ func SalesReport(c *fiber.Ctx) error {
 			COALESCE(SUM(si.discount_amount), 0) as total_discount,
 			COALESCE(SUM(si.tax_amount), 0) as total_tax,
 			COALESCE(SUM(si.points_earned), 0) as points_earned,
-			COALESCE(SUM(si.points_used), 0) as points_used
+			COALESCE(SUM(si.points_used), 0) as points_used,
+			COALESCE(SUM(si.rounding_adjustment), 0) as cash_rounding
 		FROM supermarket.sales_invoices si
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 	`, dateFrom, dateTo).Scan(&summary).Error
 
 	if err != nil {
@@ -172,6 +178,20 @@ func SalesReport(c *fiber.Ctx) error {
 		})
 	}
 
+	// Refunds issued in the period are deducted from revenue
+	err = db.Raw(`
+		SELECT COALESCE(SUM(sr.refund_amount), 0)
+		FROM supermarket.sales_returns sr
+		WHERE DATE(sr.return_date) BETWEEN $1 AND $2
+	`, dateFrom, dateTo).Scan(&summary.TotalRefunds).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải thống kê trả hàng: " + err.Error(),
+		})
+	}
+	summary.NetRevenue = summary.TotalRevenue - summary.TotalRefunds
+
 	// Daily/Weekly/Monthly sales trend
 	var trendData []struct {
 		Period    string  `json:"period"`
@@ -190,7 +210,7 @@ func SalesReport(c *fiber.Ctx) error {
 				COUNT(DISTINCT si.invoice_id) as invoices,
 				COUNT(DISTINCT si.customer_id) as customers
 			FROM supermarket.sales_invoices si
-			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 			GROUP BY DATE_TRUNC('week', si.invoice_date)
 			ORDER BY DATE_TRUNC('week', si.invoice_date) DESC
 		`
@@ -202,7 +222,7 @@ func SalesReport(c *fiber.Ctx) error {
 				COUNT(DISTINCT si.invoice_id) as invoices,
 				COUNT(DISTINCT si.customer_id) as customers
 			FROM supermarket.sales_invoices si
-			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 			GROUP BY DATE_TRUNC('month', si.invoice_date)
 			ORDER BY DATE_TRUNC('month', si.invoice_date) DESC
 		`
@@ -214,7 +234,7 @@ func SalesReport(c *fiber.Ctx) error {
 				COUNT(DISTINCT si.invoice_id) as invoices,
 				COUNT(DISTINCT si.customer_id) as customers
 			FROM supermarket.sales_invoices si
-			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 			GROUP BY DATE(si.invoice_date)
 			ORDER BY DATE(si.invoice_date) DESC
 		`
@@ -252,7 +272,7 @@ func SalesReport(c *fiber.Ctx) error {
 		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
 		JOIN supermarket.products p ON sid.product_id = p.product_id
 		LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 		GROUP BY p.product_id, p.product_code, p.product_name, pc.category_name
 		ORDER BY total_sold DESC
 		LIMIT 10
@@ -265,24 +285,27 @@ func SalesReport(c *fiber.Ctx) error {
 		})
 	}
 
-	// Payment method analysis
+	// Payment method analysis, aggregated per tender so split payments count towards each method
 	var paymentMethods []struct {
 		PaymentMethod string  `json:"payment_method"`
 		Count         int64   `json:"count"`
 		TotalAmount   float64 `json:"total_amount"`
+		ChangeGiven   float64 `json:"change_given"`
 		Percentage    float64 `json:"percentage"`
 	}
 
 	err = db.Raw(`
 		SELECT 
-			COALESCE(si.payment_method, 'UNKNOWN') as payment_method,
-			COUNT(*) as count,
-			SUM(si.total_amount) as total_amount,
-			ROUND(COUNT(*) * 100.0 / SUM(COUNT(*)) OVER(), 2) as percentage
-		FROM supermarket.sales_invoices si
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
-		GROUP BY si.payment_method
-		ORDER BY count DESC
+			t.payment_method,
+			COUNT(DISTINCT t.invoice_id) as count,
+			SUM(t.amount) as total_amount,
+			SUM(t.change_amount) as change_given,
+			ROUND(SUM(t.amount) * 100.0 / NULLIF(SUM(SUM(t.amount)) OVER(), 0), 2) as percentage
+		FROM supermarket.v_invoice_tenders t
+		JOIN supermarket.sales_invoices si ON t.invoice_id = si.invoice_id
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
+		GROUP BY t.payment_method
+		ORDER BY total_amount DESC
 	`, dateFrom, dateTo).Scan(&paymentMethods).Error
 
 	if err != nil {
@@ -310,7 +333,7 @@ func SalesReport(c *fiber.Ctx) error {
 			AVG(si.total_amount) as avg_invoice
 		FROM supermarket.sales_invoices si
 		JOIN supermarket.employees e ON si.employee_id = e.employee_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 		GROUP BY e.employee_id, e.full_name
 		ORDER BY total_revenue DESC
 	`, dateFrom, dateTo).Scan(&employeePerformance).Error
@@ -379,7 +402,7 @@ func ProductReport(c *fiber.Ctx) error {
 		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
 		JOIN supermarket.products p ON sid.product_id = p.product_id
 		LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 	`
 
 	args := []interface{}{dateFrom, dateTo}
@@ -438,7 +461,7 @@ func ProductReport(c *fiber.Ctx) error {
         JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
         JOIN supermarket.products p ON sid.product_id = p.product_id
         LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
     `
 	topRevArgs := []interface{}{dateFrom, dateTo}
 	topRevIdx := 3
@@ -480,7 +503,7 @@ func ProductReport(c *fiber.Ctx) error {
         JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
         JOIN supermarket.products p ON sid.product_id = p.product_id
         LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
     `
 	topUnitArgs := []interface{}{dateFrom, dateTo}
 	topUnitIdx := 3
@@ -558,7 +581,7 @@ func RevenueReport(c *fiber.Ctx) error {
             AVG(si.total_amount) as avg_invoice,
             COUNT(DISTINCT si.customer_id) as total_customers
         FROM supermarket.sales_invoices si
-        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
         GROUP BY DATE(si.invoice_date)
         ORDER BY DATE(si.invoice_date) DESC
     `, dateFrom, dateTo).Scan(&revenueData).Error
@@ -588,7 +611,7 @@ func RevenueReport(c *fiber.Ctx) error {
 		JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
 		JOIN supermarket.products p ON sid.product_id = p.product_id
 		LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
 		GROUP BY pc.category_name
 		ORDER BY total_revenue DESC
 	`, dateFrom, dateTo).Scan(&categoryRevenue).Error
@@ -619,7 +642,7 @@ func RevenueReport(c *fiber.Ctx) error {
 			COUNT(invoice_id) as total_invoices,
 			AVG(total_amount) as avg_invoice_value
 		FROM supermarket.sales_invoices
-		WHERE DATE(invoice_date) BETWEEN $1 AND $2
+		WHERE DATE(invoice_date) BETWEEN $1 AND $2 AND status = 'COMPLETED'
 	`, dateFrom, dateTo).Scan(&summary).Error
 
 	if err != nil {
@@ -676,7 +699,7 @@ func SupplierReport(c *fiber.Ctx) error {
         JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
         JOIN supermarket.products p ON sid.product_id = p.product_id
         JOIN supermarket.suppliers s ON p.supplier_id = s.supplier_id
-        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2
+        WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
         GROUP BY s.supplier_id, s.supplier_name, s.phone
         ORDER BY total_revenue DESC
     `, dateFrom, dateTo).Scan(&supplierRevenue).Error
@@ -728,6 +751,7 @@ func CustomerReport(c *fiber.Ctx) error {
 		LEFT JOIN supermarket.membership_levels ml ON c.membership_level_id = ml.level_id
 		LEFT JOIN supermarket.sales_invoices si ON c.customer_id = si.customer_id 
 			AND DATE(si.invoice_date) BETWEEN $1 AND $2
+			AND si.status = 'COMPLETED'
 		WHERE 1=1
 	`
 
@@ -795,3 +819,369 @@ func CustomerReport(c *fiber.Ctx) error {
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
 }
+
+// PromotionReport displays what each promotion cost in discounts over a period and the
+// sales uplift of the products it covers. Uplift compares the average daily units and
+// revenue of the covered products while the promotion ran against the same number of
+// days just before it started.
+func PromotionReport(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	dateFrom := c.Query("date_from", time.Now().AddDate(0, -1, 0).Format("2006-01-02"))
+	dateTo := c.Query("date_to", time.Now().Format("2006-01-02"))
+
+	var rows []struct {
+		PromotionID        uint      `json:"promotion_id"`
+		PromotionCode      string    `json:"promotion_code"`
+		PromotionName      string    `json:"promotion_name"`
+		PromotionType      string    `json:"promotion_type"`
+		StartDate          time.Time `json:"start_date"`
+		EndDate            time.Time `json:"end_date"`
+		InvoiceCount       int64     `json:"invoice_count"`
+		UnitsSold          int64     `json:"units_sold"`
+		FreeUnits          int64     `json:"free_units"`
+		PromotionCost      float64   `json:"promotion_cost"`
+		PromotedRevenue    float64   `json:"promoted_revenue"`
+		Days               int       `json:"days"`
+		PromoUnits         float64   `json:"promo_units"`
+		BaselineUnits      float64   `json:"baseline_units"`
+		PromoRevenue       float64   `json:"promo_revenue"`
+		BaselineRevenue    float64   `json:"baseline_revenue"`
+		PromoDailyUnits    float64   `json:"promo_daily_units"`
+		BaselineDailyUnits float64   `json:"baseline_daily_units"`
+		HasBaseline        bool      `json:"has_baseline"`
+		UnitUplift         float64   `json:"unit_uplift"`
+		RevenueUplift      float64   `json:"revenue_uplift"`
+	}
+
+	err := db.Raw(`
+		WITH usage AS (
+			SELECT sip.promotion_id,
+				COUNT(DISTINCT si.invoice_id) as invoice_count,
+				SUM(sid.quantity) as units_sold,
+				SUM(sip.free_quantity) as free_units,
+				SUM(sip.discount_amount) as promotion_cost,
+				SUM(sid.subtotal) as promoted_revenue
+			FROM supermarket.sales_invoice_promotions sip
+			JOIN supermarket.sales_invoice_details sid ON sip.detail_id = sid.detail_id
+			JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
+			GROUP BY sip.promotion_id
+		),
+		windows AS (
+			SELECT p.promotion_id, p.start_date as promo_from,
+				LEAST(p.end_date, CURRENT_DATE) as promo_to,
+				LEAST(p.end_date, CURRENT_DATE) - p.start_date + 1 as days
+			FROM supermarket.promotions p
+			WHERE p.start_date <= CURRENT_DATE
+		),
+		covered AS (
+			SELECT DISTINCT w.promotion_id, pr.product_id
+			FROM windows w
+			JOIN supermarket.products pr ON
+				NOT EXISTS (SELECT 1 FROM supermarket.promotion_items pi WHERE pi.promotion_id = w.promotion_id)
+				OR EXISTS (
+					SELECT 1 FROM supermarket.promotion_items pi
+					WHERE pi.promotion_id = w.promotion_id
+					  AND (pi.product_id = pr.product_id
+					       OR pi.category_id = pr.category_id
+					       OR LOWER(pi.brand) = LOWER(pr.brand))
+				)
+		),
+		daily AS (
+			SELECT sid.product_id, DATE(si.invoice_date) as sale_date,
+				SUM(sid.quantity) as units, SUM(sid.subtotal) as revenue
+			FROM supermarket.sales_invoice_details sid
+			JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+			WHERE si.status = 'COMPLETED'
+			GROUP BY sid.product_id, DATE(si.invoice_date)
+		),
+		uplift AS (
+			SELECT w.promotion_id, w.days,
+				COALESCE(SUM(d.units) FILTER (WHERE d.sale_date BETWEEN w.promo_from AND w.promo_to), 0) as promo_units,
+				COALESCE(SUM(d.units) FILTER (WHERE d.sale_date BETWEEN w.promo_from - w.days AND w.promo_from - 1), 0) as baseline_units,
+				COALESCE(SUM(d.revenue) FILTER (WHERE d.sale_date BETWEEN w.promo_from AND w.promo_to), 0) as promo_revenue,
+				COALESCE(SUM(d.revenue) FILTER (WHERE d.sale_date BETWEEN w.promo_from - w.days AND w.promo_from - 1), 0) as baseline_revenue
+			FROM windows w
+			JOIN covered cv ON cv.promotion_id = w.promotion_id
+			LEFT JOIN daily d ON d.product_id = cv.product_id
+			GROUP BY w.promotion_id, w.days
+		)
+		SELECT p.promotion_id, p.promotion_code, p.promotion_name, p.promotion_type, p.start_date, p.end_date,
+			COALESCE(u.invoice_count, 0) as invoice_count,
+			COALESCE(u.units_sold, 0) as units_sold,
+			COALESCE(u.free_units, 0) as free_units,
+			COALESCE(u.promotion_cost, 0) as promotion_cost,
+			COALESCE(u.promoted_revenue, 0) as promoted_revenue,
+			COALESCE(up.days, 0) as days,
+			COALESCE(up.promo_units, 0) as promo_units,
+			COALESCE(up.baseline_units, 0) as baseline_units,
+			COALESCE(up.promo_revenue, 0) as promo_revenue,
+			COALESCE(up.baseline_revenue, 0) as baseline_revenue
+		FROM supermarket.promotions p
+		LEFT JOIN usage u ON u.promotion_id = p.promotion_id
+		LEFT JOIN uplift up ON up.promotion_id = p.promotion_id
+		WHERE u.promotion_id IS NOT NULL OR (p.start_date <= $2 AND p.end_date >= $1)
+		ORDER BY promotion_cost DESC, p.start_date DESC
+	`, dateFrom, dateTo).Scan(&rows).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải báo cáo khuyến mãi: " + err.Error(),
+		})
+	}
+
+	var totals struct {
+		PromotionCost   float64
+		PromotedRevenue float64
+		UnitsSold       int64
+		FreeUnits       int64
+	}
+	for i := range rows {
+		r := &rows[i]
+		if r.Days > 0 {
+			r.PromoDailyUnits = r.PromoUnits / float64(r.Days)
+			r.BaselineDailyUnits = r.BaselineUnits / float64(r.Days)
+		}
+		if r.BaselineUnits > 0 {
+			r.HasBaseline = true
+			r.UnitUplift = (r.PromoUnits - r.BaselineUnits) / r.BaselineUnits * 100
+			if r.BaselineRevenue > 0 {
+				r.RevenueUplift = (r.PromoRevenue - r.BaselineRevenue) / r.BaselineRevenue * 100
+			}
+		}
+		totals.PromotionCost += r.PromotionCost
+		totals.PromotedRevenue += r.PromotedRevenue
+		totals.UnitsSold += r.UnitsSold
+		totals.FreeUnits += r.FreeUnits
+	}
+
+	return c.Render("pages/reports/promotions", fiber.Map{
+		"Title":      "Báo cáo khuyến mãi",
+		"Active":     "reports",
+		"Promotions": rows,
+		"Totals":     totals,
+		"Filters": fiber.Map{
+			"DateFrom": dateFrom,
+			"DateTo":   dateTo,
+		},
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// VoucherReport reconciles the voucher ledger: the liability at the start of the period,
+// the movements by entry type, the liability at the end, and the vouchers whose stored
+// balance no longer matches the sum of their ledger entries
+func VoucherReport(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	dateFrom := c.Query("date_from", time.Now().AddDate(0, -1, 0).Format("2006-01-02"))
+	dateTo := c.Query("date_to", time.Now().Format("2006-01-02"))
+
+	var openingLiability float64
+	db.Raw(`
+		SELECT COALESCE(SUM(amount), 0)
+		FROM supermarket.voucher_transactions
+		WHERE DATE(created_at) < $1
+	`, dateFrom).Scan(&openingLiability)
+
+	var movements []struct {
+		EntryType  models.VoucherEntryType `json:"entry_type"`
+		EntryCount int64                   `json:"entry_count"`
+		Amount     float64                 `json:"amount"`
+	}
+	err := db.Raw(`
+		SELECT entry_type, COUNT(*) as entry_count, SUM(amount) as amount
+		FROM supermarket.voucher_transactions
+		WHERE DATE(created_at) BETWEEN $1 AND $2
+		GROUP BY entry_type
+		ORDER BY entry_type
+	`, dateFrom, dateTo).Scan(&movements).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải sổ voucher: " + err.Error(),
+		})
+	}
+
+	closingLiability := openingLiability
+	for _, m := range movements {
+		closingLiability += m.Amount
+	}
+
+	// What the vouchers hold right now; equals the closing liability when the period ends today
+	var outstanding float64
+	db.Raw("SELECT COALESCE(SUM(balance), 0) FROM supermarket.vouchers").Scan(&outstanding)
+
+	var mismatches []struct {
+		VoucherID     uint    `json:"voucher_id"`
+		VoucherCode   string  `json:"voucher_code"`
+		Status        string  `json:"status"`
+		Balance       float64 `json:"balance"`
+		LedgerBalance float64 `json:"ledger_balance"`
+	}
+	db.Raw(`
+		SELECT v.voucher_id, v.voucher_code, v.status, v.balance, COALESCE(SUM(vt.amount), 0) as ledger_balance
+		FROM supermarket.vouchers v
+		LEFT JOIN supermarket.voucher_transactions vt ON vt.voucher_id = v.voucher_id
+		GROUP BY v.voucher_id, v.voucher_code, v.status, v.balance
+		HAVING ABS(v.balance - COALESCE(SUM(vt.amount), 0)) > 0.005
+		ORDER BY v.voucher_code
+	`).Scan(&mismatches)
+
+	var entries []struct {
+		TransactionID uint                    `json:"transaction_id"`
+		VoucherID     uint                    `json:"voucher_id"`
+		VoucherCode   string                  `json:"voucher_code"`
+		EntryType     models.VoucherEntryType `json:"entry_type"`
+		Amount        float64                 `json:"amount"`
+		BalanceAfter  float64                 `json:"balance_after"`
+		InvoiceID     *uint                   `json:"invoice_id"`
+		InvoiceNo     string                  `json:"invoice_no"`
+		EmployeeName  string                  `json:"employee_name"`
+		CreatedAt     time.Time               `json:"created_at"`
+	}
+	db.Raw(`
+		SELECT vt.transaction_id, vt.voucher_id, v.voucher_code, vt.entry_type, vt.amount, vt.balance_after,
+			vt.invoice_id, COALESCE(si.invoice_no, '') as invoice_no, COALESCE(e.full_name, '') as employee_name,
+			vt.created_at
+		FROM supermarket.voucher_transactions vt
+		JOIN supermarket.vouchers v ON vt.voucher_id = v.voucher_id
+		LEFT JOIN supermarket.sales_invoices si ON vt.invoice_id = si.invoice_id
+		LEFT JOIN supermarket.employees e ON vt.employee_id = e.employee_id
+		WHERE DATE(vt.created_at) BETWEEN $1 AND $2
+		ORDER BY vt.created_at DESC, vt.transaction_id DESC
+		LIMIT 500
+	`, dateFrom, dateTo).Scan(&entries)
+
+	return c.Render("pages/reports/vouchers", fiber.Map{
+		"Title":            "Sổ voucher và thẻ quà tặng",
+		"Active":           "reports",
+		"OpeningLiability": openingLiability,
+		"Movements":        movements,
+		"ClosingLiability": closingLiability,
+		"Outstanding":      outstanding,
+		"EndsToday":        dateTo >= time.Now().Format("2006-01-02"),
+		"Mismatches":       mismatches,
+		"Entries":          entries,
+		"EntryLabels":      voucherEntryLabels,
+		"Filters": fiber.Map{
+			"DateFrom": dateFrom,
+			"DateTo":   dateTo,
+		},
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// VATReport lists output VAT by month and tax rate for filing: the taxable base and tax of
+// completed invoices, less the tax given back on sales returns in the same month
+func VATReport(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	now := time.Now()
+	dateFrom := c.Query("date_from", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02"))
+	dateTo := c.Query("date_to", now.Format("2006-01-02"))
+
+	type vatRow struct {
+		Period        string  `json:"period"`
+		TaxRate       float64 `json:"tax_rate"`
+		TaxMode       string  `json:"tax_mode"`
+		LineCount     int64   `json:"line_count"`
+		SalesTaxable  float64 `json:"sales_taxable"`
+		SalesTax      float64 `json:"sales_tax"`
+		ReturnTaxable float64 `json:"return_taxable"`
+		ReturnTax     float64 `json:"return_tax"`
+		NetTaxable    float64 `json:"net_taxable"`
+		NetTax        float64 `json:"net_tax"`
+	}
+
+	var rows []vatRow
+	err := db.Raw(`
+		WITH sales AS (
+			SELECT TO_CHAR(si.invoice_date, 'YYYY-MM') as period, sid.tax_rate, sid.tax_mode,
+				COUNT(*) as line_count,
+				SUM(sid.subtotal - CASE WHEN sid.tax_mode = 'INCLUSIVE' THEN sid.tax_amount ELSE 0 END) as sales_taxable,
+				SUM(sid.tax_amount) as sales_tax
+			FROM supermarket.sales_invoice_details sid
+			JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+			WHERE DATE(si.invoice_date) BETWEEN $1 AND $2 AND si.status = 'COMPLETED'
+			GROUP BY 1, 2, 3
+		),
+		returns AS (
+			SELECT TO_CHAR(sr.return_date, 'YYYY-MM') as period, sid.tax_rate, sid.tax_mode,
+				SUM(srd.refund_amount - srd.tax_amount) as return_taxable,
+				SUM(srd.tax_amount) as return_tax
+			FROM supermarket.sales_return_details srd
+			JOIN supermarket.sales_returns sr ON srd.return_id = sr.return_id
+			JOIN supermarket.sales_invoice_details sid ON srd.invoice_detail_id = sid.detail_id
+			WHERE DATE(sr.return_date) BETWEEN $1 AND $2
+			GROUP BY 1, 2, 3
+		)
+		SELECT COALESCE(s.period, r.period) as period,
+			COALESCE(s.tax_rate, r.tax_rate) as tax_rate,
+			COALESCE(s.tax_mode, r.tax_mode) as tax_mode,
+			COALESCE(s.line_count, 0) as line_count,
+			COALESCE(s.sales_taxable, 0) as sales_taxable,
+			COALESCE(s.sales_tax, 0) as sales_tax,
+			COALESCE(r.return_taxable, 0) as return_taxable,
+			COALESCE(r.return_tax, 0) as return_tax
+		FROM sales s
+		FULL OUTER JOIN returns r
+			ON s.period = r.period AND s.tax_rate = r.tax_rate AND s.tax_mode = r.tax_mode
+		ORDER BY 1, 2, 3
+	`, dateFrom, dateTo).Scan(&rows).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải báo cáo thuế: " + err.Error(),
+		})
+	}
+
+	// Totals per rate over the whole range, which is what goes on the return
+	var byRate []vatRow
+	var totals vatRow
+	rateIndex := make(map[float64]int)
+	for i := range rows {
+		r := &rows[i]
+		r.NetTaxable = r.SalesTaxable - r.ReturnTaxable
+		r.NetTax = r.SalesTax - r.ReturnTax
+
+		idx, ok := rateIndex[r.TaxRate]
+		if !ok {
+			idx = len(byRate)
+			rateIndex[r.TaxRate] = idx
+			byRate = append(byRate, vatRow{TaxRate: r.TaxRate})
+		}
+		byRate[idx].LineCount += r.LineCount
+		byRate[idx].SalesTaxable += r.SalesTaxable
+		byRate[idx].SalesTax += r.SalesTax
+		byRate[idx].ReturnTaxable += r.ReturnTaxable
+		byRate[idx].ReturnTax += r.ReturnTax
+		byRate[idx].NetTaxable += r.NetTaxable
+		byRate[idx].NetTax += r.NetTax
+
+		totals.SalesTaxable += r.SalesTaxable
+		totals.SalesTax += r.SalesTax
+		totals.ReturnTaxable += r.ReturnTaxable
+		totals.ReturnTax += r.ReturnTax
+		totals.NetTaxable += r.NetTaxable
+		totals.NetTax += r.NetTax
+	}
+	sort.Slice(byRate, func(i, j int) bool { return byRate[i].TaxRate < byRate[j].TaxRate })
+
+	return c.Render("pages/reports/vat", fiber.Map{
+		"Title":  "Báo cáo thuế GTGT đầu ra",
+		"Active": "reports",
+		"Rows":   rows,
+		"ByRate": byRate,
+		"Totals": totals,
+		"Filters": fiber.Map{
+			"DateFrom": dateFrom,
+			"DateTo":   dateTo,
+		},
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
@@ -0,0 +1,657 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// returnableLine is an invoice line together with the quantity already returned
+type returnableLine struct {
+	DetailID       uint    `json:"detail_id"`
+	ProductID      uint    `json:"product_id"`
+	ProductCode    string  `json:"product_code"`
+	ProductName    string  `json:"product_name"`
+	Quantity       int     `json:"quantity"`
+	UnitPrice      float64 `json:"unit_price"`
+	Subtotal       float64 `json:"subtotal"`
+	Payable        float64 `json:"payable"`
+	ReturnedQty    int     `json:"returned_qty"`
+	ReturnableQty  int     `json:"returnable_qty"`
+	UnitRefundable float64 `json:"unit_refundable"`
+}
+
+// SalesReturnNew displays the return form for an invoice
+func SalesReturnNew(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	invoiceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID hóa đơn không hợp lệ",
+			"Code":  400,
+		})
+	}
+
+	var invoice struct {
+		models.SalesInvoice
+		CustomerName *string `json:"customer_name"`
+	}
+	err = db.Raw(`
+		SELECT si.*, c.full_name as customer_name
+		FROM supermarket.sales_invoices si
+		LEFT JOIN supermarket.customers c ON si.customer_id = c.customer_id
+		WHERE si.invoice_id = $1
+	`, invoiceID).Scan(&invoice).Error
+	if err != nil || invoice.InvoiceID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy hóa đơn",
+			"Code":  404,
+		})
+	}
+
+	var lines []returnableLine
+	err = db.Raw(`
+		SELECT
+			sid.detail_id,
+			sid.product_id,
+			p.product_code,
+			p.product_name,
+			sid.quantity,
+			sid.unit_price,
+			sid.subtotal,
+			sid.subtotal + CASE WHEN sid.tax_mode = 'EXCLUSIVE' THEN sid.tax_amount ELSE 0 END as payable,
+			COALESCE(r.returned_qty, 0) as returned_qty
+		FROM supermarket.sales_invoice_details sid
+		JOIN supermarket.products p ON sid.product_id = p.product_id
+		LEFT JOIN (
+			SELECT invoice_detail_id, SUM(quantity) as returned_qty
+			FROM supermarket.sales_return_details
+			GROUP BY invoice_detail_id
+		) r ON r.invoice_detail_id = sid.detail_id
+		WHERE sid.invoice_id = $1
+		ORDER BY sid.detail_id
+	`, invoiceID).Scan(&lines).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải chi tiết hóa đơn: " + err.Error(),
+		})
+	}
+
+	var invoicePayable float64
+	for _, l := range lines {
+		invoicePayable += l.Payable
+	}
+	for i := range lines {
+		lines[i].ReturnableQty = lines[i].Quantity - lines[i].ReturnedQty
+		if invoicePayable > 0 && lines[i].Quantity > 0 {
+			lines[i].UnitRefundable = invoice.TotalAmount * (lines[i].Payable / float64(lines[i].Quantity)) / invoicePayable
+		}
+	}
+
+	var employees []models.Employee
+	db.Raw("SELECT employee_id, full_name FROM supermarket.employees WHERE is_active = true ORDER BY full_name").Scan(&employees)
+
+	return c.Render("pages/sales/return_form", fiber.Map{
+		"Title":           "Trả hàng - " + invoice.InvoiceNo,
+		"Active":          "sales",
+		"Invoice":         invoice,
+		"Lines":           lines,
+		"Employees":       employees,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// SalesReturnCreate records a return against an invoice, restocks or bins the goods
+// and reverses the customer's spending and loyalty points
+func SalesReturnCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	invoiceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID hóa đơn không hợp lệ",
+		})
+	}
+
+	employeeID, err := strconv.ParseUint(c.FormValue("employee_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên xử lý trả hàng",
+		})
+	}
+	reason := c.FormValue("reason")
+
+	refundMethod := models.PaymentMethod(c.FormValue("refund_method", string(models.PaymentCash)))
+	if refundMethod != models.PaymentCash && refundMethod != models.PaymentCard && refundMethod != models.PaymentTransfer {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Phương thức hoàn tiền không hợp lệ: " + string(refundMethod),
+		})
+	}
+
+	detailIDList := parseStringArray(c.FormValue("detail_ids"))
+	quantityList := parseStringArray(c.FormValue("quantities"))
+	dispositionList := parseStringArray(c.FormValue("dispositions"))
+
+	if len(detailIDList) == 0 || len(detailIDList) != len(quantityList) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn ít nhất một sản phẩm để trả",
+		})
+	}
+
+	// Start transaction
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Lock the invoice so concurrent returns cannot exceed the sold quantity
+	var invoice models.SalesInvoice
+	err = tx.Raw(`
+		SELECT * FROM supermarket.sales_invoices
+		WHERE invoice_id = $1
+		FOR UPDATE
+	`, invoiceID).Scan(&invoice).Error
+	if err != nil || invoice.InvoiceID == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy hóa đơn",
+		})
+	}
+
+	if invoice.Status != models.InvoiceCompleted {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Chỉ có thể trả hàng cho hóa đơn đã hoàn tất",
+		})
+	}
+
+	// Lines are weighted by what the customer paid for them, i.e. including tax added on top
+	var invoicePayable float64
+	err = tx.Raw(`
+		SELECT COALESCE(SUM(subtotal + CASE WHEN tax_mode = 'EXCLUSIVE' THEN tax_amount ELSE 0 END), 0)
+		FROM supermarket.sales_invoice_details
+		WHERE invoice_id = $1
+	`, invoiceID).Scan(&invoicePayable).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tải chi tiết hóa đơn: " + err.Error(),
+		})
+	}
+
+	// Cash is paid out of an open till, so the refund is booked against that session and
+	// comes off its expected cash; card and transfer refunds name a session only when given one
+	var sessionID *uint
+	if refundMethod == models.PaymentCash || c.FormValue("session_id") != "" {
+		id, err := resolveRegisterSession(tx, c.FormValue("session_id"), uint(employeeID))
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		sessionID = &id
+	}
+
+	returnNo, err := nextDocumentNo(tx, models.DocSalesReturn, "")
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var returnID uint
+	err = tx.Raw(`
+		INSERT INTO supermarket.sales_returns
+		(return_no, invoice_id, customer_id, employee_id, return_date, reason, refund_method, session_id, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, $5, $6, $7, CURRENT_TIMESTAMP)
+		RETURNING return_id
+	`, returnNo, invoiceID, invoice.CustomerID, employeeID, nullIfEmpty(reason), refundMethod, sessionID).Scan(&returnID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tạo phiếu trả hàng: " + err.Error(),
+		})
+	}
+
+	var totalRefund float64
+	for i, detailIDStr := range detailIDList {
+		detailID, err := strconv.ParseUint(detailIDStr, 10, 64)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "ID chi tiết hóa đơn không hợp lệ: " + detailIDStr,
+			})
+		}
+
+		quantity, err := strconv.Atoi(quantityList[i])
+		if err != nil || quantity < 0 {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Số lượng trả không hợp lệ: " + quantityList[i],
+			})
+		}
+		if quantity == 0 {
+			continue
+		}
+
+		disposition := models.ReturnRestock
+		if i < len(dispositionList) && dispositionList[i] == string(models.ReturnDamaged) {
+			disposition = models.ReturnDamaged
+		}
+
+		var line struct {
+			DetailID    uint
+			ProductID   uint
+			Quantity    int
+			Payable     float64
+			TaxAmount   float64
+			ReturnedQty int
+		}
+		err = tx.Raw(`
+			SELECT
+				sid.detail_id,
+				sid.product_id,
+				sid.quantity,
+				sid.subtotal + CASE WHEN sid.tax_mode = 'EXCLUSIVE' THEN sid.tax_amount ELSE 0 END as payable,
+				sid.tax_amount,
+				COALESCE((
+					SELECT SUM(srd.quantity)
+					FROM supermarket.sales_return_details srd
+					WHERE srd.invoice_detail_id = sid.detail_id
+				), 0) as returned_qty
+			FROM supermarket.sales_invoice_details sid
+			WHERE sid.detail_id = $1 AND sid.invoice_id = $2
+		`, detailID, invoiceID).Scan(&line).Error
+		if err != nil || line.DetailID == 0 {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Dòng hóa đơn không thuộc hóa đơn này: " + detailIDStr,
+			})
+		}
+
+		// Cap returned quantity at what was sold minus what has already been returned
+		if quantity > line.Quantity-line.ReturnedQty {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Số lượng trả vượt quá số lượng đã bán (đã bán: %d, đã trả: %d, yêu cầu: %d)",
+					line.Quantity, line.ReturnedQty, quantity),
+			})
+		}
+
+		// Refund the share of the paid total (after discounts and VAT) belonging to these units
+		lineRefund := 0.0
+		if invoicePayable > 0 {
+			lineRefund = invoice.TotalAmount * (line.Payable / float64(line.Quantity) * float64(quantity)) / invoicePayable
+		}
+		lineRefund = math.Round(lineRefund*100) / 100
+		// Output VAT reversed by this return, used by the VAT report
+		lineTax := math.Round(line.TaxAmount*float64(quantity)/float64(line.Quantity)*100) / 100
+
+		var shelfID *uint
+		var batchCode *string
+		if disposition == models.ReturnRestock {
+			shelfID, batchCode, err = restockReturnedGoods(tx, line.DetailID, line.ProductID, quantity, returnNo)
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		} else {
+			err = tx.Exec(`
+				INSERT INTO supermarket.damaged_stock
+				(product_id, quantity, source_type, source_id, notes, created_at)
+				VALUES ($1, $2, 'SALES_RETURN', $3, $4, CURRENT_TIMESTAMP)
+			`, line.ProductID, quantity, returnID, nullIfEmpty(reason)).Error
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Không thể chuyển hàng vào kho hàng hỏng: " + err.Error(),
+				})
+			}
+		}
+
+		err = tx.Exec(`
+			INSERT INTO supermarket.sales_return_details
+			(return_id, invoice_detail_id, product_id, quantity, refund_amount, tax_amount, disposition, shelf_id, batch_code, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+		`, returnID, line.DetailID, line.ProductID, quantity, lineRefund, lineTax, disposition, shelfID, batchCode).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể thêm chi tiết trả hàng: " + err.Error(),
+			})
+		}
+
+		totalRefund += lineRefund
+	}
+
+	if totalRefund <= 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập số lượng trả cho ít nhất một sản phẩm",
+		})
+	}
+
+	// Reverse customer metrics in proportion to the refunded share of the invoice
+	pointsReversed := 0
+	pointsRestored := 0
+	if invoice.CustomerID != nil && invoice.TotalAmount > 0 {
+		earned, used, err := returnPointsShare(tx, &invoice, returnID, totalRefund)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể tính điểm cần hoàn tác: " + err.Error(),
+			})
+		}
+		returnedBy := uint(employeeID)
+		pointsReversed, pointsRestored, err = reverseSaleLoyalty(tx, invoice.InvoiceID, *invoice.CustomerID, &returnID,
+			earned, used, &returnedBy, "Trả hàng "+returnNo)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể hoàn tác điểm khách hàng: " + err.Error(),
+			})
+		}
+
+		// Lifetime spending drops by the refund; the tier itself follows windowed spending below
+		err = tx.Exec(`
+			UPDATE supermarket.customers
+			SET total_spending = GREATEST(total_spending - $1, 0), updated_at = CURRENT_TIMESTAMP
+			WHERE customer_id = $2
+		`, totalRefund, *invoice.CustomerID).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể cập nhật thông tin khách hàng: " + err.Error(),
+			})
+		}
+
+		// Re-evaluate the tier on the reduced spending the way the scheduled evaluation does:
+		// dropping below the tier starts its grace period, and only a lapsed grace downgrades
+		if _, err := evaluateCustomerTier(tx, *invoice.CustomerID, time.Now(), true); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể đánh giá hạng thành viên: " + err.Error(),
+			})
+		}
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.sales_returns
+		SET refund_amount = $1, points_reversed = $2, points_restored = $3
+		WHERE return_id = $4
+	`, totalRefund, pointsReversed, pointsRestored, returnID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật phiếu trả hàng: " + err.Error(),
+		})
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'sales_returns', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeSaleReturned,
+		fmt.Sprintf("Trả hàng %s cho hóa đơn %s - Hoàn tiền: %.0f VNĐ", returnNo, invoice.InvoiceNo, totalRefund),
+		returnID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi nhật ký: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":       true,
+			"return_id":     returnID,
+			"return_no":     returnNo,
+			"refund_amount": totalRefund,
+			"refund_method": refundMethod,
+			"session_id":    sessionID,
+			"message":       "Tạo phiếu trả hàng thành công",
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/sales/returns/%d", returnID))
+}
+
+// returnPointsShare works out the earned and redeemed points a return reverses. The shares are
+// taken over everything refunded on the invoice so far, less what earlier returns already
+// reversed, so flooring each partial return does not leave points behind and a full return
+// reverses all of them.
+func returnPointsShare(tx *gorm.DB, invoice *models.SalesInvoice, returnID uint, refund float64) (int, int, error) {
+	var earlier struct {
+		Refunded float64
+		Reversed int
+		Restored int
+	}
+	err := tx.Raw(`
+		SELECT COALESCE(SUM(refund_amount), 0) as refunded,
+			COALESCE(SUM(points_reversed), 0) as reversed,
+			COALESCE(SUM(points_restored), 0) as restored
+		FROM supermarket.sales_returns
+		WHERE invoice_id = $1 AND return_id <> $2
+	`, invoice.InvoiceID, returnID).Scan(&earlier).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Refunds are rounded per line, so a fully returned invoice is recognised by its quantities
+	var fullyReturned bool
+	err = tx.Raw(`
+		SELECT NOT EXISTS (
+			SELECT 1 FROM supermarket.sales_invoice_details sid
+			WHERE sid.invoice_id = $1
+			  AND sid.quantity > (
+				SELECT COALESCE(SUM(srd.quantity), 0)
+				FROM supermarket.sales_return_details srd
+				WHERE srd.invoice_detail_id = sid.detail_id
+			  )
+		)
+	`, invoice.InvoiceID).Scan(&fullyReturned).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ratio := 1.0
+	if !fullyReturned {
+		ratio = math.Min((earlier.Refunded+refund)/invoice.TotalAmount, 1)
+	}
+	return cumulativePointsShare(invoice.PointsEarned, ratio, earlier.Reversed),
+		cumulativePointsShare(invoice.PointsUsed, ratio, earlier.Restored), nil
+}
+
+// cumulativePointsShare is the part of points owed for the returned share of an invoice that
+// earlier returns have not yet covered
+func cumulativePointsShare(points int, returnedRatio float64, alreadyReversed int) int {
+	return max(int(math.Floor(float64(points)*returnedRatio))-alreadyReversed, 0)
+}
+
+// SalesReturnView displays a specific sales return
+func SalesReturnView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	returnID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "ID phiếu trả hàng không hợp lệ",
+			"Code":  400,
+		})
+	}
+
+	var ret struct {
+		models.SalesReturn
+		InvoiceNo    string  `json:"invoice_no"`
+		CustomerName *string `json:"customer_name"`
+		EmployeeName string  `json:"employee_name"`
+	}
+	err = db.Raw(`
+		SELECT
+			sr.*,
+			si.invoice_no,
+			c.full_name as customer_name,
+			e.full_name as employee_name
+		FROM supermarket.sales_returns sr
+		JOIN supermarket.sales_invoices si ON sr.invoice_id = si.invoice_id
+		LEFT JOIN supermarket.customers c ON sr.customer_id = c.customer_id
+		JOIN supermarket.employees e ON sr.employee_id = e.employee_id
+		WHERE sr.return_id = $1
+	`, returnID).Scan(&ret).Error
+	if err != nil || ret.ReturnID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy phiếu trả hàng",
+			"Code":  404,
+		})
+	}
+
+	var items []struct {
+		models.SalesReturnDetail
+		ProductCode string  `json:"product_code"`
+		ProductName string  `json:"product_name"`
+		ShelfName   *string `json:"shelf_name"`
+	}
+	err = db.Raw(`
+		SELECT
+			srd.*,
+			p.product_code,
+			p.product_name,
+			ds.shelf_name
+		FROM supermarket.sales_return_details srd
+		JOIN supermarket.products p ON srd.product_id = p.product_id
+		LEFT JOIN supermarket.display_shelves ds ON srd.shelf_id = ds.shelf_id
+		WHERE srd.return_id = $1
+		ORDER BY srd.return_detail_id
+	`, returnID).Scan(&items).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải chi tiết trả hàng: " + err.Error(),
+		})
+	}
+
+	return c.Render("pages/sales/return_view", fiber.Map{
+		"Title":           "Phiếu trả hàng " + ret.ReturnNo,
+		"Active":          "sales",
+		"Return":          ret,
+		"Items":           items,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// restockReturnedGoods puts resellable returned units back onto a shelf that carries the product.
+// Units go back to the batch the line was originally allocated from when that batch is still sellable,
+// otherwise into the most recently stocked batch on the shelf, or a new batch named after the return.
+func restockReturnedGoods(tx *gorm.DB, detailID, productID uint, quantity int, returnNo string) (*uint, *string, error) {
+	var allocation struct {
+		ShelfID    uint
+		BatchCode  string
+		ExpiryDate *time.Time
+		BatchPrice float64
+	}
+	tx.Raw(`
+		SELECT shelf_id, batch_code, expiry_date, batch_price
+		FROM supermarket.sales_invoice_allocations
+		WHERE detail_id = $1
+		  AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+		ORDER BY expiry_date DESC NULLS FIRST, allocation_id DESC
+		LIMIT 1
+	`, detailID).Scan(&allocation)
+
+	if allocation.ShelfID != 0 {
+		err := tx.Exec(`
+			INSERT INTO supermarket.shelf_batch_inventory
+			(shelf_id, product_id, batch_code, quantity, expiry_date, stocked_date, import_price, current_price, created_at, updated_at)
+			SELECT $1, p.product_id, $2, $3, $4, CURRENT_TIMESTAMP, p.import_price, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			FROM supermarket.products p
+			WHERE p.product_id = $6
+			ON CONFLICT (shelf_id, product_id, batch_code)
+			DO UPDATE SET
+				quantity = supermarket.shelf_batch_inventory.quantity + EXCLUDED.quantity,
+				updated_at = CURRENT_TIMESTAMP
+		`, allocation.ShelfID, allocation.BatchCode, quantity, allocation.ExpiryDate, allocation.BatchPrice, productID).Error
+		if err != nil {
+			return nil, nil, fmt.Errorf("Không thể nhập lại hàng lên quầy: %v", err)
+		}
+		if err := syncShelfInventorySummary(tx, allocation.ShelfID, productID); err != nil {
+			return nil, nil, fmt.Errorf("Không thể cập nhật tồn kho quầy: %v", err)
+		}
+		return &allocation.ShelfID, &allocation.BatchCode, nil
+	}
+
+	var shelfID uint
+	err := tx.Raw(`
+		SELECT shelf_id FROM supermarket.shelf_inventory
+		WHERE product_id = $1
+		ORDER BY current_quantity DESC, shelf_id
+		LIMIT 1
+	`, productID).Scan(&shelfID).Error
+	if err != nil || shelfID == 0 {
+		return nil, nil, fmt.Errorf("Sản phẩm %d chưa được bày trên quầy nào, vui lòng chuyển vào hàng hỏng", productID)
+	}
+
+	var batch struct {
+		ShelfBatchID uint
+		BatchCode    string
+	}
+	tx.Raw(`
+		SELECT shelf_batch_id, batch_code
+		FROM supermarket.shelf_batch_inventory
+		WHERE shelf_id = $1 AND product_id = $2
+		  AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+		ORDER BY stocked_date DESC
+		LIMIT 1
+	`, shelfID, productID).Scan(&batch)
+
+	batchCode := batch.BatchCode
+	if batch.ShelfBatchID != 0 {
+		err = tx.Exec(`
+			UPDATE supermarket.shelf_batch_inventory
+			SET quantity = quantity + $1, updated_at = CURRENT_TIMESTAMP
+			WHERE shelf_batch_id = $2
+		`, quantity, batch.ShelfBatchID).Error
+	} else {
+		batchCode = returnNo
+		err = tx.Exec(`
+			INSERT INTO supermarket.shelf_batch_inventory
+			(shelf_id, product_id, batch_code, quantity, stocked_date, import_price, current_price, created_at, updated_at)
+			SELECT $1, p.product_id, $2, $3, CURRENT_TIMESTAMP, p.import_price, p.selling_price, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			FROM supermarket.products p
+			WHERE p.product_id = $4
+		`, shelfID, batchCode, quantity, productID).Error
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Không thể nhập lại hàng lên quầy: %v", err)
+	}
+
+	if err := syncShelfInventorySummary(tx, shelfID, productID); err != nil {
+		return nil, nil, fmt.Errorf("Không thể cập nhật tồn kho quầy: %v", err)
+	}
+
+	return &shelfID, &batchCode, nil
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import "testing"
+
+func TestCumulativePointsShare(t *testing.T) {
+	tests := []struct {
+		name            string
+		points          int
+		returnedRatio   float64
+		alreadyReversed int
+		want            int
+	}{
+		{"first half", 15, 0.5, 0, 7},
+		{"second half completes the invoice", 15, 1, 7, 8},
+		{"three thirds add up", 10, 2.0 / 3, 3, 3},
+		{"last third", 10, 1, 6, 4},
+		{"nothing left to reverse", 10, 0.5, 5, 0},
+		{"earlier returns reversed more", 10, 0.2, 5, 0},
+		{"no points", 0, 1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cumulativePointsShare(tt.points, tt.returnedRatio, tt.alreadyReversed); got != tt.want {
+				t.Errorf("cumulativePointsShare(%d, %v, %d) = %d, want %d",
+					tt.points, tt.returnedRatio, tt.alreadyReversed, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// How a broken sale rule can be cleared at the till
+const (
+	restrictionClearAgeCheck = "AGE_CONFIRMATION"
+	restrictionClearManager  = "MANAGER_APPROVAL"
+)
+
+var saleHourPattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// saleRestrictionLabels are the display names of the sale rules
+var saleRestrictionLabels = map[models.SaleRestrictionRule]string{
+	models.RestrictionMinAge:            "Giới hạn tuổi",
+	models.RestrictionQtyPerInvoice:     "Số lượng tối đa mỗi hóa đơn",
+	models.RestrictionQtyPerCustomerDay: "Số lượng tối đa mỗi khách mỗi ngày",
+	models.RestrictionSaleHours:         "Giờ bán",
+}
+
+// saleRestrictionViolation is a sale rule a sale breaks, and how it can be cleared
+type saleRestrictionViolation struct {
+	ProductID   uint                       `json:"product_id"`
+	ProductName string                     `json:"product_name"`
+	Rule        models.SaleRestrictionRule `json:"rule"`
+	Message     string                     `json:"message"`
+	Limit       string                     `json:"limit"`
+	Requested   string                     `json:"requested"`
+	ClearedBy   string                     `json:"cleared_by"`
+}
+
+// saleRestrictionError is returned when a sale breaks sale rules that were not cleared
+type saleRestrictionError struct {
+	Violations []saleRestrictionViolation
+}
+
+func (e *saleRestrictionError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// restrictionClearance records who cleared the broken sale rules of a sale
+type restrictionClearance struct {
+	AgeVerifiedBy *uint
+	OverriddenBy  *uint
+	Overridden    []saleRestrictionViolation
+}
+
+// saleErrorJSON is the error body of a refused sale, with the broken rules of restricted products
+func saleErrorJSON(err error) fiber.Map {
+	body := fiber.Map{"error": err.Error()}
+	var restrictionErr *saleRestrictionError
+	if errors.As(err, &restrictionErr) {
+		body["violations"] = restrictionErr.Violations
+	}
+	return body
+}
+
+// loadSaleRestriction returns the sale rules of a product, inheriting its category's rules
+func loadSaleRestriction(tx *gorm.DB, productID uint, categories map[uint]models.SaleRestriction) (models.SaleRestriction, error) {
+	var product models.Product
+	if err := tx.Raw("SELECT * FROM supermarket.products WHERE product_id = $1", productID).Scan(&product).Error; err != nil {
+		return models.SaleRestriction{}, err
+	}
+	category, ok := categories[product.CategoryID]
+	if !ok {
+		var row models.ProductCategory
+		if err := tx.Raw("SELECT * FROM supermarket.product_categories WHERE category_id = $1", product.CategoryID).Scan(&row).Error; err != nil {
+			return models.SaleRestriction{}, err
+		}
+		category = row.SaleRestriction
+		categories[product.CategoryID] = category
+	}
+	return product.SaleRestriction.Inherit(category), nil
+}
+
+// checkSaleRestrictions lists the sale rules a priced sale breaks. Quantities are summed per
+// product over the lines; the daily limit counts the member's completed sales of the same day.
+func checkSaleRestrictions(tx *gorm.DB, req *saleRequest, quote *priceQuote) ([]saleRestrictionViolation, error) {
+	var productIDs []uint
+	quantities := map[uint]int{}
+	names := map[uint]string{}
+	for _, line := range quote.Lines {
+		if _, ok := quantities[line.ProductID]; !ok {
+			productIDs = append(productIDs, line.ProductID)
+		}
+		quantities[line.ProductID] += line.Quantity
+		names[line.ProductID] = line.ProductName
+	}
+
+	var violations []saleRestrictionViolation
+	categories := map[uint]models.SaleRestriction{}
+	for _, productID := range productIDs {
+		rules, err := loadSaleRestriction(tx, productID, categories)
+		if err != nil {
+			return nil, err
+		}
+		if rules.IsEmpty() {
+			continue
+		}
+		name, quantity := names[productID], quantities[productID]
+
+		if rules.MinAge != nil {
+			violations = append(violations, saleRestrictionViolation{
+				ProductID: productID, ProductName: name, Rule: models.RestrictionMinAge,
+				Message:   fmt.Sprintf("%s chỉ bán cho khách từ %d tuổi, thu ngân cần xác nhận tuổi", name, *rules.MinAge),
+				Limit:     strconv.Itoa(*rules.MinAge),
+				ClearedBy: restrictionClearAgeCheck,
+			})
+		}
+
+		if rules.MaxQtyPerInvoice != nil && quantity > *rules.MaxQtyPerInvoice {
+			violations = append(violations, saleRestrictionViolation{
+				ProductID: productID, ProductName: name, Rule: models.RestrictionQtyPerInvoice,
+				Message: fmt.Sprintf("%s chỉ được bán tối đa %d mỗi hóa đơn, yêu cầu: %d",
+					name, *rules.MaxQtyPerInvoice, quantity),
+				Limit:     strconv.Itoa(*rules.MaxQtyPerInvoice),
+				Requested: strconv.Itoa(quantity),
+				ClearedBy: restrictionClearManager,
+			})
+		}
+
+		// Walk-in sales cannot be traced to a customer, so the daily limit applies to members only
+		if rules.MaxQtyPerCustomerDay != nil && req.CustomerID != nil {
+			var boughtToday int
+			err := tx.Raw(`
+				SELECT COALESCE(SUM(sid.quantity), 0)
+				FROM supermarket.sales_invoice_details sid
+				JOIN supermarket.sales_invoices si ON sid.invoice_id = si.invoice_id
+				WHERE si.customer_id = $1
+				  AND sid.product_id = $2
+				  AND si.status = $3
+				  AND DATE(si.invoice_date) = $4
+			`, *req.CustomerID, productID, models.InvoiceCompleted, quote.PricedAt.Format("2006-01-02")).Scan(&boughtToday).Error
+			if err != nil {
+				return nil, err
+			}
+			if boughtToday+quantity > *rules.MaxQtyPerCustomerDay {
+				violations = append(violations, saleRestrictionViolation{
+					ProductID: productID, ProductName: name, Rule: models.RestrictionQtyPerCustomerDay,
+					Message: fmt.Sprintf("%s chỉ được bán tối đa %d mỗi khách mỗi ngày, khách đã mua %d, yêu cầu thêm %d",
+						name, *rules.MaxQtyPerCustomerDay, boughtToday, quantity),
+					Limit:     strconv.Itoa(*rules.MaxQtyPerCustomerDay),
+					Requested: strconv.Itoa(boughtToday + quantity),
+					ClearedBy: restrictionClearManager,
+				})
+			}
+		}
+
+		if !rules.SaleAllowedAt(quote.PricedAt) {
+			violations = append(violations, saleRestrictionViolation{
+				ProductID: productID, ProductName: name, Rule: models.RestrictionSaleHours,
+				Message: fmt.Sprintf("%s chỉ được bán từ %s đến %s", name,
+					*rules.SaleHoursStart, *rules.SaleHoursEnd),
+				Limit:     *rules.SaleHoursStart + "-" + *rules.SaleHoursEnd,
+				Requested: quote.PricedAt.Format("15:04"),
+				ClearedBy: restrictionClearManager,
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// enforceSaleRestrictions refuses a sale that breaks sale rules unless the cashier confirmed
+// the buyer's age and a manager approved exceeding the other limits with their PIN.
+// Every broken rule is kept on the quote, cleared or not.
+func enforceSaleRestrictions(tx *gorm.DB, employeeID uint, req *saleRequest, quote *priceQuote) (*restrictionClearance, error) {
+	violations, err := checkSaleRestrictions(tx, req, quote)
+	if err != nil {
+		return nil, fmt.Errorf("Không thể kiểm tra giới hạn bán hàng: %v", err)
+	}
+	quote.Restrictions = violations
+
+	clearance := &restrictionClearance{}
+	var refused []saleRestrictionViolation
+	for _, v := range violations {
+		switch {
+		case v.ClearedBy == restrictionClearAgeCheck && req.AgeVerified:
+			clearance.AgeVerifiedBy = &employeeID
+		case v.ClearedBy == restrictionClearManager && req.OverrideRestrictions:
+			clearance.Overridden = append(clearance.Overridden, v)
+		default:
+			refused = append(refused, v)
+		}
+	}
+	if len(refused) > 0 {
+		return nil, &saleRestrictionError{Violations: refused}
+	}
+
+	if len(clearance.Overridden) > 0 {
+		approver, err := verifyOverrideApprover(tx, req)
+		if err != nil {
+			return nil, err
+		}
+		clearance.OverriddenBy = &approver.EmployeeID
+	}
+	return clearance, nil
+}
+
+// logRestrictionOverride records a manager letting a sale exceed its sale rules
+func logRestrictionOverride(tx *gorm.DB, invoiceID uint, invoiceNo, reason string, clearance *restrictionClearance) error {
+	if clearance.OverriddenBy == nil {
+		return nil
+	}
+	rules := make([]string, 0, len(clearance.Overridden))
+	for _, v := range clearance.Overridden {
+		rules = append(rules, fmt.Sprintf("%s (%s)", v.ProductName, saleRestrictionLabels[v.Rule]))
+	}
+	return tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+		VALUES ($1, $2, 'sales_invoices', $3, $4, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeRestrictionOverridden,
+		fmt.Sprintf("Hóa đơn %s vượt giới hạn bán: %s. Lý do: %s", invoiceNo, strings.Join(rules, ", "), reason),
+		invoiceID, *clearance.OverriddenBy).Error
+}
+
+// parseSaleRestrictionForm reads the sale rule fields of a product or category form.
+// suffix is appended to the field names, e.g. "_3" for category 3 on the bulk form.
+func parseSaleRestrictionForm(c *fiber.Ctx, suffix string) (models.SaleRestriction, error) {
+	var r models.SaleRestriction
+	optionalInt := func(name, label string, min, max int) (*int, error) {
+		value := strings.TrimSpace(c.FormValue(name + suffix))
+		if value == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min || n > max {
+			return nil, fmt.Errorf("%s không hợp lệ: %s", label, value)
+		}
+		return &n, nil
+	}
+
+	var err error
+	if r.MinAge, err = optionalInt("min_age", "Tuổi tối thiểu", 1, 99); err != nil {
+		return r, err
+	}
+	if r.MaxQtyPerInvoice, err = optionalInt("max_qty_per_invoice", "Số lượng tối đa mỗi hóa đơn", 1, 1000000); err != nil {
+		return r, err
+	}
+	if r.MaxQtyPerCustomerDay, err = optionalInt("max_qty_per_customer_day", "Số lượng tối đa mỗi khách mỗi ngày", 1, 1000000); err != nil {
+		return r, err
+	}
+
+	start := strings.TrimSpace(c.FormValue("sale_hours_start" + suffix))
+	end := strings.TrimSpace(c.FormValue("sale_hours_end" + suffix))
+	if start == "" && end == "" {
+		return r, nil
+	}
+	if !saleHourPattern.MatchString(start) || !saleHourPattern.MatchString(end) || start == end {
+		return r, fmt.Errorf("Giờ bán phải có giờ bắt đầu và kết thúc khác nhau (HH:MM)")
+	}
+	r.SaleHoursStart, r.SaleHoursEnd = &start, &end
+	return r, nil
+}
+
+// SaleRestrictionList shows the sale rules of every category and the products with their own rules
+func SaleRestrictionList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var categories []models.ProductCategory
+	if err := db.Raw("SELECT * FROM supermarket.product_categories ORDER BY category_name").Scan(&categories).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh mục: " + err.Error(),
+		})
+	}
+
+	var products []struct {
+		models.Product
+		CategoryName string
+	}
+	db.Raw(`
+		SELECT p.*, c.category_name
+		FROM supermarket.products p
+		JOIN supermarket.product_categories c ON p.category_id = c.category_id
+		WHERE p.min_age IS NOT NULL OR p.max_qty_per_invoice IS NOT NULL
+		   OR p.max_qty_per_customer_day IS NOT NULL OR p.sale_hours_start IS NOT NULL
+		ORDER BY p.product_name
+	`).Scan(&products)
+
+	return c.Render("pages/sale_restrictions/list", fiber.Map{
+		"Title":           "Giới hạn bán hàng",
+		"Active":          "products",
+		"Categories":      categories,
+		"Products":        products,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// SaleRestrictionSaveCategories saves the sale rules of every product category
+// (form fields min_age_<id>, max_qty_per_invoice_<id>, ...; empty means no rule)
+func SaleRestrictionSaveCategories(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var categories []models.ProductCategory
+	db.Raw("SELECT category_id, category_name FROM supermarket.product_categories").Scan(&categories)
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, category := range categories {
+		r, err := parseSaleRestrictionForm(c, fmt.Sprintf("_%d", category.CategoryID))
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": category.CategoryName + ": " + err.Error(),
+			})
+		}
+		err = tx.Exec(`
+			UPDATE supermarket.product_categories
+			SET min_age = $1, max_qty_per_invoice = $2, max_qty_per_customer_day = $3,
+			    sale_hours_start = $4, sale_hours_end = $5, updated_at = CURRENT_TIMESTAMP
+			WHERE category_id = $6
+		`, r.MinAge, r.MaxQtyPerInvoice, r.MaxQtyPerCustomerDay, r.SaleHoursStart, r.SaleHoursEnd,
+			category.CategoryID).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Không thể cập nhật giới hạn bán của danh mục: " + err.Error(),
+			})
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return c.Redirect("/sale-restrictions")
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/supermarket/database"
 	"github.com/supermarket/models"
+	"gorm.io/gorm"
 )
 
 // SalesList displays all sales invoices
@@ -40,6 +42,7 @@ func SalesList(c *fiber.Ctx) error {
 			si.payment_method,
 			si.points_earned,
 			si.points_used,
+			si.status,
 			c.full_name as customer_name,
 			c.phone as customer_phone,
 			e.full_name as employee_name,
@@ -104,6 +107,7 @@ func SalesList(c *fiber.Ctx) error {
 		PaymentMethod  *string   `json:"payment_method"`
 		PointsEarned   int       `json:"points_earned"`
 		PointsUsed     int       `json:"points_used"`
+		Status         string    `json:"status"`
 		CustomerName   *string   `json:"customer_name"`
 		CustomerPhone  *string   `json:"customer_phone"`
 		EmployeeName   string    `json:"employee_name"`
@@ -330,6 +334,33 @@ func SalesNew(c *fiber.Ctx) error {
 		})
 	}
 
+	// Employees who can approve price overrides: a position with override limits and a PIN set
+	var managers []models.Employee
+	db.Raw(`
+		SELECT e.employee_id, e.full_name
+		FROM supermarket.employees e
+		JOIN supermarket.positions p ON e.position_id = p.position_id
+		WHERE e.is_active = true
+		  AND (p.override_max_percent IS NOT NULL OR p.override_max_amount IS NOT NULL)
+		  AND e.approval_pin_hash IS NOT NULL
+		ORDER BY e.full_name
+	`).Scan(&managers)
+
+	// Open till sessions a sale can be booked on
+	var sessions []struct {
+		SessionID    uint   `json:"session_id"`
+		RegisterCode string `json:"register_code"`
+		EmployeeID   uint   `json:"employee_id"`
+		EmployeeName string `json:"employee_name"`
+	}
+	db.Raw(`
+		SELECT rs.session_id, rs.register_code, rs.employee_id, e.full_name as employee_name
+		FROM supermarket.register_sessions rs
+		JOIN supermarket.employees e ON rs.employee_id = e.employee_id
+		WHERE rs.status = $1
+		ORDER BY rs.register_code
+	`, models.RegisterSessionOpen).Scan(&sessions)
+
 	// Get products with shelf inventory
 	var products []struct {
 		ProductID     uint       `json:"product_id"`
@@ -405,11 +436,30 @@ func SalesNew(c *fiber.Ctx) error {
 		})
 	}
 
+	// A resumed parked cart is loaded back into the form
+	var resumeCart *posCartView
+	if cartIDStr := c.Query("cart_id"); cartIDStr != "" {
+		cartID, err := strconv.ParseUint(cartIDStr, 10, 64)
+		if err == nil {
+			resumeCart, err = loadPosCart(db, uint(cartID))
+		}
+		if err != nil || !resumeCart.Cart.IsEditable() {
+			return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+				"Title": "Lỗi",
+				"Error": "Không thể mở lại giỏ hàng tạm giữ",
+				"Code":  400,
+			})
+		}
+	}
+
 	return c.Render("pages/sales/form", fiber.Map{
 		"Title":            "Tạo hóa đơn bán hàng",
 		"Active":           "sales",
 		"Customers":        customers,
 		"Employees":        employees,
+		"Managers":         managers,
+		"Sessions":         sessions,
+		"ResumeCart":       resumeCart,
 		"Products":         products,
 		"MembershipLevels": membershipLevels,
 		"SQLQueries":       c.Locals("SQLQueries"),
@@ -417,15 +467,178 @@ func SalesNew(c *fiber.Ctx) error {
 	}, "layouts/base")
 }
 
+// saleResult is what createSaleInvoice booked for a sale
+type saleResult struct {
+	InvoiceID  uint
+	InvoiceNo  string
+	SessionID  uint
+	Quote      *priceQuote
+	Settlement *tenderSettlement
+}
+
+// createSaleInvoice books a sale inside tx: it resolves the till session, prices the lines
+// with the shelf batches locked, writes the invoice and its lines (the triggers deduct the
+// stock and total the invoice), settles the tenders and takes the redeemed points.
+// The returned status tells the caller whether the request or the server was at fault;
+// the caller owns the transaction and rolls back on error.
+func createSaleInvoice(tx *gorm.DB, employeeID uint, sessionIDStr, notes string, saleReq *saleRequest, tenders []tenderRequest) (*saleResult, int, error) {
+	result, status, err := insertSaleInvoice(tx, employeeID, sessionIDStr, notes, saleReq, models.InvoiceCompleted)
+	if err != nil {
+		return nil, status, err
+	}
+
+	settlement, status, err := settleSaleInvoice(tx, result.InvoiceID, saleReq.CustomerID, result.Quote.PointsUsed, employeeID, tenders)
+	if err != nil {
+		return nil, status, err
+	}
+	result.Settlement = settlement
+
+	return result, fiber.StatusOK, nil
+}
+
+// createDraftInvoice writes a priced sale as a draft. The stock trigger skips the lines of a
+// draft, and no tender or points are booked until SalesComplete completes it.
+func createDraftInvoice(tx *gorm.DB, employeeID uint, sessionIDStr, notes string, saleReq *saleRequest) (*saleResult, int, error) {
+	return insertSaleInvoice(tx, employeeID, sessionIDStr, notes, saleReq, models.InvoiceDraft)
+}
+
+// insertSaleInvoice resolves the till session, prices the sale and writes the invoice with
+// the given status and its lines
+func insertSaleInvoice(tx *gorm.DB, employeeID uint, sessionIDStr, notes string, saleReq *saleRequest, invoiceStatus models.InvoiceStatus) (*saleResult, int, error) {
+	// Every sale is booked on an open till session; an offline sale on the one it was rung up in
+	var sessionID uint
+	var err error
+	if saleReq.ClientUUID != nil {
+		sessionID, err = resolveOfflineSession(tx, sessionIDStr, *saleReq.SoldAt)
+	} else {
+		sessionID, err = resolveRegisterSession(tx, sessionIDStr, employeeID)
+	}
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+
+	// Invoice numbers run per till, allocated in this transaction so a failed sale leaves no gap
+	var registerCode string
+	tx.Raw("SELECT register_code FROM supermarket.register_sessions WHERE session_id = $1", sessionID).Scan(&registerCode)
+	invoiceNo, err := nextDocumentNo(tx, models.DocSalesInvoice, registerCode)
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, err
+	}
+
+	// Price the sale inside the transaction with the shelf batches locked,
+	// so the stock trigger allocates exactly the batches that were priced
+	quote, err := priceSale(tx, saleReq, true)
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	if err := authorizeOverrides(tx, saleReq, quote); err != nil {
+		return nil, fiber.StatusForbidden, err
+	}
+	clearance, err := enforceSaleRestrictions(tx, employeeID, saleReq, quote)
+	if err != nil {
+		return nil, fiber.StatusUnprocessableEntity, err
+	}
+
+	breakdown, err := marshalPriceQuote(quote)
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể lưu diễn giải giá: %v", err)
+	}
+
+	// Offline sales keep the time they were rung up and record when they were uploaded
+	var syncedAt *time.Time
+	if saleReq.ClientUUID != nil {
+		now := time.Now()
+		syncedAt = &now
+	}
+
+	// Create sales invoice
+	var invoiceID uint
+	err = tx.Raw(`
+		INSERT INTO supermarket.sales_invoices 
+		(invoice_no, customer_id, employee_id, session_id, invoice_date, points_used, notes, pricing_breakdown,
+		 client_uuid, synced_at, age_verified_by, restriction_override_by, status)
+		VALUES ($1, $2, $3, $4, COALESCE($5, CURRENT_TIMESTAMP), $6, $7, $8::jsonb, $9, $10, $11, $12, $13)
+		RETURNING invoice_id
+	`, invoiceNo, saleReq.CustomerID, employeeID, sessionID, saleReq.SoldAt, quote.PointsUsed, notes, breakdown,
+		saleReq.ClientUUID, syncedAt, clearance.AgeVerifiedBy, clearance.OverriddenBy, invoiceStatus).Scan(&invoiceID).Error
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể tạo hóa đơn: %v", err)
+	}
+	if err := logRestrictionOverride(tx, invoiceID, invoiceNo, saleReq.OverrideReason, clearance); err != nil {
+		return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể ghi nhật ký vượt giới hạn bán: %v", err)
+	}
+
+	// Insert invoice details with the server-derived prices and discount breakdown
+	for _, line := range quote.Lines {
+		var approvedBy *uint
+		var overrideReason *string
+		if line.Overridden {
+			approvedBy = quote.OverrideApprovedBy
+			overrideReason = &quote.OverrideReason
+		}
+
+		var detailID uint
+		err = tx.Raw(`
+			INSERT INTO supermarket.sales_invoice_details 
+			(invoice_id, product_id, quantity, unit_price, discount_percentage, list_price,
+			 batch_discount_amount, promotion_discount_amount, membership_discount_amount, points_discount_amount,
+			 promotion_id, override_approved_by, override_type, override_reason, tax_class_id, tax_rate, tax_mode)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			RETURNING detail_id
+		`, invoiceID, line.ProductID, line.Quantity, line.UnitPrice, line.DiscountPercentage, line.ListPrice,
+			line.BatchDiscountAmount, line.PromotionDiscountAmount, line.MembershipDiscountAmount, line.PointsDiscountAmount,
+			line.PromotionID, approvedBy, line.OverrideType, overrideReason, line.TaxClassID, line.TaxRate, line.TaxMode).Scan(&detailID).Error
+		if err != nil {
+			return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể thêm chi tiết hóa đơn: %v", err)
+		}
+
+		if err := recordInvoicePromotions(tx, detailID, &line); err != nil {
+			return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể ghi nhận khuyến mãi: %v", err)
+		}
+	}
+
+	return &saleResult{
+		InvoiceID: invoiceID,
+		InvoiceNo: invoiceNo,
+		SessionID: sessionID,
+		Quote:     quote,
+	}, fiber.StatusOK, nil
+}
+
+// settleSaleInvoice finishes a completed invoice whose stock the triggers have deducted:
+// it syncs the shelf summaries, settles the tenders and books the points
+func settleSaleInvoice(tx *gorm.DB, invoiceID uint, customerID *uint, pointsUsed int, employeeID uint, tenders []tenderRequest) (*tenderSettlement, int, error) {
+	// Keep shelf summaries in sync with the FEFO batch allocation done by the deduction trigger
+	if err := syncInvoiceShelfSummaries(tx, invoiceID); err != nil {
+		return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể cập nhật tồn kho quầy: %v", err)
+	}
+
+	// Settle the tenders against the total computed by the totals trigger
+	settlement, err := recordInvoicePayments(tx, invoiceID, tenders)
+	if err != nil {
+		return nil, fiber.StatusBadRequest, fmt.Errorf("Không thể ghi nhận thanh toán: %v", err)
+	}
+
+	// Book the redeemed and the earned points in the customer's points ledger
+	if customerID != nil {
+		if err := bookSaleLoyalty(tx, invoiceID, *customerID, pointsUsed, employeeID); err != nil {
+			return nil, fiber.StatusBadRequest, fmt.Errorf("Không thể ghi sổ điểm khách hàng: %v", err)
+		}
+		// Upgrades take effect at checkout; downgrades wait for the scheduled evaluation
+		if _, err := evaluateCustomerTier(tx, *customerID, time.Now(), false); err != nil {
+			return nil, fiber.StatusInternalServerError, fmt.Errorf("Không thể đánh giá hạng thành viên: %v", err)
+		}
+	}
+
+	return settlement, fiber.StatusOK, nil
+}
+
 // SalesCreate processes the new sales invoice creation
 func SalesCreate(c *fiber.Ctx) error {
 	db := database.GetDB()
 
 	// Parse form data
-	customerIDStr := c.FormValue("customer_id")
 	employeeIDStr := c.FormValue("employee_id")
-	paymentMethod := c.FormValue("payment_method")
-	pointsUsedStr := c.FormValue("points_used")
 	notes := c.FormValue("notes")
 
 	// Validate required fields
@@ -442,110 +655,246 @@ func SalesCreate(c *fiber.Ctx) error {
 		})
 	}
 
-	pointsUsed := 0
-	if pointsUsedStr != "" {
-		pointsUsed, err = strconv.Atoi(pointsUsedStr)
-		if err != nil || pointsUsed < 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Số điểm sử dụng không hợp lệ",
-			})
-		}
+	// Only products and quantities are taken from the client; prices come from the pricing engine
+	saleReq, err := parseSaleRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// A draft is priced and saved without payment; stock is deducted when it is completed
+	draft := c.FormValue("draft") == "true"
+
+	tenders, err := parseTenders(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
 
-	// Load customer membership and points if provided
-	var membershipDiscount float64 = 0
-	var customerAvailablePoints int = 0
-	var customerID *uint
-	if customerIDStr != "" {
-		var tmpID uint64
-		tmpID, err = strconv.ParseUint(customerIDStr, 10, 64)
+	// Start transaction
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// A basket resumed from a parked cart closes that cart with the invoice
+	var cartID uint
+	if cartIDStr := c.FormValue("cart_id"); cartIDStr != "" {
+		id, err := strconv.ParseUint(cartIDStr, 10, 64)
 		if err != nil {
+			tx.Rollback()
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "ID khách hàng không hợp lệ",
+				"error": "ID giỏ hàng không hợp lệ",
 			})
 		}
-		cid := uint(tmpID)
-		customerID = &cid
-
-		var cust struct {
-			LoyaltyPoints      int
-			MembershipDiscount float64
+		if _, status, err := lockOpenCart(tx, uint(id)); err != nil {
+			tx.Rollback()
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
 		}
-		err = db.Raw(`
-			SELECT 
-				c.loyalty_points,
-				COALESCE(ml.discount_percentage, 0) AS membership_discount
-			FROM supermarket.customers c
-			LEFT JOIN supermarket.membership_levels ml ON c.membership_level_id = ml.level_id
-			WHERE c.customer_id = $1
-		`, customerID).Scan(&cust).Error
-		if err != nil {
+		cartID = uint(id)
+	}
+
+	var result *saleResult
+	var status int
+	if draft {
+		result, status, err = createDraftInvoice(tx, uint(employeeID), c.FormValue("session_id"), notes, saleReq)
+	} else {
+		result, status, err = createSaleInvoice(tx, uint(employeeID), c.FormValue("session_id"), notes, saleReq, tenders)
+	}
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(saleErrorJSON(err))
+	}
+
+	if cartID != 0 {
+		if err := completeCart(tx, cartID, result); err != nil {
+			tx.Rollback()
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Không thể tải thông tin khách hàng: " + err.Error(),
+				"error": "Không thể đóng giỏ hàng: " + err.Error(),
 			})
 		}
-		customerAvailablePoints = cust.LoyaltyPoints
-		membershipDiscount = cust.MembershipDiscount
-		// Validate points usage does not exceed available
-		if pointsUsed > customerAvailablePoints {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Điểm sử dụng vượt quá số điểm hiện có",
-			})
+	}
+
+	// Commit transaction
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	// Return success response
+	if c.Get("Content-Type") == "application/json" {
+		message := "Tạo hóa đơn thành công"
+		if draft {
+			message = "Lưu hóa đơn nháp thành công"
 		}
+		return c.JSON(fiber.Map{
+			"success":    true,
+			"invoice_id": result.InvoiceID,
+			"invoice_no": result.InvoiceNo,
+			"session_id": result.SessionID,
+			"pricing":    result.Quote,
+			"payment":    result.Settlement,
+			"message":    message,
+		})
 	}
 
-	// Parse products from form
-	productIDs := c.FormValue("product_ids")
-	quantities := c.FormValue("quantities")
-	unitPrices := c.FormValue("unit_prices")
-	discountPercentages := c.FormValue("discount_percentages")
+	// A draft goes back to its invoice page, where it can be completed or voided
+	if draft {
+		return c.Redirect(fmt.Sprintf("/sales/%d", result.InvoiceID))
+	}
+
+	// Redirect to invoice view
+	return c.Redirect(fmt.Sprintf("/sales/invoice/%d", result.InvoiceID))
+}
+
+// SalesComplete completes a draft invoice. The status change trigger deducts the stock of
+// every line, update_customer_metrics counts the sale and its points, and the tenders and
+// points are then booked on the session that takes the payment.
+func SalesComplete(c *fiber.Ctx) error {
+	db := database.GetDB()
 
-	if productIDs == "" || quantities == "" || unitPrices == "" {
+	invoiceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Vui lòng chọn ít nhất một sản phẩm",
+			"error": "ID hóa đơn không hợp lệ",
 		})
 	}
 
-	// Parse arrays (assuming they're comma-separated)
-	productIDList := parseStringArray(productIDs)
-	quantityList := parseStringArray(quantities)
-	unitPriceList := parseStringArray(unitPrices)
-	discountList := parseStringArray(discountPercentages)
+	employeeIDStr := c.FormValue("employee_id")
+	if employeeIDStr == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng chọn nhân viên thu ngân",
+		})
+	}
+	employeeID, err := strconv.ParseUint(employeeIDStr, 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID nhân viên không hợp lệ",
+		})
+	}
 
-	if len(productIDList) != len(quantityList) || len(productIDList) != len(unitPriceList) {
+	tenders, err := parseTenders(c)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Dữ liệu sản phẩm không hợp lệ",
+			"error": err.Error(),
 		})
 	}
 
-	// Generate unique invoice number with retry logic
-	var invoiceNo string
-	var invoiceID uint
-	maxRetries := 10
+	// Start transaction
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
 
-	for i := 0; i < maxRetries; i++ {
-		// Use timestamp + microseconds + retry counter for uniqueness
-		timestamp := time.Now()
-		invoiceNo = fmt.Sprintf("HD%s%06d", timestamp.Format("20060102"), timestamp.Nanosecond()/1000+i)
+	var invoice models.SalesInvoice
+	err = tx.Raw(`
+		SELECT * FROM supermarket.sales_invoices
+		WHERE invoice_id = $1
+		FOR UPDATE
+	`, invoiceID).Scan(&invoice).Error
+	if err != nil || invoice.InvoiceID == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy hóa đơn",
+		})
+	}
 
-		// Check if invoice_no already exists
-		var count int64
-		err = db.Raw("SELECT COUNT(*) FROM supermarket.sales_invoices WHERE invoice_no = $1", invoiceNo).Scan(&count).Error
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Không thể kiểm tra số hóa đơn: " + err.Error(),
-			})
-		}
+	if invoice.Status != models.InvoiceDraft {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Chỉ có thể hoàn tất hóa đơn nháp",
+		})
+	}
 
-		if count == 0 {
-			break // Found unique invoice_no
-		}
+	// The payment is taken on an open till session, which may not be the one the draft was written on
+	sessionID, err := resolveRegisterSession(tx, c.FormValue("session_id"), uint(employeeID))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-		if i == maxRetries-1 {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Không thể tạo số hóa đơn duy nhất",
+	// The sale is dated when it is completed, which is when the goods leave the shelves
+	err = tx.Exec(`
+		UPDATE supermarket.sales_invoices
+		SET status = $1, session_id = $2, invoice_date = CURRENT_TIMESTAMP
+		WHERE invoice_id = $3
+	`, models.InvoiceCompleted, sessionID, invoiceID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể hoàn tất hóa đơn: " + err.Error(),
+		})
+	}
+
+	settlement, status, err := settleSaleInvoice(tx, uint(invoiceID), invoice.CustomerID, invoice.PointsUsed, uint(employeeID), tenders)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":    true,
+			"invoice_id": invoiceID,
+			"invoice_no": invoice.InvoiceNo,
+			"session_id": sessionID,
+			"payment":    settlement,
+			"message":    "Hoàn tất hóa đơn thành công",
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/sales/invoice/%d", invoiceID))
+}
+
+// SalesVoid voids a draft or completed sales invoice. For a completed sale the status change
+// trigger puts the stock back and update_customer_metrics reverses the customer's spending;
+// the earned and redeemed points are reversed in the points ledger by reverseSaleLoyalty.
+func SalesVoid(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	invoiceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID hóa đơn không hợp lệ",
+		})
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập lý do hủy hóa đơn",
+		})
+	}
+
+	var voidedBy *uint
+	if employeeIDStr := c.FormValue("employee_id"); employeeIDStr != "" {
+		employeeID, err := strconv.ParseUint(employeeIDStr, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "ID nhân viên không hợp lệ",
 			})
 		}
+		eid := uint(employeeID)
+		voidedBy = &eid
 	}
 
 	// Start transaction
@@ -556,159 +905,132 @@ func SalesCreate(c *fiber.Ctx) error {
 		}
 	}()
 
-	// Create sales invoice
+	var invoice models.SalesInvoice
 	err = tx.Raw(`
-		INSERT INTO supermarket.sales_invoices 
-		(invoice_no, customer_id, employee_id, invoice_date, payment_method, points_used, notes)
-		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, $4, $5, $6)
-		RETURNING invoice_id
-	`, invoiceNo, customerIDStr, employeeID, paymentMethod, pointsUsed, notes).Scan(&invoiceID).Error
+		SELECT * FROM supermarket.sales_invoices
+		WHERE invoice_id = $1
+		FOR UPDATE
+	`, invoiceID).Scan(&invoice).Error
+	if err != nil || invoice.InvoiceID == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy hóa đơn",
+		})
+	}
 
-	if err != nil {
+	if invoice.IsVoided() {
 		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Không thể tạo hóa đơn: " + err.Error(),
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Hóa đơn đã bị hủy trước đó",
+		})
+	}
+
+	var returnCount int64
+	tx.Raw("SELECT COUNT(*) FROM supermarket.sales_returns WHERE invoice_id = $1", invoiceID).Scan(&returnCount)
+	if returnCount > 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Hóa đơn đã có phiếu trả hàng, không thể hủy",
 		})
 	}
 
-	// First pass to compute per-item subtotals with base + membership discount
-	type itemCalc struct {
-		productID       uint64
-		quantity        int
-		unitPrice       float64
-		baseDiscountPct float64
-		effectivePct    float64
-		lineSubtotal    float64
-		lineNetBeforePt float64
+	// A signed e-invoice has been reported to the tax authority and a void would leave it
+	// standing; the goods come back as a return, which the e-invoice adjusts
+	if export := currentEInvoice(tx, uint(invoiceID)); export != nil {
+		documentNo := ""
+		if export.DocumentNo != nil {
+			documentNo = *export.DocumentNo
+		}
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Hóa đơn đã xuất hóa đơn điện tử %s, không thể hủy. Vui lòng lập phiếu trả hàng tại /sales/%d/return rồi xuất hóa đơn điều chỉnh", documentNo, invoiceID),
+		})
 	}
-	items := make([]itemCalc, 0, len(productIDList))
-	for i, productIDStr := range productIDList {
-		productID, err := strconv.ParseUint(productIDStr, 10, 64)
+
+	// A void takes the sale out of its till's takings, so only a sale of a till that is still
+	// open can be voided; once the till is closed and counted the goods come back as a return.
+	// The session row is locked FOR SHARE so the till cannot be closed while the void is written.
+	// A draft has taken no money, and a sale booked before till sessions existed has no till
+	// to reconcile, so both are voided without one.
+	if invoice.Status == models.InvoiceCompleted && invoice.SessionID != nil {
+		var session models.RegisterSession
+		err = tx.Raw(`
+			SELECT * FROM supermarket.register_sessions
+			WHERE session_id = $1
+			FOR SHARE
+		`, *invoice.SessionID).Scan(&session).Error
 		if err != nil {
 			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "ID sản phẩm không hợp lệ: " + productIDStr,
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể tải ca thu ngân: " + err.Error(),
 			})
 		}
-
-		quantity, err := strconv.Atoi(quantityList[i])
-		if err != nil || quantity <= 0 {
+		if session.SessionID == 0 {
 			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Số lượng không hợp lệ: " + quantityList[i],
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Không tìm thấy ca thu ngân của hóa đơn",
 			})
 		}
-
-		unitPrice, err := strconv.ParseFloat(unitPriceList[i], 64)
-		if err != nil || unitPrice <= 0 {
+		if !session.IsOpen() {
 			tx.Rollback()
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Giá đơn vị không hợp lệ: " + unitPriceList[i],
+				"error": fmt.Sprintf("Ca thu ngân %s của hóa đơn đã đóng, không thể hủy. Vui lòng lập phiếu trả hàng tại /sales/%d/return", session.RegisterCode, invoiceID),
 			})
 		}
+	}
 
-		discountPercentage := 0.0
-		if i < len(discountList) && discountList[i] != "" {
-			discountPercentage, err = strconv.ParseFloat(discountList[i], 64)
-			if err != nil || discountPercentage < 0 || discountPercentage > 100 {
-				tx.Rollback()
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": "Phần trăm giảm giá không hợp lệ: " + discountList[i],
-				})
-			}
-		}
+	err = tx.Exec(`
+		UPDATE supermarket.sales_invoices
+		SET status = $1, voided_at = CURRENT_TIMESTAMP, voided_by = $2, void_reason = $3
+		WHERE invoice_id = $4
+	`, models.InvoiceVoided, voidedBy, reason, invoiceID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hủy hóa đơn: " + err.Error(),
+		})
+	}
 
-		// Apply membership discount on top of base discount
-		effectivePct := discountPercentage + membershipDiscount
-		if effectivePct > 100 {
-			effectivePct = 100
-		}
-		lineSubtotal := float64(quantity) * unitPrice
-		lineNet := lineSubtotal * (1 - effectivePct/100.0)
-		items = append(items, itemCalc{
-			productID:       productID,
-			quantity:        quantity,
-			unitPrice:       unitPrice,
-			baseDiscountPct: discountPercentage,
-			effectivePct:    effectivePct,
-			lineSubtotal:    lineSubtotal,
-			lineNetBeforePt: lineNet,
-		})
-	}
-
-	// Distribute points discount (1000 points = 1 VND)
-	pointsDiscountVND := float64(pointsUsed) / 1000.0
-	var totalNetBeforePoints float64 = 0
-	for _, it := range items {
-		totalNetBeforePoints += it.lineNetBeforePt
-	}
-
-	// Guard against over-discounting
-	if pointsDiscountVND > totalNetBeforePoints {
-		pointsDiscountVND = totalNetBeforePoints
-	}
-
-	// Insert invoice details with final discount percentages (including membership and points)
-	for _, it := range items {
-		finalPct := it.effectivePct
-		if totalNetBeforePoints > 0 && it.lineSubtotal > 0 && pointsDiscountVND > 0 {
-			// prorate extra discount value to this item
-			prorata := it.lineNetBeforePt / totalNetBeforePoints
-			extraVND := pointsDiscountVND * prorata
-			extraPct := (extraVND / it.lineSubtotal) * 100.0
-			finalPct += extraPct
-		}
-		if finalPct > 100 {
-			finalPct = 100
-		}
+	if err := syncInvoiceShelfSummaries(tx, uint(invoiceID)); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật tồn kho quầy: " + err.Error(),
+		})
+	}
 
-		err = tx.Exec(`
-			INSERT INTO supermarket.sales_invoice_details 
-			(invoice_id, product_id, quantity, unit_price, discount_percentage)
-			VALUES ($1, $2, $3, $4, $5)
-		`, invoiceID, it.productID, it.quantity, it.unitPrice, finalPct).Error
-		if err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Không thể thêm chi tiết hóa đơn: " + err.Error(),
-			})
-		}
+	if err := reverseInvoiceVouchers(tx, uint(invoiceID), voidedBy); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn lại voucher: " + err.Error(),
+		})
 	}
 
-	// Deduct used points from customer balance if applicable
-	if customerID != nil && pointsUsed > 0 {
-		err = tx.Exec(`
-			UPDATE supermarket.customers
-			SET loyalty_points = GREATEST(loyalty_points - $1, 0), updated_at = CURRENT_TIMESTAMP
-			WHERE customer_id = $2
-		`, pointsUsed, *customerID).Error
+	if invoice.CustomerID != nil && invoice.Status == models.InvoiceCompleted {
+		_, _, err := reverseSaleLoyalty(tx, uint(invoiceID), *invoice.CustomerID, nil,
+			invoice.PointsEarned, invoice.PointsUsed, voidedBy, "Hủy hóa đơn "+invoice.InvoiceNo)
 		if err != nil {
 			tx.Rollback()
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Không thể trừ điểm khách hàng: " + err.Error(),
+				"error": "Không thể hoàn tác điểm khách hàng: " + err.Error(),
 			})
 		}
 	}
 
-	// Commit transaction
 	if err := tx.Commit().Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
 		})
 	}
 
-	// Return success response
 	if c.Get("Content-Type") == "application/json" {
 		return c.JSON(fiber.Map{
 			"success":    true,
 			"invoice_id": invoiceID,
-			"invoice_no": invoiceNo,
-			"message":    "Tạo hóa đơn thành công",
+			"message":    "Hủy hóa đơn thành công",
 		})
 	}
 
-	// Redirect to invoice view
-	return c.Redirect(fmt.Sprintf("/sales/invoice/%d", invoiceID))
+	return c.Redirect(fmt.Sprintf("/sales/%d", invoiceID))
 }
 
 // SalesView displays a specific sales invoice
@@ -775,30 +1097,21 @@ func SalesView(c *fiber.Ctx) error {
 			p.product_code,
 			p.product_name,
 			pc.category_name,
-			ds.shelf_name,
-			sbi.expiry_date
+			alloc.shelf_name,
+			alloc.expiry_date
 		FROM supermarket.sales_invoice_details sid
 		JOIN supermarket.products p ON sid.product_id = p.product_id
 		LEFT JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
-		LEFT JOIN supermarket.shelf_inventory si ON p.product_id = si.product_id
-		LEFT JOIN supermarket.display_shelves ds ON si.shelf_id = ds.shelf_id
 		LEFT JOIN (
-			-- Chỉ lấy 1 batch cho mỗi sản phẩm dựa trên thời gian mua hàng
-			SELECT DISTINCT ON (sbi.product_id, sbi.shelf_id)
-				sbi.shelf_id,
-				sbi.product_id,
-				sbi.expiry_date,
-				sbi.stocked_date,
-				sbi.batch_code
-			FROM supermarket.shelf_batch_inventory sbi
-			JOIN supermarket.sales_invoices si ON si.invoice_id = $1
-			WHERE sbi.quantity > 0
-			ORDER BY sbi.product_id, sbi.shelf_id, 
-				-- Ưu tiên batch có stocked_date gần nhất với thời gian mua hàng
-				ABS(EXTRACT(EPOCH FROM (sbi.stocked_date - si.invoice_date))),
-				-- Nếu cùng thời gian, chọn batch có expiry_date sớm nhất (FIFO)
-				sbi.expiry_date ASC NULLS LAST
-		) sbi ON si.shelf_id = sbi.shelf_id AND p.product_id = sbi.product_id
+			-- Quầy và hạn dùng thực tế theo phân bổ FEFO của từng dòng
+			SELECT 
+				a.detail_id,
+				STRING_AGG(DISTINCT ds.shelf_name, ', ') as shelf_name,
+				MIN(a.expiry_date) as expiry_date
+			FROM supermarket.sales_invoice_allocations a
+			JOIN supermarket.display_shelves ds ON a.shelf_id = ds.shelf_id
+			GROUP BY a.detail_id
+		) alloc ON alloc.detail_id = sid.detail_id
 		WHERE sid.invoice_id = $1
 		ORDER BY sid.detail_id
 	`, invoiceID).Scan(&items).Error
@@ -823,11 +1136,64 @@ func SalesView(c *fiber.Ctx) error {
 		}
 	}
 
+	// Get batch allocations for each line
+	var allocations []struct {
+		models.SalesInvoiceAllocation
+		ProductName string `json:"product_name"`
+		ShelfName   string `json:"shelf_name"`
+	}
+	db.Raw(`
+		SELECT a.*, p.product_name, ds.shelf_name
+		FROM supermarket.sales_invoice_allocations a
+		JOIN supermarket.sales_invoice_details sid ON a.detail_id = sid.detail_id
+		JOIN supermarket.products p ON sid.product_id = p.product_id
+		JOIN supermarket.display_shelves ds ON a.shelf_id = ds.shelf_id
+		WHERE sid.invoice_id = $1
+		ORDER BY a.detail_id, a.allocation_id
+	`, invoiceID).Scan(&allocations)
+
+	// Get returns recorded against this invoice
+	var returns []models.SalesReturn
+	db.Raw(`
+		SELECT * FROM supermarket.sales_returns
+		WHERE invoice_id = $1
+		ORDER BY return_date
+	`, invoiceID).Scan(&returns)
+
+	// Decode the price breakdown stored when the invoice was created
+	var pricing *priceQuote
+	if invoice.PricingBreakdown != nil {
+		var quote priceQuote
+		if err := json.Unmarshal([]byte(*invoice.PricingBreakdown), &quote); err == nil {
+			pricing = &quote
+		}
+	}
+
+	// Only a sale of a till that is still open can be voided; after that it goes through returns.
+	// A sale without a till session has nothing to reconcile and can always be voided.
+	sessionOpen := invoice.SessionID == nil
+	if invoice.SessionID != nil {
+		db.Raw(`
+			SELECT status = $1 FROM supermarket.register_sessions WHERE session_id = $2
+		`, models.RegisterSessionOpen, *invoice.SessionID).Scan(&sessionOpen)
+	}
+
+	var employees []models.Employee
+	db.Raw("SELECT employee_id, full_name FROM supermarket.employees WHERE is_active = true ORDER BY full_name").Scan(&employees)
+
 	return c.Render("pages/sales/view", fiber.Map{
 		"Title":           "Chi tiết hóa đơn",
 		"Active":          "sales",
 		"Invoice":         invoice,
 		"Items":           items,
+		"Returns":         returns,
+		"Allocations":     allocations,
+		"Pricing":         pricing,
+		"Payments":        loadInvoicePayments(db, invoiceID),
+		"TaxBreakdown":    loadInvoiceTaxBreakdown(db, invoiceID),
+		"Employees":       employees,
+		"SessionOpen":     sessionOpen,
+		"EInvoiceCurrent": currentEInvoice(db, uint(invoiceID)) != nil,
 		"SQLQueries":      c.Locals("SQLQueries"),
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
@@ -859,6 +1225,7 @@ func SalesInvoice(c *fiber.Ctx) error {
 		StoreName       string  `json:"store_name"`
 		StoreAddress    string  `json:"store_address"`
 		StorePhone      string  `json:"store_phone"`
+		StoreTaxCode    string  `json:"store_tax_code"`
 	}
 
 	err = db.Raw(`
@@ -869,10 +1236,7 @@ func SalesInvoice(c *fiber.Ctx) error {
 			c.email as customer_email,
 			c.address as customer_address,
 			e.full_name as employee_name,
-			ml.level_name as membership_level,
-			'Siêu thị ABC' as store_name,
-			'123 Đường ABC, Quận 1, TP.HCM' as store_address,
-			'(028) 1234-5678' as store_phone
+			ml.level_name as membership_level
 		FROM supermarket.sales_invoices si
 		LEFT JOIN supermarket.customers c ON si.customer_id = c.customer_id
 		LEFT JOIN supermarket.membership_levels ml ON c.membership_level_id = ml.level_id
@@ -887,6 +1251,10 @@ func SalesInvoice(c *fiber.Ctx) error {
 			"Code":  404,
 		})
 	}
+	invoice.StoreName = receiptSettings.StoreName
+	invoice.StoreAddress = receiptSettings.StoreAddress
+	invoice.StorePhone = receiptSettings.StorePhone
+	invoice.StoreTaxCode = receiptSettings.StoreTaxCode
 
 	// Get invoice items
 	var items []struct {
@@ -918,12 +1286,24 @@ func SalesInvoice(c *fiber.Ctx) error {
 		})
 	}
 
+	var einvoices []einvoiceExportRow
+	db.Raw(einvoiceExportSelect+" WHERE ee.invoice_id = $1 ORDER BY ee.created_at, ee.export_id", invoiceID).Scan(&einvoices)
+	labelEInvoiceRows(einvoices)
+
 	return c.Render("pages/sales/invoice", fiber.Map{
-		"Title":           "Hóa đơn bán hàng",
-		"Invoice":         invoice,
-		"Items":           items,
-		"SQLQueries":      c.Locals("SQLQueries"),
-		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+		"Title":          "Hóa đơn bán hàng",
+		"Invoice":        invoice,
+		"Items":          items,
+		"Payments":       loadInvoicePayments(db, invoiceID),
+		"TaxBreakdown":   loadInvoiceTaxBreakdown(db, invoiceID),
+		"PrinterEnabled": receiptSettings.PrinterAddr != "",
+		// Electronic invoices of the sale
+		"EInvoices":         einvoices,
+		"EInvoiceCurrent":   currentEInvoice(db, invoice.InvoiceID) != nil,
+		"EInvoiceRequired":  invoice.TotalAmount >= einvoiceSettings.Threshold,
+		"EInvoiceThreshold": einvoiceSettings.Threshold,
+		"SQLQueries":        c.Locals("SQLQueries"),
+		"TotalSQLQueries":   c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
 }
 
@@ -0,0 +1,979 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// stockCountScopeLabels are the display names of the count scopes
+var stockCountScopeLabels = map[models.StockCountScope]string{
+	models.StockCountWarehouse: "Theo kho",
+	models.StockCountShelf:     "Theo kệ",
+	models.StockCountCategory:  "Theo danh mục",
+}
+
+// stockCountStatusLabels are the display names of the count session statuses
+var stockCountStatusLabels = map[models.StockCountStatus]string{
+	models.StockCountCounting:  "Đang đếm",
+	models.StockCountRecount:   "Đếm lại",
+	models.StockCountSubmitted: "Chờ duyệt",
+	models.StockCountApproved:  "Đã duyệt",
+	models.StockCountCancelled: "Đã hủy",
+}
+
+// stockReasonLabels are the display names of the stock adjustment reasons
+var stockReasonLabels = map[models.StockReasonCode]string{
+	models.StockReasonDamaged:        "Hư hỏng",
+	models.StockReasonTheft:          "Mất cắp",
+	models.StockReasonExpired:        "Hết hạn",
+	models.StockReasonRecordingError: "Sai sót ghi nhận",
+	models.StockReasonFound:          "Phát hiện thừa",
+	models.StockReasonUnknown:        "Không rõ nguyên nhân",
+	models.StockReasonDonation:       "Tặng từ thiện",
+	models.StockReasonSupplierReturn: "Trả nhà cung cấp",
+}
+
+// stockCountReasons are the reasons a count variance can be explained with
+var stockCountReasons = stockReasonChoices(
+	models.StockReasonDamaged, models.StockReasonTheft, models.StockReasonExpired,
+	models.StockReasonRecordingError, models.StockReasonFound, models.StockReasonUnknown,
+)
+
+// stockReasonChoices picks the labels of the given reasons for a form
+func stockReasonChoices(codes ...models.StockReasonCode) map[models.StockReasonCode]string {
+	choices := make(map[models.StockReasonCode]string, len(codes))
+	for _, code := range codes {
+		choices[code] = stockReasonLabels[code]
+	}
+	return choices
+}
+
+// stockCountRow is a count session with its scope target and progress
+type stockCountRow struct {
+	models.StockCount
+	TargetName    string  `json:"target_name"`
+	CreatorName   string  `json:"creator_name"`
+	ApproverName  string  `json:"approver_name"`
+	LineCount     int     `json:"line_count"`
+	CountedLines  int     `json:"counted_lines"`
+	VarianceValue float64 `json:"variance_value"`
+}
+
+// stockCountLineRow is a count line with the names of its product and location
+type stockCountLineRow struct {
+	models.StockCountLine
+	ProductCode     string  `json:"product_code"`
+	ProductName     string  `json:"product_name"`
+	LocationName    string  `json:"location_name"`
+	CounterName     string  `json:"counter_name"`
+	RecounterName   string  `json:"recounter_name"`
+	Variance        int     `json:"variance"`
+	VarianceValue   float64 `json:"variance_value"`
+	AwaitingRecount bool    `json:"awaiting_recount"`
+	ReasonLabel     string  `json:"reason_label,omitempty"`
+}
+
+func parseStockCountID(c *fiber.Ctx) (uint, error) {
+	countID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ID phiếu kiểm kê không hợp lệ")
+	}
+	return uint(countID), nil
+}
+
+// lockStockCount loads a count session for update
+func lockStockCount(tx *gorm.DB, countID uint) (*models.StockCount, int, error) {
+	var count models.StockCount
+	err := tx.Raw(`
+		SELECT * FROM supermarket.stock_counts
+		WHERE count_id = $1
+		FOR UPDATE
+	`, countID).Scan(&count).Error
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, err
+	}
+	if count.CountID == 0 {
+		return nil, fiber.StatusNotFound, fmt.Errorf("Không tìm thấy phiếu kiểm kê")
+	}
+	return &count, fiber.StatusOK, nil
+}
+
+// loadStockCountLines loads the lines of a count session, warehouse lines first
+func loadStockCountLines(db *gorm.DB, countID uint) ([]stockCountLineRow, error) {
+	var lines []stockCountLineRow
+	err := db.Raw(`
+		SELECT l.*, p.product_code, p.product_name,
+		       COALESCE(w.warehouse_name, ds.shelf_name, '') as location_name,
+		       COALESCE(counter.full_name, '') as counter_name,
+		       COALESCE(recounter.full_name, '') as recounter_name
+		FROM supermarket.stock_count_lines l
+		JOIN supermarket.products p ON l.product_id = p.product_id
+		LEFT JOIN supermarket.warehouse w ON l.warehouse_id = w.warehouse_id
+		LEFT JOIN supermarket.display_shelves ds ON l.shelf_id = ds.shelf_id
+		LEFT JOIN supermarket.employees counter ON l.counted_by = counter.employee_id
+		LEFT JOIN supermarket.employees recounter ON l.recounted_by = recounter.employee_id
+		WHERE l.count_id = $1
+		ORDER BY l.location DESC, location_name, p.product_name, l.expiry_date NULLS LAST, l.batch_code
+	`, countID).Scan(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		lines[i].Variance = lines[i].StockCountLine.Variance()
+		lines[i].VarianceValue = float64(lines[i].Variance) * lines[i].UnitCost
+		lines[i].AwaitingRecount = lines[i].NeedsRecount && lines[i].RecountQuantity == nil
+		if lines[i].ReasonCode != nil {
+			lines[i].ReasonLabel = stockReasonLabels[*lines[i].ReasonCode]
+		}
+	}
+	return lines, nil
+}
+
+// stockCountFormData loads the choices of the new count form
+func stockCountFormData(db *gorm.DB) fiber.Map {
+	var warehouses []models.Warehouse
+	db.Raw("SELECT warehouse_id, warehouse_code, warehouse_name FROM supermarket.warehouse ORDER BY warehouse_name").Scan(&warehouses)
+
+	var shelves []models.DisplayShelf
+	db.Raw(`
+		SELECT shelf_id, shelf_code, shelf_name
+		FROM supermarket.display_shelves
+		WHERE is_active = true
+		ORDER BY shelf_name
+	`).Scan(&shelves)
+
+	var categories []models.ProductCategory
+	db.Raw("SELECT category_id, category_name FROM supermarket.product_categories ORDER BY category_name").Scan(&categories)
+
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	return fiber.Map{
+		"Warehouses": warehouses,
+		"Shelves":    shelves,
+		"Categories": categories,
+		"Employees":  employees,
+	}
+}
+
+// StockCountList lists the count sessions with their progress and posted variance
+func StockCountList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	status := strings.ToUpper(c.Query("status"))
+	if _, ok := stockCountStatusLabels[models.StockCountStatus(status)]; !ok {
+		status = ""
+	}
+
+	var counts []stockCountRow
+	err := db.Raw(`
+		SELECT sc.*,
+		       COALESCE(w.warehouse_name, ds.shelf_name, pc.category_name, '') as target_name,
+		       creator.full_name as creator_name, COALESCE(approver.full_name, '') as approver_name,
+		       (SELECT COUNT(*) FROM supermarket.stock_count_lines l WHERE l.count_id = sc.count_id) as line_count,
+		       (SELECT COUNT(*) FROM supermarket.stock_count_lines l
+		        WHERE l.count_id = sc.count_id AND l.counted_quantity IS NOT NULL) as counted_lines,
+		       (SELECT COALESCE(SUM(l.adjusted_quantity * l.unit_cost), 0)
+		        FROM supermarket.stock_count_lines l
+		        WHERE l.count_id = sc.count_id AND l.adjusted_quantity IS NOT NULL) as variance_value
+		FROM supermarket.stock_counts sc
+		LEFT JOIN supermarket.warehouse w ON sc.warehouse_id = w.warehouse_id
+		LEFT JOIN supermarket.display_shelves ds ON sc.shelf_id = ds.shelf_id
+		LEFT JOIN supermarket.product_categories pc ON sc.category_id = pc.category_id
+		JOIN supermarket.employees creator ON sc.created_by = creator.employee_id
+		LEFT JOIN supermarket.employees approver ON sc.approved_by = approver.employee_id
+		WHERE $1 = '' OR sc.status = $1
+		ORDER BY sc.created_at DESC, sc.count_id DESC
+		LIMIT 200
+	`, status).Scan(&counts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách kiểm kê: " + err.Error(),
+		})
+	}
+
+	data := stockCountFormData(db)
+	data["Title"] = "Kiểm kê hàng hóa"
+	data["Active"] = "inventory"
+	data["Counts"] = counts
+	data["Status"] = status
+	data["StatusLabels"] = stockCountStatusLabels
+	data["ScopeLabels"] = stockCountScopeLabels
+	data["DefaultThreshold"] = models.DefaultRecountThresholdPercent
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/inventory/counts", data, "layouts/base")
+}
+
+// StockCountCreate opens a count session and snapshots the batches in its scope
+func StockCountCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	scope := models.StockCountScope(strings.ToUpper(c.FormValue("scope")))
+	if _, ok := stockCountScopeLabels[scope]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Phạm vi kiểm kê không hợp lệ"})
+	}
+
+	count := models.StockCount{
+		Scope:                   scope,
+		Blind:                   c.FormValue("blind") == "on" || c.FormValue("blind") == "true",
+		Status:                  models.StockCountCounting,
+		RecountThresholdPercent: models.DefaultRecountThresholdPercent,
+		CreatedBy:               employeeID,
+		Notes:                   nullIfEmpty(c.FormValue("notes")),
+	}
+	targetField := map[models.StockCountScope]string{
+		models.StockCountWarehouse: "warehouse_id",
+		models.StockCountShelf:     "shelf_id",
+		models.StockCountCategory:  "category_id",
+	}[scope]
+	targetID, err := strconv.ParseUint(c.FormValue(targetField), 10, 64)
+	if err != nil || targetID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Vui lòng chọn kho, kệ hoặc danh mục cần kiểm kê"})
+	}
+	target := uint(targetID)
+	switch scope {
+	case models.StockCountWarehouse:
+		count.WarehouseID = &target
+	case models.StockCountShelf:
+		count.ShelfID = &target
+	case models.StockCountCategory:
+		count.CategoryID = &target
+	}
+	if v := strings.TrimSpace(c.FormValue("recount_threshold_percent")); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Ngưỡng đếm lại không hợp lệ"})
+		}
+		count.RecountThresholdPercent = threshold
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	count.CountNo, err = nextDocumentNo(tx, models.DocStockCount, "")
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Create(&count).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tạo phiếu kiểm kê: " + err.Error(),
+		})
+	}
+
+	var warehouseID, shelfID, categoryID uint
+	if count.WarehouseID != nil {
+		warehouseID = *count.WarehouseID
+	}
+	if count.ShelfID != nil {
+		shelfID = *count.ShelfID
+	}
+	if count.CategoryID != nil {
+		categoryID = *count.CategoryID
+	}
+
+	// Batches that are on the books; stock found outside them is received, not counted
+	var lineCount int64
+	if scope != models.StockCountShelf {
+		res := tx.Exec(`
+			INSERT INTO supermarket.stock_count_lines
+				(count_id, location, warehouse_id, product_id, batch_code, expiry_date, unit_cost, system_quantity, created_at)
+			SELECT $1, $2, wi.warehouse_id, wi.product_id, wi.batch_code, wi.expiry_date, wi.import_price, wi.quantity, CURRENT_TIMESTAMP
+			FROM supermarket.warehouse_inventory wi
+			JOIN supermarket.products p ON wi.product_id = p.product_id
+			WHERE wi.quantity > 0
+			  AND ($3 = 0 OR wi.warehouse_id = $3)
+			  AND ($4 = 0 OR p.category_id = $4)
+		`, count.CountID, models.StockLocationWarehouse, warehouseID, categoryID)
+		if res.Error != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể lấy tồn kho cần kiểm kê: " + res.Error.Error(),
+			})
+		}
+		lineCount += res.RowsAffected
+	}
+	if scope != models.StockCountWarehouse {
+		res := tx.Exec(`
+			INSERT INTO supermarket.stock_count_lines
+				(count_id, location, shelf_id, product_id, batch_code, expiry_date, unit_cost, system_quantity, created_at)
+			SELECT $1, $2, sbi.shelf_id, sbi.product_id, sbi.batch_code, sbi.expiry_date, sbi.import_price, sbi.quantity, CURRENT_TIMESTAMP
+			FROM supermarket.shelf_batch_inventory sbi
+			JOIN supermarket.products p ON sbi.product_id = p.product_id
+			WHERE sbi.quantity > 0
+			  AND ($3 = 0 OR sbi.shelf_id = $3)
+			  AND ($4 = 0 OR p.category_id = $4)
+		`, count.CountID, models.StockLocationShelf, shelfID, categoryID)
+		if res.Error != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể lấy tồn kệ cần kiểm kê: " + res.Error.Error(),
+			})
+		}
+		lineCount += res.RowsAffected
+	}
+	if lineCount == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không có lô hàng nào trong phạm vi kiểm kê"})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":  true,
+			"count_id": count.CountID,
+			"count_no": count.CountNo,
+			"lines":    lineCount,
+			"message":  fmt.Sprintf("Đã tạo phiếu kiểm kê %s với %d lô hàng", count.CountNo, lineCount),
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/counts/%d", count.CountID))
+}
+
+// StockCountView shows a count session. While a blind session is counting, the system
+// quantities and variances are left out, also from the JSON.
+func StockCountView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	countID, err := parseStockCountID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": err.Error(),
+		})
+	}
+
+	var count stockCountRow
+	db.Raw(`
+		SELECT sc.*,
+		       COALESCE(w.warehouse_name, ds.shelf_name, pc.category_name, '') as target_name,
+		       creator.full_name as creator_name, COALESCE(approver.full_name, '') as approver_name
+		FROM supermarket.stock_counts sc
+		LEFT JOIN supermarket.warehouse w ON sc.warehouse_id = w.warehouse_id
+		LEFT JOIN supermarket.display_shelves ds ON sc.shelf_id = ds.shelf_id
+		LEFT JOIN supermarket.product_categories pc ON sc.category_id = pc.category_id
+		JOIN supermarket.employees creator ON sc.created_by = creator.employee_id
+		LEFT JOIN supermarket.employees approver ON sc.approved_by = approver.employee_id
+		WHERE sc.count_id = $1
+	`, countID).Scan(&count)
+	if count.CountID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy phiếu kiểm kê",
+		})
+	}
+
+	lines, err := loadStockCountLines(db, countID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải các dòng kiểm kê: " + err.Error(),
+		})
+	}
+
+	hidden := count.HidesSystemQuantity()
+	var shortageValue, surplusValue float64
+	varianceLines := 0
+	for i := range lines {
+		if hidden {
+			lines[i].SystemQuantity, lines[i].Variance, lines[i].VarianceValue = 0, 0, 0
+			continue
+		}
+		if lines[i].Variance != 0 {
+			varianceLines++
+		}
+		if lines[i].VarianceValue < 0 {
+			shortageValue += lines[i].VarianceValue
+		} else {
+			surplusValue += lines[i].VarianceValue
+		}
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"count":          count,
+			"lines":          lines,
+			"blind":          hidden,
+			"variance_lines": varianceLines,
+			"shortage_value": shortageValue,
+			"surplus_value":  surplusValue,
+		})
+	}
+
+	data := stockCountFormData(db)
+	data["Title"] = "Phiếu kiểm kê " + count.CountNo
+	data["Active"] = "inventory"
+	data["Count"] = count
+	data["Lines"] = lines
+	data["Hidden"] = hidden
+	data["VarianceLines"] = varianceLines
+	data["ShortageValue"] = shortageValue
+	data["SurplusValue"] = surplusValue
+	data["StatusLabels"] = stockCountStatusLabels
+	data["ScopeLabels"] = stockCountScopeLabels
+	data["ReasonLabels"] = stockCountReasons
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/inventory/count_view", data, "layouts/base")
+}
+
+// currentBatchQuantity reads the book quantity of a counted batch now; a batch that has since
+// been removed counts as 0
+func currentBatchQuantity(tx *gorm.DB, line *models.StockCountLine, forUpdate bool) (uint, int, error) {
+	var row struct {
+		ID       uint
+		Quantity int
+	}
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE"
+	}
+	var err error
+	if line.Location == models.StockLocationWarehouse {
+		err = tx.Raw(`
+			SELECT inventory_id as id, quantity FROM supermarket.warehouse_inventory
+			WHERE warehouse_id = $1 AND product_id = $2 AND batch_code = $3
+		`+lock, *line.WarehouseID, line.ProductID, line.BatchCode).Scan(&row).Error
+	} else {
+		err = tx.Raw(`
+			SELECT shelf_batch_id as id, quantity FROM supermarket.shelf_batch_inventory
+			WHERE shelf_id = $1 AND product_id = $2 AND batch_code = $3
+		`+lock, *line.ShelfID, line.ProductID, line.BatchCode).Scan(&row).Error
+	}
+	return row.ID, row.Quantity, err
+}
+
+// StockCountRecord saves the counted quantities of a session. Fields are named qty_<line id>;
+// blank fields are skipped so a count can be entered in several rounds. During a recount
+// only the lines sent to recount take a quantity.
+func StockCountRecord(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	countID, err := parseStockCountID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	count, status, err := lockStockCount(tx, countID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !count.IsCounting() {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Phiếu kiểm kê không còn nhận số đếm"})
+	}
+
+	var lines []models.StockCountLine
+	if err := tx.Where("count_id = ?", countID).Find(&lines).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	recorded := 0
+	now := time.Now()
+	for i := range lines {
+		line := &lines[i]
+		value := strings.TrimSpace(c.FormValue(fmt.Sprintf("qty_%d", line.LineID)))
+		if value == "" {
+			continue
+		}
+		if count.Status == models.StockCountRecount && !line.NeedsRecount {
+			continue
+		}
+		quantity, err := strconv.Atoi(value)
+		if err != nil || quantity < 0 {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Số lượng đếm của lô %s không hợp lệ", line.BatchCode),
+			})
+		}
+
+		// The book quantity is taken again at the moment of counting, so sales and
+		// transfers since the session opened are not counted as variance
+		_, system, err := currentBatchQuantity(tx, line, false)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		updates := map[string]interface{}{"system_quantity": system}
+		if count.Status == models.StockCountRecount {
+			updates["recount_quantity"] = quantity
+			updates["recounted_by"] = employeeID
+			updates["recounted_at"] = now
+		} else {
+			updates["counted_quantity"] = quantity
+			updates["counted_by"] = employeeID
+			updates["counted_at"] = now
+		}
+		if err := tx.Model(line).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể lưu số đếm: " + err.Error(),
+			})
+		}
+		recorded++
+	}
+
+	if err := tx.Exec("UPDATE supermarket.stock_counts SET updated_at = CURRENT_TIMESTAMP WHERE count_id = $1", countID).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":  true,
+			"recorded": recorded,
+			"message":  fmt.Sprintf("Đã lưu số đếm của %d lô hàng", recorded),
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/counts/%d", countID))
+}
+
+// StockCountSubmit closes a counting round. After the first count the lines whose variance
+// reaches the session's threshold go to recount; once they are recounted the session waits
+// for approval.
+func StockCountSubmit(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	countID, err := parseStockCountID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	count, status, err := lockStockCount(tx, countID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !count.IsCounting() {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Phiếu kiểm kê đã được nộp"})
+	}
+
+	var lines []models.StockCountLine
+	if err := tx.Where("count_id = ?", countID).Find(&lines).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	recounts := 0
+	for i := range lines {
+		line := &lines[i]
+		if line.CountedQuantity == nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Lô %s chưa được đếm", line.BatchCode),
+			})
+		}
+		if count.Status == models.StockCountRecount {
+			if line.NeedsRecount && line.RecountQuantity == nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Lô %s chưa được đếm lại", line.BatchCode),
+				})
+			}
+			continue
+		}
+		if line.ExceedsRecountThreshold(count.RecountThresholdPercent) {
+			if err := tx.Model(line).Update("needs_recount", true).Error; err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+			}
+			recounts++
+		}
+	}
+
+	next := models.StockCountSubmitted
+	if recounts > 0 {
+		next = models.StockCountRecount
+	}
+	updates := map[string]interface{}{"status": next, "updated_at": time.Now()}
+	if next == models.StockCountSubmitted {
+		updates["submitted_at"] = time.Now()
+	}
+	if err := tx.Model(&models.StockCount{}).Where("count_id = ?", countID).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể nộp phiếu kiểm kê: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	message := "Đã nộp phiếu kiểm kê chờ duyệt"
+	if recounts > 0 {
+		message = fmt.Sprintf("Có %d lô hàng chênh lệch lớn cần đếm lại", recounts)
+	}
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":  true,
+			"status":   next,
+			"recounts": recounts,
+			"message":  message,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/counts/%d", countID))
+}
+
+// StockCountApprove posts the variances of a submitted session to the batches they were
+// counted on. Each variance line needs a reason code (reason_<line id>). The variance is
+// applied to the batch as it stands now, so stock moved after the count is kept; a batch
+// can not go below zero.
+func StockCountApprove(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	countID, err := parseStockCountID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	approverID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	count, status, err := lockStockCount(tx, countID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if count.Status != models.StockCountSubmitted {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Chỉ duyệt được phiếu kiểm kê đã nộp"})
+	}
+
+	// Whoever opened or counted the session does not approve its adjustments
+	var involved int64
+	tx.Raw(`
+		SELECT COUNT(*) FROM supermarket.stock_count_lines
+		WHERE count_id = $1 AND (counted_by = $2 OR recounted_by = $2)
+	`, countID, approverID).Scan(&involved)
+	if involved > 0 || count.CreatedBy == approverID {
+		tx.Rollback()
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Người lập hoặc người đếm không được duyệt phiếu kiểm kê",
+		})
+	}
+
+	lines, err := loadStockCountLines(tx, countID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	adjusted, netQuantity := 0, 0
+	var netValue float64
+	for i := range lines {
+		line := &lines[i].StockCountLine
+		variance := line.Variance()
+		if variance == 0 {
+			continue
+		}
+		reason := models.StockReasonCode(c.FormValue(fmt.Sprintf("reason_%d", line.LineID)))
+		if _, ok := stockCountReasons[reason]; !ok {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Vui lòng chọn lý do chênh lệch cho %s, lô %s", lines[i].ProductName, line.BatchCode),
+			})
+		}
+
+		recordID, current, err := currentBatchQuantity(tx, line, true)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		change := 0
+		if recordID != 0 {
+			change = max(current+variance, 0) - current
+		}
+
+		table := "warehouse_inventory"
+		if change != 0 && line.Location == models.StockLocationWarehouse {
+			err = tx.Exec(`
+				UPDATE supermarket.warehouse_inventory
+				SET quantity = quantity + $1, updated_at = CURRENT_TIMESTAMP
+				WHERE inventory_id = $2
+			`, change, recordID).Error
+		} else if change != 0 {
+			table = "shelf_batch_inventory"
+			err = tx.Exec(`
+				UPDATE supermarket.shelf_batch_inventory
+				SET quantity = quantity + $1, updated_at = CURRENT_TIMESTAMP
+				WHERE shelf_batch_id = $2
+			`, change, recordID).Error
+			if err == nil {
+				err = syncShelfInventorySummary(tx, *line.ShelfID, line.ProductID)
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Không thể điều chỉnh tồn của %s, lô %s: %v", lines[i].ProductName, line.BatchCode, err),
+			})
+		}
+
+		err = tx.Model(&models.StockCountLine{}).Where("line_id = ?", line.LineID).Updates(map[string]interface{}{
+			"reason_code":       reason,
+			"adjusted_quantity": change,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if change != 0 {
+			err = tx.Exec(`
+				INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+				VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+			`, models.ActivityTypeInventoryAdjustment,
+				fmt.Sprintf("Kiểm kê %s: %s lô %s tại %s %+d (%s)", count.CountNo, lines[i].ProductName, line.BatchCode,
+					lines[i].LocationName, change, stockReasonLabels[reason]),
+				table, recordID, approverID).Error
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Không thể ghi nhật ký điều chỉnh tồn: " + err.Error(),
+				})
+			}
+			adjusted++
+			netQuantity += change
+			netValue += float64(change) * line.UnitCost
+		}
+	}
+
+	err = tx.Model(&models.StockCount{}).Where("count_id = ?", countID).Updates(map[string]interface{}{
+		"status":      models.StockCountApproved,
+		"approved_by": approverID,
+		"approved_at": time.Now(),
+		"updated_at":  time.Now(),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể duyệt phiếu kiểm kê: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":      true,
+			"adjusted":     adjusted,
+			"net_quantity": netQuantity,
+			"net_value":    netValue,
+			"message":      fmt.Sprintf("Đã điều chỉnh tồn %d lô hàng", adjusted),
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/counts/%d", countID))
+}
+
+// StockCountCancel abandons a session that has not been approved; stock is left untouched
+func StockCountCancel(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	countID, err := parseStockCountID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	count, status, err := lockStockCount(tx, countID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if count.Status == models.StockCountApproved || count.Status == models.StockCountCancelled {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Phiếu kiểm kê đã được duyệt hoặc đã hủy"})
+	}
+
+	err = tx.Model(&models.StockCount{}).Where("count_id = ?", countID).Updates(map[string]interface{}{
+		"status":     models.StockCountCancelled,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hủy phiếu kiểm kê: " + err.Error(),
+		})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{"success": true, "message": "Đã hủy phiếu kiểm kê " + count.CountNo})
+	}
+
+	return c.Redirect("/inventory/counts")
+}
+
+// StockVarianceReport totals the adjustments posted by approved counts in a period by reason
+// and by category, valued at the batches' import price
+func StockVarianceReport(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	now := time.Now()
+	dateFrom := c.Query("date_from", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02"))
+	dateTo := c.Query("date_to", now.Format("2006-01-02"))
+
+	type varianceRow struct {
+		Group         string  `json:"group"`
+		LineCount     int     `json:"line_count"`
+		ShortageQty   int     `json:"shortage_qty"`
+		SurplusQty    int     `json:"surplus_qty"`
+		ShortageValue float64 `json:"shortage_value"`
+		SurplusValue  float64 `json:"surplus_value"`
+		NetValue      float64 `json:"net_value"`
+	}
+	query := func(groupBy string) ([]varianceRow, error) {
+		var rows []varianceRow
+		err := db.Raw(`
+			SELECT `+groupBy+` as "group", COUNT(*) as line_count,
+			       COALESCE(SUM(CASE WHEN l.adjusted_quantity < 0 THEN -l.adjusted_quantity ELSE 0 END), 0) as shortage_qty,
+			       COALESCE(SUM(CASE WHEN l.adjusted_quantity > 0 THEN l.adjusted_quantity ELSE 0 END), 0) as surplus_qty,
+			       COALESCE(SUM(CASE WHEN l.adjusted_quantity < 0 THEN -l.adjusted_quantity * l.unit_cost ELSE 0 END), 0) as shortage_value,
+			       COALESCE(SUM(CASE WHEN l.adjusted_quantity > 0 THEN l.adjusted_quantity * l.unit_cost ELSE 0 END), 0) as surplus_value,
+			       COALESCE(SUM(l.adjusted_quantity * l.unit_cost), 0) as net_value
+			FROM supermarket.stock_count_lines l
+			JOIN supermarket.stock_counts sc ON l.count_id = sc.count_id
+			JOIN supermarket.products p ON l.product_id = p.product_id
+			JOIN supermarket.product_categories pc ON p.category_id = pc.category_id
+			WHERE sc.status = $1 AND l.adjusted_quantity <> 0
+			  AND DATE(sc.approved_at) BETWEEN $2 AND $3
+			GROUP BY 1
+			ORDER BY net_value
+		`, models.StockCountApproved, dateFrom, dateTo).Scan(&rows).Error
+		return rows, err
+	}
+
+	byReason, err := query("l.reason_code")
+	if err == nil {
+		for i := range byReason {
+			byReason[i].Group = stockReasonLabels[models.StockReasonCode(byReason[i].Group)]
+		}
+	}
+	var byCategory []varianceRow
+	if err == nil {
+		byCategory, err = query("pc.category_name")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải báo cáo chênh lệch kiểm kê: " + err.Error(),
+		})
+	}
+
+	var total varianceRow
+	for _, r := range byReason {
+		total.LineCount += r.LineCount
+		total.ShortageQty += r.ShortageQty
+		total.SurplusQty += r.SurplusQty
+		total.ShortageValue += r.ShortageValue
+		total.SurplusValue += r.SurplusValue
+		total.NetValue += r.NetValue
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"date_from":   dateFrom,
+			"date_to":     dateTo,
+			"by_reason":   byReason,
+			"by_category": byCategory,
+			"total":       total,
+		})
+	}
+
+	return c.Render("pages/reports/stock_variance", fiber.Map{
+		"Title":           "Chênh lệch kiểm kê",
+		"Active":          "reports",
+		"DateFrom":        dateFrom,
+		"DateTo":          dateTo,
+		"ByReason":        byReason,
+		"ByCategory":      byCategory,
+		"Total":           total,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
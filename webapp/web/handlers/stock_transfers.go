@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// stockTransferRequest is the body of a warehouse to shelf transfer. BatchCode pins the batch
+// to draw on first; the rest of the quantity follows the first expiry first out order.
+type stockTransferRequest struct {
+	ProductID       uint   `json:"product_id" form:"product_id"`
+	FromWarehouseID uint   `json:"from_warehouse_id" form:"from_warehouse_id"`
+	ToShelfID       uint   `json:"to_shelf_id" form:"to_shelf_id"`
+	Quantity        int    `json:"quantity" form:"quantity"`
+	EmployeeID      uint   `json:"employee_id" form:"employee_id"`
+	BatchCode       string `json:"batch_code" form:"batch_code"`
+	Notes           string `json:"notes" form:"notes"`
+}
+
+// transferAllocation is a warehouse batch that can be moved to a shelf and the quantity
+// allocated from it
+type transferAllocation struct {
+	InventoryID uint       `json:"inventory_id"`
+	BatchCode   string     `json:"batch_code"`
+	ExpiryDate  *time.Time `json:"expiry_date,omitempty"`
+	ImportPrice float64    `json:"import_price"`
+	Available   int        `json:"available"`
+	Quantity    int        `json:"quantity"`
+	Pinned      bool       `json:"pinned,omitempty"`
+}
+
+// checkStockTransferRequest checks the fields a transfer and its preview both need
+func checkStockTransferRequest(req *stockTransferRequest) error {
+	req.BatchCode = strings.TrimSpace(req.BatchCode)
+	if req.ProductID == 0 {
+		return fmt.Errorf("Vui lòng chọn sản phẩm")
+	}
+	if req.FromWarehouseID == 0 {
+		return fmt.Errorf("ID kho nguồn không hợp lệ")
+	}
+	if req.Quantity <= 0 {
+		return fmt.Errorf("Số lượng không hợp lệ")
+	}
+	return nil
+}
+
+// transferableBatches lists the unexpired batches of a product in a warehouse in first expiry
+// first out order. forUpdate locks them for a transfer about to draw on them.
+func transferableBatches(db *gorm.DB, warehouseID, productID uint, forUpdate bool) ([]transferAllocation, error) {
+	query := `
+		SELECT inventory_id, batch_code, expiry_date, import_price, quantity as available
+		FROM supermarket.warehouse_inventory
+		WHERE warehouse_id = $1 AND product_id = $2 AND quantity > 0
+		  AND (expiry_date IS NULL OR expiry_date >= CURRENT_DATE)
+		ORDER BY expiry_date NULLS LAST, import_date, inventory_id
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var batches []transferAllocation
+	err := db.Raw(query, warehouseID, productID).Scan(&batches).Error
+	return batches, err
+}
+
+// allocateTransferBatches allocates quantity over batches, the pinned batch first and then in
+// the order given, and returns one allocation per batch drawn on with the part it could not
+// cover. The allocated quantities are taken off the batches' Available, so several
+// allocations against the same slice never promise the same stock twice.
+func allocateTransferBatches(batches []transferAllocation, quantity int, pinnedBatch string) ([]transferAllocation, int, error) {
+	order := make([]int, 0, len(batches))
+	if pinnedBatch != "" {
+		pinned := -1
+		for i := range batches {
+			if batches[i].BatchCode == pinnedBatch {
+				pinned = i
+				break
+			}
+		}
+		if pinned < 0 {
+			return nil, quantity, fmt.Errorf("Lô %s không còn hàng còn hạn trong kho", pinnedBatch)
+		}
+		order = append(order, pinned)
+	}
+	for i := range batches {
+		if batches[i].BatchCode != pinnedBatch {
+			order = append(order, i)
+		}
+	}
+
+	var allocation []transferAllocation
+	remaining := quantity
+	for _, i := range order {
+		if remaining == 0 {
+			break
+		}
+		take := min(batches[i].Available, remaining)
+		if take <= 0 {
+			continue
+		}
+		line := batches[i]
+		line.Quantity = take
+		line.Pinned = line.BatchCode == pinnedBatch
+		allocation = append(allocation, line)
+		batches[i].Available -= take
+		remaining -= take
+	}
+	return allocation, remaining, nil
+}
+
+// executeStockTransfer writes a transfer header and one stock_transfers line per allocated
+// batch; the stock triggers move each line's quantity from its batch onto the shelf
+func executeStockTransfer(tx *gorm.DB, req *stockTransferRequest, allocation []transferAllocation) (*models.StockTransferHeader, error) {
+	var sellingPrice float64
+	err := tx.Raw("SELECT selling_price FROM supermarket.products WHERE product_id = $1", req.ProductID).Scan(&sellingPrice).Error
+	if err != nil {
+		return nil, fmt.Errorf("Không thể lấy giá bán sản phẩm: %v", err)
+	}
+
+	transferNo, err := nextDocumentNo(tx, models.DocStockTransfer, "")
+	if err != nil {
+		return nil, err
+	}
+	header := models.StockTransferHeader{
+		TransferNo:      transferNo,
+		ProductID:       req.ProductID,
+		FromWarehouseID: req.FromWarehouseID,
+		ToShelfID:       req.ToShelfID,
+		EmployeeID:      req.EmployeeID,
+		PinnedBatchCode: nullIfEmpty(req.BatchCode),
+		Notes:           nullIfEmpty(req.Notes),
+		TransferDate:    time.Now(),
+	}
+	for _, line := range allocation {
+		header.Quantity += line.Quantity
+	}
+	if err := tx.Create(&header).Error; err != nil {
+		return nil, fmt.Errorf("Không thể tạo phiếu chuyển hàng: %v", err)
+	}
+
+	for i, line := range allocation {
+		// A single batch keeps the transfer number; several batches number their lines
+		transferCode := transferNo
+		if len(allocation) > 1 {
+			transferCode = fmt.Sprintf("%s-%d", transferNo, i+1)
+		}
+		transfer := models.StockTransfer{
+			TransferCode:    transferCode,
+			HeaderID:        &header.HeaderID,
+			ProductID:       req.ProductID,
+			FromWarehouseID: req.FromWarehouseID,
+			ToShelfID:       req.ToShelfID,
+			Quantity:        line.Quantity,
+			EmployeeID:      req.EmployeeID,
+			BatchCode:       line.BatchCode,
+			ExpiryDate:      line.ExpiryDate,
+			ImportPrice:     line.ImportPrice,
+			SellingPrice:    sellingPrice,
+			Notes:           header.Notes,
+		}
+
+		// Let database triggers handle the inventory updates
+		err := tx.Raw(`
+			INSERT INTO supermarket.stock_transfers
+			(transfer_code, header_id, product_id, from_warehouse_id, to_shelf_id, quantity,
+			 employee_id, batch_code, expiry_date, import_price, selling_price, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING transfer_id
+		`, transfer.TransferCode, transfer.HeaderID, transfer.ProductID, transfer.FromWarehouseID,
+			transfer.ToShelfID, transfer.Quantity, transfer.EmployeeID, transfer.BatchCode,
+			transfer.ExpiryDate, transfer.ImportPrice, transfer.SellingPrice, transfer.Notes,
+		).Scan(&transfer.TransferID).Error
+		if err != nil {
+			// Database triggers will provide detailed error messages
+			return nil, fmt.Errorf("Không thể chuyển lô %s: %v", line.BatchCode, err)
+		}
+		header.Lines = append(header.Lines, transfer)
+	}
+	return &header, nil
+}
+
+// StockTransferPreview shows how a transfer would be allocated over the warehouse batches
+// without moving anything
+func StockTransferPreview(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	req := stockTransferRequest{
+		ProductID:       uint(c.QueryInt("product_id")),
+		FromWarehouseID: uint(c.QueryInt("from_warehouse_id")),
+		ToShelfID:       uint(c.QueryInt("to_shelf_id")),
+		Quantity:        c.QueryInt("quantity"),
+		BatchCode:       c.Query("batch_code"),
+	}
+	if err := checkStockTransferRequest(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	batches, err := transferableBatches(db, req.FromWarehouseID, req.ProductID, false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tải tồn kho theo lô: " + err.Error(),
+		})
+	}
+	// The batches to pin are listed with what they hold before this allocation
+	available := append([]transferAllocation(nil), batches...)
+	allocation, shortfall, err := allocateTransferBatches(batches, req.Quantity, req.BatchCode)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result := fiber.Map{
+		"requested":  req.Quantity,
+		"allocated":  req.Quantity - shortfall,
+		"shortfall":  shortfall,
+		"allocation": allocation,
+		"batches":    available,
+	}
+
+	if req.ToShelfID != 0 {
+		var shelf struct {
+			MaxQuantity     *int `json:"max_quantity"`
+			CurrentQuantity int  `json:"current_quantity"`
+		}
+		db.Raw(`
+			SELECT sl.max_quantity, COALESCE(si.current_quantity, 0) as current_quantity
+			FROM supermarket.shelf_layout sl
+			LEFT JOIN supermarket.shelf_inventory si ON sl.shelf_id = si.shelf_id AND sl.product_id = si.product_id
+			WHERE sl.shelf_id = $1 AND sl.product_id = $2
+		`, req.ToShelfID, req.ProductID).Scan(&shelf)
+		result["shelf_max_quantity"] = shelf.MaxQuantity
+		result["shelf_current_quantity"] = shelf.CurrentQuantity
+		switch {
+		case shelf.MaxQuantity == nil:
+			result["warning"] = "Sản phẩm chưa được bố trí trên quầy này"
+		case shelf.CurrentQuantity+req.Quantity > *shelf.MaxQuantity:
+			result["warning"] = fmt.Sprintf("Quầy chỉ còn chỗ cho %d sản phẩm", max(*shelf.MaxQuantity-shelf.CurrentQuantity, 0))
+		}
+	}
+
+	return c.JSON(result)
+}
+
+// StockTransfer processes stock transfer from warehouse to shelf, drawing on as many
+// warehouse batches as the quantity needs
+func StockTransfer(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var req stockTransferRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dữ liệu không hợp lệ: " + err.Error(),
+		})
+	}
+	if err := checkStockTransferRequest(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.ToShelfID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID quầy đích không hợp lệ",
+		})
+	}
+	if req.EmployeeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID nhân viên không hợp lệ",
+		})
+	}
+
+	// The batches are locked and the transfer number allocated in the same transaction as the transfer
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	batches, err := transferableBatches(tx, req.FromWarehouseID, req.ProductID, true)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể tải tồn kho theo lô: " + err.Error(),
+		})
+	}
+	allocation, shortfall, err := allocateTransferBatches(batches, req.Quantity, req.BatchCode)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if shortfall > 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Kho chỉ còn %d sản phẩm còn hạn, không đủ %d", req.Quantity-shortfall, req.Quantity),
+		})
+	}
+
+	header, err := executeStockTransfer(tx, &req, allocation)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể thực hiện chuyển hàng: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	// Return success response
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":     true,
+			"transfer":    header,
+			"transfer_id": header.Lines[0].TransferID,
+			"message":     fmt.Sprintf("Chuyển hàng thành công %d sản phẩm từ %d lô", header.Quantity, len(header.Lines)),
+		})
+	}
+
+	// Redirect to inventory page
+	return c.Redirect("/inventory")
+}
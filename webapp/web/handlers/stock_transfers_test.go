@@ -0,0 +1,75 @@
+package handlers
+
+import "testing"
+
+func TestAllocateTransferBatches(t *testing.T) {
+	// Batches come in FEFO order, the way transferableBatches loads them
+	newBatches := func() []transferAllocation {
+		return []transferAllocation{
+			{InventoryID: 1, BatchCode: "LOT-A", Available: 5},
+			{InventoryID: 2, BatchCode: "LOT-B", Available: 10},
+			{InventoryID: 3, BatchCode: "LOT-C", Available: 20},
+		}
+	}
+
+	type take struct {
+		batch    string
+		quantity int
+		pinned   bool
+	}
+	tests := []struct {
+		name          string
+		quantity      int
+		pinned        string
+		want          []take
+		wantRemaining int
+		wantErr       bool
+	}{
+		{"single batch", 3, "", []take{{"LOT-A", 3, false}}, 0, false},
+		{"spans batches in order", 12, "", []take{{"LOT-A", 5, false}, {"LOT-B", 7, false}}, 0, false},
+		{"pinned batch first", 12, "LOT-C", []take{{"LOT-C", 12, true}}, 0, false},
+		{"pinned batch then the rest", 27, "LOT-B", []take{{"LOT-B", 10, true}, {"LOT-A", 5, false}, {"LOT-C", 12, false}}, 0, false},
+		{"short of stock", 40, "", []take{{"LOT-A", 5, false}, {"LOT-B", 10, false}, {"LOT-C", 20, false}}, 5, false},
+		{"unknown pinned batch", 5, "LOT-X", nil, 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, remaining, err := allocateTransferBatches(newBatches(), tt.quantity, tt.pinned)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if remaining != tt.wantRemaining {
+				t.Errorf("remaining = %d, want %d", remaining, tt.wantRemaining)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d allocations, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				if got[i].BatchCode != w.batch || got[i].Quantity != w.quantity || got[i].Pinned != w.pinned {
+					t.Errorf("allocation %d = %s x %d (pinned %v), want %s x %d (pinned %v)",
+						i, got[i].BatchCode, got[i].Quantity, got[i].Pinned, w.batch, w.quantity, w.pinned)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateTransferBatchesSharedStock(t *testing.T) {
+	batches := []transferAllocation{
+		{InventoryID: 1, BatchCode: "LOT-A", Available: 5},
+		{InventoryID: 2, BatchCode: "LOT-B", Available: 10},
+	}
+
+	// Two allocations against the same batches must not promise the same stock twice
+	if _, remaining, _ := allocateTransferBatches(batches, 8, ""); remaining != 0 {
+		t.Fatalf("first allocation remaining = %d, want 0", remaining)
+	}
+	got, remaining, _ := allocateTransferBatches(batches, 10, "")
+	if remaining != 3 {
+		t.Errorf("second allocation remaining = %d, want 3", remaining)
+	}
+	if len(got) != 1 || got[0].BatchCode != "LOT-B" || got[0].Quantity != 7 {
+		t.Errorf("second allocation = %+v, want LOT-B x 7", got)
+	}
+}
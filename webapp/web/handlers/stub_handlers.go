@@ -8,6 +8,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/supermarket/database"
 	"github.com/supermarket/models"
+	"gorm.io/gorm"
 )
 
 // Employee handlers (full CRUD)
@@ -69,11 +70,19 @@ func EmployeeCreate(c *fiber.Ctx) error {
 	positionID, _ := strconv.ParseUint(c.FormValue("position_id"), 10, 64)
 	hireDate := c.FormValue("hire_date")
 	isActive := c.FormValue("is_active") == "on"
+	pinHash, err := approvalPINFormValue(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if pinHash != nil && *pinHash == "" {
+		pinHash = nil
+	}
 
-	err := db.Exec(`
+	err = db.Exec(`
         INSERT INTO supermarket.employees
-        (employee_code, full_name, position_id, phone, email, address, hire_date, id_card, bank_account, is_active)
-        VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+        (employee_code, full_name, position_id, phone, email, address, hire_date, id_card, bank_account, is_active,
+         approval_pin_hash)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
     `,
 		c.FormValue("employee_code"),
 		c.FormValue("full_name"),
@@ -85,6 +94,7 @@ func EmployeeCreate(c *fiber.Ctx) error {
 		nullIfEmpty(c.FormValue("id_card")),
 		nullIfEmpty(c.FormValue("bank_account")),
 		isActive,
+		pinHash,
 	).Error
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể tạo nhân viên: " + err.Error()})
@@ -151,11 +161,21 @@ func EmployeeUpdate(c *fiber.Ctx) error {
 	positionID, _ := strconv.ParseUint(c.FormValue("position_id"), 10, 64)
 	hireDate := c.FormValue("hire_date")
 	isActive := c.FormValue("is_active") == "on"
+	// A blank PIN keeps the stored one
+	pinHash, err := approvalPINFormValue(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	keepPIN := pinHash == nil
+	if pinHash != nil && *pinHash == "" {
+		pinHash = nil
+	}
 
-	err := db.Exec(`
+	err = db.Exec(`
         UPDATE supermarket.employees
         SET employee_code=$1, full_name=$2, position_id=$3, phone=$4, email=$5,
-            address=$6, hire_date=$7, id_card=$8, bank_account=$9, is_active=$10
+            address=$6, hire_date=$7, id_card=$8, bank_account=$9, is_active=$10,
+            approval_pin_hash=CASE WHEN $12 THEN approval_pin_hash ELSE $13 END
         WHERE employee_id=$11
     `,
 		c.FormValue("employee_code"),
@@ -169,6 +189,8 @@ func EmployeeUpdate(c *fiber.Ctx) error {
 		nullIfEmpty(c.FormValue("bank_account")),
 		isActive,
 		id,
+		keepPIN,
+		pinHash,
 	).Error
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể cập nhật nhân viên: " + err.Error()})
@@ -322,6 +344,9 @@ func CustomerView(c *fiber.Ctx) error {
 		"Active":          "customers",
 		"Customer":        customer,
 		"Invoices":        invoices,
+		"TierProgress":    customerTierProgress(db, customer.CustomerID, customer.MembershipLevelID),
+		"TierHistory":     loadTierHistory(db, customer.CustomerID, 50),
+		"ChangeLabels":    tierChangeLabels,
 		"SQLQueries":      c.Locals("SQLQueries"),
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
@@ -356,7 +381,20 @@ func CustomerUpdate(c *fiber.Ctx) error {
 	levelID, _ := strconv.ParseUint(c.FormValue("membership_level_id"), 10, 64)
 	isActive := c.FormValue("is_active") == "on"
 
-	err := db.Exec(`
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var current models.Customer
+	if err := tx.Raw("SELECT * FROM supermarket.customers WHERE customer_id = $1 FOR UPDATE", id).Scan(&current).Error; err != nil || current.CustomerID == 0 {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Không tìm thấy khách hàng"})
+	}
+
+	err := tx.Exec(`
         UPDATE supermarket.customers
         SET customer_code=$1, full_name=$2, phone=NULLIF($3,''), email=NULLIF($4,''), address=NULLIF($5,''),
             membership_card_no=NULLIF($6,''), membership_level_id=NULLIF($7,0), is_active=$8
@@ -373,8 +411,37 @@ func CustomerUpdate(c *fiber.Ctx) error {
 		id,
 	).Error
 	if err != nil {
+		tx.Rollback()
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể cập nhật khách hàng: " + err.Error()})
 	}
+
+	// A level set by hand ends any grace period and is recorded in the tier history
+	var fromLevel uint
+	if current.MembershipLevelID != nil {
+		fromLevel = *current.MembershipLevelID
+	}
+	if uint(levelID) != fromLevel {
+		var toLevelID *uint
+		if levelID > 0 {
+			to := uint(levelID)
+			toLevelID = &to
+		}
+		spending, _ := customerWindowSpending(tx, current.CustomerID, tierWindowStart(time.Now()))
+		if err := tx.Exec("UPDATE supermarket.customers SET tier_grace_until = NULL WHERE customer_id = $1", id).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể cập nhật khách hàng: " + err.Error()})
+		}
+		err := writeTierHistory(tx, current.CustomerID, models.TierManual, current.MembershipLevelID, toLevelID,
+			spending, nil, "Đổi hạng thủ công khi cập nhật khách hàng")
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể ghi lịch sử hạng: " + err.Error()})
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể cập nhật khách hàng: " + err.Error()})
+	}
 	return c.Redirect("/customers")
 }
 
@@ -388,8 +455,21 @@ func CustomerDelete(c *fiber.Ctx) error {
 	if cnt > 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể xóa khách hàng có hóa đơn"})
 	}
+	db.Raw("SELECT COUNT(*) FROM supermarket.loyalty_transactions WHERE customer_id = $1", id).Scan(&cnt)
+	if cnt > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể xóa khách hàng đã có giao dịch điểm"})
+	}
 
-	if err := db.Exec("DELETE FROM supermarket.customers WHERE customer_id = $1", id).Error; err != nil {
+	tx := db.Begin()
+	if err := tx.Exec("DELETE FROM supermarket.membership_tier_history WHERE customer_id = $1", id).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa khách hàng: " + err.Error()})
+	}
+	if err := tx.Exec("DELETE FROM supermarket.customers WHERE customer_id = $1", id).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa khách hàng: " + err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa khách hàng: " + err.Error()})
 	}
 	return c.SendStatus(fiber.StatusOK)
@@ -541,130 +621,7 @@ func StockTransferForm(c *fiber.Ctx) error {
 	}, "layouts/base")
 }
 
-// StockTransfer processes stock transfer from warehouse to shelf
-func StockTransfer(c *fiber.Ctx) error {
-	db := database.GetDB()
-
-	// Parse form data
-	productIDStr := c.FormValue("product_id")
-	if productIDStr == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Vui lòng chọn sản phẩm",
-		})
-	}
-
-	productID, err := strconv.ParseUint(productIDStr, 10, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID sản phẩm không hợp lệ: " + productIDStr,
-		})
-	}
-
-	fromWarehouseID, err := strconv.ParseUint(c.FormValue("from_warehouse_id"), 10, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID kho nguồn không hợp lệ",
-		})
-	}
-
-	toShelfID, err := strconv.ParseUint(c.FormValue("to_shelf_id"), 10, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID quầy đích không hợp lệ",
-		})
-	}
-
-	quantity, err := strconv.ParseInt(c.FormValue("quantity"), 10, 64)
-	if err != nil || quantity <= 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Số lượng không hợp lệ",
-		})
-	}
-
-	employeeID, err := strconv.ParseUint(c.FormValue("employee_id"), 10, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID nhân viên không hợp lệ",
-		})
-	}
-
-	// Get batch information from warehouse inventory
-	var warehouseInventory struct {
-		BatchCode   string     `json:"batch_code"`
-		ExpiryDate  *time.Time `json:"expiry_date"`
-		ImportPrice float64    `json:"import_price"`
-		Available   int64      `json:"available"`
-	}
-
-	err = db.Raw(`
-		SELECT wi.batch_code, wi.expiry_date, wi.import_price, wi.quantity as available
-		FROM supermarket.warehouse_inventory wi
-		WHERE wi.warehouse_id = $1 AND wi.product_id = $2 AND wi.quantity >= $3
-		ORDER BY wi.expiry_date NULLS LAST, wi.import_date
-		LIMIT 1
-	`, fromWarehouseID, productID, quantity).Scan(&warehouseInventory).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Không tìm thấy batch phù hợp trong kho hoặc không đủ số lượng",
-		})
-	}
-
-	// Get product selling price
-	var sellingPrice float64
-	err = db.Raw("SELECT selling_price FROM supermarket.products WHERE product_id = $1", productID).Scan(&sellingPrice).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Không thể lấy giá bán sản phẩm: " + err.Error(),
-		})
-	}
-
-	// Generate transfer code
-	transferCode := fmt.Sprintf("TR%s%03d", time.Now().Format("20060102"), time.Now().Nanosecond()%1000)
-
-	// Insert stock transfer record - Let database triggers handle the inventory updates
-	query := `
-		INSERT INTO supermarket.stock_transfers 
-		(transfer_code, product_id, from_warehouse_id, to_shelf_id, quantity, 
-		 employee_id, batch_code, expiry_date, import_price, selling_price, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING transfer_id
-	`
-
-	var transferID uint
-	err = db.Raw(query,
-		transferCode,
-		productID,
-		fromWarehouseID,
-		toShelfID,
-		quantity,
-		employeeID,
-		warehouseInventory.BatchCode,
-		warehouseInventory.ExpiryDate,
-		warehouseInventory.ImportPrice,
-		sellingPrice,
-		c.FormValue("notes"),
-	).Scan(&transferID).Error
-
-	if err != nil {
-		// Database triggers will provide detailed error messages
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Không thể thực hiện chuyển hàng: " + err.Error(),
-		})
-	}
-
-	// Return success response
-	if c.Get("Content-Type") == "application/json" {
-		return c.JSON(fiber.Map{
-			"success":     true,
-			"transfer_id": transferID,
-			"message":     fmt.Sprintf("Chuyển hàng thành công %d sản phẩm", quantity),
-		})
-	}
-
-	// Redirect to inventory page
-	return c.Redirect("/inventory")
-}
+// Stock transfer processing moved to stock_transfers.go
 
 // Sales handlers moved to sales.go
 
@@ -854,28 +811,21 @@ func GetShelfProducts(c *fiber.Ctx) error {
 	return c.JSON(products)
 }
 
-// CheckInventory checks real-time inventory for a product
-func CheckInventory(c *fiber.Ctx) error {
-	db := database.GetDB()
-
-	productIDStr := c.Params("productId")
-	productID, err := strconv.ParseUint(productIDStr, 10, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID sản phẩm không hợp lệ",
-		})
-	}
+// productAvailability is the live stock and price of a product as reported by CheckInventory
+type productAvailability struct {
+	ShelfQuantity     int64    `json:"available"`
+	WarehouseQuantity int64    `json:"warehouse"`
+	SellingPrice      float64  `json:"selling_price"`
+	DiscountPrice     *float64 `json:"discount_price"`
+	ExpiryDate        *string  `json:"expiry_date"`
+	DaysToExpiry      *int     `json:"days_to_expiry"`
+}
 
-	var inventory struct {
-		ShelfQuantity     int64    `json:"shelf_quantity"`
-		WarehouseQuantity int64    `json:"warehouse_quantity"`
-		SellingPrice      float64  `json:"selling_price"`
-		DiscountPrice     *float64 `json:"discount_price"`
-		ExpiryDate        *string  `json:"expiry_date"`
-		DaysToExpiry      *int     `json:"days_to_expiry"`
-	}
+// loadProductAvailability reads the live shelf and warehouse stock of a product
+func loadProductAvailability(db *gorm.DB, productID uint64) (*productAvailability, error) {
+	var inventory productAvailability
 
-	err = db.Raw(`
+	err := db.Raw(`
 		SELECT 
 			COALESCE(si.current_quantity, 0) as shelf_quantity,
 			COALESCE(SUM(wi.quantity), 0) as warehouse_quantity,
@@ -898,21 +848,33 @@ func CheckInventory(c *fiber.Ctx) error {
 		WHERE p.product_id = $1
 		GROUP BY p.product_id, si.current_quantity, p.selling_price, sbi.expiry_date
 	`, productID).Scan(&inventory).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &inventory, nil
+}
+
+// CheckInventory checks real-time inventory for a product
+func CheckInventory(c *fiber.Ctx) error {
+	db := database.GetDB()
 
+	productIDStr := c.Params("productId")
+	productID, err := strconv.ParseUint(productIDStr, 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID sản phẩm không hợp lệ",
+		})
+	}
+
+	inventory, err := loadProductAvailability(db, productID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Không thể kiểm tra tồn kho: " + err.Error(),
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"available":      inventory.ShelfQuantity,
-		"warehouse":      inventory.WarehouseQuantity,
-		"selling_price":  inventory.SellingPrice,
-		"discount_price": inventory.DiscountPrice,
-		"expiry_date":    inventory.ExpiryDate,
-		"days_to_expiry": inventory.DaysToExpiry,
-	})
+	return c.JSON(inventory)
 }
 
 // CalculateDiscount calculates discount for a product based on expiry date
@@ -1183,13 +1145,15 @@ func PositionCreate(c *fiber.Ctx) error {
 	db := database.GetDB()
 	err := db.Exec(`
         INSERT INTO supermarket.positions
-        (position_code, position_name, base_salary, hourly_rate)
-        VALUES ($1,$2,$3,$4)
+        (position_code, position_name, base_salary, hourly_rate, override_max_percent, override_max_amount)
+        VALUES ($1,$2,$3,$4,$5,$6)
     `,
 		c.FormValue("position_code"),
 		c.FormValue("position_name"),
 		c.FormValue("base_salary"),
 		c.FormValue("hourly_rate"),
+		nullIfEmpty(c.FormValue("override_max_percent")),
+		nullIfEmpty(c.FormValue("override_max_amount")),
 	).Error
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể tạo chức danh: " + err.Error()})
@@ -1235,7 +1199,8 @@ func PositionUpdate(c *fiber.Ctx) error {
 	id := c.Params("id")
 	err := db.Exec(`
         UPDATE supermarket.positions
-        SET position_code=$1, position_name=$2, base_salary=$3, hourly_rate=$4
+        SET position_code=$1, position_name=$2, base_salary=$3, hourly_rate=$4,
+            override_max_percent=$6, override_max_amount=$7
         WHERE position_id=$5
     `,
 		c.FormValue("position_code"),
@@ -1243,6 +1208,8 @@ func PositionUpdate(c *fiber.Ctx) error {
 		c.FormValue("base_salary"),
 		c.FormValue("hourly_rate"),
 		id,
+		nullIfEmpty(c.FormValue("override_max_percent")),
+		nullIfEmpty(c.FormValue("override_max_amount")),
 	).Error
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể cập nhật chức danh: " + err.Error()})
@@ -1273,6 +1240,9 @@ func LevelList(c *fiber.Ctx) error {
 		"Title":           "Cấp thành viên",
 		"Active":          "membership-levels",
 		"Levels":          rows,
+		"TierChanges":     loadTierHistory(db, 0, 20),
+		"ChangeLabels":    tierChangeLabels,
+		"Settings":        loyaltySettings,
 		"SQLQueries":      c.Locals("SQLQueries"),
 		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
 	}, "layouts/base")
@@ -1371,6 +1341,10 @@ func LevelDelete(c *fiber.Ctx) error {
 	if ref > 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể xóa cấp thành viên đang có khách hàng"})
 	}
+	db.Raw("SELECT COUNT(*) FROM supermarket.membership_tier_history WHERE from_level_id=$1 OR to_level_id=$1", id).Scan(&ref)
+	if ref > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Không thể xóa cấp thành viên đã có trong lịch sử hạng"})
+	}
 	if err := db.Exec("DELETE FROM supermarket.membership_levels WHERE level_id=$1", id).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa cấp thành viên: " + err.Error()})
 	}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// taxClassRow is a tax class with where it is used
+type taxClassRow struct {
+	models.TaxClass
+	CategoryCount int64 `json:"category_count"`
+	ProductCount  int64 `json:"product_count"`
+	LineCount     int64 `json:"line_count"`
+}
+
+// categoryTaxRow is a product category with its tax class (0 = default rate)
+type categoryTaxRow struct {
+	CategoryID   uint   `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	TaxClassID   uint   `json:"tax_class_id"`
+}
+
+// loadInvoiceTaxBreakdown sums the tax of an invoice by rate and mode
+func loadInvoiceTaxBreakdown(db *gorm.DB, invoiceID uint64) []taxBreakdownRow {
+	var rows []taxBreakdownRow
+	db.Raw(`
+		SELECT tax_rate, tax_mode,
+			SUM(subtotal - CASE WHEN tax_mode = 'INCLUSIVE' THEN tax_amount ELSE 0 END) as taxable_amount,
+			SUM(tax_amount) as tax_amount
+		FROM supermarket.sales_invoice_details
+		WHERE invoice_id = $1
+		GROUP BY tax_rate, tax_mode
+		ORDER BY tax_rate, tax_mode
+	`, invoiceID).Scan(&rows)
+	return rows
+}
+
+// loadTaxClasses returns the active tax classes for the selects
+func loadTaxClasses(db *gorm.DB) []models.TaxClass {
+	var taxClasses []models.TaxClass
+	db.Raw("SELECT * FROM supermarket.tax_classes WHERE is_active = true ORDER BY rate, tax_code").Scan(&taxClasses)
+	return taxClasses
+}
+
+// optionalTaxClassID reads an optional tax class id; empty means "inherit"
+func optionalTaxClassID(c *fiber.Ctx, name string) (*uint, error) {
+	s := c.FormValue(name)
+	if s == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Nhóm thuế không hợp lệ")
+	}
+	taxClassID := uint(id)
+	return &taxClassID, nil
+}
+
+// parseTaxClassForm reads and validates the tax class form
+func parseTaxClassForm(c *fiber.Ctx) (*models.TaxClass, error) {
+	tc := &models.TaxClass{
+		TaxCode:     strings.ToUpper(strings.TrimSpace(c.FormValue("tax_code"))),
+		TaxName:     strings.TrimSpace(c.FormValue("tax_name")),
+		TaxMode:     models.TaxMode(c.FormValue("tax_mode")),
+		Description: nullIfEmpty(strings.TrimSpace(c.FormValue("description"))),
+		IsActive:    c.FormValue("is_active") == "on" || c.FormValue("is_active") == "true",
+	}
+	if tc.TaxCode == "" || tc.TaxName == "" {
+		return nil, fmt.Errorf("Vui lòng nhập mã và tên nhóm thuế")
+	}
+	if tc.TaxMode != models.TaxInclusive && tc.TaxMode != models.TaxExclusive {
+		return nil, fmt.Errorf("Cách tính thuế không hợp lệ")
+	}
+	rate, err := strconv.ParseFloat(c.FormValue("rate"), 64)
+	if err != nil || rate < 0 || rate > 100 {
+		return nil, fmt.Errorf("Thuế suất phải từ 0 đến 100%%")
+	}
+	tc.Rate = rate
+	return tc, nil
+}
+
+// TaxClassList shows the tax classes and which class each product category uses
+func TaxClassList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var rows []taxClassRow
+	err := db.Raw(`
+		SELECT tc.*,
+			(SELECT COUNT(*) FROM supermarket.product_categories pc WHERE pc.tax_class_id = tc.tax_class_id) as category_count,
+			(SELECT COUNT(*) FROM supermarket.products p WHERE p.tax_class_id = tc.tax_class_id) as product_count,
+			(SELECT COUNT(*) FROM supermarket.sales_invoice_details sid WHERE sid.tax_class_id = tc.tax_class_id) as line_count
+		FROM supermarket.tax_classes tc
+		ORDER BY tc.is_active DESC, tc.rate, tc.tax_code
+	`).Scan(&rows).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách nhóm thuế: " + err.Error(),
+		})
+	}
+
+	var categories []categoryTaxRow
+	db.Raw(`
+		SELECT category_id, category_name, COALESCE(tax_class_id, 0) as tax_class_id
+		FROM supermarket.product_categories
+		ORDER BY category_name
+	`).Scan(&categories)
+
+	return c.Render("pages/tax_classes/list", fiber.Map{
+		"Title":           "Nhóm thuế VAT",
+		"Active":          "products",
+		"TaxClasses":      rows,
+		"ActiveClasses":   loadTaxClasses(db),
+		"Categories":      categories,
+		"DefaultRate":     models.DefaultTaxRate,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// TaxClassNew shows the form to create a tax class
+func TaxClassNew(c *fiber.Ctx) error {
+	return c.Render("pages/tax_classes/form", fiber.Map{
+		"Title":           "Thêm nhóm thuế",
+		"Active":          "products",
+		"IsNew":           true,
+		"TaxClass":        models.TaxClass{TaxMode: models.TaxInclusive, IsActive: true},
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// TaxClassCreate creates a tax class
+func TaxClassCreate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	tc, err := parseTaxClassForm(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = db.Exec(`
+		INSERT INTO supermarket.tax_classes
+		(tax_code, tax_name, rate, tax_mode, description, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, tc.TaxCode, tc.TaxName, tc.Rate, tc.TaxMode, tc.Description, tc.IsActive).Error
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể tạo nhóm thuế: " + err.Error(),
+		})
+	}
+
+	return c.Redirect("/tax-classes")
+}
+
+// TaxClassEdit shows the form to edit a tax class
+func TaxClassEdit(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var tc models.TaxClass
+	db.Raw("SELECT * FROM supermarket.tax_classes WHERE tax_class_id = $1", c.Params("id")).Scan(&tc)
+	if tc.TaxClassID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy nhóm thuế",
+			"Code":  404,
+		})
+	}
+
+	return c.Render("pages/tax_classes/form", fiber.Map{
+		"Title":           "Sửa nhóm thuế",
+		"Active":          "products",
+		"IsNew":           false,
+		"TaxClass":        tc,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// TaxClassUpdate updates a tax class. Invoice lines keep the rate they were sold with.
+func TaxClassUpdate(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	tc, err := parseTaxClassForm(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	result := db.Exec(`
+		UPDATE supermarket.tax_classes
+		SET tax_code = $1, tax_name = $2, rate = $3, tax_mode = $4, description = $5, is_active = $6,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE tax_class_id = $7
+	`, tc.TaxCode, tc.TaxName, tc.Rate, tc.TaxMode, tc.Description, tc.IsActive, c.Params("id"))
+	if result.Error != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Không thể cập nhật nhóm thuế: " + result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy nhóm thuế",
+		})
+	}
+
+	return c.Redirect("/tax-classes")
+}
+
+// TaxClassDelete deletes an unused tax class; one already used is only deactivated
+func TaxClassDelete(c *fiber.Ctx) error {
+	db := database.GetDB()
+	id := c.Params("id")
+
+	var used int64
+	db.Raw(`
+		SELECT
+			(SELECT COUNT(*) FROM supermarket.product_categories WHERE tax_class_id = $1) +
+			(SELECT COUNT(*) FROM supermarket.products WHERE tax_class_id = $1) +
+			(SELECT COUNT(*) FROM supermarket.sales_invoice_details WHERE tax_class_id = $1)
+	`, id).Scan(&used)
+	if used > 0 {
+		if err := db.Exec("UPDATE supermarket.tax_classes SET is_active = false, updated_at = CURRENT_TIMESTAMP WHERE tax_class_id = $1", id).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể tắt nhóm thuế: " + err.Error()})
+		}
+		return c.JSON(fiber.Map{"success": true, "deactivated": true, "message": "Nhóm thuế đang được sử dụng nên chỉ được tắt"})
+	}
+
+	if err := db.Exec("DELETE FROM supermarket.tax_classes WHERE tax_class_id = $1", id).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Không thể xóa nhóm thuế: " + err.Error()})
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// TaxClassAssignCategories saves the tax class of every product category
+// (form fields category_<id>; empty means the default rate)
+func TaxClassAssignCategories(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var categories []models.ProductCategory
+	db.Raw("SELECT category_id FROM supermarket.product_categories").Scan(&categories)
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, category := range categories {
+		taxClassID, err := optionalTaxClassID(c, fmt.Sprintf("category_%d", category.CategoryID))
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		err = tx.Exec(`
+			UPDATE supermarket.product_categories
+			SET tax_class_id = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE category_id = $2
+		`, taxClassID, category.CategoryID).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Không thể cập nhật nhóm thuế của danh mục: " + err.Error(),
+			})
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	return c.Redirect("/tax-classes")
+}
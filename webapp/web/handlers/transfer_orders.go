@@ -0,0 +1,757 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// transferOrderStatusLabels are the display names of the transfer order statuses
+var transferOrderStatusLabels = map[models.TransferOrderStatus]string{
+	models.TransferOrderInTransit: "Đang vận chuyển",
+	models.TransferOrderReceived:  "Đã nhận",
+	models.TransferOrderCancelled: "Đã hủy",
+}
+
+// transferDiscrepancyReasons are the reasons a short receipt can be explained with
+var transferDiscrepancyReasons = stockReasonChoices(
+	models.StockReasonDamaged, models.StockReasonTheft,
+	models.StockReasonRecordingError, models.StockReasonUnknown,
+)
+
+// transferOrderItem is one source batch to send; a zero quantity sends all the batch holds
+type transferOrderItem struct {
+	BatchID  uint `json:"batch_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// transferOrderRequest is the body of a dispatch: JSON with a list of items, or the form with
+// a qty_<batch id> field per source batch
+type transferOrderRequest struct {
+	FromLocation  models.StockLocation `json:"from_location" form:"from_location"`
+	FromID        uint                 `json:"from_id" form:"from_id"`
+	ToWarehouseID uint                 `json:"to_warehouse_id" form:"to_warehouse_id"`
+	EmployeeID    uint                 `json:"employee_id" form:"employee_id"`
+	Notes         string               `json:"notes" form:"notes"`
+	Items         []transferOrderItem  `json:"items" form:"-"`
+}
+
+// transferOrderRow is a transfer order with the names of its locations and people
+type transferOrderRow struct {
+	models.TransferOrder
+	FromName        string  `json:"from_name"`
+	ToName          string  `json:"to_name"`
+	DispatcherName  string  `json:"dispatcher_name"`
+	ReceiverName    string  `json:"receiver_name"`
+	LineCount       int     `json:"line_count"`
+	DispatchedUnits int     `json:"dispatched_units"`
+	ReceivedUnits   int     `json:"received_units"`
+	ShortValue      float64 `json:"short_value"`
+}
+
+// transferOrderLineRow is a transfer order line with its product and receipt figures
+type transferOrderLineRow struct {
+	models.TransferOrderLine
+	ProductCode   string  `json:"product_code"`
+	ProductName   string  `json:"product_name"`
+	InTransit     int     `json:"in_transit"`
+	ShortQuantity int     `json:"short_quantity"`
+	ShortValue    float64 `json:"short_value"`
+	ReasonLabel   string  `json:"reason_label,omitempty"`
+}
+
+func parseTransferOrderID(c *fiber.Ctx) (uint, error) {
+	orderID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ID phiếu điều chuyển không hợp lệ")
+	}
+	return uint(orderID), nil
+}
+
+// lockTransferOrder loads a transfer order for update
+func lockTransferOrder(tx *gorm.DB, orderID uint) (*models.TransferOrder, int, error) {
+	var order models.TransferOrder
+	err := tx.Raw(`
+		SELECT * FROM supermarket.transfer_orders
+		WHERE transfer_order_id = $1
+		FOR UPDATE
+	`, orderID).Scan(&order).Error
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, err
+	}
+	if order.TransferOrderID == 0 {
+		return nil, fiber.StatusNotFound, fmt.Errorf("Không tìm thấy phiếu điều chuyển")
+	}
+	return &order, fiber.StatusOK, nil
+}
+
+// loadTransferOrderLines loads the lines of a transfer order
+func loadTransferOrderLines(db *gorm.DB, orderID uint) ([]transferOrderLineRow, error) {
+	var lines []transferOrderLineRow
+	err := db.Raw(`
+		SELECT l.*, p.product_code, p.product_name
+		FROM supermarket.transfer_order_lines l
+		JOIN supermarket.products p ON l.product_id = p.product_id
+		WHERE l.transfer_order_id = $1
+		ORDER BY p.product_name, l.expiry_date NULLS LAST, l.batch_code
+	`, orderID).Scan(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		lines[i].InTransit = lines[i].InTransitQuantity()
+		lines[i].ShortQuantity = lines[i].TransferOrderLine.ShortQuantity()
+		lines[i].ShortValue = float64(lines[i].ShortQuantity) * lines[i].ImportPrice
+		if lines[i].DiscrepancyReason != nil {
+			lines[i].ReasonLabel = stockReasonLabels[*lines[i].DiscrepancyReason]
+		}
+	}
+	return lines, nil
+}
+
+// sourceBatches lists the batches with stock in a warehouse or on a shelf
+func sourceBatches(db *gorm.DB, location models.StockLocation, locationID uint) ([]stockBatch, error) {
+	var batches []stockBatch
+	var err error
+	switch location {
+	case models.StockLocationWarehouse:
+		err = db.Raw(`
+			SELECT wi.inventory_id as id, wi.warehouse_id, wi.product_id, p.product_name, wi.batch_code,
+			       wi.expiry_date, wi.quantity, wi.import_price
+			FROM supermarket.warehouse_inventory wi
+			JOIN supermarket.products p ON wi.product_id = p.product_id
+			WHERE wi.warehouse_id = $1 AND wi.quantity > 0
+			ORDER BY p.product_name, wi.expiry_date NULLS LAST, wi.batch_code
+		`, locationID).Scan(&batches).Error
+	case models.StockLocationShelf:
+		err = db.Raw(`
+			SELECT sbi.shelf_batch_id as id, sbi.shelf_id, sbi.product_id, p.product_name, sbi.batch_code,
+			       sbi.expiry_date, sbi.quantity, sbi.import_price
+			FROM supermarket.shelf_batch_inventory sbi
+			JOIN supermarket.products p ON sbi.product_id = p.product_id
+			WHERE sbi.shelf_id = $1 AND sbi.quantity > 0
+			ORDER BY p.product_name, sbi.expiry_date NULLS LAST, sbi.batch_code
+		`, locationID).Scan(&batches).Error
+	default:
+		return nil, fmt.Errorf("Vị trí xuất không hợp lệ")
+	}
+	return batches, err
+}
+
+// parseTransferOrderRequest reads and checks a dispatch request
+func parseTransferOrderRequest(c *fiber.Ctx, db *gorm.DB) (*transferOrderRequest, error) {
+	var req transferOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, fmt.Errorf("Dữ liệu không hợp lệ: %v", err)
+	}
+	req.FromLocation = models.StockLocation(strings.ToUpper(string(req.FromLocation)))
+	if req.FromLocation != models.StockLocationWarehouse && req.FromLocation != models.StockLocationShelf {
+		return nil, fmt.Errorf("Vị trí xuất không hợp lệ")
+	}
+	if req.FromID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn nơi xuất hàng")
+	}
+	if req.ToWarehouseID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn kho nhận")
+	}
+	if req.FromLocation == models.StockLocationWarehouse && req.FromID == req.ToWarehouseID {
+		return nil, fmt.Errorf("Kho nhận phải khác kho xuất")
+	}
+	if req.EmployeeID == 0 {
+		return nil, fmt.Errorf("Vui lòng chọn nhân viên thực hiện")
+	}
+
+	if c.Get("Content-Type") != "application/json" {
+		batches, err := sourceBatches(db, req.FromLocation, req.FromID)
+		if err != nil {
+			return nil, err
+		}
+		for _, batch := range batches {
+			value := strings.TrimSpace(c.FormValue(fmt.Sprintf("qty_%d", batch.ID)))
+			if value == "" || value == "0" {
+				continue
+			}
+			quantity, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Số lượng chuyển của lô %s không hợp lệ", batch.BatchCode)
+			}
+			req.Items = append(req.Items, transferOrderItem{BatchID: batch.ID, Quantity: quantity})
+		}
+	}
+	for _, item := range req.Items {
+		if item.Quantity < 0 {
+			return nil, fmt.Errorf("Số lượng chuyển không hợp lệ")
+		}
+	}
+	if len(req.Items) == 0 {
+		return nil, fmt.Errorf("Vui lòng nhập số lượng cần chuyển")
+	}
+	return &req, nil
+}
+
+// dispatchTransferOrder writes a transfer order and takes its quantities out of the source
+// batches. The quantities stay in transit until the destination receives them.
+func dispatchTransferOrder(tx *gorm.DB, req *transferOrderRequest) (*models.TransferOrder, error) {
+	var toName string
+	tx.Raw("SELECT warehouse_name FROM supermarket.warehouse WHERE warehouse_id = $1", req.ToWarehouseID).Scan(&toName)
+	if toName == "" {
+		return nil, fmt.Errorf("Không tìm thấy kho nhận")
+	}
+
+	transferNo, err := nextDocumentNo(tx, models.DocTransferOrder, "")
+	if err != nil {
+		return nil, err
+	}
+	order := models.TransferOrder{
+		TransferNo:    transferNo,
+		FromLocation:  req.FromLocation,
+		ToWarehouseID: req.ToWarehouseID,
+		Status:        models.TransferOrderInTransit,
+		DispatchedBy:  req.EmployeeID,
+		DispatchedAt:  time.Now(),
+		Notes:         nullIfEmpty(req.Notes),
+	}
+	fromID := req.FromID
+	if req.FromLocation == models.StockLocationWarehouse {
+		order.FromWarehouseID = &fromID
+	} else {
+		order.FromShelfID = &fromID
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, fmt.Errorf("Không thể tạo phiếu điều chuyển: %v", err)
+	}
+
+	units := 0
+	shelfProducts := map[uint]bool{}
+	for _, item := range req.Items {
+		batch, err := loadStockBatch(tx, req.FromLocation, item.BatchID, true)
+		if err != nil {
+			return nil, err
+		}
+		if (batch.WarehouseID != nil && *batch.WarehouseID != fromID) || (batch.ShelfID != nil && *batch.ShelfID != fromID) {
+			return nil, fmt.Errorf("Lô %s không thuộc nơi xuất đã chọn", batch.BatchCode)
+		}
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = batch.Quantity
+		}
+		if quantity == 0 {
+			continue
+		}
+		if quantity > batch.Quantity {
+			return nil, fmt.Errorf("Lô %s của %s chỉ còn %d, không thể chuyển %d", batch.BatchCode, batch.ProductName, batch.Quantity, quantity)
+		}
+
+		if req.FromLocation == models.StockLocationWarehouse {
+			err = tx.Exec(`
+				UPDATE supermarket.warehouse_inventory
+				SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP
+				WHERE inventory_id = $2
+			`, quantity, batch.ID).Error
+		} else {
+			err = tx.Exec(`
+				UPDATE supermarket.shelf_batch_inventory
+				SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP
+				WHERE shelf_batch_id = $2
+			`, quantity, batch.ID).Error
+			// The shelf summary is re-read once every batch has been taken
+			shelfProducts[batch.ProductID] = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Không thể trừ tồn lô %s: %v", batch.BatchCode, err)
+		}
+
+		line := models.TransferOrderLine{
+			TransferOrderID:    order.TransferOrderID,
+			SourceBatchID:      batch.ID,
+			ProductID:          batch.ProductID,
+			BatchCode:          batch.BatchCode,
+			ExpiryDate:         batch.ExpiryDate,
+			ImportPrice:        batch.ImportPrice,
+			DispatchedQuantity: quantity,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			return nil, fmt.Errorf("Không thể ghi dòng điều chuyển: %v", err)
+		}
+		order.Lines = append(order.Lines, line)
+		units += quantity
+	}
+	if len(order.Lines) == 0 {
+		return nil, fmt.Errorf("Các lô hàng đã chọn không còn tồn để chuyển")
+	}
+	for productID := range shelfProducts {
+		if err := syncShelfInventorySummary(tx, fromID, productID); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+		VALUES ($1, $2, 'transfer_orders', $3, $4, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeStockTransfer,
+		fmt.Sprintf("Xuất điều chuyển %s: %d sản phẩm từ %d lô về kho %s", order.TransferNo, units, len(order.Lines), toName),
+		order.TransferOrderID, order.DispatchedBy).Error
+	if err != nil {
+		return nil, fmt.Errorf("Không thể ghi nhật ký điều chuyển: %v", err)
+	}
+	return &order, nil
+}
+
+// TransferOrderList lists the transfer orders and the quantities still in transit
+func TransferOrderList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	status := strings.ToUpper(c.Query("status"))
+	if _, ok := transferOrderStatusLabels[models.TransferOrderStatus(status)]; !ok {
+		status = ""
+	}
+
+	var orders []transferOrderRow
+	err := db.Raw(`
+		SELECT t.*,
+		       COALESCE(fw.warehouse_name, ds.shelf_name, '') as from_name, tw.warehouse_name as to_name,
+		       dispatcher.full_name as dispatcher_name, COALESCE(receiver.full_name, '') as receiver_name,
+		       (SELECT COUNT(*) FROM supermarket.transfer_order_lines l WHERE l.transfer_order_id = t.transfer_order_id) as line_count,
+		       (SELECT COALESCE(SUM(l.dispatched_quantity), 0) FROM supermarket.transfer_order_lines l
+		        WHERE l.transfer_order_id = t.transfer_order_id) as dispatched_units,
+		       (SELECT COALESCE(SUM(l.received_quantity), 0) FROM supermarket.transfer_order_lines l
+		        WHERE l.transfer_order_id = t.transfer_order_id) as received_units,
+		       (SELECT COALESCE(SUM((l.dispatched_quantity - l.received_quantity) * l.import_price), 0)
+		        FROM supermarket.transfer_order_lines l
+		        WHERE l.transfer_order_id = t.transfer_order_id AND l.received_quantity IS NOT NULL) as short_value
+		FROM supermarket.transfer_orders t
+		LEFT JOIN supermarket.warehouse fw ON t.from_warehouse_id = fw.warehouse_id
+		LEFT JOIN supermarket.display_shelves ds ON t.from_shelf_id = ds.shelf_id
+		JOIN supermarket.warehouse tw ON t.to_warehouse_id = tw.warehouse_id
+		JOIN supermarket.employees dispatcher ON t.dispatched_by = dispatcher.employee_id
+		LEFT JOIN supermarket.employees receiver ON t.received_by = receiver.employee_id
+		WHERE $1 = '' OR t.status = $1
+		ORDER BY t.dispatched_at DESC, t.transfer_order_id DESC
+		LIMIT 200
+	`, status).Scan(&orders).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách phiếu điều chuyển: " + err.Error(),
+		})
+	}
+
+	var inTransit []struct {
+		ProductCode string  `json:"product_code"`
+		ProductName string  `json:"product_name"`
+		ToName      string  `json:"to_name"`
+		Quantity    int     `json:"quantity"`
+		Value       float64 `json:"value"`
+	}
+	db.Raw(`
+		SELECT p.product_code, p.product_name, w.warehouse_name as to_name,
+		       SUM(l.dispatched_quantity) as quantity, SUM(l.dispatched_quantity * l.import_price) as value
+		FROM supermarket.transfer_order_lines l
+		JOIN supermarket.transfer_orders t ON l.transfer_order_id = t.transfer_order_id
+		JOIN supermarket.products p ON l.product_id = p.product_id
+		JOIN supermarket.warehouse w ON t.to_warehouse_id = w.warehouse_id
+		WHERE t.status = $1
+		GROUP BY p.product_code, p.product_name, w.warehouse_name
+		ORDER BY w.warehouse_name, p.product_name
+	`, models.TransferOrderInTransit).Scan(&inTransit)
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"transfer_orders": orders,
+			"in_transit":      inTransit,
+		})
+	}
+
+	return c.Render("pages/inventory/transfer_orders", fiber.Map{
+		"Title":           "Phiếu điều chuyển",
+		"Active":          "inventory",
+		"Orders":          orders,
+		"InTransit":       inTransit,
+		"Status":          status,
+		"StatusLabels":    transferOrderStatusLabels,
+		"SQLQueries":      c.Locals("SQLQueries"),
+		"TotalSQLQueries": c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// TransferOrderForm is the dispatch form: pick the source, then the quantity to send of each
+// of its batches
+func TransferOrderForm(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	data := stockCountFormData(db)
+	from := models.StockLocation(strings.ToUpper(c.Query("from")))
+	if from != models.StockLocationShelf {
+		from = models.StockLocationWarehouse
+	}
+	fromID, _ := strconv.ParseUint(c.Query("from_id"), 10, 64)
+
+	var batches []stockBatch
+	if fromID != 0 {
+		var err error
+		batches, err = sourceBatches(db, from, uint(fromID))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+				"Title": "Lỗi",
+				"Error": "Không thể tải tồn kho nơi xuất: " + err.Error(),
+			})
+		}
+	}
+
+	data["Title"] = "Xuất điều chuyển"
+	data["Active"] = "inventory"
+	data["From"] = from
+	data["FromID"] = uint(fromID)
+	data["Batches"] = batches
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/inventory/transfer_order_form", data, "layouts/base")
+}
+
+// TransferOrderDispatch sends stock from a warehouse or shelf towards another warehouse
+func TransferOrderDispatch(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	req, err := parseTransferOrderRequest(c, db)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, err := dispatchTransferOrder(tx, req)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":        true,
+			"transfer_order": order,
+			"message":        "Đã xuất hàng theo phiếu điều chuyển " + order.TransferNo,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/transfer-orders/%d", order.TransferOrderID))
+}
+
+// TransferOrderView shows a transfer order; while in transit it is the receipt form
+func TransferOrderView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	orderID, err := parseTransferOrderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": err.Error(),
+		})
+	}
+
+	var order transferOrderRow
+	db.Raw(`
+		SELECT t.*,
+		       COALESCE(fw.warehouse_name, ds.shelf_name, '') as from_name, tw.warehouse_name as to_name,
+		       dispatcher.full_name as dispatcher_name, COALESCE(receiver.full_name, '') as receiver_name
+		FROM supermarket.transfer_orders t
+		LEFT JOIN supermarket.warehouse fw ON t.from_warehouse_id = fw.warehouse_id
+		LEFT JOIN supermarket.display_shelves ds ON t.from_shelf_id = ds.shelf_id
+		JOIN supermarket.warehouse tw ON t.to_warehouse_id = tw.warehouse_id
+		JOIN supermarket.employees dispatcher ON t.dispatched_by = dispatcher.employee_id
+		LEFT JOIN supermarket.employees receiver ON t.received_by = receiver.employee_id
+		WHERE t.transfer_order_id = $1
+	`, orderID).Scan(&order)
+	if order.TransferOrderID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy phiếu điều chuyển",
+		})
+	}
+
+	lines, err := loadTransferOrderLines(db, orderID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải dòng điều chuyển: " + err.Error(),
+		})
+	}
+	for _, line := range lines {
+		order.DispatchedUnits += line.DispatchedQuantity
+		if line.ReceivedQuantity != nil {
+			order.ReceivedUnits += *line.ReceivedQuantity
+		}
+		order.ShortValue += line.ShortValue
+	}
+
+	if c.Get("Accept") == "application/json" {
+		return c.JSON(fiber.Map{
+			"transfer_order": order,
+			"lines":          lines,
+		})
+	}
+
+	data := stockCountFormData(db)
+	data["Title"] = "Phiếu điều chuyển " + order.TransferNo
+	data["Active"] = "inventory"
+	data["Order"] = order
+	data["Lines"] = lines
+	data["StatusLabels"] = transferOrderStatusLabels
+	data["ReasonLabels"] = transferDiscrepancyReasons
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/inventory/transfer_order_view", data, "layouts/base")
+}
+
+// TransferOrderReceive books what arrived into the destination warehouse under the source
+// batch codes, expiry dates and import prices. Whatever is short needs a reason and is
+// written off.
+func TransferOrderReceive(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	orderID, err := parseTransferOrderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, status, err := lockTransferOrder(tx, orderID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !order.IsInTransit() {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Phiếu điều chuyển đã được nhận hoặc đã hủy"})
+	}
+
+	lines, err := loadTransferOrderLines(tx, orderID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	received, short := 0, 0
+	for i := range lines {
+		line := &lines[i].TransferOrderLine
+		quantity := line.DispatchedQuantity
+		if value := strings.TrimSpace(c.FormValue(fmt.Sprintf("received_%d", line.LineID))); value != "" {
+			quantity, err = strconv.Atoi(value)
+			if err != nil || quantity < 0 || quantity > line.DispatchedQuantity {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Số lượng nhận của %s, lô %s phải từ 0 đến %d", lines[i].ProductName, line.BatchCode, line.DispatchedQuantity),
+				})
+			}
+		}
+
+		updates := map[string]interface{}{"received_quantity": quantity}
+		if quantity < line.DispatchedQuantity {
+			reason := models.StockReasonCode(c.FormValue(fmt.Sprintf("reason_%d", line.LineID)))
+			if _, ok := transferDiscrepancyReasons[reason]; !ok {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("Vui lòng chọn lý do thiếu cho %s, lô %s", lines[i].ProductName, line.BatchCode),
+				})
+			}
+			updates["discrepancy_reason"] = reason
+		}
+
+		if quantity > 0 {
+			err = tx.Exec(`
+				INSERT INTO supermarket.warehouse_inventory (warehouse_id, product_id, batch_code, quantity, import_date,
+				                                             expiry_date, import_price, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, CURRENT_DATE, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+				ON CONFLICT (warehouse_id, product_id, batch_code)
+				DO UPDATE SET
+					quantity = warehouse_inventory.quantity + EXCLUDED.quantity,
+					updated_at = CURRENT_TIMESTAMP
+			`, order.ToWarehouseID, line.ProductID, line.BatchCode, quantity, line.ExpiryDate, line.ImportPrice).Error
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": fmt.Sprintf("Không thể nhập kho %s, lô %s: %v", lines[i].ProductName, line.BatchCode, err),
+				})
+			}
+		}
+
+		if err := tx.Model(&models.TransferOrderLine{}).Where("line_id = ?", line.LineID).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		received += quantity
+		short += line.DispatchedQuantity - quantity
+	}
+
+	now := time.Now()
+	err = tx.Model(&models.TransferOrder{}).Where("transfer_order_id = ?", orderID).Updates(map[string]interface{}{
+		"status":      models.TransferOrderReceived,
+		"received_by": employeeID,
+		"received_at": now,
+		"updated_at":  now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể nhận phiếu điều chuyển: " + err.Error(),
+		})
+	}
+
+	description := fmt.Sprintf("Nhận điều chuyển %s: %d sản phẩm", order.TransferNo, received)
+	if short > 0 {
+		description += fmt.Sprintf(", thiếu %d", short)
+	}
+	err = tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+		VALUES ($1, $2, 'transfer_orders', $3, $4, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeStockTransfer, description, orderID, employeeID).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi nhật ký điều chuyển: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":  true,
+			"received": received,
+			"short":    short,
+			"message":  description,
+		})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/transfer-orders/%d", orderID))
+}
+
+// TransferOrderCancel calls back a transfer order still in transit and puts its quantities
+// back into the source batches
+func TransferOrderCancel(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	orderID, err := parseTransferOrderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order, status, err := lockTransferOrder(tx, orderID)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !order.IsInTransit() {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Chỉ hủy được phiếu điều chuyển đang vận chuyển"})
+	}
+
+	var lines []models.TransferOrderLine
+	if err := tx.Where("transfer_order_id = ?", orderID).Find(&lines).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	products := map[uint]bool{}
+	for _, line := range lines {
+		var result *gorm.DB
+		if order.FromLocation == models.StockLocationWarehouse {
+			result = tx.Exec(`
+				UPDATE supermarket.warehouse_inventory
+				SET quantity = quantity + $1, updated_at = CURRENT_TIMESTAMP
+				WHERE inventory_id = $2
+			`, line.DispatchedQuantity, line.SourceBatchID)
+		} else {
+			result = tx.Exec(`
+				UPDATE supermarket.shelf_batch_inventory
+				SET quantity = quantity + $1, updated_at = CURRENT_TIMESTAMP
+				WHERE shelf_batch_id = $2
+			`, line.DispatchedQuantity, line.SourceBatchID)
+			products[line.ProductID] = true
+		}
+		if result.Error == nil && result.RowsAffected == 0 {
+			result.Error = fmt.Errorf("lô nguồn không còn tồn tại")
+		}
+		if result.Error != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Không thể hoàn trả lô %s: %v", line.BatchCode, result.Error),
+			})
+		}
+	}
+	for productID := range products {
+		if err := syncShelfInventorySummary(tx, *order.FromShelfID, productID); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	err = tx.Model(&models.TransferOrder{}).Where("transfer_order_id = ?", orderID).Updates(map[string]interface{}{
+		"status":     models.TransferOrderCancelled,
+		"updated_at": time.Now(),
+	}).Error
+	if err == nil {
+		err = tx.Exec(`
+			INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, user_id, created_at)
+			VALUES ($1, $2, 'transfer_orders', $3, $4, CURRENT_TIMESTAMP)
+		`, models.ActivityTypeStockTransfer, "Hủy điều chuyển "+order.TransferNo+", hàng được trả về nơi xuất",
+			orderID, employeeID).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hủy phiếu điều chuyển: " + err.Error(),
+		})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{"success": true, "message": "Đã hủy phiếu điều chuyển " + order.TransferNo})
+	}
+
+	return c.Redirect(fmt.Sprintf("/inventory/transfer-orders/%d", orderID))
+}
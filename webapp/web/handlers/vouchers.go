@@ -0,0 +1,813 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/supermarket/database"
+	"github.com/supermarket/models"
+	"gorm.io/gorm"
+)
+
+// voucherKindLabels are the display names of the voucher kinds
+var voucherKindLabels = map[models.VoucherKind]string{
+	models.VoucherKindVoucher:  "Voucher",
+	models.VoucherKindGiftCard: "Thẻ quà tặng",
+}
+
+// voucherEntryLabels are the display names of the voucher ledger entry types
+var voucherEntryLabels = map[models.VoucherEntryType]string{
+	models.VoucherEntryIssue:    "Phát hành",
+	models.VoucherEntryTopUp:    "Nạp thêm",
+	models.VoucherEntryRedeem:   "Thanh toán",
+	models.VoucherEntryReversal: "Hoàn lại",
+	models.VoucherEntryExpire:   "Hết hạn",
+	models.VoucherEntryCancel:   "Hủy",
+	models.VoucherEntryForfeit:  "Không hoàn phần dư",
+}
+
+// voucherCodePrefixes start the generated codes so cashiers can tell the kinds apart
+var voucherCodePrefixes = map[models.VoucherKind]string{
+	models.VoucherKindVoucher:  "VC",
+	models.VoucherKindGiftCard: "GC",
+}
+
+// voucherCodeAlphabet leaves out 0/O and 1/I so codes can be read out and typed safely
+const voucherCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// maxVoucherBatch caps how many vouchers one issuance can create
+const maxVoucherBatch = 500
+
+// voucherRow is a voucher with the names shown in the lists
+type voucherRow struct {
+	models.Voucher
+	CustomerName string `json:"customer_name"`
+	IssuerName   string `json:"issuer_name"`
+	KindLabel    string `json:"kind_label" gorm:"-"`
+}
+
+// voucherEntryRow is a ledger entry with its invoice and employee
+type voucherEntryRow struct {
+	models.VoucherTransaction
+	VoucherCode  string `json:"voucher_code"`
+	InvoiceNo    string `json:"invoice_no"`
+	EmployeeName string `json:"employee_name"`
+}
+
+// normalizeVoucherCode makes codes typed at the till match the stored ones
+func normalizeVoucherCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// voucherExpired checks if the expiry date of a voucher is before the date of t
+func voucherExpired(v *models.Voucher, t time.Time) bool {
+	return v.ExpiresAt != nil && v.ExpiresAt.Format("2006-01-02") < t.Format("2006-01-02")
+}
+
+// generateVoucherCode creates an unused random code for the kind
+func generateVoucherCode(tx *gorm.DB, kind models.VoucherKind) (string, error) {
+	maxRetries := 10
+	alphabetSize := big.NewInt(int64(len(voucherCodeAlphabet)))
+	for i := 0; i < maxRetries; i++ {
+		var sb strings.Builder
+		sb.WriteString(voucherCodePrefixes[kind])
+		for j := 0; j < 10; j++ {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return "", fmt.Errorf("Không thể tạo mã voucher: %v", err)
+			}
+			sb.WriteByte(voucherCodeAlphabet[n.Int64()])
+		}
+		code := sb.String()
+
+		var count int64
+		err := tx.Raw("SELECT COUNT(*) FROM supermarket.vouchers WHERE voucher_code = $1", code).Scan(&count).Error
+		if err != nil {
+			return "", fmt.Errorf("Không thể kiểm tra mã voucher: %v", err)
+		}
+		if count == 0 {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("Không thể tạo mã voucher duy nhất")
+}
+
+// writeVoucherEntry appends an entry to the voucher ledger
+func writeVoucherEntry(tx *gorm.DB, voucherID uint, entryType models.VoucherEntryType, amount, balanceAfter float64,
+	invoiceID, paymentID, employeeID *uint, notes *string) error {
+	return tx.Exec(`
+		INSERT INTO supermarket.voucher_transactions
+		(voucher_id, entry_type, amount, balance_after, invoice_id, payment_id, employee_id, notes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+	`, voucherID, entryType, roundVND(amount), roundVND(balanceAfter), invoiceID, paymentID, employeeID, notes).Error
+}
+
+// setVoucherBalance stores the balance and status written by the last ledger entry
+func setVoucherBalance(tx *gorm.DB, voucherID uint, balance float64, status models.VoucherStatus) error {
+	return tx.Exec(`
+		UPDATE supermarket.vouchers
+		SET balance = $1, status = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE voucher_id = $3
+	`, roundVND(balance), status, voucherID).Error
+}
+
+// lockVoucher locks a voucher row by id for a balance change
+func lockVoucher(tx *gorm.DB, voucherID uint) (*models.Voucher, error) {
+	var v models.Voucher
+	err := tx.Raw(`
+		SELECT * FROM supermarket.vouchers
+		WHERE voucher_id = $1
+		FOR UPDATE
+	`, voucherID).Scan(&v).Error
+	if err != nil {
+		return nil, err
+	}
+	if v.VoucherID == 0 {
+		return nil, fmt.Errorf("Không tìm thấy voucher")
+	}
+	return &v, nil
+}
+
+// issueVoucher stores a new voucher and its ISSUE ledger entry for the face value
+func issueVoucher(tx *gorm.DB, v *models.Voucher) error {
+	err := tx.Raw(`
+		INSERT INTO supermarket.vouchers
+		(voucher_code, kind, face_value, balance, multi_use, status, expires_at, customer_id, issued_by, notes, created_at, updated_at)
+		VALUES ($1, $2, $3, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING voucher_id
+	`, v.VoucherCode, v.Kind, v.FaceValue, v.MultiUse, models.VoucherActive, v.ExpiresAt, v.CustomerID, v.IssuedBy, v.Notes).Scan(&v.VoucherID).Error
+	if err != nil {
+		return err
+	}
+	v.Balance = v.FaceValue
+	v.Status = models.VoucherActive
+	return writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryIssue, v.FaceValue, v.FaceValue, nil, nil, v.IssuedBy, nil)
+}
+
+// redeemVoucher takes amount off the voucher with the code as payment paymentID of an
+// invoice. The voucher row stays locked until the sale commits, so two tills can never
+// spend the same balance. A single-use voucher is used up by its first redemption: what
+// is left is written off as FORFEIT.
+func redeemVoucher(tx *gorm.DB, code string, amount float64, invoiceID, paymentID, employeeID uint) (uint, error) {
+	code = normalizeVoucherCode(code)
+
+	var v models.Voucher
+	err := tx.Raw(`
+		SELECT * FROM supermarket.vouchers
+		WHERE voucher_code = $1
+		FOR UPDATE
+	`, code).Scan(&v).Error
+	if err != nil {
+		return 0, err
+	}
+	if v.VoucherID == 0 {
+		return 0, fmt.Errorf("Không tìm thấy voucher %s", code)
+	}
+
+	now := time.Now()
+	switch {
+	case v.Status == models.VoucherRedeemed:
+		return 0, fmt.Errorf("Voucher %s đã được sử dụng hết", code)
+	case v.Status == models.VoucherCancelled:
+		return 0, fmt.Errorf("Voucher %s đã bị hủy", code)
+	case v.Status == models.VoucherExpired || voucherExpired(&v, now):
+		return 0, fmt.Errorf("Voucher %s đã hết hạn", code)
+	case !v.IsRedeemable(now):
+		return 0, fmt.Errorf("Voucher %s không thể sử dụng", code)
+	case amount > v.Balance+0.005:
+		return 0, fmt.Errorf("Số dư voucher %s chỉ còn %.0f VND", code, v.Balance)
+	}
+
+	balance := roundVND(v.Balance - amount)
+	if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryRedeem, -amount, balance, &invoiceID, &paymentID, &employeeID, nil); err != nil {
+		return 0, err
+	}
+	if !v.MultiUse && balance > 0 {
+		notes := "Voucher dùng một lần, phần còn lại không được hoàn"
+		if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryForfeit, -balance, 0, &invoiceID, &paymentID, &employeeID, &notes); err != nil {
+			return 0, err
+		}
+		balance = 0
+	}
+
+	status := models.VoucherActive
+	if balance == 0 {
+		status = models.VoucherRedeemed
+	}
+	if err := setVoucherBalance(tx, v.VoucherID, balance, status); err != nil {
+		return 0, err
+	}
+
+	err = tx.Exec(`
+		UPDATE supermarket.sales_invoice_payments SET voucher_id = $1 WHERE payment_id = $2
+	`, v.VoucherID, paymentID).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return v.VoucherID, nil
+}
+
+// reverseInvoiceVouchers gives back what a voided invoice took off its vouchers, including
+// the forfeited remainder of single-use ones. A voucher cancelled or expired in the
+// meantime gets the amount back and loses it again in the same step, so the ledger stays
+// complete without reviving it.
+func reverseInvoiceVouchers(tx *gorm.DB, invoiceID uint, employeeID *uint) error {
+	var redemptions []struct {
+		VoucherID uint
+		Amount    float64
+	}
+	err := tx.Raw(`
+		SELECT voucher_id, -SUM(amount) as amount
+		FROM supermarket.voucher_transactions
+		WHERE invoice_id = $1
+		GROUP BY voucher_id
+		HAVING -SUM(amount) > 0
+		ORDER BY voucher_id
+	`, invoiceID).Scan(&redemptions).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, r := range redemptions {
+		v, err := lockVoucher(tx, r.VoucherID)
+		if err != nil {
+			return err
+		}
+
+		balance := roundVND(v.Balance + r.Amount)
+		notes := "Hoàn lại do hủy hóa đơn"
+		if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryReversal, r.Amount, balance, &invoiceID, nil, employeeID, &notes); err != nil {
+			return err
+		}
+
+		status := models.VoucherActive
+		switch {
+		case v.Status == models.VoucherCancelled:
+			notes := "Voucher đã bị hủy, số dư hoàn lại không được sử dụng"
+			if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryCancel, -balance, 0, &invoiceID, nil, employeeID, &notes); err != nil {
+				return err
+			}
+			balance, status = 0, models.VoucherCancelled
+		case v.Status == models.VoucherExpired || voucherExpired(v, now):
+			notes := "Voucher đã hết hạn, số dư hoàn lại không được sử dụng"
+			if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryExpire, -balance, 0, &invoiceID, nil, employeeID, &notes); err != nil {
+				return err
+			}
+			balance, status = 0, models.VoucherExpired
+		}
+
+		if err := setVoucherBalance(tx, v.VoucherID, balance, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireVouchers writes off the balance of active vouchers past their expiry date
+func expireVouchers(db *gorm.DB) (int64, error) {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var vouchers []models.Voucher
+	err := tx.Raw(`
+		SELECT * FROM supermarket.vouchers
+		WHERE status = $1 AND expires_at < CURRENT_DATE
+		FOR UPDATE SKIP LOCKED
+	`, models.VoucherActive).Scan(&vouchers).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, v := range vouchers {
+		if v.Balance > 0 {
+			if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryExpire, -v.Balance, 0, nil, nil, nil, nil); err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+		if err := setVoucherBalance(tx, v.VoucherID, 0, models.VoucherExpired); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return int64(len(vouchers)), nil
+}
+
+// RunVoucherExpiry expires vouchers past their expiry date every interval until the process exits
+func RunVoucherExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := expireVouchers(database.GetDB())
+		if err != nil {
+			log.Printf("Failed to expire vouchers: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d voucher(s)", expired)
+		}
+	}
+}
+
+// voucherEmployeeID reads the required employee_id form value
+func voucherEmployeeID(c *fiber.Ctx) (uint, error) {
+	employeeID, err := strconv.ParseUint(c.FormValue("employee_id"), 10, 64)
+	if err != nil || employeeID == 0 {
+		return 0, fmt.Errorf("Vui lòng chọn nhân viên thực hiện")
+	}
+	return uint(employeeID), nil
+}
+
+// voucherFormData loads the choices of the issuance form
+func voucherFormData(db *gorm.DB) fiber.Map {
+	var employees []models.Employee
+	db.Raw(`
+		SELECT employee_id, full_name
+		FROM supermarket.employees
+		WHERE is_active = true
+		ORDER BY full_name
+	`).Scan(&employees)
+
+	var customers []models.Customer
+	db.Raw("SELECT customer_id, full_name, phone FROM supermarket.customers ORDER BY full_name").Scan(&customers)
+
+	return fiber.Map{
+		"Employees":  employees,
+		"Customers":  customers,
+		"KindLabels": voucherKindLabels,
+	}
+}
+
+// VoucherList shows the vouchers and gift cards with the outstanding liability
+func VoucherList(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	// Expire lazily as well, so the list is right even between two background runs
+	if _, err := expireVouchers(db); err != nil {
+		log.Printf("Failed to expire vouchers: %v", err)
+	}
+
+	status := c.Query("status")
+	kind := c.Query("kind")
+	search := normalizeVoucherCode(c.Query("q"))
+
+	query := `
+		SELECT v.*, COALESCE(cu.full_name, '') as customer_name, COALESCE(e.full_name, '') as issuer_name
+		FROM supermarket.vouchers v
+		LEFT JOIN supermarket.customers cu ON v.customer_id = cu.customer_id
+		LEFT JOIN supermarket.employees e ON v.issued_by = e.employee_id
+		WHERE 1=1`
+	var args []interface{}
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND v.status = $%d", len(args))
+	}
+	if kind != "" {
+		args = append(args, kind)
+		query += fmt.Sprintf(" AND v.kind = $%d", len(args))
+	}
+	if search != "" {
+		args = append(args, "%"+search+"%")
+		query += fmt.Sprintf(" AND v.voucher_code LIKE $%d", len(args))
+	}
+	query += " ORDER BY v.created_at DESC, v.voucher_id DESC LIMIT 200"
+
+	var rows []voucherRow
+	if err := db.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không thể tải danh sách voucher: " + err.Error(),
+		})
+	}
+	for i := range rows {
+		rows[i].KindLabel = voucherKindLabels[rows[i].Kind]
+	}
+
+	var liabilities []struct {
+		Kind        models.VoucherKind `json:"kind"`
+		KindLabel   string             `json:"kind_label" gorm:"-"`
+		ActiveCount int64              `json:"active_count"`
+		Outstanding float64            `json:"outstanding"`
+	}
+	db.Raw(`
+		SELECT kind, COUNT(*) as active_count, COALESCE(SUM(balance), 0) as outstanding
+		FROM supermarket.vouchers
+		WHERE status = $1
+		GROUP BY kind
+		ORDER BY kind
+	`, models.VoucherActive).Scan(&liabilities)
+	var totalOutstanding float64
+	for i := range liabilities {
+		liabilities[i].KindLabel = voucherKindLabels[liabilities[i].Kind]
+		totalOutstanding += liabilities[i].Outstanding
+	}
+
+	return c.Render("pages/vouchers/list", fiber.Map{
+		"Title":            "Voucher và thẻ quà tặng",
+		"Active":           "vouchers",
+		"Vouchers":         rows,
+		"Liabilities":      liabilities,
+		"TotalOutstanding": totalOutstanding,
+		"Status":           status,
+		"Kind":             kind,
+		"Search":           search,
+		"SQLQueries":       c.Locals("SQLQueries"),
+		"TotalSQLQueries":  c.Locals("TotalSQLQueries"),
+	}, "layouts/base")
+}
+
+// VoucherNew shows the issuance form
+func VoucherNew(c *fiber.Ctx) error {
+	data := voucherFormData(database.GetDB())
+	data["Title"] = "Phát hành voucher"
+	data["Active"] = "vouchers"
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/vouchers/form", data, "layouts/base")
+}
+
+// VoucherIssue issues one voucher with a chosen code, or a batch with generated codes
+func VoucherIssue(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	kind := models.VoucherKind(c.FormValue("kind"))
+	if _, ok := voucherKindLabels[kind]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Loại voucher không hợp lệ",
+		})
+	}
+
+	faceValue, err := strconv.ParseFloat(c.FormValue("face_value"), 64)
+	if err != nil || faceValue <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Mệnh giá phải lớn hơn 0",
+		})
+	}
+
+	quantity := 1
+	if q := c.FormValue("quantity"); q != "" {
+		quantity, err = strconv.Atoi(q)
+		if err != nil || quantity < 1 || quantity > maxVoucherBatch {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Số lượng phát hành phải từ 1 đến %d", maxVoucherBatch),
+			})
+		}
+	}
+
+	code := normalizeVoucherCode(c.FormValue("voucher_code"))
+	if code != "" && quantity > 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Chỉ được nhập mã khi phát hành một voucher",
+		})
+	}
+
+	issuedBy, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var expiresAt *time.Time
+	if s := c.FormValue("expires_at"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Ngày hết hạn không hợp lệ",
+			})
+		}
+		if s < time.Now().Format("2006-01-02") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Ngày hết hạn không được ở trong quá khứ",
+			})
+		}
+		expiresAt = &t
+	}
+
+	var customerID *uint
+	if s := c.FormValue("customer_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "ID khách hàng không hợp lệ",
+			})
+		}
+		cid := uint(id)
+		customerID = &cid
+	}
+
+	multiUse := c.FormValue("multi_use") == "on" || c.FormValue("multi_use") == "true"
+	notes := nullIfEmpty(strings.TrimSpace(c.FormValue("notes")))
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	issued := make([]models.Voucher, 0, quantity)
+	for i := 0; i < quantity; i++ {
+		v := models.Voucher{
+			VoucherCode: code,
+			Kind:        kind,
+			FaceValue:   roundVND(faceValue),
+			MultiUse:    multiUse,
+			ExpiresAt:   expiresAt,
+			CustomerID:  customerID,
+			IssuedBy:    &issuedBy,
+			Notes:       notes,
+		}
+		if v.VoucherCode == "" {
+			v.VoucherCode, err = generateVoucherCode(tx, kind)
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		}
+		if err := issueVoucher(tx, &v); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Không thể phát hành voucher: " + err.Error(),
+			})
+		}
+		issued = append(issued, v)
+	}
+
+	description := fmt.Sprintf("Phát hành %s %s mệnh giá %.0f", issued[0].VoucherCode, voucherKindLabels[kind], faceValue)
+	if quantity > 1 {
+		description = fmt.Sprintf("Phát hành %d %s mệnh giá %.0f (tổng %.0f)", quantity, voucherKindLabels[kind], faceValue, faceValue*float64(quantity))
+	}
+	tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'vouchers', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeVoucherIssued, description, issued[0].VoucherID)
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success":  true,
+			"vouchers": issued,
+			"message":  description,
+		})
+	}
+	if quantity == 1 {
+		return c.Redirect(fmt.Sprintf("/vouchers/%d", issued[0].VoucherID))
+	}
+	return c.Redirect("/vouchers?kind=" + string(kind))
+}
+
+// VoucherView shows a voucher with its ledger
+func VoucherView(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	var v voucherRow
+	err := db.Raw(`
+		SELECT v.*, COALESCE(cu.full_name, '') as customer_name, COALESCE(e.full_name, '') as issuer_name
+		FROM supermarket.vouchers v
+		LEFT JOIN supermarket.customers cu ON v.customer_id = cu.customer_id
+		LEFT JOIN supermarket.employees e ON v.issued_by = e.employee_id
+		WHERE v.voucher_id = $1
+	`, c.Params("id")).Scan(&v).Error
+	if err != nil || v.VoucherID == 0 {
+		return c.Status(fiber.StatusNotFound).Render("pages/error", fiber.Map{
+			"Title": "Lỗi",
+			"Error": "Không tìm thấy voucher",
+			"Code":  404,
+		})
+	}
+	v.KindLabel = voucherKindLabels[v.Kind]
+
+	var entries []voucherEntryRow
+	db.Raw(`
+		SELECT vt.*, COALESCE(si.invoice_no, '') as invoice_no, COALESCE(e.full_name, '') as employee_name
+		FROM supermarket.voucher_transactions vt
+		LEFT JOIN supermarket.sales_invoices si ON vt.invoice_id = si.invoice_id
+		LEFT JOIN supermarket.employees e ON vt.employee_id = e.employee_id
+		WHERE vt.voucher_id = $1
+		ORDER BY vt.created_at, vt.transaction_id
+	`, v.VoucherID).Scan(&entries)
+
+	data := voucherFormData(db)
+	data["Title"] = "Voucher " + v.VoucherCode
+	data["Active"] = "vouchers"
+	data["Voucher"] = v
+	data["Entries"] = entries
+	data["EntryLabels"] = voucherEntryLabels
+	data["CanTopUp"] = v.Kind == models.VoucherKindGiftCard && v.MultiUse &&
+		(v.Status == models.VoucherActive || v.Status == models.VoucherRedeemed) && !voucherExpired(&v.Voucher, time.Now())
+	data["SQLQueries"] = c.Locals("SQLQueries")
+	data["TotalSQLQueries"] = c.Locals("TotalSQLQueries")
+	return c.Render("pages/vouchers/view", data, "layouts/base")
+}
+
+// VoucherTopUp loads more value onto a reloadable gift card
+func VoucherTopUp(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	voucherID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID voucher không hợp lệ",
+		})
+	}
+
+	amount, err := strconv.ParseFloat(c.FormValue("amount"), 64)
+	if err != nil || amount <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Số tiền nạp phải lớn hơn 0",
+		})
+	}
+	amount = roundVND(amount)
+
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	v, err := lockVoucher(tx, uint(voucherID))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if v.Kind != models.VoucherKindGiftCard || !v.MultiUse {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Chỉ thẻ quà tặng dùng nhiều lần mới được nạp thêm",
+		})
+	}
+	if (v.Status != models.VoucherActive && v.Status != models.VoucherRedeemed) || voucherExpired(v, time.Now()) {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Thẻ đã hết hạn hoặc đã bị hủy, không thể nạp thêm",
+		})
+	}
+
+	balance := roundVND(v.Balance + amount)
+	notes := nullIfEmpty(strings.TrimSpace(c.FormValue("notes")))
+	if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryTopUp, amount, balance, nil, nil, &employeeID, notes); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể ghi sổ voucher: " + err.Error(),
+		})
+	}
+	if err := setVoucherBalance(tx, v.VoucherID, balance, models.VoucherActive); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể cập nhật số dư: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"balance": balance,
+			"message": "Nạp tiền vào thẻ thành công",
+		})
+	}
+	return c.Redirect(fmt.Sprintf("/vouchers/%d", voucherID))
+}
+
+// VoucherCancel cancels an active voucher and writes off its balance
+func VoucherCancel(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	voucherID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID voucher không hợp lệ",
+		})
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Vui lòng nhập lý do hủy voucher",
+		})
+	}
+
+	employeeID, err := voucherEmployeeID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	v, err := lockVoucher(tx, uint(voucherID))
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if v.Status != models.VoucherActive {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Voucher đang ở trạng thái %s, không thể hủy", v.Status),
+		})
+	}
+
+	if v.Balance > 0 {
+		if err := writeVoucherEntry(tx, v.VoucherID, models.VoucherEntryCancel, -v.Balance, 0, nil, nil, &employeeID, &reason); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể ghi sổ voucher: " + err.Error(),
+			})
+		}
+	}
+	if err := setVoucherBalance(tx, v.VoucherID, 0, models.VoucherCancelled); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hủy voucher: " + err.Error(),
+		})
+	}
+
+	tx.Exec(`
+		INSERT INTO supermarket.activity_logs (activity_type, description, table_name, record_id, created_at)
+		VALUES ($1, $2, 'vouchers', $3, CURRENT_TIMESTAMP)
+	`, models.ActivityTypeVoucherCancelled, fmt.Sprintf("Hủy voucher %s (số dư %.0f): %s", v.VoucherCode, v.Balance, reason), v.VoucherID)
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Không thể hoàn tất giao dịch: " + err.Error(),
+		})
+	}
+
+	if c.Get("Content-Type") == "application/json" {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Hủy voucher thành công",
+		})
+	}
+	return c.Redirect(fmt.Sprintf("/vouchers/%d", voucherID))
+}
+
+// VoucherLookup returns the balance of a voucher code for the checkout screens
+func VoucherLookup(c *fiber.Ctx) error {
+	db := database.GetDB()
+
+	code := normalizeVoucherCode(c.Params("code"))
+	var v models.Voucher
+	db.Raw("SELECT * FROM supermarket.vouchers WHERE voucher_code = $1", code).Scan(&v)
+	if v.VoucherID == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Không tìm thấy voucher " + code,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"voucher_code": v.VoucherCode,
+		"kind":         v.Kind,
+		"balance":      v.Balance,
+		"multi_use":    v.MultiUse,
+		"status":       v.Status,
+		"expires_at":   v.ExpiresAt,
+		"redeemable":   v.IsRedeemable(time.Now()),
+	})
+}
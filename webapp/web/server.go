@@ -212,6 +212,8 @@ func setupRoutes(app *fiber.App) {
 	customers.Get("/new", handlers.CustomerNew)
 	customers.Post("/", handlers.CustomerCreate)
 	customers.Get("/:id", handlers.CustomerView)
+	customers.Get("/:id/points", handlers.CustomerPoints)
+	customers.Post("/:id/points/adjust", handlers.CustomerPointsAdjust)
 	customers.Get("/:id/edit", handlers.CustomerEdit)
 	customers.Put("/:id", handlers.CustomerUpdate)
 	customers.Delete("/:id", handlers.CustomerDelete)
@@ -223,9 +225,29 @@ func setupRoutes(app *fiber.App) {
 	inventory.Get("/shelf", handlers.ShelfInventory)
 	inventory.Get("/transfer", handlers.StockTransferForm)
 	inventory.Post("/transfer", handlers.StockTransfer)
+	inventory.Get("/transfer/preview", handlers.StockTransferPreview)
 	inventory.Get("/low-stock", handlers.LowStockAlert)
 	inventory.Get("/expired", handlers.ExpiredProducts)
 	inventory.Get("/transfers", handlers.StockTransferHistory)
+	inventory.Get("/counts", handlers.StockCountList)
+	inventory.Post("/counts", handlers.StockCountCreate)
+	inventory.Get("/counts/:id", handlers.StockCountView)
+	inventory.Post("/counts/:id/lines", handlers.StockCountRecord)
+	inventory.Post("/counts/:id/submit", handlers.StockCountSubmit)
+	inventory.Post("/counts/:id/approve", handlers.StockCountApprove)
+	inventory.Post("/counts/:id/cancel", handlers.StockCountCancel)
+	inventory.Get("/disposals", handlers.DisposalList)
+	inventory.Get("/disposals/new", handlers.DisposalForm)
+	inventory.Post("/disposals", handlers.DisposalCreate)
+	inventory.Get("/disposals/:id", handlers.DisposalView)
+	inventory.Get("/transfer-orders", handlers.TransferOrderList)
+	inventory.Get("/transfer-orders/new", handlers.TransferOrderForm)
+	inventory.Post("/transfer-orders", handlers.TransferOrderDispatch)
+	inventory.Get("/transfer-orders/:id", handlers.TransferOrderView)
+	inventory.Post("/transfer-orders/:id/receive", handlers.TransferOrderReceive)
+	inventory.Post("/transfer-orders/:id/cancel", handlers.TransferOrderCancel)
+	inventory.Get("/replenishment", handlers.ReplenishmentPlan)
+	inventory.Post("/replenishment", handlers.ReplenishmentConfirm)
 	inventory.Post("/apply-discount", handlers.ApplyDiscountRules)
 
 	// Discount rules management
@@ -244,6 +266,10 @@ func setupRoutes(app *fiber.App) {
 	purchaseOrders.Get("/:id/edit", handlers.PurchaseOrderEdit)
 	purchaseOrders.Put("/:id", handlers.PurchaseOrderUpdate)
 	purchaseOrders.Delete("/:id", handlers.PurchaseOrderDelete)
+	purchaseOrders.Post("/:id/close", handlers.PurchaseOrderClose)
+	purchaseOrders.Get("/:id/receipts/new", handlers.GoodsReceiptForm)
+	purchaseOrders.Post("/:id/receipts", handlers.GoodsReceiptCreate)
+	purchaseOrders.Get("/:id/receipts/:receipt_id", handlers.GoodsReceiptView)
 
 	// Sales operations
 	sales := app.Group("/sales")
@@ -260,8 +286,31 @@ func setupRoutes(app *fiber.App) {
 	})
 	sales.Get("/new", handlers.SalesNew)
 	sales.Post("/", handlers.SalesCreate)
+	sales.Post("/park", handlers.SalesPark)
+	sales.Get("/parked", handlers.SalesParkedList)
+	sales.Post("/parked/:id/resume", handlers.SalesParkedResume)
+	sales.Get("/shortages", handlers.StockShortageList)
+	sales.Post("/shortages/settle", handlers.StockShortageSettleAll)
+	sales.Post("/shortages/:id/settle", handlers.StockShortageSettle)
+	sales.Post("/shortages/:id/dismiss", handlers.StockShortageDismiss)
+	sales.Get("/returns/:id", handlers.SalesReturnView)
 	sales.Get("/:id", handlers.SalesView)
+	sales.Get("/:id/return", handlers.SalesReturnNew)
+	sales.Post("/:id/return", handlers.SalesReturnCreate)
+	sales.Post("/:id/complete", handlers.SalesComplete)
+	sales.Post("/:id/void", handlers.SalesVoid)
 	sales.Get("/invoice/:id", handlers.SalesInvoice)
+	sales.Get("/invoice/:id/escpos", handlers.SalesReceiptESCPOS)
+	sales.Get("/invoice/:id/pdf", handlers.SalesReceiptPDF)
+	sales.Post("/invoice/:id/print", handlers.SalesReceiptPrint)
+	sales.Post("/invoice/:id/einvoice", handlers.EInvoiceCreate)
+
+	// Register (till) sessions
+	registers := app.Group("/registers")
+	registers.Get("/", handlers.RegisterSessionList)
+	registers.Post("/", handlers.RegisterSessionOpen)
+	registers.Get("/:id", handlers.RegisterSessionView)
+	registers.Post("/:id/close", handlers.RegisterSessionClose)
 
 	// Reports and statistics
 	reports := app.Group("/reports")
@@ -271,6 +320,59 @@ func setupRoutes(app *fiber.App) {
 	reports.Get("/revenue", handlers.RevenueReport)
 	reports.Get("/suppliers", handlers.SupplierReport)
 	reports.Get("/customers", handlers.CustomerReport)
+	reports.Get("/promotions", handlers.PromotionReport)
+	reports.Get("/vouchers", handlers.VoucherReport)
+	reports.Get("/vat", handlers.VATReport)
+	reports.Get("/overrides", handlers.OverrideReport)
+	reports.Get("/stock-variance", handlers.StockVarianceReport)
+	reports.Get("/shrinkage", handlers.ShrinkageReport)
+
+	// Promotions admin
+	promotions := app.Group("/promotions")
+	promotions.Get("/", handlers.PromotionList)
+	promotions.Get("/new", handlers.PromotionNew)
+	promotions.Post("/", handlers.PromotionCreate)
+	promotions.Get("/:id", handlers.PromotionView)
+	promotions.Get("/:id/edit", handlers.PromotionEdit)
+	promotions.Put("/:id", handlers.PromotionUpdate)
+	promotions.Delete("/:id", handlers.PromotionDelete)
+
+	// Tax classes
+	taxClasses := app.Group("/tax-classes")
+	taxClasses.Get("/", handlers.TaxClassList)
+	taxClasses.Get("/new", handlers.TaxClassNew)
+	taxClasses.Post("/", handlers.TaxClassCreate)
+	taxClasses.Post("/categories", handlers.TaxClassAssignCategories)
+	taxClasses.Get("/:id/edit", handlers.TaxClassEdit)
+	taxClasses.Put("/:id", handlers.TaxClassUpdate)
+	taxClasses.Delete("/:id", handlers.TaxClassDelete)
+
+	// Sale restrictions of product categories (product rules are on the product form)
+	saleRestrictions := app.Group("/sale-restrictions")
+	saleRestrictions.Get("/", handlers.SaleRestrictionList)
+	saleRestrictions.Post("/categories", handlers.SaleRestrictionSaveCategories)
+
+	// Document numbering
+	sequences := app.Group("/document-sequences")
+	sequences.Get("/", handlers.DocumentSequenceList)
+	sequences.Put("/:type", handlers.DocumentSequenceUpdate)
+
+	// Electronic invoices
+	einvoices := app.Group("/einvoices")
+	einvoices.Get("/", handlers.EInvoiceList)
+	einvoices.Get("/:id", handlers.EInvoiceView)
+	einvoices.Get("/:id/xml", handlers.EInvoiceDownload)
+	einvoices.Post("/:id/replace", handlers.EInvoiceReplace)
+	einvoices.Post("/:id/adjust", handlers.EInvoiceAdjust)
+
+	// Vouchers and gift cards
+	vouchers := app.Group("/vouchers")
+	vouchers.Get("/", handlers.VoucherList)
+	vouchers.Get("/new", handlers.VoucherNew)
+	vouchers.Post("/", handlers.VoucherIssue)
+	vouchers.Get("/:id", handlers.VoucherView)
+	vouchers.Post("/:id/topup", handlers.VoucherTopUp)
+	vouchers.Post("/:id/cancel", handlers.VoucherCancel)
 
 	// Positions admin
 	positions := app.Group("/positions")
@@ -287,6 +389,7 @@ func setupRoutes(app *fiber.App) {
 	levels.Get("/", handlers.LevelList)
 	levels.Get("/new", handlers.LevelNew)
 	levels.Post("/", handlers.LevelCreate)
+	levels.Post("/evaluate", handlers.MembershipTierEvaluate)
 	levels.Get("/:id", handlers.LevelView)
 	levels.Get("/:id/edit", handlers.LevelEdit)
 	levels.Put("/:id", handlers.LevelUpdate)
@@ -309,6 +412,30 @@ func setupRoutes(app *fiber.App) {
 	// Discount calculation
 	api.Post("/discount/calculate", handlers.CalculateDiscount)
 
+	// Server-side sale pricing with explained breakdown
+	api.Post("/pricing/quote", handlers.PricingQuote)
+
+	// Idempotent upload of sales rung up by offline tills
+	api.Post("/sales/offline", handlers.OfflineSalesIngest)
+
+	// Voucher balance lookup for the checkout screens
+	api.Get("/vouchers/:code", handlers.VoucherLookup)
+
+	// POS API: server-side carts driven by a scanner client
+	pos := api.Group("/pos")
+	pos.Get("/carts", handlers.PosCartList)
+	pos.Post("/carts", handlers.PosCartCreate)
+	pos.Get("/carts/:id", handlers.PosCartGet)
+	pos.Delete("/carts/:id", handlers.PosCartCancel)
+	pos.Post("/carts/:id/scan", handlers.PosCartScan)
+	pos.Put("/carts/:id/items/:productId", handlers.PosCartUpdateItem)
+	pos.Delete("/carts/:id/items/:productId", handlers.PosCartRemoveItem)
+	pos.Post("/carts/:id/customer", handlers.PosCartAttachCustomer)
+	pos.Delete("/carts/:id/customer", handlers.PosCartDetachCustomer)
+	pos.Post("/carts/:id/park", handlers.PosCartPark)
+	pos.Post("/carts/:id/resume", handlers.PosCartResume)
+	pos.Post("/carts/:id/finalize", handlers.PosCartFinalize)
+
 	// Discount rules API
 	apiInventory := api.Group("/inventory")
 	apiInventory.Post("/discount-rules", handlers.CreateDiscountRule)
@@ -317,7 +444,7 @@ func setupRoutes(app *fiber.App) {
 	// Warehouse utilities
 	apiInventory.Post("/warehouse/expiry", handlers.UpdateWarehouseExpiry)
 
-	// Inventory disposal endpoints
+	// Inventory disposal endpoints; each call posts a disposal document
 	apiInventory.Delete("/warehouse/:id", handlers.DeleteWarehouseInventory)
 	apiInventory.Delete("/shelf/:id", handlers.DeleteShelfInventory)
 	apiInventory.Post("/dispose-all-expired", handlers.DisposeAllExpired)